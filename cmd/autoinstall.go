@@ -0,0 +1,95 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os/exec"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/rancher/elemental-toolkit/v2/cmd/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// NewAutoInstallCmd returns a new instance of the autoinstall subcommand and appends it to
+// the root command. requireRoot is to initiate it with or without the CheckRoot
+// pre-run check. This method is mostly used for testing purposes.
+func NewAutoInstallCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "autoinstall",
+		Short: "Elemental unattended installer",
+		Long: "Elemental unattended installer\n\n" +
+			"Runs the installation defined by the 'install' key of the configuration on the first disk\n" +
+			"matching the rules of the 'autoinstall' key. Disks already including an Elemental installation\n" +
+			"are not installed over unless overwrite is enabled.",
+		Args: cobra.NoArgs,
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if addCheckRoot {
+				return CheckRoot()
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			path, err := exec.LookPath("mount")
+			if err != nil {
+				return err
+			}
+			mounter := types.NewMounter(path)
+
+			cfg, err := config.ReadConfigRun(viper.GetString("config-dir"), cmd.Flags(), mounter)
+			if err != nil {
+				cfg.Logger.Errorf("Error reading config: %s\n", err)
+				return elementalError.NewFromError(err, elementalError.ReadingRunConfig)
+			}
+
+			cmd.SilenceUsage = true
+			spec, err := config.ReadInstallSpec(cfg, nil)
+			if err != nil {
+				cfg.Logger.Errorf("invalid install setup %v", err)
+				return elementalError.NewFromError(err, elementalError.ReadingSpecConfig)
+			}
+			auto, err := config.ReadAutoInstallSpec(cfg, cmd.Flags())
+			if err != nil {
+				cfg.Logger.Errorf("invalid autoinstall command setup %v", err)
+				return elementalError.NewFromError(err, elementalError.ReadingSpecConfig)
+			}
+
+			if spec.Target != "" {
+				spec.Target, _ = utils.ResolveLink(cfg.Fs, spec.Target, "/", constants.MaxLinkDepth)
+			}
+
+			cfg.Logger.Infof("Autoinstall called")
+			err = action.NewAutoInstallAction(cfg, spec, auto).Run()
+			if err != nil {
+				cfg.Logger.Errorf("autoinstall command failed: %v", err)
+			}
+			return err
+		},
+	}
+	root.AddCommand(c)
+	c.Flags().Uint("countdown", constants.AutoInstallCountdown, "Seconds to wait before starting the installation")
+	c.Flags().Bool("overwrite", false, "Install over disks already including an Elemental installation")
+	return c
+}
+
+// register the subcommand into rootCmd
+var _ = NewAutoInstallCmd(rootCmd, true)
@@ -0,0 +1,52 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+)
+
+var _ = Describe("Autoinstall", Label("autoinstall", "cmd"), func() {
+	var buf *bytes.Buffer
+	BeforeEach(func() {
+		rootCmd = NewRootCmd()
+		_ = NewAutoInstallCmd(rootCmd, false)
+		buf = new(bytes.Buffer)
+		rootCmd.SetOut(buf)
+		rootCmd.SetErr(buf)
+	})
+	AfterEach(func() {
+		viper.Reset()
+	})
+	It("Errors out if a target device is given as argument", Label("args"), func() {
+		_, _, err := executeCommandC(rootCmd, "autoinstall", "/dev/whatever")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("unknown command"))
+	})
+	It("Errors out if no installation source is defined", Label("args"), func() {
+		_, _, err := executeCommandC(rootCmd, "autoinstall", "--countdown", "0")
+		Expect(err).ToNot(BeNil())
+		Expect(buf.String()).To(ContainSubstring("undefined system source to install"))
+		Expect(err.(*elementalError.ElementalError).ExitCode()).To(Equal(elementalError.ReadingSpecConfig))
+	})
+})
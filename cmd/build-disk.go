@@ -38,11 +38,15 @@ func NewBuildDisk(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 		Use:   "build-disk image",
 		Short: "Build a disk image using the given image (experimental and subject to change)",
 		Args:  cobra.ExactArgs(0),
-		PreRunE: func(_ *cobra.Command, _ []string) error {
-			if addCheckRoot {
-				return CheckRoot()
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if !addCheckRoot {
+				return nil
 			}
-			return nil
+			// Rootless builds still require uid 0 to keep the ownership of the files in the image
+			if rootless, _ := cmd.Flags().GetBool("rootless"); rootless {
+				return CheckNamespaceRoot()
+			}
+			return CheckRoot()
 		},
 		RunE: func(cmd *cobra.Command, _ []string) (err error) {
 			var cfg *types.BuildConfig
@@ -54,13 +58,16 @@ func NewBuildDisk(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 				}
 			}()
 
+			flags := cmd.Flags()
+
+			// Rootless builds do not mount anything, the mount utility is not required
+			rootless, _ := flags.GetBool("rootless")
 			path, err := exec.LookPath("mount")
-			if err != nil {
+			if err != nil && !rootless {
 				return err
 			}
 			mounter := types.NewMounter(path)
 
-			flags := cmd.Flags()
 			cfg, err = config.ReadConfigBuild(viper.GetString("config-dir"), flags, mounter)
 			if err != nil {
 				return eleError.NewFromError(err, eleError.ReadingBuildConfig)
@@ -95,12 +102,18 @@ func NewBuildDisk(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 		},
 	}
 	root.AddCommand(c)
-	imgType := newEnumFlag([]string{constants.RawType, constants.AzureType, constants.GCEType}, constants.RawType)
+	imgType := newEnumFlag([]string{constants.RawType, constants.AzureType, constants.GCEType, constants.QCOW2Type, constants.VMDKType, constants.OVAType, constants.VHDType, constants.VHDXType}, constants.RawType)
+	compression := newEnumFlag([]string{constants.NoCompression, constants.ZlibCompression, constants.ZstdCompression}, constants.NoCompression)
+	firmType := newEnumFlag([]string{types.EFI, types.BIOS}, types.EFI)
 	c.Flags().StringP("name", "n", "", "Basename of the generated disk file")
 	c.Flags().StringP("output", "o", "", "Output directory (defaults to current directory)")
 	c.Flags().Bool("date", false, "Adds a date suffix into the generated disk file")
 	c.Flags().Bool("expandable", false, "Creates an expandable image including only the recovery image")
+	c.Flags().Bool("rootless", false, "Creates the image without loop devices, mounts or chroots, so it can run as root within a user namespace")
 	c.Flags().VarP(imgType, "type", "t", "Type of image to create")
+	c.Flags().Var(compression, "compression", "Compression of the image data clusters (only for qcow2 images)")
+	c.Flags().Var(firmType, "firmware", "Firmware to boot, 'bios' creates a disk bootable on both legacy BIOS and EFI")
+	c.Flags().String("board", "", "Board profile of the disk, 'rpi' includes the Raspberry Pi firmware and U-Boot (only for arm64)")
 	c.Flags().StringSliceP("cloud-init", "c", []string{}, "Cloud-init config files to include in disk")
 	c.Flags().StringSlice("cloud-init-paths", []string{}, "Cloud-init config files to run during build")
 	c.Flags().StringSlice("deploy-command", []string{"elemental", "--debug", "reset", "--reboot"}, "Deployment command for expandable images")
@@ -18,13 +18,16 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
 	"os/exec"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/twpayne/go-vfs/v4"
 
 	"github.com/rancher/elemental-toolkit/v2/cmd/config"
 	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	"github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
@@ -125,6 +128,14 @@ func NewBuildISO(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 				}
 			}
 
+			if spec.AutoInstall != "" {
+				err = validateAutoInstall(cfg, spec.AutoInstall)
+				if err != nil {
+					cfg.Logger.Errorf("invalid autoinstall config: %v", err)
+					return elementalError.NewFromError(err, elementalError.ReadingSpecConfig)
+				}
+			}
+
 			buildISO := action.NewBuildISOAction(cfg, spec)
 			err = buildISO.Run()
 			if err != nil {
@@ -135,7 +146,7 @@ func NewBuildISO(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 		},
 	}
 
-	firmType := newEnumFlag([]string{types.EFI}, types.EFI)
+	firmType := newEnumFlag([]string{types.EFI, types.BIOS}, types.EFI)
 
 	root.AddCommand(c)
 	c.Flags().StringP("name", "n", "", "Basename of the generated ISO file")
@@ -147,8 +158,8 @@ func NewBuildISO(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 	c.Flags().String("label", "", "Label of the ISO volume")
 	c.Flags().String("extra-cmdline", "", fmt.Sprintf("Extra kernel cmdline (defaults to '%s')", constants.ISODefaultExtraCmdline))
 	c.Flags().Bool("bootloader-in-rootfs", false, "Fetch ISO bootloader binaries from the rootfs")
-	c.Flags().Var(firmType, "firmware", "Firmware to install, only 'efi' is currently supported")
-	_ = c.Flags().MarkDeprecated("firmware", "'firmware' is deprecated. only efi firmware is supported.")
+	c.Flags().Var(firmType, "firmware", "Firmware to boot, 'bios' creates a hybrid ISO for both legacy BIOS and EFI")
+	c.Flags().String("autoinstall", "", "Install config file embedded in the ISO to run an unattended installation at boot")
 	addPlatformFlags(c)
 	addCosignFlags(c)
 	addSquashFsCompressionFlags(c)
@@ -156,5 +167,35 @@ func NewBuildISO(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 	return c
 }
 
+// validateAutoInstall checks the given unattended installation config and its local cloud-init files
+func validateAutoInstall(cfg *types.BuildConfig, path string) error {
+	if ok, _ := utils.Exists(cfg.Fs, path); !ok {
+		return fmt.Errorf("invalid path '%s'", path)
+	}
+	install, _, err := config.ReadAutoInstallConfig(cfg, path)
+	if err != nil {
+		return err
+	}
+
+	var cloudInit []string
+	for _, ci := range install.CloudInit {
+		if local, _ := utils.IsLocalURI(ci); local {
+			u, _ := url.Parse(ci)
+			cloudInit = append(cloudInit, u.Path)
+		}
+	}
+	issues, err := cloudinit.NewValidator(vfs.OSFS, cfg.Runner).Validate(cloudInit...)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		cfg.Logger.Errorf(issue.String())
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("found %d issues in cloud-init files", len(issues))
+	}
+	return nil
+}
+
 // register the subcommand into rootCmd
 var _ = NewBuildISO(rootCmd, true)
@@ -36,11 +36,11 @@ var _ = Describe("BuidISO", Label("iso", "cmd"), func() {
 	AfterEach(func() {
 		viper.Reset()
 	})
-	It("Errors out setting firmware to anything else than efi", Label("flags"), func() {
-		_, _, err := executeCommandC(rootCmd, "build-iso", "--firmware", "bios")
+	It("Errors out setting firmware to anything else than efi or bios", Label("flags"), func() {
+		_, _, err := executeCommandC(rootCmd, "build-iso", "--firmware", "uboot")
 		Expect(err).ToNot(BeNil())
 		Expect(err.Error()).To(ContainSubstring("invalid argument"))
-		Expect(err.Error()).To(ContainSubstring("'bios' is not included in: efi"))
+		Expect(err.Error()).To(ContainSubstring("'uboot' is not included in: efi,bios"))
 	})
 	It("Errors out setting consign-key without setting cosign", Label("flags"), func() {
 		_, _, err := executeCommandC(rootCmd, "build-iso", "--cosign-key", "pubKey.url")
@@ -79,4 +79,11 @@ var _ = Describe("BuidISO", Label("iso", "cmd"), func() {
 		Expect(err).ToNot(BeNil())
 		Expect(err.Error()).To(ContainSubstring("Invalid path"))
 	})
+	It("Errors out if autoinstall config path does not exist", Label("flags"), func() {
+		_, _, err := executeCommandC(
+			rootCmd, "build-iso", "some/image:latest", "--autoinstall", "/nonexistingpath",
+		)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("invalid path"))
+	})
 })
@@ -0,0 +1,129 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/rancher/elemental-toolkit/v2/cmd/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// NewBuildNetboot returns a new instance of the build-netboot subcommand and appends it to
+// the root command. requireRoot is to initiate it with or without the CheckRoot
+// pre-run check. This method is mostly used for testing purposes.
+func NewBuildNetboot(root *cobra.Command, addCheckRoot bool) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "build-netboot SOURCE",
+		Short: "Build network boot artifacts for PXE and HTTP boot",
+		Long: "Build network boot artifacts for PXE and HTTP boot\n\n" +
+			"Creates the kernel, initrd and squashfs root of a live system together with an iPXE script\n" +
+			"and a grub configuration to boot it from the given base URL.\n\n" +
+			"SOURCE - should be provided as uri in following format <sourceType>:<sourceName>\n" +
+			"    * <sourceType> - might be [\"dir\", \"file\", \"oci\", \"docker\"], as default is \"docker\"\n" +
+			"    * <sourceName> - is path to file or directory, image name with tag version",
+		Args: cobra.MaximumNArgs(1),
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if addCheckRoot {
+				return CheckRoot()
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := exec.LookPath("mount")
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.StatFile)
+			}
+			mounter := types.NewMounter(path)
+
+			cfg, err := config.ReadConfigBuild(viper.GetString("config-dir"), cmd.Flags(), mounter)
+			if err != nil {
+				cfg.Logger.Errorf("Error reading config: %s\n", err)
+				return elementalError.NewFromError(err, elementalError.ReadingBuildConfig)
+			}
+
+			flags := cmd.Flags()
+			err = validateCosignFlags(cfg.Logger, flags)
+			if err != nil {
+				cfg.Logger.Errorf("flags validation failed: %v", err)
+				return elementalError.NewFromError(err, elementalError.CosignWrongFlags)
+			}
+
+			// Set this after parsing of the flags, so it fails on parsing and prints usage properly
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true // Do not propagate errors down the line, we control them
+			spec, err := config.ReadBuildNetboot(cfg, flags)
+			if err != nil {
+				cfg.Logger.Errorf("invalid build-netboot command setup %v", err)
+				return elementalError.NewFromError(err, elementalError.ReadingSpecConfig)
+			}
+
+			if len(args) == 1 {
+				imgSource, err := types.NewSrcFromURI(args[0])
+				if err != nil {
+					cfg.Logger.Errorf("not a valid rootfs source image argument: %s", args[0])
+					return elementalError.NewFromError(err, elementalError.IdentifySource)
+				}
+				spec.RootFS = []*types.ImageSource{imgSource}
+			} else if len(spec.RootFS) == 0 {
+				errmsg := "rootfs source image for building netboot artifacts was not provided"
+				cfg.Logger.Errorf(errmsg)
+				return elementalError.New(errmsg, elementalError.NoSourceProvided)
+			}
+
+			if spec.CloudConfig != "" {
+				if ok, err := utils.Exists(cfg.Fs, spec.CloudConfig); !ok {
+					msg := fmt.Sprintf("Invalid path '%s': %v", spec.CloudConfig, err)
+					cfg.Logger.Errorf(msg)
+					return elementalError.New(msg, elementalError.StatFile)
+				}
+			}
+
+			buildNetboot := action.NewBuildNetbootAction(cfg, spec)
+			err = buildNetboot.Run()
+			if err != nil {
+				cfg.Logger.Errorf("build-netboot command failed: %v", err)
+			}
+
+			return err
+		},
+	}
+
+	root.AddCommand(c)
+	c.Flags().StringP("name", "n", "", "Basename of the generated netboot files")
+	c.Flags().StringP("output", "o", "", "Output directory (defaults to current directory)")
+	c.Flags().Bool("date", false, "Adds a date suffix into the generated netboot files")
+	c.Flags().String("base-url", "", "HTTP or HTTPS URL the netboot files are served from")
+	c.Flags().String("cloud-config", "", "Cloud-config file run by the live system, it is fetched from the base URL")
+	c.Flags().String("extra-cmdline", "", fmt.Sprintf("Extra kernel cmdline (defaults to '%s')", constants.ISODefaultExtraCmdline))
+	addPlatformFlags(c)
+	addCosignFlags(c)
+	addSquashFsCompressionFlags(c)
+	addLocalImageFlag(c)
+	return c
+}
+
+// register the subcommand into rootCmd
+var _ = NewBuildNetboot(rootCmd, true)
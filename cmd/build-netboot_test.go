@@ -0,0 +1,67 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("BuildNetboot", Label("netboot", "cmd"), func() {
+	var buf *bytes.Buffer
+	BeforeEach(func() {
+		rootCmd = NewRootCmd()
+		_ = NewBuildNetboot(rootCmd, false)
+		buf = new(bytes.Buffer)
+		rootCmd.SetOut(buf)
+		rootCmd.SetErr(buf)
+	})
+	AfterEach(func() {
+		viper.Reset()
+	})
+	It("Errors out setting consign-key without setting cosign", Label("flags"), func() {
+		_, _, err := executeCommandC(rootCmd, "build-netboot", "--cosign-key", "pubKey.url")
+		Expect(err).ToNot(BeNil())
+		Expect(buf.String()).To(ContainSubstring("Usage:"))
+		Expect(err.Error()).To(ContainSubstring("'cosign-key' requires 'cosign' option to be enabled"))
+	})
+	It("Errors out if no base URL is defined", Label("flags"), func() {
+		_, _, err := executeCommandC(rootCmd, "build-netboot", "some/image:latest")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("undefined base URL"))
+	})
+	It("Errors out if the base URL is not an HTTP URL", Label("flags"), func() {
+		_, _, err := executeCommandC(rootCmd, "build-netboot", "--base-url", "tftp://pxe/elemental", "some/image:latest")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("invalid base URL"))
+	})
+	It("Errors out if no rootfs sources are defined", Label("flags"), func() {
+		_, _, err := executeCommandC(rootCmd, "build-netboot", "--base-url", "http://pxe/elemental")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("rootfs source image for building netboot artifacts was not provided"))
+	})
+	It("Errors out if the cloud-config file does not exist", Label("flags"), func() {
+		_, _, err := executeCommandC(
+			rootCmd, "build-netboot", "--base-url", "http://pxe/elemental", "--cloud-config", "/nonexistingpath", "some/image:latest",
+		)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Invalid path"))
+	})
+})
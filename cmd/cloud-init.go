@@ -17,16 +17,19 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
 	"io"
 	"os"
 
 	"github.com/rancher/elemental-toolkit/v2/cmd/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
 	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 
 	"github.com/rancher/yip/pkg/schema"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/twpayne/go-vfs/v4"
 )
 
 func NewCloudInitCmd(root *cobra.Command) *cobra.Command {
@@ -75,5 +78,48 @@ func NewCloudInitCmd(root *cobra.Command) *cobra.Command {
 	return c
 }
 
-// register the subcommand into rootCmd
-var _ = NewCloudInitCmd(rootCmd)
+func NewCloudInitValidateCmd(root *cobra.Command) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "validate PATH...",
+		Short: "Validates cloud-init files without running them",
+		Long: "Validates cloud-init files or directories against the schema. Unknown keys and stages, invalid\n" +
+			"'if' and 'node' conditionals, unknown 'after' dependencies and relative paths of the files written\n" +
+			"are reported with their line numbers. Commands are only checked to exist within the --root tree.",
+		Args: cobra.MinimumNArgs(1),
+		PreRun: func(cmd *cobra.Command, _ []string) {
+			_ = viper.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.ReadConfigRun(viper.GetString("config-dir"), cmd.Flags(), types.NewDummyMounter())
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.ReadingRunConfig)
+			}
+			cmd.SilenceUsage = true
+
+			stages, _ := cmd.Flags().GetStringSlice("allow-stage")
+			rootDir, _ := cmd.Flags().GetString("root")
+			issues, err := cloudinit.NewValidator(
+				vfs.OSFS, cfg.Runner, cloudinit.WithStages(stages...), cloudinit.WithRootDir(rootDir),
+			).Validate(args...)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.CloudInitValidate)
+			}
+
+			for _, issue := range issues {
+				fmt.Fprintln(cmd.OutOrStdout(), issue.String())
+			}
+			if len(issues) > 0 {
+				return elementalError.New(fmt.Sprintf("found %d issues in cloud-init files", len(issues)), elementalError.CloudInitValidate)
+			}
+			return nil
+		},
+	}
+	root.AddCommand(c)
+	c.Flags().StringSlice("allow-stage", []string{}, "Additional stage names to accept")
+	c.Flags().String("root", "", "Root tree where absolute command paths must exist")
+	return c
+}
+
+// register the subcommands into rootCmd
+var cloudInitCmd = NewCloudInitCmd(rootCmd)
+var _ = NewCloudInitValidateCmd(cloudInitCmd)
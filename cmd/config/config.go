@@ -249,6 +249,72 @@ func ReadInstallSpec(r *types.RunConfig, flags *pflag.FlagSet) (*types.InstallSp
 	return install, err
 }
 
+func ReadAutoInstallSpec(r *types.RunConfig, flags *pflag.FlagSet) (*types.AutoInstallSpec, error) {
+	auto := config.NewAutoInstallSpec()
+	vp := viper.Sub("autoinstall")
+	if vp == nil {
+		vp = viper.New()
+	}
+	// Bind autoinstall cmd flags
+	bindGivenFlags(vp, flags)
+	// Bind autoinstall env vars
+	viperReadEnv(vp, "AUTOINSTALL", constants.GetAutoInstallKeyEnvMap())
+
+	err := vp.Unmarshal(auto, setDecoder, decodeHook)
+	if err != nil {
+		r.Logger.Warnf("error unmarshalling AutoInstallSpec: %s", err)
+	}
+	err = auto.Sanitize()
+	r.Logger.Debugf("Loaded autoinstall spec: %s", litter.Sdump(auto))
+	return auto, err
+}
+
+// ReadAutoInstallConfig reads and validates the given unattended installation config file. It is
+// read as the config.yaml of an installation run from the live system, so the install spec
+// defaults to the live system as installation source.
+func ReadAutoInstallConfig(b *types.BuildConfig, path string) (*types.InstallSpec, *types.AutoInstallSpec, error) {
+	vp := viper.New()
+	vp.SetConfigFile(path)
+	vp.SetConfigType("yaml")
+	err := vp.ReadInConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading autoinstall config '%s': %w", path, err)
+	}
+
+	install := config.NewInstallSpec(b.Config)
+	if sub := vp.Sub("install"); sub != nil {
+		err = sub.Unmarshal(install, setDecoder, decodeHook)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed unmarshalling install spec: %w", err)
+		}
+	}
+	if install.System.IsEmpty() && install.Iso == "" {
+		install.System = types.NewDirSrc(constants.ISOBaseTree)
+	}
+	err = install.Sanitize()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid install spec: %w", err)
+	}
+
+	auto := config.NewAutoInstallSpec()
+	if sub := vp.Sub("autoinstall"); sub != nil {
+		err = sub.Unmarshal(auto, setDecoder, decodeHook)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed unmarshalling autoinstall spec: %w", err)
+		}
+	}
+	err = auto.Sanitize()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid autoinstall spec: %w", err)
+	}
+	if vp.GetBool("reboot") && vp.GetBool("poweroff") {
+		return nil, nil, fmt.Errorf("reboot and poweroff can't be set at the same time")
+	}
+
+	b.Logger.Debugf("Loaded autoinstall config: %s %s", litter.Sdump(install), litter.Sdump(auto))
+	return install, auto, nil
+}
+
 func ReadInitSpec(r *types.RunConfig, flags *pflag.FlagSet) (*types.InitSpec, error) {
 	init := config.NewInitSpec()
 	vp := viper.Sub("init")
@@ -373,13 +439,13 @@ func applyMountEnvVars(r *types.RunConfig, mount *types.MountSpec) error {
 	rwPaths := os.Getenv("RW_PATHS")
 	if rwPaths != "" {
 		r.Logger.Debugf("Setting ephemeral paths based on RW_PATHS")
-		mount.Ephemeral.Paths = strings.Split(rwPaths, " ")
+		mount.Ephemeral.Paths = types.NewEphemeralPaths(strings.Split(rwPaths, " ")...)
 	}
 
 	persistentPaths := os.Getenv("PERSISTENT_STATE_PATHS")
 	if persistentPaths != "" {
 		r.Logger.Debugf("Setting persistent paths based on PERSISTENT_STATE_PATHS")
-		mount.Persistent.Paths = strings.Split(persistentPaths, " ")
+		mount.Persistent.Paths = types.NewPersistentPaths(strings.Split(persistentPaths, " ")...)
 	}
 
 	persistentBind := os.Getenv("PERSISTENT_STATE_BIND")
@@ -444,6 +510,26 @@ func ReadResetSpec(r *types.RunConfig, flags *pflag.FlagSet) (*types.ResetSpec,
 	return reset, err
 }
 
+func ReadRepartitionSpec(r *types.RunConfig, flags *pflag.FlagSet) (*types.RepartitionSpec, error) {
+	repartition := config.NewRepartitionSpec(r.Config)
+	vp := viper.Sub("repartition")
+	if vp == nil {
+		vp = viper.New()
+	}
+	// Bind repartition cmd flags
+	bindGivenFlags(vp, flags)
+	// Bind repartition env vars
+	viperReadEnv(vp, "REPARTITION", constants.GetRepartitionKeyEnvMap())
+
+	err := vp.Unmarshal(repartition, setDecoder, decodeHook)
+	if err != nil {
+		r.Logger.Warnf("error unmarshalling RepartitionSpec: %s", err)
+	}
+	err = repartition.Sanitize()
+	r.Logger.Debugf("Loaded repartition spec: %s", litter.Sdump(repartition))
+	return repartition, err
+}
+
 func ReadUpgradeSpec(r *types.RunConfig, flags *pflag.FlagSet, recoveryOnly bool) (*types.UpgradeSpec, error) {
 	upgrade, err := config.NewUpgradeSpec(r.Config)
 	if err != nil {
@@ -492,6 +578,26 @@ func ReadBuildISO(b *types.BuildConfig, flags *pflag.FlagSet) (*types.LiveISO, e
 	return iso, err
 }
 
+func ReadBuildNetboot(b *types.BuildConfig, flags *pflag.FlagSet) (*types.Netboot, error) {
+	netboot := config.NewNetboot()
+	vp := viper.Sub("netboot")
+	if vp == nil {
+		vp = viper.New()
+	}
+	// Bind build-netboot cmd flags
+	bindGivenFlags(vp, flags)
+	// Bind build-netboot env vars
+	viperReadEnv(vp, "NETBOOT", constants.GetNetbootKeyEnvMap())
+
+	err := vp.Unmarshal(netboot, setDecoder, decodeHook)
+	if err != nil {
+		b.Logger.Warnf("error unmarshalling Netboot: %s", err)
+	}
+	err = netboot.Sanitize()
+	b.Logger.Debugf("Loaded Netboot: %s", litter.Sdump(netboot))
+	return netboot, err
+}
+
 func ReadBuildDisk(b *types.BuildConfig, flags *pflag.FlagSet) (*types.DiskSpec, error) {
 	disk := config.NewDisk(b)
 	vp := viper.Sub("disk")
@@ -183,6 +183,39 @@ var _ = Describe("Config", Label("config"), func() {
 				Expect(iso.Label).To(Equal("LIVE_LABEL"))
 			})
 		})
+		Describe("Netboot spec", Label("netboot"), func() {
+			It("initiates a Netboot spec", func() {
+				netboot, err := ReadBuildNetboot(cfg, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				// From config file
+				Expect(netboot.RootFS[0].Value()).To(Equal("system/cos:latest"))
+				Expect(netboot.BaseURL).To(Equal("http://pxe.example.org/cos"))
+				// Defaults
+				Expect(netboot.ExtraCmdline).To(Equal(constants.ISODefaultExtraCmdline))
+			})
+		})
+		Describe("Autoinstall config", Label("autoinstall"), func() {
+			It("reads and validates an autoinstall config file", func() {
+				install, auto, err := ReadAutoInstallConfig(cfg, "fixtures/autoinstall/config.yaml")
+				Expect(err).ShouldNot(HaveOccurred())
+
+				// Defaults to the live system as installation source
+				Expect(install.System.Value()).To(Equal(constants.ISOBaseTree))
+				Expect(install.CloudInit).To(Equal([]string{"https://example.org/cloud-init.yaml"}))
+				Expect(install.Partitions.Persistent.Size).To(Equal(uint(2048)))
+				Expect(auto.Countdown).To(Equal(uint(5)))
+				Expect(auto.Target.Model).To(Equal("QEMU*"))
+				Expect(auto.Target.MinSize).To(Equal(uint(16384)))
+			})
+			It("fails on invalid autoinstall config files", func() {
+				_, _, err := ReadAutoInstallConfig(cfg, "fixtures/autoinstall/invalid.yaml")
+				Expect(err).To(MatchError(ContainSubstring("invalid autoinstall spec")))
+
+				_, _, err = ReadAutoInstallConfig(cfg, "fixtures/autoinstall/nonexisting.yaml")
+				Expect(err).To(MatchError(ContainSubstring("failed reading autoinstall config")))
+			})
+		})
 		Describe("RawDisk spec", Label("disk"), func() {
 			It("initiates a RawDisk spec", func() {
 				disk, err := ReadBuildDisk(cfg, nil)
@@ -383,6 +416,19 @@ var _ = Describe("Config", Label("config"), func() {
 				Expect(spec.CloudInit[1]).To(Equal("/absolute/path/to/file2.yaml"))
 			})
 		})
+		Describe("Read AutoInstallSpec", Label("autoinstall"), func() {
+			It("inits an autoinstall spec according to given configs", func() {
+				flags := pflag.NewFlagSet("testflags", 1)
+				flags.Bool("overwrite", false, "testing flag")
+				flags.Set("overwrite", "true")
+
+				auto, err := ReadAutoInstallSpec(cfg, flags)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(auto.Countdown).To(Equal(uint(3)))
+				Expect(auto.Target.Serial).To(Equal("S4EW*"))
+				Expect(auto.Overwrite).To(BeTrue())
+			})
+		})
 		Describe("Read ResetSpec", Label("install"), func() {
 			var flags *pflag.FlagSet
 			var bootedFrom string
@@ -561,6 +607,11 @@ var _ = Describe("Config", Label("config"), func() {
 				Expect(spec.Mode).To(Equal("active"))
 				Expect(spec.Sysroot).To(Equal("/newroot"))
 				Expect(spec.SelinuxRelabel).To(BeFalse())
+				Expect(spec.Persistent.Paths).To(Equal([]types.PersistentPath{
+					{Path: "/opt", Mode: constants.BindMode},
+					{Path: "/etc/ssh", Mode: constants.OverlayMode},
+					{Path: "/var/lib/rancher", Mode: constants.BindMode},
+				}))
 			})
 			It("picks kernel cmdline first then env-vars", func() {
 				_ = os.Setenv("ELEMENTAL_MOUNT_IMAGE", "passive")
@@ -29,3 +29,15 @@ func CheckRoot() error {
 	}
 	return nil
 }
+
+// CheckNamespaceRoot is a helper to return on PreRunE for commands that do not require root privileges
+// on the host, but must run as root within a user namespace to preserve file ownership
+func CheckNamespaceRoot() error {
+	if os.Geteuid() != 0 {
+		return errors.New(
+			"this command requires uid 0, run it as root or within a user namespace mapping the current user to root",
+			errors.RequiresRoot,
+		)
+	}
+	return nil
+}
@@ -97,8 +97,8 @@ func NewInstallCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 			return err
 		},
 	}
-	firmType := newEnumFlag([]string{types.EFI}, types.EFI)
-	pTableType := newEnumFlag([]string{types.GPT}, types.GPT)
+	firmType := newEnumFlag([]string{types.EFI, types.BIOS}, types.EFI)
+	pTableType := newEnumFlag([]string{types.GPT, types.MSDOS}, types.GPT)
 	snapshotterType := newEnumFlag(
 		[]string{constants.LoopDeviceSnapshotterType, constants.BtrfsSnapshotterType},
 		constants.LoopDeviceSnapshotterType,
@@ -109,15 +109,13 @@ func NewInstallCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
 	c.Flags().StringP("iso", "i", "", "Performs an installation from the ISO url")
 	c.Flags().Bool("no-format", false, "Don’t format disks. It is implied that COS_STATE, COS_RECOVERY, COS_PERSISTENT, COS_OEM are already existing")
 
-	c.Flags().Var(firmType, "firmware", "Firmware to install, only 'efi' is currently supported")
-	_ = c.Flags().MarkDeprecated("firmware", "'firmware' is deprecated. only efi firmware is supported.")
-
-	c.Flags().Var(pTableType, "part-table", "Partition table type to use, only GPT type is currently supported")
-	_ = c.Flags().MarkDeprecated("part-table", "'part-table' is deprecated. only GPT type is supported.")
+	c.Flags().Var(firmType, "firmware", "Firmware to install, 'bios' installs grub for both legacy BIOS and EFI")
+	c.Flags().Var(pTableType, "part-table", "Partition table type to use")
 
 	c.Flags().Bool("force", false, "Force install")
 	c.Flags().Bool("eject-cd", false, "Try to eject the cd on reboot, only valid if booting from iso")
 	c.Flags().Bool("disable-boot-entry", false, "Dont create an EFI entry for the system install.")
+	c.Flags().StringSlice("mirror-targets", []string{}, "Additional target devices to mirror the installation into using RAID1")
 	c.Flags().Var(snapshotterType, "snapshotter.type", "Sets the snapshotter type to install")
 	c.Flags().StringSlice("cloud-init-paths", []string{}, "Cloud-init config files to run during install")
 	addSharedInstallUpgradeFlags(c)
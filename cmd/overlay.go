@@ -0,0 +1,122 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/rancher/elemental-toolkit/v2/cmd/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+func NewOverlayCmd(root *cobra.Command) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "overlay",
+		Short: "Inspects the ephemeral and persistent paths on top of the immutable system",
+		Args:  cobra.ExactArgs(0),
+	}
+	root.AddCommand(c)
+	return c
+}
+
+func NewOverlayDiffCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "diff [PATH...]",
+		Short: "Shows the changes of the ephemeral and persistent paths compared to the image",
+		Long: "Shows the added (A), modified (M), deleted (D) and opaque (O) entries of each ephemeral and\n" +
+			"persistent path compared to the image. Opaque directories hide the image directory contents.",
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if addCheckRoot {
+				return CheckRoot()
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			viper.SetDefault("quiet", true) // Prevents any other writes to stdout
+			path, err := exec.LookPath("mount")
+			if err != nil {
+				return err
+			}
+			mounter := types.NewMounter(path)
+
+			cfg, err := config.ReadConfigRun(viper.GetString("config-dir"), cmd.Flags(), mounter)
+			if err != nil {
+				cfg.Logger.Errorf("Error reading config: %s\n", err)
+				return elementalError.NewFromError(err, elementalError.ReadingRunConfig)
+			}
+
+			cmd.SilenceUsage = true
+			spec, err := config.ReadMountSpec(cfg, nil)
+			if err != nil {
+				cfg.Logger.Errorf("Error reading spec: %s\n", err)
+				return elementalError.NewFromError(err, elementalError.ReadingSpecConfig)
+			}
+
+			reset, _ := cmd.Flags().GetString("reset")
+			if reset != "" {
+				return action.ResetPersistentPath(cfg, spec, reset)
+			}
+
+			content, _ := cmd.Flags().GetBool("content")
+			diffs, err := action.NewOverlayDiffAction(
+				cfg, spec, action.WithOverlayDiffPaths(args...), action.WithOverlayDiffContent(content),
+			).Run()
+			if err != nil {
+				cfg.Logger.Errorf("overlay diff command failed: %v", err)
+				return err
+			}
+
+			if err = writeOverlayDiffs(cmd.OutOrStdout(), diffs); err != nil {
+				cfg.Logger.Errorf("Error writing overlay changes on stdout: %s\n", err)
+				return elementalError.NewFromError(err, elementalError.OverlayDiff)
+			}
+			return nil
+		},
+	}
+	root.AddCommand(c)
+	c.Flags().Bool("content", false, "Include the content diffs of modified files")
+	c.Flags().String("reset", "", "Drop the persisted changes of the given path on next boot")
+	return c
+}
+
+// writeOverlayDiffs writes the changes of each path in a human readable format
+func writeOverlayDiffs(w io.Writer, diffs []action.OverlayDiff) error {
+	var out strings.Builder
+	for _, diff := range diffs {
+		fmt.Fprintf(&out, "%s (%s, %s): %d changes\n", diff.Path, diff.Kind, diff.Mode, len(diff.Changes))
+		for _, change := range diff.Changes {
+			fmt.Fprintf(&out, "%s\t%s\n", change.Type, change.Path)
+			if change.Diff != "" {
+				out.WriteString(change.Diff)
+			}
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// register the subcommands into rootCmd
+var overlayCmd = NewOverlayCmd(rootCmd)
+var _ = NewOverlayDiffCmd(overlayCmd, true)
@@ -0,0 +1,79 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os/exec"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/rancher/elemental-toolkit/v2/cmd/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+func NewRepartitionCmd(root *cobra.Command, addCheckRoot bool) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "repartition",
+		Short: "Resizes or adds partitions on an existing installation",
+		Long: "Resizes or adds partitions on an existing installation according to the 'repartition' configuration.\n" +
+			"Resized partitions must not be mounted, hence this is usually run from the recovery system.",
+		Args: cobra.ExactArgs(0),
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if addCheckRoot {
+				return CheckRoot()
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			path, err := exec.LookPath("mount")
+			if err != nil {
+				return err
+			}
+			mounter := types.NewMounter(path)
+
+			cfg, err := config.ReadConfigRun(viper.GetString("config-dir"), cmd.Flags(), mounter)
+			if err != nil {
+				cfg.Logger.Errorf("Error reading config: %s\n", err)
+				return elementalError.NewFromError(err, elementalError.ReadingRunConfig)
+			}
+
+			cmd.SilenceUsage = true
+			spec, err := config.ReadRepartitionSpec(cfg, cmd.Flags())
+			if err != nil {
+				cfg.Logger.Errorf("invalid repartition command setup %v", err)
+				return elementalError.NewFromError(err, elementalError.ReadingSpecConfig)
+			}
+
+			cfg.Logger.Infof("Repartition called")
+			err = action.NewRepartitionAction(cfg, spec).Run()
+			if err != nil {
+				cfg.Logger.Errorf("repartition command failed: %v", err)
+			}
+
+			return err
+		},
+	}
+	root.AddCommand(c)
+	c.Flags().StringP("target", "t", "", "Target device to repartition, defaults to the disk of the current installation")
+	return c
+}
+
+// register the subcommand into rootCmd
+var _ = NewRepartitionCmd(rootCmd, true)
@@ -17,10 +17,16 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"strings"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/rancher/elemental-toolkit/v2/cmd/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
@@ -40,6 +46,24 @@ func NewRunStage(root *cobra.Command) *cobra.Command {
 
 			cmd.SilenceUsage = true
 
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if dryRun {
+				rootDir, _ := cmd.Flags().GetString("root")
+				report, err := cloudinit.SimulateStage(cfg.Config, rootDir, args[0], cfg.Strict, cfg.CloudInitPaths...)
+				if report != nil {
+					if werr := writeSandboxReport(cmd.OutOrStdout(), report); werr != nil {
+						cfg.Logger.Errorf("Error writing simulated changes on stdout: %s\n", werr)
+					}
+				}
+				return elementalError.NewFromError(err, elementalError.CloudInitRunStage)
+			}
+
+			if trace, _ := cmd.Flags().GetBool("trace"); trace {
+				if ci, ok := cfg.CloudInitRunner.(*cloudinit.YipCloudInitRunner); ok {
+					ci.SetTraceFile(constants.StageTraceFile)
+				}
+			}
+
 			err = utils.RunStage(&cfg.Config, args[0], cfg.Strict, cfg.CloudInitPaths...)
 			return elementalError.NewFromError(err, elementalError.CloudInitRunStage)
 		},
@@ -47,8 +71,36 @@ func NewRunStage(root *cobra.Command) *cobra.Command {
 	root.AddCommand(c)
 	c.Flags().Bool("strict", false, "Set strict checking for errors, i.e. fail if errors were found")
 	c.Flags().StringSlice("cloud-init-paths", []string{}, "Cloud-init config files to run")
+	c.Flags().String("root", "", "Sandbox root directory to run the stage against, requires --dry-run")
+	c.Flags().Bool("dry-run", false, "Simulate the stage in the --root directory and report the resulting changes")
+	c.MarkFlagsRequiredTogether("root", "dry-run")
+	c.Flags().Bool("trace", false, "Time each stage, file and step and append the traces to "+constants.StageTraceFile)
 	return c
 }
 
+// writeSandboxReport writes the changes of a simulated stage in a human readable format
+func writeSandboxReport(w io.Writer, report *cloudinit.SandboxReport) error {
+	var out strings.Builder
+	sections := []struct {
+		title   string
+		entries []string
+	}{
+		{"Files written", report.Files},
+		{"Users created", report.Users},
+		{"Units enabled", report.Units},
+		{"Commands", report.Commands},
+		{"Conditionals (assumed true)", report.Conditionals},
+		{"Skipped", report.Skipped},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(&out, "%s: %d\n", section.title, len(section.entries))
+		for _, entry := range section.entries {
+			fmt.Fprintf(&out, "\t%s\n", entry)
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
 // register the subcommand into rootCmd
 var _ = NewRunStage(rootCmd)
@@ -0,0 +1,105 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/twpayne/go-vfs/v4"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+)
+
+func NewStageCmd(root *cobra.Command) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "stage",
+		Short: "Inspects the cloud-init stages run on boot",
+		Args:  cobra.ExactArgs(0),
+	}
+	root.AddCommand(c)
+	return c
+}
+
+func NewStageReportCmd(root *cobra.Command) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "report",
+		Short: "Shows the slowest steps and the failures of the stages run on the current boot",
+		Long: "Shows the duration of each stage and source, the slowest steps and the failed steps of the\n" +
+			"stages run with 'run-stage --trace' on the current boot.",
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			file, _ := cmd.Flags().GetString("file")
+			top, _ := cmd.Flags().GetInt("top")
+
+			trace, err := cloudinit.ReadBootTrace(vfs.OSFS, file)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.ReadFile)
+			}
+			if err = writeBootTrace(cmd.OutOrStdout(), trace, top); err != nil {
+				return elementalError.NewFromError(err, elementalError.StageReport)
+			}
+			return nil
+		},
+	}
+	root.AddCommand(c)
+	c.Flags().String("file", constants.StageTraceFile, "Stage trace file to report")
+	c.Flags().Int("top", 10, "Number of slowest steps to show")
+	return c
+}
+
+// writeBootTrace writes the stage durations, slowest steps and failures of a boot in a human readable format
+func writeBootTrace(w io.Writer, trace *cloudinit.BootTrace, top int) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Boot %s: %s in %d stage runs\n", trace.BootID, round(trace.Duration()), len(trace.Stages))
+	for _, stage := range trace.Stages {
+		status := ""
+		if stage.Failed() {
+			status = " (failed)"
+		}
+		fmt.Fprintf(&out, "\t%s\t%s\t%s%s\n", round(stage.Duration), stage.Stage, stage.Source, status)
+	}
+
+	steps := trace.SlowestSteps(top)
+	fmt.Fprintf(&out, "Slowest steps: %d\n", len(steps))
+	for _, step := range steps {
+		fmt.Fprintf(&out, "\t%s\t%s\t%s\t%s\n", round(step.Duration), step.Stage, step.Name, step.File)
+	}
+
+	failures := trace.Failures()
+	fmt.Fprintf(&out, "Failures: %d\n", len(failures))
+	for _, step := range failures {
+		fmt.Fprintf(&out, "\t%s\t%s\t%s: %s\n", step.Stage, step.Name, step.File, strings.Join(step.Errors, "; "))
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// round rounds durations to milliseconds for display
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+// register the subcommands into rootCmd
+var stageCmd = NewStageCmd(rootCmd)
+var _ = NewStageReportCmd(stageCmd)
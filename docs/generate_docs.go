@@ -35,18 +35,29 @@ import (
 
 func main() {
 	rootCmd := cmd.NewRootCmd()
+	overlayCmd := cmd.NewOverlayCmd(rootCmd)
+	cloudInitCmd := cmd.NewCloudInitCmd(rootCmd)
+	stageCmd := cmd.NewStageCmd(rootCmd)
 	for _, command := range []*cobra.Command{
 		rootCmd,
+		cmd.NewAutoInstallCmd(rootCmd, false),
 		cmd.NewBuildISO(rootCmd, false),
-		cmd.NewCloudInitCmd(rootCmd),
+		cmd.NewBuildNetboot(rootCmd, false),
+		cloudInitCmd,
+		cmd.NewCloudInitValidateCmd(cloudInitCmd),
+		stageCmd,
+		cmd.NewStageReportCmd(stageCmd),
 		cmd.NewInstallCmd(rootCmd, false),
 		cmd.NewPullImageCmd(rootCmd, false),
 		cmd.NewResetCmd(rootCmd, false),
+		cmd.NewRepartitionCmd(rootCmd, false),
 		cmd.NewRunStage(rootCmd),
 		cmd.NewUpgradeCmd(rootCmd, false),
 		cmd.NewUpgradeRecoveryCmd(rootCmd, false),
 		cmd.NewVersionCmd(rootCmd),
 		cmd.NewStateCmd(rootCmd),
+		overlayCmd,
+		cmd.NewOverlayDiffCmd(overlayCmd, false),
 	} {
 		// Disables the line AUTOGENERATED BY ... ON DATE
 		command.DisableAutoGenTag = true
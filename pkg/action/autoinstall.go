@@ -0,0 +1,201 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/block"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+type AutoInstallAction struct {
+	cfg      *types.RunConfig
+	spec     *types.InstallSpec
+	auto     *types.AutoInstallSpec
+	opts     []InstallActionOption
+	interval time.Duration
+}
+
+type AutoInstallActionOption func(a *AutoInstallAction)
+
+// WithAutoInstallOptions sets the options of the install action run by the unattended installation
+func WithAutoInstallOptions(opts ...InstallActionOption) AutoInstallActionOption {
+	return func(a *AutoInstallAction) {
+		a.opts = append(a.opts, opts...)
+	}
+}
+
+// WithCountdownInterval sets the interval of each countdown step, defaults to one second
+func WithCountdownInterval(interval time.Duration) AutoInstallActionOption {
+	return func(a *AutoInstallAction) {
+		a.interval = interval
+	}
+}
+
+func NewAutoInstallAction(cfg *types.RunConfig, spec *types.InstallSpec, auto *types.AutoInstallSpec, opts ...AutoInstallActionOption) *AutoInstallAction {
+	a := &AutoInstallAction{cfg: cfg, spec: spec, auto: auto, interval: time.Second}
+	for _, o := range opts {
+		o(a)
+	}
+	return a
+}
+
+// Run selects the target disk, checks it does not include an Elemental installation and, after the
+// configured countdown, runs the installation on it
+func (a *AutoInstallAction) Run() error {
+	disks, err := listDisks()
+	if err != nil {
+		a.cfg.Logger.Errorf("failed listing disks: %v", err)
+		return elementalError.NewFromError(err, elementalError.SelectTarget)
+	}
+
+	if a.spec.Target == "" {
+		disk, err := a.SelectTarget(disks)
+		if err != nil {
+			a.cfg.Logger.Errorf("failed selecting the target disk: %v", err)
+			return elementalError.NewFromError(err, elementalError.SelectTarget)
+		}
+		a.spec.Target = filepath.Join("/dev", disk.Name)
+	}
+
+	// All disks wiped by the installation must be known and free of Elemental installations
+	for _, target := range append([]string{a.spec.Target}, a.spec.MirrorTargets...) {
+		disk := findDisk(disks, target)
+		if disk == nil {
+			msg := fmt.Sprintf("could not find target %s, refusing to run an unattended installation on it", target)
+			a.cfg.Logger.Errorf(msg)
+			return elementalError.New(msg, elementalError.SelectTarget)
+		}
+		if !a.auto.Overwrite && a.isElementalDisk(disk) {
+			msg := fmt.Sprintf("target %s already contains an Elemental installation, set 'overwrite' to install over it", target)
+			a.cfg.Logger.Errorf(msg)
+			return elementalError.New(msg, elementalError.AlreadyInstalled)
+		}
+	}
+
+	for i := a.auto.Countdown; i > 0; i-- {
+		a.cfg.Logger.Infof("Installing to %s in %d seconds...", a.spec.Target, i)
+		time.Sleep(a.interval)
+	}
+
+	a.cfg.Logger.Infof("Starting unattended installation to %s", a.spec.Target)
+	install, err := NewInstallAction(a.cfg, a.spec, a.opts...)
+	if err != nil {
+		a.cfg.Logger.Errorf("failed to initialize install action: %v", err)
+		return err
+	}
+	return install.Run()
+}
+
+// SelectTarget returns the first of the given disks, sorted by name, matching all the target rules.
+// Optical, floppy, empty and virtual drives, disks with mounted partitions and the disk backing the
+// live media, even if mounted as a whole, are never selected.
+func (a *AutoInstallAction) SelectTarget(disks []*block.Disk) (*block.Disk, error) {
+	sorted := slices.Clone(disks)
+	slices.SortFunc(sorted, func(x, y *block.Disk) int { return strings.Compare(x.Name, y.Name) })
+	live := a.liveDisk(sorted)
+
+	for _, disk := range sorted {
+		if disk.DriveType == block.DriveTypeODD || disk.DriveType == block.DriveTypeFDD || disk.SizeBytes == 0 {
+			continue
+		}
+		if disk.StorageController == block.StorageControllerLoop || disk.StorageController == block.StorageControllerUnknown {
+			continue
+		}
+		if disk == live {
+			a.cfg.Logger.Debugf("Skipping disk %s, it backs the live media", disk.Name)
+			continue
+		}
+		if slices.ContainsFunc(disk.Partitions, func(p *block.Partition) bool { return p.MountPoint != "" }) {
+			a.cfg.Logger.Debugf("Skipping disk %s, it has mounted partitions", disk.Name)
+			continue
+		}
+		if a.auto.Target.Matches(filepath.Join("/dev", disk.Name), disk.Model, disk.SerialNumber, disk.SizeBytes) {
+			a.cfg.Logger.Infof("Selected target disk %s (model: %s, serial: %s)", disk.Name, disk.Model, disk.SerialNumber)
+			return disk, nil
+		}
+	}
+	return nil, fmt.Errorf("no disk matches the autoinstall target rules")
+}
+
+// liveDisk returns the disk backing the live media mount point, either the whole disk or one of its
+// partitions, nil if not found
+func (a *AutoInstallAction) liveDisk(disks []*block.Disk) *block.Disk {
+	out, err := a.cfg.Runner.Run("findmnt", "-n", "-f", "-o", "SOURCE", "--mountpoint", constants.LiveDir)
+	source := strings.TrimSpace(string(out))
+	if err != nil || source == "" {
+		return nil
+	}
+	if resolved, err := utils.ResolveLink(a.cfg.Fs, source, "/", constants.MaxLinkDepth); err == nil {
+		source = resolved
+	}
+	name := filepath.Base(source)
+	for _, disk := range disks {
+		if disk.Name == name || slices.ContainsFunc(disk.Partitions, func(p *block.Partition) bool { return p.Name == name }) {
+			return disk
+		}
+	}
+	return nil
+}
+
+// isElementalDisk checks if any partition of the given disk is an Elemental state or recovery partition
+func (a *AutoInstallAction) isElementalDisk(disk *block.Disk) bool {
+	labels := []string{constants.StateLabel, constants.RecoveryLabel}
+	for _, part := range []*types.Partition{a.spec.Partitions.State, a.spec.Partitions.Recovery} {
+		if part != nil && part.FilesystemLabel != "" {
+			labels = append(labels, part.FilesystemLabel)
+		}
+	}
+	for _, part := range disk.Partitions {
+		if slices.Contains(labels, part.FilesystemLabel) {
+			return true
+		}
+		if part.Label == constants.StatePartName || part.Label == constants.RecoveryPartName {
+			return true
+		}
+	}
+	return false
+}
+
+// findDisk returns the disk of the given device path, nil if not found
+func findDisk(disks []*block.Disk, device string) *block.Disk {
+	for _, d := range disks {
+		if filepath.Join("/dev", d.Name) == device {
+			return d
+		}
+	}
+	return nil
+}
+
+// listDisks returns all the disks of the host
+func listDisks() ([]*block.Disk, error) {
+	blockDevices, err := block.New(ghw.WithDisableTools(), ghw.WithDisableWarnings())
+	if err != nil {
+		return nil, err
+	}
+	return blockDevices.Disks, nil
+}
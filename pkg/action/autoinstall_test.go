@@ -0,0 +1,231 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action_test
+
+import (
+	"bytes"
+	"slices"
+	"time"
+
+	"github.com/jaypipes/ghw/pkg/block"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	conf "github.com/rancher/elemental-toolkit/v2/pkg/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+const gib = 1024 * 1024 * 1024
+
+var _ = Describe("Autoinstall action tests", Label("autoinstall"), func() {
+	var config *types.RunConfig
+	var runner *mocks.FakeRunner
+	var fs vfs.FS
+	var logger types.Logger
+	var cleanup func()
+	var memLog *bytes.Buffer
+	var ghwTest mocks.GhwMock
+	var spec *types.InstallSpec
+	var auto *types.AutoInstallSpec
+	var autoInstall *action.AutoInstallAction
+
+	BeforeEach(func() {
+		runner = mocks.NewFakeRunner()
+		memLog = &bytes.Buffer{}
+		logger = types.NewBufferLogger(memLog)
+		logger.SetLevel(types.DebugLevel())
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{})
+		Expect(err).Should(BeNil())
+
+		config = conf.NewRunConfig(
+			conf.WithFs(fs),
+			conf.WithRunner(runner),
+			conf.WithLogger(logger),
+			conf.WithMounter(mocks.NewFakeMounter()),
+			conf.WithSyscall(&mocks.FakeSyscall{}),
+			conf.WithClient(&mocks.FakeHTTPClient{}),
+			conf.WithCloudInitRunner(&mocks.FakeCloudInitRunner{}),
+			conf.WithPlatform("linux/amd64"),
+		)
+
+		spec = conf.NewInstallSpec(config.Config)
+		spec.System = types.NewDirSrc(constants.ISOBaseTree)
+		Expect(spec.Sanitize()).To(Succeed())
+		auto = conf.NewAutoInstallSpec()
+		auto.Countdown = 0
+
+		ghwTest = mocks.GhwMock{}
+		ghwTest.AddDisk(block.Disk{
+			Name: "sda", SizeBytes: 32 * gib, Model: "QEMU HARDDISK", SerialNumber: "QM0001",
+			Partitions: []*block.Partition{
+				{Name: "sda1", FilesystemLabel: constants.BootLabel, Type: "vfat"},
+				{Name: "sda2", FilesystemLabel: constants.StateLabel, Type: "ext4"},
+			},
+		})
+		ghwTest.AddDisk(block.Disk{Name: "sdb", SizeBytes: 64 * gib, Model: "QEMU HARDDISK", SerialNumber: "QM0002"})
+		ghwTest.AddDisk(block.Disk{
+			Name: "sdc", SizeBytes: 8 * gib, Model: "USB DISK", SerialNumber: "USB0001",
+			Partitions: []*block.Partition{
+				{Name: "sdc1", FilesystemLabel: constants.ISOLabel, Type: "iso9660", MountPoint: constants.LiveDir},
+			},
+		})
+		ghwTest.AddDisk(block.Disk{Name: "nvme0n1", SizeBytes: 128 * gib, Model: "Samsung SSD", SerialNumber: "S4EW01"})
+		ghwTest.AddDisk(block.Disk{Name: "loop0", SizeBytes: 1 * gib})
+		ghwTest.CreateDevices()
+	})
+
+	AfterEach(func() {
+		ghwTest.Clean()
+		cleanup()
+	})
+
+	Describe("Target selection", func() {
+		var disks []*block.Disk
+
+		BeforeEach(func() {
+			blockDevices, err := block.New()
+			Expect(err).ToNot(HaveOccurred())
+			disks = blockDevices.Disks
+			Expect(disks).To(HaveLen(5))
+		})
+		It("selects the first disk matching the target rules", func() {
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			disk, err := autoInstall.SelectTarget(disks)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk.Name).To(Equal("nvme0n1"))
+
+			auto.Target = types.TargetSelector{Device: "/dev/sd*"}
+			disk, err = autoInstall.SelectTarget(disks)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk.Name).To(Equal("sda"))
+
+			auto.Target = types.TargetSelector{Model: "QEMU*", MinSize: 40960}
+			disk, err = autoInstall.SelectTarget(disks)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk.Name).To(Equal("sdb"))
+
+			auto.Target = types.TargetSelector{Serial: "QM0002"}
+			disk, err = autoInstall.SelectTarget(disks)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk.Name).To(Equal("sdb"))
+		})
+		It("never selects the live media nor loop devices", func() {
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			auto.Target = types.TargetSelector{Device: "/dev/sdc"}
+			_, err := autoInstall.SelectTarget(disks)
+			Expect(err).To(MatchError(ContainSubstring("no disk matches")))
+
+			auto.Target = types.TargetSelector{Device: "/dev/loop*"}
+			_, err = autoInstall.SelectTarget(disks)
+			Expect(err).To(MatchError(ContainSubstring("no disk matches")))
+		})
+	})
+
+	Describe("Target selection with a whole disk live media", func() {
+		It("never selects the disk backing the live media", func() {
+			ghwTest.AddDisk(block.Disk{Name: "sdd", SizeBytes: 16 * gib, Model: "USB STICK", SerialNumber: "USB0002"})
+			ghwTest.CreateDevices()
+			blockDevices, err := block.New()
+			Expect(err).ToNot(HaveOccurred())
+
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "findmnt" && slices.Contains(args, constants.LiveDir) {
+					return []byte("/dev/sdd\n"), nil
+				}
+				return []byte{}, nil
+			}
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			auto.Target = types.TargetSelector{Model: "USB STICK"}
+			_, err = autoInstall.SelectTarget(blockDevices.Disks)
+			Expect(err).To(MatchError(ContainSubstring("no disk matches")))
+			Expect(memLog.String()).To(ContainSubstring("Skipping disk sdd, it backs the live media"))
+
+			// The same disk is selected if it does not back the live media
+			runner.SideEffect = nil
+			disk, err := autoInstall.SelectTarget(blockDevices.Disks)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk.Name).To(Equal("sdd"))
+		})
+	})
+
+	Describe("Run", func() {
+		It("refuses to install over an existing installation", func() {
+			auto.Target = types.TargetSelector{Model: "QEMU*"}
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			err := autoInstall.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*elementalError.ElementalError).ExitCode()).To(Equal(elementalError.AlreadyInstalled))
+			Expect(spec.Target).To(Equal("/dev/sda"))
+			Expect(memLog.String()).NotTo(ContainSubstring("Starting unattended installation"))
+		})
+		It("refuses to install over an existing installation on a given target", func() {
+			spec.Target = "/dev/sda"
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			err := autoInstall.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("already contains an Elemental installation"))
+		})
+		It("refuses to install on a given target that is not found", func() {
+			spec.Target = "/dev/sdz"
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			err := autoInstall.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*elementalError.ElementalError).ExitCode()).To(Equal(elementalError.SelectTarget))
+			Expect(memLog.String()).NotTo(ContainSubstring("Starting unattended installation"))
+		})
+		It("refuses to install over an existing installation on a mirror target", func() {
+			spec.Target = "/dev/sdb"
+			spec.MirrorTargets = []string{"/dev/sda"}
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			err := autoInstall.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*elementalError.ElementalError).ExitCode()).To(Equal(elementalError.AlreadyInstalled))
+			Expect(err.Error()).To(ContainSubstring("target /dev/sda already contains"))
+		})
+		It("fails if no disk matches the target rules", func() {
+			auto.Target = types.TargetSelector{MinSize: 1024 * 1024}
+			autoInstall = action.NewAutoInstallAction(config, spec, auto)
+			err := autoInstall.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*elementalError.ElementalError).ExitCode()).To(Equal(elementalError.SelectTarget))
+		})
+		It("starts the installation after the countdown", func() {
+			auto.Target = types.TargetSelector{Model: "QEMU*"}
+			auto.Overwrite = true
+			auto.Countdown = 2
+			autoInstall = action.NewAutoInstallAction(
+				config, spec, auto,
+				action.WithCountdownInterval(time.Millisecond),
+				action.WithAutoInstallOptions(action.WithInstallBootloader(&mocks.FakeBootloader{})),
+			)
+			// The installation fails as the target device does not exist in the test FS
+			Expect(autoInstall.Run()).NotTo(Succeed())
+			Expect(spec.Target).To(Equal("/dev/sda"))
+			Expect(memLog.String()).To(ContainSubstring("Installing to /dev/sda in 2 seconds"))
+			Expect(memLog.String()).To(ContainSubstring("Installing to /dev/sda in 1 seconds"))
+			Expect(memLog.String()).To(ContainSubstring("Starting unattended installation to /dev/sda"))
+		})
+	})
+})
@@ -17,7 +17,6 @@ limitations under the License.
 package action
 
 import (
-	"archive/tar"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -89,6 +88,13 @@ func NewBuildDiskAction(cfg *types.BuildConfig, spec *types.DiskSpec, opts ...Bu
 			cfg.Logger.Warning("Btrfs snapshotter type, forcing btrfs filesystem on state partition")
 			spec.Partitions.State.FS = constants.Btrfs
 		}
+		if cfg.Rootless && !spec.Expandable {
+			return nil, fmt.Errorf("rootless builds of non expandable disks require the %s snapshotter", constants.LoopDeviceSnapshotterType)
+		}
+	}
+
+	if spec.Board == constants.RPiBoard && cfg.Platform.Arch != constants.ArchArm64 {
+		return nil, fmt.Errorf("%s board disk images are only supported on %s", spec.Board, constants.ArchArm64)
 	}
 
 	return b, err
@@ -133,19 +139,32 @@ func (b *BuildDiskAction) buildAfterDiskHook(root string) error {
 }
 
 func (b *BuildDiskAction) buildDiskChrootHook(hook string, root string) error {
+	if b.cfg.Rootless {
+		b.cfg.Logger.Warnf("Skipping %s hook, chroot hooks can't run in rootless builds", hook)
+		return nil
+	}
 	return ChrootHook(&b.cfg.Config, hook, b.cfg.Strict, root, nil, b.cfg.CloudInitPaths...)
 }
 
-func (b *BuildDiskAction) preparePartitionsRoot() error {
-	var err error
+// layout returns the partitions included in the disk image sorted according to the configured layout
+func (b *BuildDiskAction) layout() types.PartitionList {
 	var excludes []*types.Partition
 
-	rootMap := map[string]string{}
-
 	if b.spec.Expandable {
 		excludes = append(excludes, b.spec.Partitions.Persistent, b.spec.Partitions.State)
 	}
-	for _, part := range b.spec.Partitions.PartitionsByInstallOrder(types.PartitionList{}, excludes...) {
+	return b.spec.PartitionsByLayout(excludes...)
+}
+
+func (b *BuildDiskAction) preparePartitionsRoot() error {
+	var err error
+
+	rootMap := map[string]string{}
+
+	for _, part := range b.layout() {
+		if part.Path == "" {
+			part.Path = filepath.Join(b.cfg.OutDir, constants.DiskWorkDir, part.Name+".part")
+		}
 		rootMap[part.Name] = strings.TrimSuffix(part.Path, filepath.Ext(part.Path))
 		err = utils.MkdirAll(b.cfg.Fs, rootMap[part.Name], constants.DirPerm)
 		if err != nil {
@@ -245,6 +264,24 @@ func (b *BuildDiskAction) BuildDiskRun() (err error) { //nolint:gocyclo
 		return err
 	}
 
+	if b.spec.Firmware == types.BIOS {
+		err = b.bootloader.InstallBIOS(
+			recRoot, b.roots[constants.BootPartName], b.spec.Partitions.Boot.FilesystemLabel,
+		)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed installing grub legacy BIOS images: %s", err.Error())
+			return err
+		}
+	}
+
+	if b.spec.Board == constants.RPiBoard {
+		err = b.installRPiFirmware(recRoot, b.roots[constants.BootPartName])
+		if err != nil {
+			b.cfg.Logger.Errorf("failed installing Raspberry Pi firmware: %s", err.Error())
+			return err
+		}
+	}
+
 	// Rebrand
 	err = b.bootloader.SetDefaultEntry(b.roots[constants.BootPartName], recRoot, b.spec.GrubDefEntry)
 	if err != nil {
@@ -274,6 +311,11 @@ func (b *BuildDiskAction) BuildDiskRun() (err error) { //nolint:gocyclo
 	// reset source so the correct one will be used for the state.yaml
 	b.spec.RecoverySystem.Source = tmpSrc
 
+	err = setRecoveryRootHash(&b.cfg.Config, b.bootloader, b.roots[constants.BootPartName], b.spec.RecoverySystem.RootHash)
+	if err != nil {
+		return err
+	}
+
 	if b.spec.Expandable {
 		err = b.SetExpandableCloudInitStage()
 		if err != nil {
@@ -313,6 +355,41 @@ func (b *BuildDiskAction) BuildDiskRun() (err error) { //nolint:gocyclo
 			return err
 		}
 		b.cfg.Logger.Infof("Done! Image created at %s", fmt.Sprintf("%s.tar.gz", rawImg))
+	case constants.QCOW2Type:
+		err = Raw2Qcow2(rawImg, b.cfg.Fs, b.cfg.Logger, b.spec.Compression, false)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed creating QCOW2 image: %s", err.Error())
+			return err
+		}
+		b.cfg.Logger.Infof("Done! Image created at %s", fmt.Sprintf("%s.qcow2", rawImg))
+	case constants.VMDKType:
+		err = Raw2Vmdk(rawImg, b.cfg.Fs, b.cfg.Logger, false)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed creating VMDK image: %s", err.Error())
+			return err
+		}
+		b.cfg.Logger.Infof("Done! Image created at %s", fmt.Sprintf("%s.vmdk", rawImg))
+	case constants.OVAType:
+		err = Raw2Ova(rawImg, b.cfg.Fs, b.cfg.Logger, b.spec.VM, b.spec.Firmware, false)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed creating OVA image: %s", err.Error())
+			return err
+		}
+		b.cfg.Logger.Infof("Done! Image created at %s", fmt.Sprintf("%s.ova", rawImg))
+	case constants.VHDType:
+		err = Raw2Vhd(rawImg, b.cfg.Fs, b.cfg.Logger, false)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed creating VHD image: %s", err.Error())
+			return err
+		}
+		b.cfg.Logger.Infof("Done! Image created at %s", fmt.Sprintf("%s.vhd", rawImg))
+	case constants.VHDXType:
+		err = Raw2Vhdx(rawImg, b.cfg.Fs, b.cfg.Logger, false)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed creating VHDX image: %s", err.Error())
+			return err
+		}
+		b.cfg.Logger.Infof("Done! Image created at %s", fmt.Sprintf("%s.vhdx", rawImg))
 	}
 
 	return elementalError.NewFromError(err, elementalError.Unknown)
@@ -349,23 +426,36 @@ func (b *BuildDiskAction) CreateRAWDisk(rawImg string) error {
 		b.cfg.Logger.Errorf("failed creating partition table: %s", err.Error())
 		return err
 	}
+
+	// Embed grub into the BIOS boot partition
+	if b.spec.Firmware == types.BIOS {
+		err = b.bootloader.SetupBIOS(b.roots[constants.BootPartName], rawImg)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed setting up grub for legacy BIOS: %s", err.Error())
+			return err
+		}
+	}
 	return nil
 }
 
 // CreatePartitionImage creates partition image files and returns a slice of the created images
+// sorted according to the disk layout
 func (b *BuildDiskAction) CreatePartitionImages() ([]*types.Image, error) {
 	var err error
-	var img, stateImg *types.Image
+	var img *types.Image
 	var images []*types.Image
 
+	imgMap := map[string]*types.Image{}
+
 	// Create state partition first to compute snapshot metadata if any
 	if !b.spec.Expandable {
 		b.cfg.Logger.Infof("Creating State partition image")
-		stateImg, err = b.createStatePartitionImage()
+		img, err = b.createStatePartitionImage()
 		if err != nil {
 			b.cfg.Logger.Errorf("failed creating State partition img: %s", err.Error())
 			return nil, err
 		}
+		imgMap[b.spec.Partitions.State.Name] = img
 	}
 
 	// Add state.yaml file on recovery partition including snapshot metadata if any
@@ -381,63 +471,73 @@ func (b *BuildDiskAction) CreatePartitionImages() ([]*types.Image, error) {
 		b.cfg.Logger.Errorf("failed creating EFI img: %s", err.Error())
 		return nil, err
 	}
-	images = append(images, img)
+	imgMap[b.spec.Partitions.Boot.Name] = img
 
-	for _, part := range []*types.Partition{b.spec.Partitions.OEM, b.spec.Partitions.Recovery} {
+	parts := []*types.Partition{b.spec.Partitions.OEM, b.spec.Partitions.Recovery}
+	if b.spec.Partitions.BIOS != nil {
+		parts = append(parts, b.spec.Partitions.BIOS)
+	}
+	if !b.spec.Expandable {
+		parts = append(parts, b.spec.Partitions.Persistent)
+	}
+	parts = append(parts, b.spec.ExtraPartitions...)
+
+	for _, part := range parts {
 		b.cfg.Logger.Infof("Creating %s partition image", part.Name)
 		img = part.ToImage()
 		if part.Name == constants.RecoveryPartName && b.spec.Expandable {
 			img.Size = 0
 		}
-		err = elemental.CreateImageFromTree(
-			b.cfg.Config, img, b.roots[part.Name], b.spec.Expandable,
-			func() error { return b.cfg.Fs.RemoveAll(b.roots[part.Name]) },
-		)
+		if part.FS == "" {
+			// Partitions without filesystem are just zeroed
+			err = utils.CreateRAWFile(b.cfg.Fs, img.File, img.Size)
+			_ = b.cfg.Fs.RemoveAll(b.roots[part.Name])
+		} else {
+			err = elemental.CreateImageFromTree(
+				b.cfg.Config, img, b.roots[part.Name], b.spec.Expandable,
+				func() error { return b.cfg.Fs.RemoveAll(b.roots[part.Name]) },
+			)
+		}
 		if err != nil {
 			b.cfg.Logger.Errorf("failed creating %s partition image: %s", part.Name, err.Error())
 			return nil, err
 		}
-		images = append(images, img)
+		imgMap[part.Name] = img
 	}
 
-	if !b.spec.Expandable {
-		images = append(images, stateImg)
-
-		b.cfg.Logger.Infof("Creating Persistent partition image")
-		part := b.spec.Partitions.Persistent
-		img = part.ToImage()
-		err = elemental.CreateImageFromTree(
-			b.cfg.Config, img, b.roots[part.Name], b.spec.Expandable,
-			func() error { return b.cfg.Fs.RemoveAll(b.roots[part.Name]) },
-		)
-		if err != nil {
-			b.cfg.Logger.Errorf("failed creating %s partition image: %s", part.Name, err.Error())
-			return nil, err
-		}
-		images = append(images, img)
+	for _, part := range b.layout() {
+		images = append(images, imgMap[part.Name])
 	}
 
 	return images, nil
 }
 
-// createStatePartitionImage creates the State partitions for the configured snapshotter
+// createStatePartitionImage creates the State partitions for the configured snapshotter. On rootless
+// builds the State partition tree is prepared in its root folder and preloaded into the image afterwards.
 func (b *BuildDiskAction) createStatePartitionImage() (*types.Image, error) {
-	stateImg := b.spec.Partitions.State.ToImage()
+	var err error
 
-	err := elemental.CreateFileSystemImage(b.cfg.Config, stateImg, "", false)
-	if err != nil {
-		b.cfg.Logger.Error("failed creating state filesystem image: %v", err)
-		return nil, err
+	if b.cfg.Rootless {
+		b.spec.Partitions.State.MountPoint = b.roots[constants.StatePartName]
 	}
+	stateImg := b.spec.Partitions.State.ToImage()
 
-	err = elemental.MountFileSystemImage(b.cfg.Config, stateImg, "rw")
-	if err != nil {
-		b.cfg.Logger.Error("failed mounting state filesystem image: %v", err)
-		return nil, err
+	if !b.cfg.Rootless {
+		err = elemental.CreateFileSystemImage(b.cfg.Config, stateImg, "", false)
+		if err != nil {
+			b.cfg.Logger.Error("failed creating state filesystem image: %v", err)
+			return nil, err
+		}
+
+		err = elemental.MountFileSystemImage(b.cfg.Config, stateImg, "rw")
+		if err != nil {
+			b.cfg.Logger.Error("failed mounting state filesystem image: %v", err)
+			return nil, err
+		}
+		defer func() {
+			_ = elemental.UnmountFileSystemImage(b.cfg.Config, stateImg)
+		}()
 	}
-	defer func() {
-		_ = elemental.UnmountFileSystemImage(b.cfg.Config, stateImg)
-	}()
 
 	// Run a snapshotter transaction for System source in state partition
 	err = b.snapshotter.InitSnapshotter(b.spec.Partitions.State, b.roots[constants.BootPartName])
@@ -484,9 +584,56 @@ func (b *BuildDiskAction) createStatePartitionImage() (*types.Image, error) {
 		return stateImg, elementalError.NewFromError(err, elementalError.CreateFile)
 	}
 
+	if b.cfg.Rootless {
+		err = elemental.CreateImageFromTree(
+			b.cfg.Config, stateImg, b.roots[constants.StatePartName], true,
+			func() error { return b.cfg.Fs.RemoveAll(b.roots[constants.StatePartName]) },
+		)
+		if err != nil {
+			b.cfg.Logger.Errorf("failed creating state filesystem image: %v", err)
+			return nil, err
+		}
+	}
+
 	return stateImg, nil
 }
 
+// installRPiFirmware copies the Raspberry Pi firmware and U-Boot of the OS image in rootDir to the root
+// of the EFI partition. The firmware boots U-Boot, which then loads grub from the removable EFI path.
+// A default firmware configuration is written if the OS image does not provide any.
+func (b *BuildDiskAction) installRPiFirmware(rootDir, efiDir string) error {
+	startElf, err := utils.FindFile(b.cfg.Fs, rootDir, constants.GetRPiFirmwarePatterns()...)
+	if err != nil {
+		return err
+	}
+	err = utils.SyncData(b.cfg.Logger, b.cfg.Runner, b.cfg.Fs, filepath.Dir(startElf), efiDir)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CopyData)
+	}
+
+	uboot := filepath.Join(efiDir, constants.UBootBin)
+	if ok, _ := utils.Exists(b.cfg.Fs, uboot); !ok {
+		src, err := utils.FindFile(b.cfg.Fs, rootDir, constants.GetUBootRPiPatterns()...)
+		if err != nil {
+			return err
+		}
+		err = utils.CopyFile(b.cfg.Fs, src, uboot)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.CopyFile)
+		}
+	}
+
+	fwConfig := filepath.Join(efiDir, constants.RPiConfigTxt)
+	if ok, _ := utils.Exists(b.cfg.Fs, fwConfig); !ok {
+		b.cfg.Logger.Infof("Writing default Raspberry Pi firmware configuration")
+		err = b.cfg.Fs.WriteFile(fwConfig, []byte(constants.GetRPiDefaultConfig()), constants.FilePerm)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.CreateFile)
+		}
+	}
+	return nil
+}
+
 // createEFIPartitionImage creates the EFI partition image
 func (b *BuildDiskAction) createEFIPartitionImage() (*types.Image, error) {
 	img := b.spec.Partitions.Boot.ToImage()
@@ -559,10 +706,30 @@ func (b *BuildDiskAction) CreateDiskImage(rawDiskFile string, partImgs ...*types
 		return err
 	}
 
-	// List and concatenate all image files
+	layout := b.layout()
+	if len(layout) != len(partImgs) {
+		return fmt.Errorf("partition images do not match the disk layout")
+	}
+	offsets, err := layout.StartOffsets()
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.InvalidSize)
+	}
+
+	// List and concatenate all image files, gaps between partitions are filled with zeros
 	partFiles = append(partFiles, initDiskFile)
-	for _, img := range partImgs {
+	next := uint(1)
+	for i, img := range partImgs {
+		if offsets[i] > next {
+			gapFile := filepath.Join(b.cfg.OutDir, constants.DiskWorkDir, fmt.Sprintf("gap%d.img", i))
+			err = utils.CreateRAWFile(b.cfg.Fs, gapFile, offsets[i]-next)
+			if err != nil {
+				b.cfg.Logger.Errorf("failed creating RAW file: %s", err.Error())
+				return err
+			}
+			partFiles = append(partFiles, gapFile)
+		}
 		partFiles = append(partFiles, img.File)
+		next = offsets[i] + layout[i].Size
 	}
 	partFiles = append(partFiles, endDiskFile)
 	err = utils.ConcatFiles(b.cfg.Fs, partFiles, rawDiskFile)
@@ -580,10 +747,11 @@ func Raw2Gce(source string, fs types.FS, logger types.Logger, keepOldImage bool)
 	// The disk image filename must be disk.raw.
 	// The compressed file must be a .tar.gz file that uses gzip compression and the --format=oldgnu option for the tar utility.
 	logger.Info("Transforming raw image into gce format")
-	actImg, err := fs.OpenFile(source, os.O_CREATE|os.O_APPEND|os.O_WRONLY, constants.FilePerm)
+	actImg, err := fs.OpenFile(source, os.O_RDONLY, constants.FilePerm)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.OpenFile)
 	}
+	defer actImg.Close()
 	info, err := actImg.Stat()
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.StatFile)
@@ -591,14 +759,11 @@ func Raw2Gce(source string, fs types.FS, logger types.Logger, keepOldImage bool)
 	actualSize := info.Size()
 	finalSizeGB := actualSize/GB + 1
 	finalSizeBytes := finalSizeGB * GB
+	// The image is resized within the archive as a trailing hole, the RAW image is not modified
 	logger.Infof("Resizing img from %d to %d", actualSize, finalSizeBytes)
-	// REMEMBER TO SEEK!
-	_, _ = actImg.Seek(0, io.SeekEnd)
-	_ = actImg.Truncate(finalSizeBytes)
-	_ = actImg.Close()
 
 	// Tar gz the image
-	logger.Infof("Compressing raw image into a tar.gz")
+	logger.Infof("Compressing raw image into a sparse tar.gz")
 	// Create destination file
 	file, err := fs.Create(fmt.Sprintf("%s.tar.gz", source))
 	logger.Debugf(fmt.Sprintf("destination: %s.tar.gz", source))
@@ -612,32 +777,12 @@ func Raw2Gce(source string, fs types.FS, logger types.Logger, keepOldImage bool)
 		return elementalError.NewFromError(err, elementalError.GzipWriter)
 	}
 	defer gzipWriter.Close()
-	// Create tarwriter pointing to our gzip writer
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
 
-	// Open disk.raw
-	sourceFile, _ := fs.Open(source)
-	sourceStat, _ := sourceFile.Stat()
-	defer sourceFile.Close()
-
-	// Add disk.raw file
-	header := &tar.Header{
-		Name:   sourceStat.Name(),
-		Size:   sourceStat.Size(),
-		Mode:   int64(sourceStat.Mode()),
-		Format: tar.FormatGNU,
-	}
-	// Write header with all the info
-	err = tarWriter.WriteHeader(header)
+	// Write the sparse disk.raw file, only its data extents are stored
+	err = utils.SparseTar(gzipWriter, actImg, info.Name(), finalSizeBytes, int64(info.Mode()))
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.TarHeader)
 	}
-	// copy the actual data
-	_, err = io.Copy(tarWriter, sourceFile)
-	if err != nil {
-		return elementalError.NewFromError(err, elementalError.CopyData)
-	}
 	// Remove full raw image, we already got the compressed one
 	if !keepOldImage {
 		_ = fs.RemoveAll(source)
@@ -651,8 +796,13 @@ func Raw2Azure(source string, fs types.FS, logger types.Logger, keepOldImage boo
 	// All VHDs on Azure must have a virtual size aligned to 1 MB (1024 × 1024 bytes)
 	// The Hyper-V virtual hard disk (VHDX) format isn't supported in Azure, only fixed VHD
 	logger.Info("Transforming raw image into azure format")
-	// Copy raw to new image with VHD appended
-	err := utils.CopyFile(fs, source, fmt.Sprintf("%s.vhd", source))
+	// Copy raw to new image with VHD appended, the raw image is just renamed if it is not kept
+	var err error
+	if keepOldImage {
+		err = utils.CopyFile(fs, source, fmt.Sprintf("%s.vhd", source))
+	} else {
+		err = fs.Rename(source, fmt.Sprintf("%s.vhd", source))
+	}
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.CopyFile)
 	}
@@ -676,6 +826,81 @@ func Raw2Azure(source string, fs types.FS, logger types.Logger, keepOldImage boo
 	// Transform it to VHD
 	utils.RawDiskToFixedVhd(vhdFile)
 	_ = vhdFile.Close()
+	return nil
+}
+
+// Raw2Qcow2 transforms an image from RAW format into QCOW2 format with the given data compression
+// THIS REMOVES THE SOURCE IMAGE BY DEFAULT
+func Raw2Qcow2(source string, fs types.FS, logger types.Logger, compression string, keepOldImage bool) error {
+	logger.Info("Transforming raw image into qcow2 format")
+	convert := func(rawFile, qcow2File *os.File) error {
+		return utils.RawDiskToQcow2(rawFile, qcow2File, compression)
+	}
+	return convertRawImage(source, fmt.Sprintf("%s.qcow2", source), fs, convert, keepOldImage)
+}
+
+// Raw2Vmdk transforms an image from RAW format into streamOptimized VMDK format
+// THIS REMOVES THE SOURCE IMAGE BY DEFAULT
+func Raw2Vmdk(source string, fs types.FS, logger types.Logger, keepOldImage bool) error {
+	logger.Info("Transforming raw image into vmdk format")
+	return convertRawImage(source, fmt.Sprintf("%s.vmdk", source), fs, utils.RawDiskToStreamVmdk, keepOldImage)
+}
+
+// Raw2Ova transforms an image from RAW format into an OVA appliance for the given virtual machine and firmware
+// THIS REMOVES THE SOURCE IMAGE BY DEFAULT
+func Raw2Ova(source string, fs types.FS, logger types.Logger, vm types.VMSpec, firmware string, keepOldImage bool) error {
+	info, err := fs.Stat(source)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.StatFile)
+	}
+
+	err = Raw2Vmdk(source, fs, logger, keepOldImage)
+	if err != nil {
+		return err
+	}
+	vmdk := fmt.Sprintf("%s.vmdk", source)
+	defer func() { _ = fs.RemoveAll(vmdk) }()
+
+	logger.Info("Bundling vmdk image into an ova appliance")
+	err = utils.VmdkToOva(fs, vmdk, fmt.Sprintf("%s.ova", source), info.Size(), vm, firmware)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	return nil
+}
+
+// Raw2Vhd transforms an image from RAW format into dynamic VHD format
+// THIS REMOVES THE SOURCE IMAGE BY DEFAULT
+func Raw2Vhd(source string, fs types.FS, logger types.Logger, keepOldImage bool) error {
+	logger.Info("Transforming raw image into dynamic vhd format")
+	return convertRawImage(source, fmt.Sprintf("%s.vhd", source), fs, utils.RawDiskToDynamicVhd, keepOldImage)
+}
+
+// Raw2Vhdx transforms an image from RAW format into dynamic VHDX format
+// THIS REMOVES THE SOURCE IMAGE BY DEFAULT
+func Raw2Vhdx(source string, fs types.FS, logger types.Logger, keepOldImage bool) error {
+	logger.Info("Transforming raw image into dynamic vhdx format")
+	return convertRawImage(source, fmt.Sprintf("%s.vhdx", source), fs, utils.RawDiskToVhdx, keepOldImage)
+}
+
+// convertRawImage streams the given RAW image into the given target file using the given conversion function
+func convertRawImage(source, target string, fs types.FS, convert func(*os.File, *os.File) error, keepOldImage bool) error {
+	rawFile, err := fs.OpenFile(source, os.O_RDONLY, constants.FilePerm)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.OpenFile)
+	}
+	defer rawFile.Close()
+
+	targetFile, err := fs.Create(target)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	defer targetFile.Close()
+
+	err = convert(rawFile, targetFile)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CopyData)
+	}
 	// Remove raw image
 	if !keepOldImage {
 		_ = fs.RemoveAll(source)
@@ -683,11 +908,18 @@ func Raw2Azure(source string, fs types.FS, logger types.Logger, keepOldImage boo
 	return nil
 }
 
+// CreateDiskPartitionTable writes the GPT partition table of the disk layout into the given disk image.
+// Rootless builds write the partition table natively instead of using sgdisk. Raspberry Pi disk images
+// also include a hybrid MBR.
 func (b *BuildDiskAction) CreateDiskPartitionTable(disk string) error {
-	var secSize, startS, sizeS uint
-	var excludes types.PartitionList
+	var secSize, sizeS uint
+	var gd partitioner.Partitioner
 
-	gd := partitioner.NewPartitioner(disk, b.cfg.Runner, partitioner.Gdisk)
+	if b.cfg.Rootless {
+		gd = partitioner.NewNativePartitioner(disk, b.cfg.Fs, b.cfg.Runner)
+	} else {
+		gd = partitioner.NewPartitioner(disk, b.cfg.Runner, partitioner.Gdisk)
+	}
 	dData, err := gd.Print()
 	if err != nil {
 		return err
@@ -698,37 +930,61 @@ func (b *BuildDiskAction) CreateDiskPartitionTable(disk string) error {
 		b.cfg.Logger.Warnf("Could not determine disk sector size, using default value (%d bytes)", defSectorSize)
 	}
 
-	if b.spec.Expandable {
-		excludes = append(excludes, b.spec.Partitions.State, b.spec.Partitions.Persistent)
+	var bootNum int
+	elParts := b.layout()
+	offsets, err := elParts.StartOffsets()
+	if err != nil {
+		return err
 	}
-	elParts := b.spec.Partitions.PartitionsByInstallOrder(types.PartitionList{}, excludes...)
 	for i, part := range elParts {
-		if i == 0 {
-			//First partition is aligned at 1MiB
-			startS = 1024 * 1024 / secSize
-		} else {
-			// reuse startS and SizeS from previous partition
-			startS = startS + sizeS
+		if part == b.spec.Partitions.Boot {
+			bootNum = i + 1
 		}
 		if part.Name == constants.RecoveryPartName && b.spec.Expandable {
 			sizeS = 0
 		} else {
 			sizeS = partitioner.MiBToSectors(part.Size, secSize)
 		}
+		attrs, err := part.GPTAttributes()
+		if err != nil {
+			return err
+		}
+		typeGUID := part.TypeGUID
+		if part == b.spec.Partitions.BIOS && typeGUID == "" {
+			typeGUID = partitioner.GPTBIOSBootType
+		}
 		var gdPart = partitioner.Partition{
 			Number:     i + 1,
-			StartS:     startS,
+			StartS:     partitioner.MiBToSectors(offsets[i], secSize),
 			SizeS:      sizeS,
 			PLabel:     part.Name,
 			FileSystem: part.FS,
+			TypeGUID:   typeGUID,
+			UUID:       part.UUID,
+			Attributes: attrs,
 		}
 		gd.CreatePartition(&gdPart)
 	}
+
 	out, err := gd.WriteChanges()
 	if err != nil {
 		b.cfg.Logger.Errorf("Failed creating partitions. stdout: %s\nerr:%v", out, err)
 		return err
 	}
+
+	// Raspberry Pi firmware only reads MBR partition tables, the EFI partition is included in a hybrid MBR
+	if b.spec.Board == constants.RPiBoard {
+		f, err := b.cfg.Fs.OpenFile(disk, os.O_RDWR, constants.FilePerm)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.OpenFile)
+		}
+		defer f.Close()
+		err = partitioner.WriteHybridMBR(f, secSize, bootNum)
+		if err != nil {
+			b.cfg.Logger.Errorf("Failed writing hybrid MBR: %v", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -749,6 +1005,7 @@ func (b *BuildDiskAction) createBuildDiskStateYaml(stateRoot, recoveryRoot strin
 			Digest:     b.spec.System.GetDigest(),
 			Active:     true,
 			FromAction: constants.ActionBuildDisk,
+			RootHash:   b.snapshot.RootHash,
 		}
 	}
 
@@ -768,6 +1025,7 @@ func (b *BuildDiskAction) createBuildDiskStateYaml(stateRoot, recoveryRoot strin
 					Label:      b.spec.RecoverySystem.Label,
 					FS:         b.spec.RecoverySystem.FS,
 					FromAction: constants.ActionBuildDisk,
+					RootHash:   b.spec.RecoverySystem.RootHash,
 				},
 			},
 		},
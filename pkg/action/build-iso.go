@@ -18,9 +18,12 @@ package action
 
 import (
 	"fmt"
+	"net/url"
 	"path/filepath"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/rancher/elemental-toolkit/v2/pkg/bootloader"
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
@@ -33,6 +36,8 @@ const (
 	isoBootCatalog = "/boot/boot.catalog"
 )
 
+var isoBIOSEltoritoPath = filepath.Join(constants.GrubBIOSPath, constants.GrubBIOSTarget, constants.GrubBIOSEltoritoImg)
+
 func grubCfgTemplate(arch, cmdline string) string {
 	return `search --no-floppy --file --set=root ` + constants.ISOKernelPath(arch) + `
 	set default=0
@@ -50,6 +55,21 @@ func grubCfgTemplate(arch, cmdline string) string {
 	`
 }
 
+// autoInstallCloudConfig returns the live cloud-config merging the embedded unattended installation
+// config into the elemental config of the live system and running the installation
+func autoInstallCloudConfig() string {
+	return `name: "Unattended installation"
+stages:
+  network:
+    - name: "Run elemental autoinstall"
+      if: '[ -f "/run/elemental/live_mode" ]'
+      commands:
+        - mkdir -p ` + constants.ConfigDir + `/config.d
+        - cp ` + constants.ISOAutoInstallPath + `/config.yaml ` + constants.ConfigDir + `/config.d/autoinstall.yaml
+        - elemental autoinstall
+`
+}
+
 type BuildISOAction struct {
 	cfg        *types.BuildConfig
 	spec       *types.LiveISO
@@ -135,21 +155,20 @@ func (b *BuildISOAction) Run() error {
 		return elementalError.NewFromError(err, elementalError.CreateDir)
 	}
 
-	if b.spec.Firmware == types.EFI {
-		b.cfg.Logger.Infof("Preparing EFI image...")
-		if b.spec.BootloaderInRootFs {
-			err = b.PrepareEFI(rootDir, uefiDir)
-			if err != nil {
-				b.cfg.Logger.Errorf("Failed fetching EFI data: %v", err)
-				return elementalError.NewFromError(err, elementalError.CopyData)
-			}
-		}
-		err = b.applySources(uefiDir, b.spec.UEFI...)
+	// EFI is always included, legacy BIOS ISOs are hybrid images also bootable on EFI
+	b.cfg.Logger.Infof("Preparing EFI image...")
+	if b.spec.BootloaderInRootFs {
+		err = b.PrepareEFI(rootDir, uefiDir)
 		if err != nil {
-			b.cfg.Logger.Errorf("Failed installing EFI packages: %v", err)
-			return err
+			b.cfg.Logger.Errorf("Failed fetching EFI data: %v", err)
+			return elementalError.NewFromError(err, elementalError.CopyData)
 		}
 	}
+	err = b.applySources(uefiDir, b.spec.UEFI...)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed installing EFI packages: %v", err)
+		return err
+	}
 
 	b.cfg.Logger.Infof("Preparing ISO image root tree...")
 	if b.spec.BootloaderInRootFs {
@@ -165,6 +184,15 @@ func (b *BuildISOAction) Run() error {
 		return err
 	}
 
+	if b.spec.AutoInstall != "" {
+		b.cfg.Logger.Infof("Embedding unattended installation config...")
+		err = b.PrepareAutoInstall(isoDir)
+		if err != nil {
+			b.cfg.Logger.Errorf("Failed embedding unattended installation config: %v", err)
+			return err
+		}
+	}
+
 	bootDir := filepath.Join(isoDir, constants.ISOLoaderPath(b.cfg.Platform.Arch))
 	err = utils.MkdirAll(b.cfg.Fs, bootDir, constants.DirPerm)
 	if err != nil {
@@ -184,12 +212,10 @@ func (b *BuildISOAction) Run() error {
 		return err
 	}
 
-	if b.spec.Firmware == types.EFI {
-		b.cfg.Logger.Info("Creating EFI image...")
-		err = b.createEFI(uefiDir, filepath.Join(isoTmpDir, constants.ISOEFIImg))
-		if err != nil {
-			return err
-		}
+	b.cfg.Logger.Info("Creating EFI image...")
+	err = b.createEFI(uefiDir, filepath.Join(isoTmpDir, constants.ISOEFIImg))
+	if err != nil {
+		return err
 	}
 
 	b.cfg.Logger.Infof("Creating ISO image...")
@@ -212,18 +238,93 @@ func (b *BuildISOAction) PrepareEFI(rootDir, uefiDir string) error {
 
 func (b *BuildISOAction) PrepareISO(rootDir, imageDir string) error {
 	// Include EFI contents in iso root too
-	return b.PrepareEFI(rootDir, imageDir)
+	err := b.PrepareEFI(rootDir, imageDir)
+	if err != nil || b.spec.Firmware != types.BIOS {
+		return err
+	}
+
+	err = b.renderGrubTemplateAt(imageDir, constants.GrubBIOSPath)
+	if err != nil {
+		return err
+	}
+	return b.bootloader.InstallBIOSEltorito(rootDir, imageDir)
+}
+
+// PrepareAutoInstall embeds the unattended installation config and its local cloud-init files into the ISO
+// root tree, together with the live cloud-config running the installation at boot
+func (b *BuildISOAction) PrepareAutoInstall(isoDir string) error {
+	data, err := b.cfg.Fs.ReadFile(b.spec.AutoInstall)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.ReadFile)
+	}
+	autoCfg := map[string]interface{}{}
+	err = yaml.Unmarshal(data, &autoCfg)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.ReadFile)
+	}
+
+	autoDir := filepath.Join(isoDir, constants.ISOAutoInstallDir)
+	err = utils.MkdirAll(b.cfg.Fs, filepath.Join(autoDir, "cloud-init"), constants.DirPerm)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateDir)
+	}
+
+	// Local cloud-init files are copied into the ISO and referenced from the live media
+	if install, ok := autoCfg["install"].(map[string]interface{}); ok {
+		cloudInit, _ := install["cloud-init"].([]interface{})
+		for i, ci := range cloudInit {
+			src, _ := ci.(string)
+			if local, _ := utils.IsLocalURI(src); !local || src == "" {
+				continue
+			}
+			u, _ := url.Parse(src)
+			name := fmt.Sprintf("%d_%s", i, filepath.Base(u.Path))
+			b.cfg.Logger.Debugf("Embedding cloud-init file %s", u.Path)
+			err = utils.CopyFile(b.cfg.Fs, u.Path, filepath.Join(autoDir, "cloud-init", name))
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.CopyFile)
+			}
+			cloudInit[i] = filepath.Join(constants.ISOAutoInstallPath, "cloud-init", name)
+		}
+	}
+
+	data, err = yaml.Marshal(autoCfg)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	err = b.cfg.Fs.WriteFile(filepath.Join(autoDir, "config.yaml"), data, constants.FilePerm)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+
+	liveCfgDir := filepath.Join(isoDir, filepath.Base(constants.ISOCloudInitPath))
+	err = utils.MkdirAll(b.cfg.Fs, liveCfgDir, constants.DirPerm)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateDir)
+	}
+	err = b.cfg.Fs.WriteFile(
+		filepath.Join(liveCfgDir, constants.ISOAutoInstallCfgFile), []byte(autoInstallCloudConfig()), constants.FilePerm,
+	)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	return nil
 }
 
 func (b *BuildISOAction) renderGrubTemplate(rootDir string) error {
-	err := utils.MkdirAll(b.cfg.Fs, filepath.Join(rootDir, constants.FallbackEFIPath), constants.DirPerm)
+	return b.renderGrubTemplateAt(rootDir, constants.FallbackEFIPath)
+}
+
+// renderGrubTemplateAt writes the ISO grub configuration at the given grub prefix
+func (b *BuildISOAction) renderGrubTemplateAt(rootDir, prefix string) error {
+	err := utils.MkdirAll(b.cfg.Fs, filepath.Join(rootDir, prefix), constants.DirPerm)
 	if err != nil {
 		return err
 	}
 
 	// Write grub.cfg file
 	return b.cfg.Fs.WriteFile(
-		filepath.Join(rootDir, constants.FallbackEFIPath, constants.GrubCfg),
+		filepath.Join(rootDir, prefix, constants.GrubCfg),
 		[]byte(fmt.Sprintf(grubCfgTemplate(b.cfg.Platform.Arch, b.spec.ExtraCmdline), b.spec.GrubEntry, b.spec.Label)),
 		constants.FilePerm,
 	)
@@ -293,7 +394,11 @@ func (b BuildISOAction) burnISO(root, efiImg string) error {
 		"-volid", b.spec.Label, "-padding", "0",
 		"-outdev", outputFile, "-map", root, "/", "-chmod", "0755", "--",
 	}
-	args = append(args, xorrisoBooloaderArgs(efiImg)...)
+	var biosHybridImg string
+	if b.spec.Firmware == types.BIOS {
+		biosHybridImg = filepath.Join(root, constants.GrubBIOSPath, constants.GrubBIOSTarget, constants.GrubBIOSHybridImg)
+	}
+	args = append(args, xorrisoBooloaderArgs(efiImg, biosHybridImg)...)
 
 	out, err := b.cfg.Runner.Run(cmd, args...)
 	b.cfg.Logger.Debugf("Xorriso: %s", string(out))
@@ -325,15 +430,31 @@ func (b BuildISOAction) applySources(target string, sources ...*types.ImageSourc
 	return nil
 }
 
-func xorrisoBooloaderArgs(efiImg string) []string {
+// xorrisoBooloaderArgs returns the xorriso arguments to boot the ISO on EFI from the given
+// EFI image. If a grub hybrid MBR image is given the ISO is also bootable on legacy BIOS.
+func xorrisoBooloaderArgs(efiImg, biosHybridImg string) []string {
 	args := []string{
 		"-append_partition", "2", "0xef", efiImg,
 		"-boot_image", "any", fmt.Sprintf("cat_path=%s", isoBootCatalog),
 		"-boot_image", "any", "cat_hidden=on",
+	}
+	if biosHybridImg != "" {
+		args = append(args,
+			"-boot_image", "grub", fmt.Sprintf("bin_path=%s", isoBIOSEltoritoPath),
+			"-boot_image", "grub", fmt.Sprintf("grub2_mbr=%s", biosHybridImg),
+			"-boot_image", "grub", "grub2_boot_info=on",
+			"-boot_image", "any", "boot_info_table=on",
+			"-boot_image", "any", "platform_id=0x00",
+			"-boot_image", "any", "emul_type=no_emulation",
+			"-boot_image", "any", "load_size=2048",
+			"-boot_image", "any", "next",
+		)
+	}
+	args = append(args,
 		"-boot_image", "any", "efi_path=--interval:appended_partition_2:all::",
 		"-boot_image", "any", "platform_id=0xef",
 		"-boot_image", "any", "appended_part_as=gpt",
 		"-boot_image", "any", "partition_offset=16",
-	}
+	)
 	return args
 }
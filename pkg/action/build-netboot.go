@@ -0,0 +1,244 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+const (
+	netbootKernelSuffix      = "-kernel"
+	netbootInitrdSuffix      = "-initrd"
+	netbootRootFSSuffix      = ".squashfs"
+	netbootCloudConfigSuffix = "-cloud-config.yaml"
+	netbootIPXESuffix        = ".ipxe"
+	netbootGrubCfgSuffix     = "-grub.cfg"
+	netbootChecksumSuffix    = ".sha256"
+)
+
+func ipxeScriptTemplate() string {
+	return `#!ipxe
+echo Booting %[1]s
+kernel %[2]s initrd=%[3]s %[4]s
+initrd %[5]s
+boot
+`
+}
+
+func grubNetbootCfgTemplate() string {
+	return `set default=0
+	set timeout=5
+	set timeout_style=menu
+
+	menuentry "%[1]s" --class os --unrestricted {
+		echo Loading kernel...
+		linux %[2]s %[3]s
+		echo Loading initrd...
+		initrd %[4]s
+	}
+	`
+}
+
+type BuildNetbootAction struct {
+	cfg  *types.BuildConfig
+	spec *types.Netboot
+	// basename of all netboot artifacts
+	name string
+}
+
+func NewBuildNetbootAction(cfg *types.BuildConfig, spec *types.Netboot) *BuildNetbootAction {
+	name := cfg.Name
+	if cfg.Date {
+		name = fmt.Sprintf("%s.%s", cfg.Name, time.Now().Format("20060102"))
+	}
+	return &BuildNetbootAction{cfg: cfg, spec: spec, name: name}
+}
+
+// Run builds the kernel, initrd and squashfs root of a live system, together with the iPXE script
+// and grub configuration to boot it over the network from the configured base URL
+func (b *BuildNetbootAction) Run() (err error) {
+	b.cfg.Logger.Infof("Building netboot artifacts for arch %s", b.cfg.Platform.Arch)
+
+	cleanup := utils.NewCleanStack()
+	defer func() { err = cleanup.Cleanup(err) }()
+
+	tmpDir, err := utils.TempDir(b.cfg.Fs, "", "elemental-netboot")
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateTempDir)
+	}
+	cleanup.Push(func() error { return b.cfg.Fs.RemoveAll(tmpDir) })
+
+	rootDir := filepath.Join(tmpDir, "rootfs")
+	err = utils.MkdirAll(b.cfg.Fs, rootDir, constants.DirPerm)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed creating rootfs dir: %s", rootDir)
+		return elementalError.NewFromError(err, elementalError.CreateDir)
+	}
+
+	outDir := b.cfg.OutDir
+	if outDir == "" {
+		outDir = "."
+	}
+	err = utils.MkdirAll(b.cfg.Fs, outDir, constants.DirPerm)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed creating output dir: %s", outDir)
+		return elementalError.NewFromError(err, elementalError.CreateDir)
+	}
+
+	b.cfg.Logger.Infof("Preparing squashfs root (%v source)...", len(b.spec.RootFS))
+	for _, src := range b.spec.RootFS {
+		err = elemental.DumpSource(b.cfg.Config, rootDir, src, utils.SyncData)
+		if err != nil {
+			b.cfg.Logger.Errorf("Failed installing OS packages: %v", err)
+			return elementalError.NewFromError(err, elementalError.DumpSource)
+		}
+	}
+	err = utils.CreateDirStructure(b.cfg.Fs, rootDir)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed creating root directory structure: %v", err)
+		return elementalError.NewFromError(err, elementalError.CreateDir)
+	}
+
+	// Kernel and initrd are copied next to the squashfs image, as done for the ISO
+	bootDir := filepath.Join(tmpDir, "boot")
+	image := &types.Image{
+		Source: types.NewDirSrc(rootDir),
+		File:   filepath.Join(bootDir, constants.ISORootFile),
+		FS:     constants.SquashFs,
+	}
+	err = elemental.DeployRecoverySystem(b.cfg.Config, image)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed creating squashfs root: %v", err)
+		return err
+	}
+
+	artifacts := map[string]string{
+		filepath.Join(bootDir, "linux"):               b.name + netbootKernelSuffix,
+		filepath.Join(bootDir, "initrd"):              b.name + netbootInitrdSuffix,
+		filepath.Join(bootDir, constants.ISORootFile): b.name + netbootRootFSSuffix,
+	}
+	if b.spec.CloudConfig != "" {
+		artifacts[b.spec.CloudConfig] = b.name + netbootCloudConfigSuffix
+	}
+	for src, dst := range artifacts {
+		b.cfg.Logger.Debugf("Copying %s to %s", src, dst)
+		err = utils.CopyFile(b.cfg.Fs, src, filepath.Join(outDir, dst))
+		if err != nil {
+			b.cfg.Logger.Errorf("Failed copying netboot artifact: %v", err)
+			return elementalError.NewFromError(err, elementalError.CopyFile)
+		}
+	}
+
+	b.cfg.Logger.Infof("Writing iPXE script and grub netboot configuration...")
+	err = b.writeBootConfigs(outDir)
+	if err != nil {
+		return err
+	}
+
+	err = b.writeChecksums(outDir)
+	if err != nil {
+		return err
+	}
+
+	b.cfg.Logger.Infof("Done! Netboot artifacts created at %s, serve them at %s", outDir, b.spec.BaseURL)
+	return nil
+}
+
+// Cmdline returns the kernel command line of the netboot artifacts. The squashfs root and the live
+// cloud-config, if any, are fetched over HTTP from the base URL
+func (b *BuildNetbootAction) Cmdline() string {
+	args := []string{
+		fmt.Sprintf("root=live:%s/%s%s", b.spec.BaseURL, b.name, netbootRootFSSuffix),
+		"rd.neednet=1", "ip=dhcp",
+	}
+	if b.spec.ExtraCmdline != "" {
+		args = append(args, b.spec.ExtraCmdline)
+	}
+	args = append(args, "elemental.disable")
+	if b.spec.CloudConfig != "" {
+		args = append(args, fmt.Sprintf("elemental.setup=%s/%s%s", b.spec.BaseURL, b.name, netbootCloudConfigSuffix))
+	}
+	return strings.Join(args, " ")
+}
+
+// writeBootConfigs renders the iPXE script and the grub configuration into the given directory.
+// Grub only supports plain HTTP, so it fetches the kernel and initrd from the base URL host over HTTP.
+func (b *BuildNetbootAction) writeBootConfigs(outDir string) error {
+	kernel := b.name + netbootKernelSuffix
+	initrd := b.name + netbootInitrdSuffix
+
+	ipxe := fmt.Sprintf(
+		ipxeScriptTemplate(), b.spec.GrubEntry, b.spec.BaseURL+"/"+kernel, initrd, b.Cmdline(), b.spec.BaseURL+"/"+initrd,
+	)
+	err := b.cfg.Fs.WriteFile(filepath.Join(outDir, b.name+netbootIPXESuffix), []byte(ipxe), constants.FilePerm)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed writing iPXE script: %v", err)
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+
+	u, err := url.Parse(b.spec.BaseURL)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	grubPrefix := fmt.Sprintf("(http,%s)%s", u.Host, u.Path)
+	grubCfg := fmt.Sprintf(
+		grubNetbootCfgTemplate(), b.spec.GrubEntry, grubPrefix+"/"+kernel, b.Cmdline(), grubPrefix+"/"+initrd,
+	)
+	err = b.cfg.Fs.WriteFile(filepath.Join(outDir, b.name+netbootGrubCfgSuffix), []byte(grubCfg), constants.FilePerm)
+	if err != nil {
+		b.cfg.Logger.Errorf("Failed writing grub netboot configuration: %v", err)
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	return nil
+}
+
+// writeChecksums writes the sha256 checksums of all the netboot artifacts in the given directory
+func (b *BuildNetbootAction) writeChecksums(outDir string) error {
+	var checksums strings.Builder
+
+	files := []string{
+		b.name + netbootKernelSuffix, b.name + netbootInitrdSuffix, b.name + netbootRootFSSuffix,
+		b.name + netbootIPXESuffix, b.name + netbootGrubCfgSuffix,
+	}
+	if b.spec.CloudConfig != "" {
+		files = append(files, b.name+netbootCloudConfigSuffix)
+	}
+	for _, file := range files {
+		checksum, err := utils.CalcFileChecksum(b.cfg.Fs, filepath.Join(outDir, file))
+		if err != nil {
+			b.cfg.Logger.Errorf("checksum computation failed: %v", err)
+			return elementalError.NewFromError(err, elementalError.CalculateChecksum)
+		}
+		checksums.WriteString(fmt.Sprintf("%s %s\n", checksum, file))
+	}
+	err := b.cfg.Fs.WriteFile(filepath.Join(outDir, b.name+netbootChecksumSuffix), []byte(checksums.String()), 0644)
+	if err != nil {
+		b.cfg.Logger.Errorf("cannot write checksum file: %v", err)
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+	return nil
+}
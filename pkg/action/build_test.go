@@ -17,13 +17,19 @@
 package action_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -35,6 +41,7 @@ import (
 	"github.com/rancher/elemental-toolkit/v2/pkg/config"
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
 )
@@ -133,6 +140,116 @@ var _ = Describe("Build Actions", func() {
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
+		It("Successfully builds a hybrid ISO for legacy BIOS and EFI", Label("bios"), func() {
+			rootSrc, _ := types.NewSrcFromURI("oci:elementalos:latest")
+			iso.RootFS = []*types.ImageSource{rootSrc}
+			iso.Firmware = types.BIOS
+
+			extractor.SideEffect = func(_, destination, _ string, _, _ bool) (string, error) {
+				Expect(utils.MkdirAll(fs, filepath.Join(destination, "boot"), constants.DirPerm)).To(Succeed())
+				Expect(utils.MkdirAll(fs, filepath.Join(destination, "lib/modules/6.4"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(destination, "boot/vmlinuz-6.4"), []byte{}, constants.FilePerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(destination, "boot/initrd"), []byte{}, constants.FilePerm)).To(Succeed())
+				return mocks.FakeDigest, nil
+			}
+
+			var xorriso []string
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "xorriso" {
+					xorriso = args
+					// The grub configuration is also available at the BIOS prefix of the ISO root
+					isoRoot := args[slices.Index(args, "-map")+1]
+					Expect(utils.Exists(fs, filepath.Join(isoRoot, "grub2/grub.cfg"))).To(BeTrue())
+					return []byte{}, fs.WriteFile(filepath.Join(cfg.OutDir, "elemental.iso"), []byte{}, constants.FilePerm)
+				}
+				return []byte{}, nil
+			}
+
+			buildISO := action.NewBuildISOAction(cfg, iso, action.WithLiveBootloader(bootloader))
+			Expect(buildISO.Run()).To(Succeed())
+
+			Expect(xorriso).To(ContainElements(
+				"bin_path=/grub2/i386-pc/eltorito.img", "grub2_boot_info=on", "platform_id=0x00", "next", "platform_id=0xef",
+			))
+			Expect(xorriso).To(ContainElement(MatchRegexp("^grub2_mbr=.+/grub2/i386-pc/boot_hybrid.img$")))
+		})
+		It("Successfully builds an ISO with an unattended installation config", Label("autoinstall"), func() {
+			rootSrc, _ := types.NewSrcFromURI("oci:elementalos:latest")
+			iso.RootFS = []*types.ImageSource{rootSrc}
+			iso.AutoInstall = "/config/autoinstall.yaml"
+
+			Expect(utils.MkdirAll(fs, "/config", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/config/autoinstall.yaml", []byte(
+				"poweroff: true\n"+
+					"install:\n"+
+					"  cloud-init:\n"+
+					"  - /config/users.yaml\n"+
+					"  - https://example.org/network.yaml\n"+
+					"autoinstall:\n"+
+					"  countdown: 30\n",
+			), constants.FilePerm)).To(Succeed())
+			Expect(fs.WriteFile("/config/users.yaml", []byte("name: users\n"), constants.FilePerm)).To(Succeed())
+
+			extractor.SideEffect = func(_, destination, _ string, _, _ bool) (string, error) {
+				Expect(utils.MkdirAll(fs, filepath.Join(destination, "boot"), constants.DirPerm)).To(Succeed())
+				Expect(utils.MkdirAll(fs, filepath.Join(destination, "lib/modules/6.4"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(destination, "boot/vmlinuz-6.4"), []byte{}, constants.FilePerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(destination, "boot/initrd"), []byte{}, constants.FilePerm)).To(Succeed())
+				return mocks.FakeDigest, nil
+			}
+
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "xorriso" {
+					isoRoot := args[slices.Index(args, "-map")+1]
+
+					// Local cloud-init files are embedded and referenced from the live media
+					autoCfg, err := fs.ReadFile(filepath.Join(isoRoot, "autoinstall/config.yaml"))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(autoCfg)).To(ContainSubstring("poweroff: true"))
+					Expect(string(autoCfg)).To(ContainSubstring("countdown: 30"))
+					Expect(string(autoCfg)).To(ContainSubstring("- /run/initramfs/live/autoinstall/cloud-init/0_users.yaml"))
+					Expect(string(autoCfg)).To(ContainSubstring("- https://example.org/network.yaml"))
+					users, err := fs.ReadFile(filepath.Join(isoRoot, "autoinstall/cloud-init/0_users.yaml"))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(users)).To(Equal("name: users\n"))
+
+					// The live system runs the installation at boot
+					liveCfg, err := fs.ReadFile(filepath.Join(isoRoot, "iso-config", constants.ISOAutoInstallCfgFile))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(liveCfg)).To(ContainSubstring("elemental autoinstall"))
+					return []byte{}, fs.WriteFile(filepath.Join(cfg.OutDir, "elemental.iso"), []byte{}, constants.FilePerm)
+				}
+				return []byte{}, nil
+			}
+
+			buildISO := action.NewBuildISOAction(cfg, iso, action.WithLiveBootloader(bootloader))
+			Expect(buildISO.Run()).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{{"xorriso"}})).To(Succeed())
+		})
+		It("Fails to build an ISO if the unattended installation cloud-init files are not found", Label("autoinstall"), func() {
+			rootSrc, _ := types.NewSrcFromURI("oci:elementalos:latest")
+			iso.RootFS = []*types.ImageSource{rootSrc}
+			iso.AutoInstall = "/config/autoinstall.yaml"
+
+			Expect(utils.MkdirAll(fs, "/config", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(
+				"/config/autoinstall.yaml", []byte("install:\n  cloud-init:\n  - /config/missing.yaml\n"), constants.FilePerm,
+			)).To(Succeed())
+
+			buildISO := action.NewBuildISOAction(cfg, iso, action.WithLiveBootloader(bootloader))
+			Expect(buildISO.Run()).NotTo(Succeed())
+			Expect(memLog.String()).To(ContainSubstring("Failed embedding unattended installation config"))
+			Expect(runner.IncludesCmds([][]string{{"xorriso"}})).NotTo(Succeed())
+		})
+		It("Fails to build a hybrid ISO if the El Torito image can't be created", Label("bios"), func() {
+			rootSrc, _ := types.NewSrcFromURI("oci:elementalos:latest")
+			iso.RootFS = []*types.ImageSource{rootSrc}
+			iso.Firmware = types.BIOS
+			bootloader.ErrorInstallBIOSEltorito = true
+
+			buildISO := action.NewBuildISOAction(cfg, iso, action.WithLiveBootloader(bootloader))
+			Expect(buildISO.Run()).NotTo(Succeed())
+		})
 		It("Fails on prepare EFI", func() {
 			iso.BootloaderInRootFs = true
 
@@ -200,6 +317,97 @@ var _ = Describe("Build Actions", func() {
 			Expect(err).Should(HaveOccurred())
 		})
 	})
+	Describe("Build netboot", Label("netboot"), func() {
+		var netboot *types.Netboot
+		BeforeEach(func() {
+			netboot = config.NewNetboot()
+			netboot.BaseURL = "http://pxe.example.org:8080/elemental"
+			rootSrc, _ := types.NewSrcFromURI("oci:elementalos:latest")
+			netboot.RootFS = []*types.ImageSource{rootSrc}
+
+			tmpDir, err := utils.TempDir(fs, "", "test")
+			Expect(err).ShouldNot(HaveOccurred())
+			cfg.Date = false
+			cfg.OutDir = tmpDir
+
+			extractor.SideEffect = func(_, destination, _ string, _, _ bool) (string, error) {
+				Expect(utils.MkdirAll(fs, filepath.Join(destination, "boot"), constants.DirPerm)).To(Succeed())
+				Expect(utils.MkdirAll(fs, filepath.Join(destination, "lib/modules/6.4"), constants.DirPerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(destination, "boot/vmlinuz-6.4"), []byte("kernel"), constants.FilePerm)).To(Succeed())
+				Expect(fs.WriteFile(filepath.Join(destination, "boot/initrd"), []byte("initrd"), constants.FilePerm)).To(Succeed())
+				return mocks.FakeDigest, nil
+			}
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "mksquashfs" {
+					return []byte{}, fs.WriteFile(args[1], []byte("rootfs"), constants.FilePerm)
+				}
+				return []byte{}, nil
+			}
+		})
+		It("Successfully builds the netboot artifacts", func() {
+			Expect(fs.WriteFile("/live.yaml", []byte("name: live"), constants.FilePerm)).To(Succeed())
+			netboot.CloudConfig = "/live.yaml"
+
+			buildNetboot := action.NewBuildNetbootAction(cfg, netboot)
+			Expect(buildNetboot.Run()).To(Succeed())
+
+			files, err := fs.ReadDir(cfg.OutDir)
+			Expect(err).NotTo(HaveOccurred())
+			var names []string
+			for _, f := range files {
+				names = append(names, f.Name())
+			}
+			Expect(names).To(ConsistOf(
+				"elemental-kernel", "elemental-initrd", "elemental.squashfs", "elemental-cloud-config.yaml",
+				"elemental.ipxe", "elemental-grub.cfg", "elemental.sha256",
+			))
+			Expect(fs.ReadFile(filepath.Join(cfg.OutDir, "elemental-kernel"))).To(Equal([]byte("kernel")))
+			Expect(fs.ReadFile(filepath.Join(cfg.OutDir, "elemental-initrd"))).To(Equal([]byte("initrd")))
+			Expect(fs.ReadFile(filepath.Join(cfg.OutDir, "elemental.squashfs"))).To(Equal([]byte("rootfs")))
+			Expect(fs.ReadFile(filepath.Join(cfg.OutDir, "elemental-cloud-config.yaml"))).To(Equal([]byte("name: live")))
+
+			cmdline := "root=live:http://pxe.example.org:8080/elemental/elemental.squashfs rd.neednet=1 ip=dhcp " +
+				constants.ISODefaultExtraCmdline + " elemental.disable " +
+				"elemental.setup=http://pxe.example.org:8080/elemental/elemental-cloud-config.yaml"
+			Expect(buildNetboot.Cmdline()).To(Equal(cmdline))
+
+			ipxe, err := fs.ReadFile(filepath.Join(cfg.OutDir, "elemental.ipxe"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(ipxe)).To(HavePrefix("#!ipxe\n"))
+			Expect(string(ipxe)).To(ContainSubstring(
+				"kernel http://pxe.example.org:8080/elemental/elemental-kernel initrd=elemental-initrd " + cmdline + "\n",
+			))
+			Expect(string(ipxe)).To(ContainSubstring("initrd http://pxe.example.org:8080/elemental/elemental-initrd\n"))
+
+			grubCfg, err := fs.ReadFile(filepath.Join(cfg.OutDir, "elemental-grub.cfg"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(grubCfg)).To(ContainSubstring(fmt.Sprintf("menuentry \"%s\"", constants.GrubDefEntry)))
+			Expect(string(grubCfg)).To(ContainSubstring("linux (http,pxe.example.org:8080)/elemental/elemental-kernel " + cmdline))
+			Expect(string(grubCfg)).To(ContainSubstring("initrd (http,pxe.example.org:8080)/elemental/elemental-initrd"))
+
+			checksums, err := fs.ReadFile(filepath.Join(cfg.OutDir, "elemental.sha256"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Split(strings.TrimSpace(string(checksums)), "\n")).To(HaveLen(6))
+			Expect(string(checksums)).To(ContainSubstring(
+				"6923dd1bc0460082c5d55a831908c24a282860b7f1cd6c2b79cf1bc8857c639c elemental-kernel",
+			))
+		})
+		It("Does not set the live cloud-config if not provided", func() {
+			buildNetboot := action.NewBuildNetbootAction(cfg, netboot)
+			Expect(buildNetboot.Run()).To(Succeed())
+
+			Expect(buildNetboot.Cmdline()).NotTo(ContainSubstring("elemental.setup"))
+			Expect(utils.Exists(fs, filepath.Join(cfg.OutDir, "elemental-cloud-config.yaml"))).To(BeFalse())
+		})
+		It("Fails if kernel or initrd is not found in rootfs", func() {
+			extractor.SideEffect = func(_, _, _ string, _, _ bool) (string, error) {
+				return mocks.FakeDigest, nil
+			}
+
+			buildNetboot := action.NewBuildNetbootAction(cfg, netboot)
+			Expect(buildNetboot.Run()).NotTo(Succeed())
+		})
+	})
 	Describe("Build disk", Label("disk", "build"), func() {
 		var disk *types.DiskSpec
 
@@ -244,6 +452,67 @@ var _ = Describe("Build Actions", func() {
 				{"partx", "-u", "/tmp/test/elemental.raw"},
 			})).To(Succeed())
 		})
+		It("Successfully builds a raw disk with a custom partition layout", func() {
+			disk.ExtraPartitions = types.PartitionList{{
+				Name: "firmware", Size: 4, Start: 8,
+				TypeGUID: "21686148-6449-6E6F-744E-656564454649", Attributes: []string{"required", "no-automount"},
+			}}
+			disk.PartitionOrder = []string{"firmware"}
+			disk.Partitions.OEM.UUID = "7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f"
+			Expect(disk.Sanitize()).To(Succeed())
+
+			buildDisk, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(buildDisk.BuildDiskRun()).To(Succeed())
+
+			// The firmware partition is placed first at 8MiB, the rest follow the default order
+			Expect(runner.MatchMilestones([][]string{
+				{"mkfs.vfat", "-n", "COS_GRUB"},
+				{"sgdisk", "-p", "-v", "/tmp/test/elemental.raw"},
+				{
+					"sgdisk", "-n=1:16384:+8192", "-c=1:firmware", "-t=1:21686148-6449-6E6F-744E-656564454649",
+					"-A=1:set:0", "-A=1:set:63", "-n=2:24576:+131072", "-c=2:efi", "-t=2:EF00",
+					"-n=3:155648:+131072", "-c=3:oem", "-t=3:8300", "-u=3:7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f",
+				},
+				{"partx", "-u", "/tmp/test/elemental.raw"},
+			})).To(Succeed())
+		})
+		It("Successfully builds a raw disk bootable on legacy BIOS", Label("bios"), func() {
+			disk.Firmware = types.BIOS
+			Expect(disk.Sanitize()).To(Succeed())
+
+			buildDisk, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(buildDisk.BuildDiskRun()).To(Succeed())
+
+			// The BIOS boot partition is placed first, before the EFI partition
+			Expect(runner.MatchMilestones([][]string{
+				{"mkfs.vfat", "-n", "COS_GRUB"},
+				{"sgdisk", "-p", "-v", "/tmp/test/elemental.raw"},
+				{
+					"sgdisk", "-n=1:2048:+2048", "-c=1:bios", "-t=1:21686148-6449-6E6F-744E-656564454649",
+					"-n=2:4096:+131072", "-c=2:efi", "-t=2:EF00",
+				},
+				{"partx", "-u", "/tmp/test/elemental.raw"},
+			})).To(Succeed())
+		})
+		It("Fails to build a legacy BIOS disk if grub can't be embedded", Label("bios"), func() {
+			disk.Firmware = types.BIOS
+			Expect(disk.Sanitize()).To(Succeed())
+			bootloader.ErrorSetupBIOS = true
+
+			buildDisk, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(buildDisk.BuildDiskRun()).NotTo(Succeed())
+		})
+		It("Fails to build an expandable disk with extra partitions", func() {
+			disk.Expandable = true
+			disk.ExtraPartitions = types.PartitionList{{Name: "firmware", Size: 4}}
+			Expect(disk.Sanitize()).NotTo(Succeed())
+		})
 		It("Successfully builds an expandable disk", func() {
 			disk.Expandable = true
 
@@ -265,6 +534,91 @@ var _ = Describe("Build Actions", func() {
 				{"partx", "-u", "/tmp/test/elemental.raw"},
 			})).To(Succeed())
 		})
+		It("Successfully builds a full raw disk without root privileges", Label("rootless"), func() {
+			cfg.Rootless = true
+
+			buildDisk, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).NotTo(HaveOccurred())
+			// test won't pass if any mount is called
+			mounter.ErrorOnMount = true
+
+			Expect(buildDisk.BuildDiskRun()).To(Succeed())
+
+			// Images are preloaded at format time
+			Expect(runner.MatchMilestones([][]string{
+				{"mksquashfs", "/tmp/test/build/recovery.img.root", "/tmp/test/build/recovery/boot/recovery.img"},
+				{"mkfs.ext2", "-L", "EL_SNAP1", "-d", "/tmp/test/build/state/.snapshots/1/snapshot.workDir"},
+				{"mkfs.ext4", "-L", "COS_STATE", "-d", "/tmp/test/build/state", "/tmp/test/build/state.part"},
+				{"mkfs.vfat", "-n", "COS_GRUB"},
+				{"mkfs.ext4", "-L", "COS_OEM", "-d", "/tmp/test/build/oem", "/tmp/test/build/oem.part"},
+				{"mkfs.ext4", "-L", "COS_RECOVERY", "-d", "/tmp/test/build/recovery", "/tmp/test/build/recovery.part"},
+				{"mkfs.ext4", "-L", "COS_PERSISTENT", "-d", "/tmp/test/build/persistent", "/tmp/test/build/persistent.part"},
+			})).To(Succeed())
+			for _, cmd := range runner.GetCmds() {
+				Expect(cmd[0]).NotTo(BeElementOf("losetup", "sgdisk", "partx", "mount"))
+			}
+
+			// GPT partition table is written natively
+			f, err := fs.Open("/tmp/test/elemental.raw")
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			header := make([]byte, 1024)
+			_, err = io.ReadFull(f, header)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(header[512:520])).To(Equal("EFI PART"))
+		})
+		It("Successfully builds a Raspberry Pi disk with a hybrid MBR", Label("rpi", "rootless"), func() {
+			cfg.Rootless = true
+			cfg.Platform, _ = types.NewPlatformFromArch(constants.ArchArm64)
+			recDir := filepath.Join(cfg.OutDir, "build/recovery.img.root")
+			Expect(utils.MkdirAll(fs, filepath.Join(recDir, "/boot/vc"), constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(recDir, "/boot/vc/start4.elf"), []byte{}, constants.FilePerm)).To(Succeed())
+			Expect(utils.MkdirAll(fs, filepath.Join(recDir, "/usr/lib/u-boot/rpi_arm64"), constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(recDir, "/usr/lib/u-boot/rpi_arm64/u-boot.bin"), []byte("uboot"), constants.FilePerm)).To(Succeed())
+			disk.Board = constants.RPiBoard
+			Expect(disk.Sanitize()).To(Succeed())
+
+			buildDisk, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(buildDisk.BuildDiskRun()).To(Succeed())
+
+			// Firmware, U-Boot and default firmware config are included in the EFI partition
+			Expect(runner.IncludesCmds([][]string{
+				{"rsync", "--progress", "--partial", "--human-readable", "--archive"},
+				{"mcopy", "-n", "-o", "-i", "/tmp/test/build/efi.part", "/tmp/test/build/efi/u-boot.bin", "::u-boot.bin"},
+				{"mcopy", "-n", "-o", "-i", "/tmp/test/build/efi.part", "/tmp/test/build/efi/config.txt", "::config.txt"},
+			})).To(Succeed())
+
+			// The EFI partition is the first entry of the hybrid MBR
+			data, err := fs.ReadFile("/tmp/test/elemental.raw")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data[446+4]).To(Equal(byte(partitioner.MBRFAT32Type)))
+			Expect(binary.LittleEndian.Uint32(data[446+8:])).To(Equal(uint32(2048)))
+			Expect(binary.LittleEndian.Uint32(data[446+12:])).To(Equal(uint32(constants.RPiBootSize * 2048)))
+			Expect(data[462+4]).To(Equal(byte(partitioner.MBRProtectiveType)))
+			Expect(string(data[512:520])).To(Equal("EFI PART"))
+		})
+		It("Fails to build a Raspberry Pi disk for other architectures", Label("rpi"), func() {
+			disk.Board = constants.RPiBoard
+			_, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).To(HaveOccurred())
+		})
+		It("Fails to build a Raspberry Pi disk if the firmware is not found", Label("rpi"), func() {
+			cfg.Platform, _ = types.NewPlatformFromArch(constants.ArchArm64)
+			disk.Board = constants.RPiBoard
+			buildDisk, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(buildDisk.BuildDiskRun()).NotTo(Succeed())
+		})
+		It("Fails to build a rootless disk with the btrfs snapshotter", Label("rootless"), func() {
+			cfg.Rootless = true
+			cfg.Snapshotter = types.NewBtrfs()
+
+			_, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
+			Expect(err).To(HaveOccurred())
+		})
 		It("Fails to build an expandable disk if expandable cloud config cannot be written", func() {
 			disk.Expandable = true
 			buildDisk, err := action.NewBuildDiskAction(cfg, disk, action.WithDiskBootloader(bootloader))
@@ -298,9 +652,18 @@ var _ = Describe("Build Actions", func() {
 			Expect(err).ToNot(HaveOccurred())
 			// Log should have the rounded size (1Gb)
 			Expect(memLog.String()).To(ContainSubstring(strconv.Itoa(1 * 1024 * 1024 * 1024)))
-			// Should be a tar file
-			//realPath, _ := fs.RawPath(tmpDir)
-			//Expect(dockerArchive.IsArchivePath(filepath.Join(realPath, "disk.raw.tar.gz"))).To(BeTrue())
+			// Should be a tar.gz file including the sparse disk.raw with the rounded size
+			archive, err := fs.Open(filepath.Join(tmpDir, "disk.raw.tar.gz"))
+			Expect(err).ToNot(HaveOccurred())
+			defer archive.Close()
+			gzipReader, err := gzip.NewReader(archive)
+			Expect(err).ToNot(HaveOccurred())
+			header, err := tar.NewReader(gzipReader).Next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(header.Name).To(Equal("disk.raw"))
+			Expect(header.Size).To(Equal(int64(1024 * 1024 * 1024)))
+			// Source image is removed
+			Expect(utils.Exists(fs, filepath.Join(tmpDir, "disk.raw"))).To(BeFalse())
 		})
 		It("Transforms raw image into Azure image", func() {
 			tmpDir, err := utils.TempDir(fs, "", "")
@@ -335,6 +698,75 @@ var _ = Describe("Build Actions", func() {
 			Expect(hex.EncodeToString(header.Features[:])).To(Equal("00000002"))
 			Expect(hex.EncodeToString(header.DataOffset[:])).To(Equal("ffffffffffffffff"))
 		})
+		It("Transforms raw image into QCOW2 image", Label("qcow2"), func() {
+			tmpDir, err := utils.TempDir(fs, "", "")
+			defer fs.RemoveAll(tmpDir)
+			Expect(err).ToNot(HaveOccurred())
+			f, err := fs.Create(filepath.Join(tmpDir, "disk.raw"))
+			Expect(err).ToNot(HaveOccurred())
+			_, _ = f.WriteAt([]byte("Hi"), 10*1024*1024)
+			_ = f.Close()
+			err = action.Raw2Qcow2(filepath.Join(tmpDir, "disk.raw"), fs, logger, constants.ZlibCompression, false)
+			Expect(err).ToNot(HaveOccurred())
+			// Raw image is removed
+			Expect(utils.Exists(fs, filepath.Join(tmpDir, "disk.raw"))).To(BeFalse())
+
+			f, _ = fs.OpenFile(filepath.Join(tmpDir, "disk.raw.qcow2"), os.O_RDONLY, constants.FilePerm)
+			header := utils.QCOW2Header{}
+			err = binary.Read(f, binary.BigEndian, &header)
+			_ = f.Close()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(header.Magic).To(Equal(uint32(utils.QCOW2Magic)))
+			Expect(header.Size).To(Equal(uint64(10*1024*1024 + 2)))
+			Expect(header.CompressionType).To(Equal(uint8(utils.QCOW2ZlibCompressionType)))
+		})
+		It("Transforms raw image into OVA appliance", Label("ova"), func() {
+			tmpDir, err := utils.TempDir(fs, "", "")
+			defer fs.RemoveAll(tmpDir)
+			Expect(err).ToNot(HaveOccurred())
+			f, err := fs.Create(filepath.Join(tmpDir, "disk.raw"))
+			Expect(err).ToNot(HaveOccurred())
+			_, _ = f.WriteAt([]byte("Hi"), 3*1024*1024)
+			_ = f.Close()
+			vm := types.VMSpec{CPUs: 2, Memory: 2048}
+			err = action.Raw2Ova(filepath.Join(tmpDir, "disk.raw"), fs, logger, vm, types.EFI, false)
+			Expect(err).ToNot(HaveOccurred())
+			// Only the OVA bundle is kept
+			Expect(utils.Exists(fs, filepath.Join(tmpDir, "disk.raw"))).To(BeFalse())
+			Expect(utils.Exists(fs, filepath.Join(tmpDir, "disk.raw.vmdk"))).To(BeFalse())
+
+			f, _ = fs.OpenFile(filepath.Join(tmpDir, "disk.raw.ova"), os.O_RDONLY, constants.FilePerm)
+			defer f.Close()
+			tarReader := tar.NewReader(f)
+			var names []string
+			for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+				names = append(names, header.Name)
+			}
+			Expect(names).To(Equal([]string{"disk.raw.ovf", "disk.raw.mf", "disk.raw.vmdk"}))
+		})
+		It("Transforms raw image into dynamic VHD and VHDX images", Label("vhd", "vhdx"), func() {
+			tmpDir, err := utils.TempDir(fs, "", "")
+			defer fs.RemoveAll(tmpDir)
+			Expect(err).ToNot(HaveOccurred())
+			f, err := fs.Create(filepath.Join(tmpDir, "disk.raw"))
+			Expect(err).ToNot(HaveOccurred())
+			// Large and mostly empty disk
+			_, _ = f.WriteAt([]byte("Hi"), 100*1024*1024)
+			_ = f.Close()
+
+			err = action.Raw2Vhd(filepath.Join(tmpDir, "disk.raw"), fs, logger, true)
+			Expect(err).ToNot(HaveOccurred())
+			info, err := fs.Stat(filepath.Join(tmpDir, "disk.raw.vhd"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Size()).To(BeNumerically("<", 3*1024*1024))
+
+			err = action.Raw2Vhdx(filepath.Join(tmpDir, "disk.raw"), fs, logger, false)
+			Expect(err).ToNot(HaveOccurred())
+			info, err = fs.Stat(filepath.Join(tmpDir, "disk.raw.vhdx"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Size()).To(BeNumerically("<", 7*1024*1024))
+			Expect(utils.Exists(fs, filepath.Join(tmpDir, "disk.raw"))).To(BeFalse())
+		})
 		It("Transforms raw image into Azure image (tiny image)", func() {
 			// This tests that the resize works for tiny images
 			// Not sure if we ever will encounter them (less than 1 Mb images?) but just in case
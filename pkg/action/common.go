@@ -17,8 +17,14 @@ limitations under the License.
 package action
 
 import (
+	"path/filepath"
+	"slices"
+	"strings"
+
 	"github.com/sirupsen/logrus"
 
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
 	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
@@ -69,3 +75,37 @@ func PowerAction(cfg *types.RunConfig) error {
 
 	return elementalError.NewFromError(err, code)
 }
+
+// setRecoveryRootHash sets the dm-verity root hashes of the recovery images into the bootloader environment
+// of the given EFI directory. Any of the given hashes is accepted at boot, so the current and the new recovery
+// images are both verified while the new one replaces the current one. If any of the hashes is empty the image
+// can't be verified and any previously set value is cleared.
+func setRecoveryRootHash(cfg *types.Config, bootloader types.Bootloader, efiDir string, hashes ...string) error {
+	value := strings.Join(hashes, ",")
+	if slices.Contains(hashes, "") {
+		value = ""
+	}
+	envFile := filepath.Join(efiDir, constants.GrubOEMEnv)
+	err := bootloader.SetPersistentVariables(envFile, map[string]string{constants.GrubRecoveryVerity: value})
+	if err != nil {
+		cfg.Logger.Errorf("failed setting recovery dm-verity root hash in %s: %v", envFile, err)
+	}
+	return err
+}
+
+// syncBootMirrors copies the content of the given EFI partition to the EFI partitions of the mirrored disks
+// recorded in the given installation state, so all disks boot with the same bootloader environment.
+func syncBootMirrors(cfg *types.Config, boot *types.Partition, state *types.InstallState) error {
+	if boot == nil || state == nil || state.Partitions[constants.BootPartName] == nil {
+		return nil
+	}
+	mirrors := state.Partitions[constants.BootPartName].Mirrors
+	if len(mirrors) == 0 {
+		return nil
+	}
+	err := elemental.SyncBootMirrors(*cfg, boot, mirrors, nil)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.MirrorBootPartition)
+	}
+	return nil
+}
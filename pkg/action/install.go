@@ -125,7 +125,8 @@ func (i *InstallAction) createInstallStateYaml() error {
 		Snapshotter: i.cfg.Snapshotter,
 		Partitions: map[string]*types.PartitionState{
 			cnst.StatePartName: {
-				FSLabel: i.spec.Partitions.State.FilesystemLabel,
+				FSLabel:    i.spec.Partitions.State.FilesystemLabel,
+				RAIDDevice: raidDevice(i.spec.Partitions.State),
 				Snapshots: map[int]*types.SystemState{
 					i.snapshot.ID: {
 						Source:     i.spec.System,
@@ -134,11 +135,13 @@ func (i *InstallAction) createInstallStateYaml() error {
 						Labels:     i.spec.SnapshotLabels,
 						Date:       date,
 						FromAction: cnst.ActionInstall,
+						RootHash:   i.snapshot.RootHash,
 					},
 				},
 			},
 			cnst.RecoveryPartName: {
-				FSLabel: i.spec.Partitions.Recovery.FilesystemLabel,
+				FSLabel:    i.spec.Partitions.Recovery.FilesystemLabel,
+				RAIDDevice: raidDevice(i.spec.Partitions.Recovery),
 				RecoveryImage: &types.SystemState{
 					Source:     i.spec.RecoverySystem.Source,
 					Digest:     i.spec.RecoverySystem.Source.GetDigest(),
@@ -147,6 +150,7 @@ func (i *InstallAction) createInstallStateYaml() error {
 					Labels:     i.spec.SnapshotLabels,
 					Date:       date,
 					FromAction: cnst.ActionInstall,
+					RootHash:   i.spec.RecoverySystem.RootHash,
 				},
 			},
 		},
@@ -154,17 +158,21 @@ func (i *InstallAction) createInstallStateYaml() error {
 
 	if i.spec.Partitions.OEM != nil {
 		installState.Partitions[cnst.OEMPartName] = &types.PartitionState{
-			FSLabel: i.spec.Partitions.OEM.FilesystemLabel,
+			FSLabel:    i.spec.Partitions.OEM.FilesystemLabel,
+			RAIDDevice: raidDevice(i.spec.Partitions.OEM),
 		}
 	}
 	if i.spec.Partitions.Persistent != nil {
 		installState.Partitions[cnst.PersistentPartName] = &types.PartitionState{
-			FSLabel: i.spec.Partitions.Persistent.FilesystemLabel,
+			FSLabel:    i.spec.Partitions.Persistent.FilesystemLabel,
+			Encryption: i.spec.Partitions.Persistent.Encryption.VolumeEncryption(),
+			RAIDDevice: raidDevice(i.spec.Partitions.Persistent),
 		}
 	}
 	if i.spec.Partitions.Boot != nil {
 		installState.Partitions[cnst.BootPartName] = &types.PartitionState{
 			FSLabel: i.spec.Partitions.Boot.FilesystemLabel,
+			Mirrors: i.bootMirrors(),
 		}
 	}
 
@@ -175,6 +183,14 @@ func (i *InstallAction) createInstallStateYaml() error {
 	)
 }
 
+// raidDevice returns the RAID array device of the given partition, if any
+func raidDevice(part *types.Partition) string {
+	if part.RAID == nil {
+		return ""
+	}
+	return part.RAID.Device
+}
+
 // InstallRun will install the system from a given configuration
 func (i InstallAction) Run() (err error) {
 	cleanup := utils.NewCleanStack()
@@ -190,6 +206,11 @@ func (i InstallAction) Run() (err error) {
 		i.spec.System = isoSrc
 	}
 
+	// Close any encrypted partition opened while preparing the device
+	cleanup.Push(func() error {
+		return elemental.CloseEncryptedPartitions(i.cfg.Config, i.spec.Partitions.PartitionsByInstallOrder(i.spec.ExtraPartitions))
+	})
+
 	// Partition and format device if needed
 	err = i.prepareDevice()
 	if err != nil {
@@ -272,12 +293,24 @@ func (i InstallAction) Run() (err error) {
 		i.cfg.Logger.Errorf("Failed deploying recovery image: %v", err)
 		return elementalError.NewFromError(err, elementalError.DeployImage)
 	}
+	i.spec.RecoverySystem.RootHash = recoverySystem.RootHash
+
+	err = setRecoveryRootHash(&i.cfg.Config, i.bootloader, i.spec.Partitions.Boot.MountPoint, recoverySystem.RootHash)
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.SetGrubVariables)
+	}
 
 	err = i.installHook(cnst.PostInstallHook)
 	if err != nil {
 		return elementalError.NewFromError(err, elementalError.HookPostInstall)
 	}
 
+	// Replicate the EFI partition to all mirrored disks
+	err = i.mirrorBootPartition()
+	if err != nil {
+		return elementalError.NewFromError(err, elementalError.MirrorBootPartition)
+	}
+
 	// Add state.yaml file on state and recovery partitions
 	i.cfg.Logger.Info("Creating installation state files")
 	err = i.createInstallStateYaml()
@@ -303,6 +336,30 @@ func (i InstallAction) Run() (err error) {
 	return PowerAction(i.cfg)
 }
 
+// mirrorBootPartition copies the content of the EFI partition to the EFI partitions of all
+// mirrored disks and adds an EFI boot entry for each of them, so the system can boot
+// from any of the disks.
+func (i *InstallAction) mirrorBootPartition() error {
+	boot := i.spec.Partitions.Boot
+	if boot == nil || len(boot.Mirrors) < 2 {
+		return nil
+	}
+	return elemental.SyncBootMirrors(i.cfg.Config, boot, boot.Mirrors[1:], i.bootloader.AddEFIEntry)
+}
+
+// bootMirrors returns the stable paths of the EFI partitions of all mirrored disks, if any
+func (i *InstallAction) bootMirrors() []string {
+	boot := i.spec.Partitions.Boot
+	if boot == nil || len(boot.Mirrors) < 2 {
+		return nil
+	}
+	mirrors := []string{}
+	for _, dev := range boot.Mirrors[1:] {
+		mirrors = append(mirrors, elemental.MirrorDevicePath(i.cfg.Config, dev))
+	}
+	return mirrors
+}
+
 func (i *InstallAction) prepareDevice() error {
 	if i.spec.NoFormat {
 		if elemental.CheckActiveDeployment(i.cfg.Config) && !i.spec.Force {
@@ -339,6 +396,22 @@ func (i *InstallAction) refineDeployment() error { //nolint:dupl
 		return elementalError.NewFromError(err, elementalError.InstallGrub)
 	}
 
+	// Legacy BIOS also requires the grub core image embedded in the target disk and all its mirrors
+	if i.spec.Firmware == types.BIOS {
+		boot := i.spec.Partitions.Boot
+		err = i.bootloader.InstallBIOS(i.snapshot.WorkDir, boot.MountPoint, boot.FilesystemLabel)
+		for _, disk := range append([]string{i.spec.Target}, i.spec.MirrorTargets...) {
+			if err != nil {
+				break
+			}
+			err = i.bootloader.SetupBIOS(boot.MountPoint, disk)
+		}
+		if err != nil {
+			i.cfg.Logger.Errorf("failed installing grub for legacy BIOS: %v", err)
+			return elementalError.NewFromError(err, elementalError.InstallGrub)
+		}
+	}
+
 	err = i.installChrootHook(cnst.AfterInstallChrootHook, cnst.WorkingImgDir)
 	if err != nil {
 		i.cfg.Logger.Errorf("failed after-install-chroot hook: %v", err)
@@ -351,6 +424,9 @@ func (i *InstallAction) refineDeployment() error { //nolint:dupl
 	}
 
 	grubVars := i.spec.GetGrubLabels()
+	if raidCmdline := elemental.RAIDKernelCmdline(i.spec.Partitions.PartitionsByInstallOrder(i.spec.ExtraPartitions)); raidCmdline != "" {
+		grubVars[cnst.GrubRAIDCmdline] = raidCmdline
+	}
 	err = i.bootloader.SetPersistentVariables(
 		filepath.Join(i.spec.Partitions.Boot.MountPoint, cnst.GrubOEMEnv),
 		grubVars,
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"path/filepath"
+	"slices"
 
 	"github.com/jaypipes/ghw/pkg/block"
 
@@ -103,8 +104,9 @@ var _ = Describe("Install action tests", func() {
 
 			bootloader = &mocks.FakeBootloader{}
 
-			partNum := 0
-			partedOut := printOutput
+			// Partition tables of each disk
+			partNum := map[string]int{}
+			partedOut := map[string]string{}
 			cmdFail = ""
 			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
 				if cmdFail == cmd {
@@ -112,19 +114,25 @@ var _ = Describe("Install action tests", func() {
 				}
 				switch cmd {
 				case "parted":
-					idx := 0
+					idx, disk := 0, ""
 					for i, arg := range args {
+						if arg == "--" && i+1 < len(args) {
+							disk = args[i+1]
+						}
 						if arg == "mkpart" {
 							idx = i
 							break
 						}
 					}
+					if _, ok := partedOut[disk]; !ok {
+						partedOut[disk] = printOutput
+					}
 					if idx > 0 {
-						partNum++
-						partedOut += fmt.Sprintf(partTmpl, partNum, args[idx+3], args[idx+4])
-						_, _ = fs.Create(fmt.Sprintf("/some/device%d", partNum))
+						partNum[disk]++
+						partedOut[disk] += fmt.Sprintf(partTmpl, partNum[disk], args[idx+3], args[idx+4])
+						_, _ = fs.Create(fmt.Sprintf("%s%d", disk, partNum[disk]))
 					}
-					return []byte(partedOut), nil
+					return []byte(partedOut[disk]), nil
 				case "lsblk":
 					return []byte(`{
 "blockdevices":
@@ -141,6 +149,11 @@ var _ = Describe("Install action tests", func() {
 						return cmdline()
 					}
 					return []byte{}, nil
+				case "mdadm":
+					if slices.Contains(args, "--detail") {
+						return []byte("MD_UUID=1c7c2a6a:8d1f4b2e:9a3b5c7d:1e2f3a4b\n"), nil
+					}
+					return []byte{}, nil
 				default:
 					return []byte{}, nil
 				}
@@ -391,6 +404,35 @@ var _ = Describe("Install action tests", func() {
 			Expect(err.Error()).To(ContainSubstring("error installing grub"))
 		})
 
+		It("Successfully installs for legacy BIOS", Label("grub", "bios"), func() {
+			spec.Target = device
+			spec.Firmware = types.BIOS
+			Expect(spec.Partitions.SetFirmwarePartitions(types.BIOS, types.GPT)).To(Succeed())
+			Expect(installer.Run()).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{{"parted", "--script", "--machine", "--", device, "unit", "s", "mkpart", "bios"}})).To(BeNil())
+		})
+
+		It("Successfully installs for legacy BIOS on mirrored disks", Label("grub", "bios", "mirror"), func() {
+			mirror := "/some/mirror"
+			_, err = fs.Create(mirror)
+			Expect(err).ShouldNot(HaveOccurred())
+			spec.Target = device
+			spec.MirrorTargets = []string{mirror}
+			spec.Firmware = types.BIOS
+			Expect(spec.Partitions.SetFirmwarePartitions(types.BIOS, types.GPT)).To(Succeed())
+			Expect(installer.Run()).To(BeNil())
+			Expect(bootloader.BIOSDisks).To(Equal([]string{device, mirror}))
+		})
+
+		It("Fails on legacy BIOS grub install errors", Label("grub", "bios"), func() {
+			spec.Target = device
+			spec.Firmware = types.BIOS
+			bootloader.ErrorSetupBIOS = true
+			err = installer.Run()
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("error setting up bios bootloader"))
+		})
+
 		It("Fails setting the grub default entry", Label("grub"), func() {
 			spec.Target = device
 			spec.GrubDefEntry = "cOS"
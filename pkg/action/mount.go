@@ -28,6 +28,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
 )
@@ -112,6 +113,7 @@ func MountVolumes(cfg *types.RunConfig, spec *types.MountSpec) error {
 
 	sort.Strings(keys)
 
+	assembled := false
 	for _, k := range keys {
 		var dev string
 		switch {
@@ -128,6 +130,35 @@ func MountVolumes(cfg *types.RunConfig, spec *types.MountSpec) error {
 			errs = multierror.Append(errs, fmt.Errorf("unkown device reference: %s", volumes[k].Device))
 			continue
 		}
+		if strings.HasPrefix(dev, constants.RAIDDevDir) && !assembled {
+			if ok, _ := utils.Exists(cfg.Fs, dev); !ok {
+				elemental.AssembleRAIDArrays(cfg.Config)
+				assembled = true
+			}
+		}
+		if volumes[k].Encryption != nil {
+			enc := *volumes[k].Encryption
+			// Key files are only read from the initrd, never from the unencrypted system image
+			if enc.KeyFile != "" {
+				if ok, _ := utils.Exists(cfg.Fs, enc.KeyFile); !ok {
+					if !enc.TPM {
+						cfg.Logger.Errorf("key file %s not found in the initrd, can't unlock %s", enc.KeyFile, dev)
+						errs = multierror.Append(errs, fmt.Errorf("key file %s not found in the initrd", enc.KeyFile))
+						continue
+					}
+					cfg.Logger.Warnf("key file %s not found in the initrd, unlocking %s with TPM2 only", enc.KeyFile, dev)
+					enc.KeyFile = ""
+				}
+			}
+			unlocked, err := elemental.UnlockDevice(cfg.Config, dev, mapperName(volumes[k]), &enc)
+			if err != nil {
+				cfg.Logger.Errorf("failed unlocking encrypted device %s", dev)
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			dev = unlocked
+		}
+
 		mountpoint := volumes[k].Mountpoint
 		if !strings.HasPrefix(mountpoint, runPath) {
 			mountpoint = filepath.Join(spec.Sysroot, mountpoint)
@@ -149,6 +180,9 @@ func MountVolumes(cfg *types.RunConfig, spec *types.MountSpec) error {
 		err = cfg.Mounter.Mount(dev, mountpoint, fstype, volumes[k].Options)
 		if err != nil {
 			cfg.Logger.Errorf("failed mounting device %s to %s", dev, mountpoint)
+			if slices.Contains(volumes[k].Options, constants.PrjQuotaOpt) {
+				cfg.Logger.Errorf("project quotas require an xfs or ext4 filesystem, ext4 also requires the 'quota' and 'project' features")
+			}
 			errs = multierror.Append(errs, err)
 		}
 	}
@@ -171,7 +205,7 @@ func MountEphemeral(cfg *types.RunConfig, sysroot string, overlay types.Ephemera
 	case constants.Tmpfs:
 		overlaySource = constants.Tmpfs
 		overlayFS = constants.Tmpfs
-		overlayOpts = []string{"defaults", fmt.Sprintf("size=%s", overlay.Size)}
+		overlayOpts = tmpfsOptions(overlay.Size)
 	case constants.Block:
 		overlaySource = overlay.Device
 		overlayFS = constants.Autofs
@@ -186,9 +220,22 @@ func MountEphemeral(cfg *types.RunConfig, sysroot string, overlay types.Ephemera
 	}
 
 	for _, path := range overlay.Paths {
-		cfg.Logger.Debugf("Mounting path %s into %s", path, sysroot)
-		if err := MountOverlayPath(cfg, sysroot, constants.OverlayDir, path); err != nil {
-			cfg.Logger.Errorf("Error mounting path %s: %s", path, err.Error())
+		if path.Size != "" {
+			dir := ephemeralPathDir(path.Path)
+			cfg.Logger.Debugf("Mounting tmpfs of size %s for path %s", path.Size, path.Path)
+			if err := utils.MkdirAll(cfg.Fs, dir, constants.DirPerm); err != nil {
+				cfg.Logger.Errorf("Error creating directory %s: %s", dir, err.Error())
+				return err
+			}
+			if err := cfg.Mounter.Mount(constants.Tmpfs, dir, constants.Tmpfs, tmpfsOptions(path.Size)); err != nil {
+				cfg.Logger.Errorf("Error mounting tmpfs for path %s: %s", path.Path, err.Error())
+				return err
+			}
+		}
+
+		cfg.Logger.Debugf("Mounting path %s into %s", path.Path, sysroot)
+		if err := MountOverlayPath(cfg, sysroot, constants.OverlayDir, path.Path); err != nil {
+			cfg.Logger.Errorf("Error mounting path %s: %s", path.Path, err.Error())
 			return err
 		}
 	}
@@ -196,12 +243,16 @@ func MountEphemeral(cfg *types.RunConfig, sysroot string, overlay types.Ephemera
 	return nil
 }
 
-func MountPersistent(cfg *types.RunConfig, spec *types.MountSpec) error {
-	mountFunc := MountOverlayPath
-	if spec.Persistent.Mode == "bind" {
-		mountFunc = MountBindPath
-	}
+// ephemeralPathDir returns the directory holding the upper and work dirs of an ephemeral path
+func ephemeralPathDir(path string) string {
+	return filepath.Join(constants.OverlayDir, persistentPathName(path)+overlaySuffix)
+}
+
+func tmpfsOptions(size string) []string {
+	return []string{"defaults", fmt.Sprintf("size=%s", size)}
+}
 
+func MountPersistent(cfg *types.RunConfig, spec *types.MountSpec) error {
 	if !spec.HasPersistent() {
 		cfg.Logger.Debug("No persistent device defined, omitting persistent paths mounts")
 		return nil
@@ -212,10 +263,49 @@ func MountPersistent(cfg *types.RunConfig, spec *types.MountSpec) error {
 		target = filepath.Join(spec.Sysroot, target)
 	}
 
+	var quota *projectQuota
+	if spec.Persistent.HasQuotas() {
+		var err error
+		quota, err = newProjectQuota(cfg, filepath.Dir(target))
+		if err != nil {
+			cfg.Logger.Errorf("Error checking project quotas support: %s", err.Error())
+			return err
+		}
+	}
+
 	for _, path := range spec.Persistent.Paths {
-		cfg.Logger.Debugf("Mounting path %s into %s", path, spec.Sysroot)
-		if err := mountFunc(cfg, spec.Sysroot, target, path); err != nil {
-			cfg.Logger.Errorf("Error mounting path %s: %s", path, err.Error())
+		mode := persistenceMode(spec, path)
+		mountFunc := MountOverlayPath
+		suffix := overlaySuffix
+		if mode == constants.BindMode {
+			mountFunc = MountBindPath
+			suffix = ".bind"
+		}
+
+		if err := resetPersistentPath(cfg, target, path.Path); err != nil {
+			cfg.Logger.Errorf("Error resetting path %s: %s", path.Path, err.Error())
+			return err
+		}
+
+		if spec.Persistent.Reconcile {
+			cfg.Logger.Debugf("Reconciling path %s with the image defaults", path.Path)
+			if err := ReconcilePersistentPath(cfg, spec.Sysroot, target, path.Path, mode); err != nil {
+				cfg.Logger.Errorf("Error reconciling path %s: %s", path.Path, err.Error())
+				return err
+			}
+		}
+
+		if path.Size != "" {
+			dataDir := filepath.Join(target, persistentPathName(path.Path)+suffix)
+			if err := quota.limit(dataDir, path.Size); err != nil {
+				cfg.Logger.Errorf("Error setting quota of path %s: %s", path.Path, err.Error())
+				return err
+			}
+		}
+
+		cfg.Logger.Debugf("Mounting path %s into %s", path.Path, spec.Sysroot)
+		if err := mountFunc(cfg, spec.Sysroot, target, path.Path); err != nil {
+			cfg.Logger.Errorf("Error mounting path %s: %s", path.Path, err.Error())
 			return err
 		}
 	}
@@ -223,6 +313,15 @@ func MountPersistent(cfg *types.RunConfig, spec *types.MountSpec) error {
 	return nil
 }
 
+// persistenceMode returns the persistence mode of the given path, paths without
+// their own mode use the mode of the persistent mounts
+func persistenceMode(spec *types.MountSpec, path types.PersistentPath) string {
+	if path.Mode != "" {
+		return path.Mode
+	}
+	return spec.Persistent.Mode
+}
+
 type MountFunc func(cfg *types.RunConfig, sysroot, overlayDir, path string) error
 
 func MountBindPath(cfg *types.RunConfig, sysroot, overlayDir, path string) error {
@@ -243,9 +342,13 @@ func MountBindPath(cfg *types.RunConfig, sysroot, overlayDir, path string) error
 		return err
 	}
 
-	if err := utils.SyncData(cfg.Logger, cfg.Runner, cfg.Fs, base, stateDir); err != nil {
-		cfg.Logger.Errorf("Error shuffling data: %s", err.Error())
-		return err
+	// The state dir is only seeded with the image data once, later image
+	// defaults are merged by the persistent paths reconciliation
+	if entries, _ := cfg.Fs.ReadDir(stateDir); len(entries) == 0 {
+		if err := utils.SyncData(cfg.Logger, cfg.Runner, cfg.Fs, base, stateDir); err != nil {
+			cfg.Logger.Errorf("Error shuffling data: %s", err.Error())
+			return err
+		}
 	}
 
 	if err := cfg.Mounter.Mount(stateDir, base, "none", []string{"defaults", "bind"}); err != nil {
@@ -294,45 +397,76 @@ func MountOverlayPath(cfg *types.RunConfig, sysroot, overlayDir, path string) er
 }
 
 func WriteFstab(cfg *types.RunConfig, spec *types.MountSpec, data string) error {
-	var errs error
-
 	if !spec.WriteFstab {
 		cfg.Logger.Debug("Skipping writing fstab")
 		return nil
 	}
 
+	entries := mountEntries(spec)
+	if spec.Output == constants.SystemdUnitsOutput {
+		cfg.Logger.Debug("Writing mount units")
+		if err := WriteMountUnits(cfg, spec.Sysroot, entries); err != nil {
+			cfg.Logger.Errorf("Error writing mount units: %s", err.Error())
+			return err
+		}
+	} else {
+		for _, entry := range entries {
+			data += fstab(entry.device, entry.path, entry.fstype, entry.options)
+		}
+	}
+
+	return cfg.Config.Fs.WriteFile(filepath.Join(spec.Sysroot, "/etc/fstab"), []byte(data), 0644)
+}
+
+// mountEntries returns the mounts set up by elemental which have to be kept after switching root
+func mountEntries(spec *types.MountSpec) []mountEntry {
+	entries := []mountEntry{}
+
 	for _, vol := range spec.Volumes {
-		data += fstab(vol.Device, vol.Mountpoint, vol.FSType, vol.Options)
+		entries = append(entries, mountEntry{
+			device: fstabDevice(vol), path: vol.Mountpoint, fstype: vol.FSType, options: vol.Options,
+		})
 	}
 
 	if spec.HasPersistent() {
 		pVol := spec.Persistent.Volume
-		data += fstab(pVol.Device, pVol.Mountpoint, pVol.FSType, pVol.Options)
-
-		for _, path := range spec.Persistent.Paths {
-			if spec.Persistent.Mode == constants.OverlayMode {
-				data += overlayLine(path, filepath.Join(pVol.Mountpoint, constants.PersistentStateDir), constants.PersistentDir)
-				continue
-			}
+		entries = append(entries, mountEntry{
+			device: fstabDevice(&pVol), path: pVol.Mountpoint, fstype: pVol.FSType, options: pVol.Options,
+		})
 
-			if spec.Persistent.Mode == constants.BindMode {
+		for _, pPath := range spec.Persistent.Paths {
+			path := pPath.Path
+			switch persistenceMode(spec, pPath) {
+			case constants.OverlayMode:
+				entries = append(entries, overlayEntry(path, filepath.Join(pVol.Mountpoint, constants.PersistentStateDir), pVol.Mountpoint))
+			case constants.BindMode:
 				trimmed := strings.TrimPrefix(path, "/")
 				pathName := strings.ReplaceAll(trimmed, "/", "-") + ".bind"
 				stateDir := filepath.Join(pVol.Mountpoint, constants.PersistentStateDir, pathName)
 
-				data = data + fstab(stateDir, path, "none", []string{"defaults", "bind"})
-				continue
+				entries = append(entries, mountEntry{
+					device: stateDir, path: path, fstype: "none", options: []string{"defaults", "bind"}, requires: pVol.Mountpoint,
+				})
 			}
-			errs = multierror.Append(errs, fmt.Errorf("unknown persistent mode '%s'", spec.Persistent.Mode))
 		}
 	}
 
-	data += fstab("tmpfs", constants.OverlayDir, "tmpfs", []string{"defaults", fmt.Sprintf("size=%s", spec.Ephemeral.Size)})
+	entries = append(entries, mountEntry{
+		device: "tmpfs", path: constants.OverlayDir, fstype: "tmpfs", options: tmpfsOptions(spec.Ephemeral.Size),
+	})
 	for _, rw := range spec.Ephemeral.Paths {
-		data += overlayLine(rw, constants.OverlayDir, constants.OverlayDir)
+		if rw.Size == "" {
+			entries = append(entries, overlayEntry(rw.Path, constants.OverlayDir, constants.OverlayDir))
+			continue
+		}
+		dir := ephemeralPathDir(rw.Path)
+		entries = append(entries, mountEntry{
+			device: "tmpfs", path: dir, fstype: "tmpfs", options: tmpfsOptions(rw.Size), requires: constants.OverlayDir,
+		})
+		entries = append(entries, overlayEntry(rw.Path, constants.OverlayDir, dir))
 	}
 
-	return cfg.Config.Fs.WriteFile(filepath.Join(spec.Sysroot, "/etc/fstab"), []byte(data), 0644)
+	return entries
 }
 
 func InitialFstabData(runner types.Runner, sysroot string) (string, error) {
@@ -357,6 +491,20 @@ func InitialFstabData(runner types.Runner, sysroot string) (string, error) {
 	return data, nil
 }
 
+// mapperName returns the device mapper name used to unlock an encrypted volume
+func mapperName(vol *types.VolumeMount) string {
+	return filepath.Base(vol.Mountpoint)
+}
+
+// fstabDevice returns the device reference of the volume to be used in fstab. Encrypted
+// volumes are referenced by their unlocked device mapper.
+func fstabDevice(vol *types.VolumeMount) string {
+	if vol.Encryption != nil {
+		return filepath.Join(constants.DevMapperDir, mapperName(vol))
+	}
+	return vol.Device
+}
+
 func fstab(device, path, fstype string, flags []string) string {
 	if len(flags) == 0 {
 		flags = []string{"defaults"}
@@ -397,7 +545,7 @@ func findmnt(runner types.Runner, mountpoint string) ([]*types.VolumeMount, erro
 	return mounts, nil
 }
 
-func overlayLine(path, upperPath, requriedMount string) string {
+func overlayEntry(path, upperPath, requiredMount string) mountEntry {
 	trimmed := strings.TrimPrefix(path, "/")
 	pathName := strings.ReplaceAll(trimmed, "/", "-") + overlaySuffix
 	upper := fmt.Sprintf("%s/%s/upper", upperPath, pathName)
@@ -407,8 +555,8 @@ func overlayLine(path, upperPath, requriedMount string) string {
 	options = append(options, fmt.Sprintf("lowerdir=%s", path))
 	options = append(options, fmt.Sprintf("upperdir=%s", upper))
 	options = append(options, fmt.Sprintf("workdir=%s", work))
-	options = append(options, fmt.Sprintf("x-systemd.requires-mounts-for=%s", requriedMount))
-	return fstab("overlay", path, "overlay", options)
+	options = append(options, fmt.Sprintf("x-systemd.requires-mounts-for=%s", requiredMount))
+	return mountEntry{device: "overlay", path: path, fstype: "overlay", options: options, requires: requiredMount}
 }
 
 func SelinuxRelabel(cfg *types.RunConfig, spec *types.MountSpec) error {
@@ -440,7 +588,10 @@ func SelinuxRelabel(cfg *types.RunConfig, spec *types.MountSpec) error {
 }
 
 func getRelabelPaths(cfg *types.RunConfig, spec *types.MountSpec) []string {
-	paths := append([]string{}, spec.Ephemeral.Paths...)
+	paths := []string{}
+	for _, path := range spec.Ephemeral.Paths {
+		paths = append(paths, path.Path)
+	}
 	for _, vol := range append(spec.Volumes, &spec.Persistent.Volume) {
 		// Omit any read-only filesystem or mountpoint under /run as those are considered transient
 		if strings.HasPrefix(vol.Mountpoint, "/run") || slices.Contains(vol.Options, "ro") {
@@ -448,7 +599,9 @@ func getRelabelPaths(cfg *types.RunConfig, spec *types.MountSpec) []string {
 		}
 		paths = append(paths, vol.Mountpoint)
 	}
-	paths = append(paths, spec.Persistent.Paths...)
+	for _, path := range spec.Persistent.Paths {
+		paths = append(paths, path.Path)
+	}
 	filteredPaths := []string{}
 
 	for _, path := range paths {
@@ -0,0 +1,135 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// quotaTools lists the tools required to set project quotas on each supported filesystem
+var quotaTools = map[string][]string{
+	"xfs":  {"xfs_quota"},
+	"ext4": {"chattr", "setquota"},
+}
+
+// projectQuota sets project quotas on directories of a mounted filesystem
+type projectQuota struct {
+	cfg        *types.RunConfig
+	mountpoint string
+	fstype     string
+}
+
+// newProjectQuota checks project quotas are supported and enabled on the filesystem mounted at the given mountpoint
+func newProjectQuota(cfg *types.RunConfig, mountpoint string) (*projectQuota, error) {
+	out, err := cfg.Runner.Run("findmnt", "-n", "-o", "FSTYPE,OPTIONS", "--mountpoint", mountpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not find the filesystem mounted at %s: %s", mountpoint, strings.TrimSpace(string(out)))
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("could not find the filesystem mounted at %s", mountpoint)
+	}
+
+	fstype, options := fields[0], strings.Split(fields[1], ",")
+	tools, ok := quotaTools[fstype]
+	if !ok {
+		return nil, fmt.Errorf("project quotas are not supported on %s filesystems, %s must be xfs or ext4", fstype, mountpoint)
+	}
+	if !slices.Contains(options, constants.PrjQuotaOpt) {
+		return nil, fmt.Errorf("project quotas are not enabled on %s, it must be mounted with the '%s' option", mountpoint, constants.PrjQuotaOpt)
+	}
+	for _, tool := range tools {
+		if !cfg.Runner.CommandExists(tool) {
+			return nil, fmt.Errorf("'%s' is required to set project quotas on %s filesystems", tool, fstype)
+		}
+	}
+	return &projectQuota{cfg: cfg, mountpoint: mountpoint, fstype: fstype}, nil
+}
+
+// limit assigns the given directory to a project and limits the project usage to the given size
+func (q projectQuota) limit(dir, size string) error {
+	kib, err := quotaSizeKiB(size)
+	if err != nil {
+		return err
+	}
+	if err = utils.MkdirAll(q.cfg.Fs, dir, constants.DirPerm); err != nil {
+		return err
+	}
+
+	id := projectID(dir)
+	q.cfg.Logger.Debugf("Setting project quota %d of %dKiB on %s", id, kib, dir)
+
+	var cmds [][]string
+	switch q.fstype {
+	case "xfs":
+		cmds = [][]string{
+			{"xfs_quota", "-x", "-c", fmt.Sprintf("project -s -p %s %d", dir, id), q.mountpoint},
+			{"xfs_quota", "-x", "-c", fmt.Sprintf("limit -p bhard=%dk %d", kib, id), q.mountpoint},
+		}
+	default:
+		cmds = [][]string{
+			{"chattr", "-R", "+P", "-p", strconv.FormatUint(uint64(id), 10), dir},
+			{"setquota", "-P", strconv.FormatUint(uint64(id), 10), "0", strconv.FormatUint(kib, 10), "0", "0", q.mountpoint},
+		}
+	}
+	for _, cmd := range cmds {
+		out, err := q.cfg.Runner.Run(cmd[0], cmd[1:]...)
+		if err != nil {
+			q.cfg.Logger.Errorf("failed setting project quota on %s: %s", dir, strings.TrimSpace(string(out)))
+			return err
+		}
+	}
+	return nil
+}
+
+// projectID returns a stable project ID for the given directory
+func projectID(dir string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(dir))
+	// Project ID 0 is the default project of any file
+	return h.Sum32()&0x7fffffff | 1
+}
+
+// quotaSizeKiB returns the given size in KiB, sizes are given in bytes or with K, M, G or T suffixes
+func quotaSizeKiB(size string) (uint64, error) {
+	units := map[byte]uint64{'K': 1, 'M': 1 << 10, 'G': 1 << 20, 'T': 1 << 30}
+	multiplier, bytes := uint64(0), true
+	if len(size) > 0 {
+		multiplier, bytes = units[size[len(size)-1]]
+		bytes = !bytes
+	}
+	num := size
+	if !bytes {
+		num = size[:len(size)-1]
+	}
+	value, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quota size '%s'", size)
+	}
+	if bytes {
+		return max((value+1023)/1024, 1), nil
+	}
+	return value * multiplier, nil
+}
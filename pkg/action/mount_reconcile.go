@@ -0,0 +1,287 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+const (
+	manifestSuffix   = ".manifest"
+	stampSuffix      = ".stamp"
+	newDefaultSuffix = ".elemental-new"
+	symlinkSumPref   = "symlink:"
+	whiteoutSum      = "whiteout"
+)
+
+// ReconcilePersistentPath merges the defaults shipped by the image under the given path into
+// its persisted data. A manifest of the image defaults is kept in the state dir, together with a
+// stamp of their sizes and modification times, so defaults are only hashed and changes are only
+// applied on the first boot of a new image:
+//   - new defaults are copied to bind mounted paths, on overlay paths they are already visible
+//   - updated defaults replace the persisted copies not modified by the user
+//   - removed defaults are also removed from the persisted data if not modified by the user
+//
+// Defaults modified by the user are kept and the new default is stored next to them with
+// the '.elemental-new' suffix.
+func ReconcilePersistentPath(cfg *types.RunConfig, sysroot, stateDir, path, mode string) error {
+	lower := filepath.Join(sysroot, path)
+	pathName := persistentPathName(path)
+	dataDir := filepath.Join(stateDir, pathName+".bind")
+	if mode == constants.OverlayMode {
+		dataDir = filepath.Join(stateDir, pathName+overlaySuffix, "upper")
+	}
+	manifestFile := filepath.Join(stateDir, pathName+manifestSuffix)
+	stampFile := filepath.Join(stateDir, pathName+stampSuffix)
+
+	stamp, err := defaultsStamp(cfg.Fs, lower)
+	if err != nil {
+		return err
+	}
+	previous, err := readManifest(cfg.Fs, manifestFile)
+	if err != nil {
+		return err
+	}
+	if prevStamp, _ := cfg.Fs.ReadFile(stampFile); previous != nil && string(prevStamp) == stamp {
+		cfg.Logger.Debugf("No new defaults for %s", path)
+		return nil
+	}
+
+	current, err := readDefaults(cfg.Fs, lower)
+	if err != nil {
+		return err
+	}
+	if previous != nil && maps.Equal(previous, current) {
+		cfg.Logger.Debugf("No new defaults for %s", path)
+		return writeStamp(cfg.Fs, stampFile, stamp)
+	}
+
+	// Nothing to reconcile on first boot or if nothing was persisted yet
+	if entries, _ := cfg.Fs.ReadDir(dataDir); previous != nil && len(entries) > 0 {
+		cfg.Logger.Infof("Merging new image defaults into persistent path %s", path)
+		err = reconcileDefaults(cfg, lower, dataDir, mode, previous, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = utils.MkdirAll(cfg.Fs, stateDir, constants.DirPerm); err != nil {
+		return err
+	}
+	if err = writeManifest(cfg.Fs, manifestFile, current); err != nil {
+		return err
+	}
+	return writeStamp(cfg.Fs, stampFile, stamp)
+}
+
+// reconcileDefaults applies the changes between the previous and current image defaults to the persisted data
+func reconcileDefaults(cfg *types.RunConfig, lower, dataDir, mode string, previous, current map[string]string) error {
+	for _, rel := range slices.Sorted(maps.Keys(current)) {
+		sum := current[rel]
+		oldSum, known := previous[rel]
+		if known && oldSum == sum {
+			continue
+		}
+
+		target := filepath.Join(dataDir, rel)
+		targetSum, err := defaultSum(cfg.Fs, target)
+		switch {
+		case os.IsNotExist(err):
+			// Overlay paths already show the new default, known defaults deleted by the user are not restored
+			if mode == constants.BindMode && !known {
+				cfg.Logger.Debugf("Adding new default %s", rel)
+				err = copyDefault(cfg.Fs, filepath.Join(lower, rel), target)
+			} else {
+				err = nil
+			}
+		case err != nil:
+			return err
+		case targetSum == whiteoutSum, targetSum == sum:
+			continue
+		case known && targetSum == oldSum && mode == constants.OverlayMode:
+			cfg.Logger.Debugf("Dropping unmodified copy of updated default %s", rel)
+			err = cfg.Fs.Remove(target)
+		case known && targetSum == oldSum:
+			cfg.Logger.Debugf("Updating default %s", rel)
+			err = copyDefault(cfg.Fs, filepath.Join(lower, rel), target)
+		default:
+			cfg.Logger.Warnf("Keeping modified %s, the new default is stored as %s", rel, rel+newDefaultSuffix)
+			err = copyDefault(cfg.Fs, filepath.Join(lower, rel), target+newDefaultSuffix)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, rel := range slices.Sorted(maps.Keys(previous)) {
+		if _, ok := current[rel]; ok {
+			continue
+		}
+		target := filepath.Join(dataDir, rel)
+		if targetSum, _ := defaultSum(cfg.Fs, target); targetSum == previous[rel] {
+			cfg.Logger.Debugf("Removing unmodified copy of removed default %s", rel)
+			if err := cfg.Fs.Remove(target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readDefaults returns the checksum of each file and symlink found under the given directory
+func readDefaults(vfs types.FS, dir string) (map[string]string, error) {
+	defaults := map[string]string{}
+	err := utils.WalkDirFs(vfs, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		sum, err := defaultSum(vfs, path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		defaults[rel] = sum
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return defaults, nil
+	}
+	return defaults, err
+}
+
+// defaultsStamp returns a digest of the type, size and modification time of each file and symlink
+// found under the given directory, it does not read any file
+func defaultsStamp(vfs types.FS, dir string) (string, error) {
+	h := sha256.New()
+	err := utils.WalkDirFs(vfs, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %s %d %d\n", rel, info.Mode().Type(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeStamp writes the stamp of the image defaults recorded in the manifest
+func writeStamp(vfs types.FS, file, stamp string) error {
+	return vfs.WriteFile(file, []byte(stamp), 0644)
+}
+
+// defaultSum returns the checksum of the given file, symlinks are identified by their target
+// and overlay whiteouts by a fixed value
+func defaultSum(vfs types.FS, path string) (string, error) {
+	info, err := vfs.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := vfs.Readlink(path)
+		return symlinkSumPref + link, err
+	case info.Mode()&fs.ModeCharDevice != 0:
+		return whiteoutSum, nil
+	default:
+		return utils.CalcFileChecksum(vfs, path)
+	}
+}
+
+// copyDefault copies the given file or symlink to target, replacing any existing one
+func copyDefault(vfs types.FS, source, target string) error {
+	if err := utils.MkdirAll(vfs, filepath.Dir(target), constants.DirPerm); err != nil {
+		return err
+	}
+	if err := vfs.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	info, err := vfs.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := vfs.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return vfs.Symlink(link, target)
+	}
+	return utils.CopyFile(vfs, source, target)
+}
+
+// readManifest reads a defaults manifest, returns nil if there is no manifest
+func readManifest(vfs types.FS, file string) (map[string]string, error) {
+	data, err := vfs.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	manifest := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		sum, path, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return nil, fmt.Errorf("invalid line in manifest %s: %s", file, scanner.Text())
+		}
+		manifest[path] = sum
+	}
+	return manifest, scanner.Err()
+}
+
+// writeManifest writes a defaults manifest in sha256sum format
+func writeManifest(vfs types.FS, file string, manifest map[string]string) error {
+	var data strings.Builder
+	for _, path := range slices.Sorted(maps.Keys(manifest)) {
+		fmt.Fprintf(&data, "%s  %s\n", manifest[path], path)
+	}
+	return vfs.WriteFile(file, []byte(data.String()), 0644)
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -84,7 +85,7 @@ var _ = Describe("Mount Action", func() {
 			},
 			Persistent: types.PersistentMounts{
 				Mode:  constants.BindMode,
-				Paths: []string{"/some/path"},
+				Paths: types.NewPersistentPaths("/some/path"),
 				Volume: types.VolumeMount{
 					Mountpoint: constants.PersistentDir,
 					Device:     "/dev/persistentdev",
@@ -148,6 +149,32 @@ var _ = Describe("Mount Action", func() {
 			Expect(string(fstab)).To(Equal(expectedFstab))
 		})
 
+		It("Writes the device mapper path of an encrypted persistent volume", func() {
+			spec.Persistent.Volume.Encryption = &types.VolumeEncryption{TPM: true}
+			err := action.WriteFstab(cfg, spec, "")
+			Expect(err).To(BeNil())
+
+			fstab, err := cfg.Config.Fs.ReadFile(filepath.Join(spec.Sysroot, "/etc/fstab"))
+			Expect(err).To(BeNil())
+			Expect(string(fstab)).To(ContainSubstring("/dev/mapper/persistent\t/run/elemental/persistent\tauto\tdefaults\t0\t0\n"))
+			Expect(string(fstab)).NotTo(ContainSubstring("/dev/persistentdev"))
+		})
+
+		It("Writes a dedicated tmpfs for ephemeral paths with their own size", func() {
+			spec.Ephemeral.Paths = []types.EphemeralPath{{Path: "/tmp", Size: "512m"}}
+			err := action.WriteFstab(cfg, spec, "")
+			Expect(err).To(BeNil())
+
+			fstab, err := cfg.Config.Fs.ReadFile(filepath.Join(spec.Sysroot, "/etc/fstab"))
+			Expect(err).To(BeNil())
+			Expect(string(fstab)).To(ContainSubstring(
+				"tmpfs\t/run/elemental/overlay/tmp.overlay\ttmpfs\tdefaults,size=512m\t0\t0\n",
+			))
+			Expect(string(fstab)).To(ContainSubstring(
+				"workdir=/run/elemental/overlay/tmp.overlay/work,x-systemd.requires-mounts-for=/run/elemental/overlay/tmp.overlay\t0\t0\n",
+			))
+		})
+
 		It("Does not write fstab if not requested", func() {
 			spec := &types.MountSpec{
 				WriteFstab: false,
@@ -163,6 +190,96 @@ var _ = Describe("Mount Action", func() {
 			Expect(ok).To(BeFalse())
 		})
 	})
+	Describe("Write mount units", Label("mount", "units"), func() {
+		var unitDir string
+		BeforeEach(func() {
+			spec.Output = constants.SystemdUnitsOutput
+			spec.Persistent.Mode = constants.OverlayMode
+			spec.Ephemeral.Paths = types.NewEphemeralPaths("/var")
+			unitDir = filepath.Join(spec.Sysroot, constants.SystemdUnitDir)
+		})
+		It("Writes mount units with dependencies on the persistent volume and ephemeral overlay", func() {
+			fstabData, err := action.InitialFstabData(runner, spec.Sysroot)
+			Expect(err).To(BeNil())
+			Expect(action.WriteFstab(cfg, spec, fstabData)).To(Succeed())
+
+			// fstab only includes already mounted filesystems
+			fstab, err := cfg.Config.Fs.ReadFile(filepath.Join(spec.Sysroot, "/etc/fstab"))
+			Expect(err).To(BeNil())
+			Expect(string(fstab)).To(Equal(fstabData))
+
+			unit, err := cfg.Config.Fs.ReadFile(filepath.Join(unitDir, "run-elemental-persistent.mount"))
+			Expect(err).To(BeNil())
+			Expect(string(unit)).To(ContainSubstring("What=/dev/persistentdev\nWhere=/run/elemental/persistent\nType=auto\nOptions=defaults\n"))
+
+			unit, err = cfg.Config.Fs.ReadFile(filepath.Join(unitDir, "some-path.mount"))
+			Expect(err).To(BeNil())
+			Expect(string(unit)).To(ContainSubstring("Requires=run-elemental-persistent.mount\nAfter=run-elemental-persistent.mount\n"))
+			Expect(string(unit)).To(ContainSubstring("Before=local-fs.target\n"))
+			Expect(string(unit)).To(ContainSubstring(
+				"Options=defaults,lowerdir=/some/path,upperdir=/run/elemental/persistent/.state/some-path.overlay/upper," +
+					"workdir=/run/elemental/persistent/.state/some-path.overlay/work\n",
+			))
+			Expect(string(unit)).NotTo(ContainSubstring("x-systemd"))
+
+			unit, err = cfg.Config.Fs.ReadFile(filepath.Join(unitDir, "var.mount"))
+			Expect(err).To(BeNil())
+			Expect(string(unit)).To(ContainSubstring("Requires=run-elemental-overlay.mount\nAfter=run-elemental-overlay.mount\n"))
+
+			dropIn, err := cfg.Config.Fs.ReadFile(filepath.Join(unitDir, "local-fs.target.d", "elemental-mounts.conf"))
+			Expect(err).To(BeNil())
+			Expect(string(dropIn)).To(ContainSubstring("Requires=run-elemental.mount\n"))
+			Expect(string(dropIn)).To(ContainSubstring("Requires=some-path.mount\n"))
+			Expect(string(dropIn)).To(ContainSubstring("Requires=var.mount\n"))
+		})
+		It("Translates x-systemd options and writes automount units", func() {
+			spec.Volumes = append(spec.Volumes, &types.VolumeMount{
+				Mountpoint: "/data/my-disk",
+				Device:     "/dev/sdb1",
+				FSType:     "xfs",
+				Options: []string{
+					"rw", "nofail", "x-systemd.automount", "x-systemd.idle-timeout=30",
+					"x-systemd.requires=/dev/sdb", "x-systemd.after=network-online.target",
+					"x-systemd.mount-timeout=10s", "x-systemd.wanted-by=multi-user.target",
+					"x-systemd.makefs",
+				},
+			})
+			Expect(action.WriteFstab(cfg, spec, "")).To(Succeed())
+
+			unit, err := cfg.Config.Fs.ReadFile(filepath.Join(unitDir, `data-my\x2ddisk.mount`))
+			Expect(err).To(BeNil())
+			Expect(string(unit)).To(ContainSubstring("Requires=dev-sdb.device\nAfter=dev-sdb.device\n"))
+			Expect(string(unit)).To(ContainSubstring("After=network-online.target\n"))
+			Expect(string(unit)).To(ContainSubstring("Type=xfs\nOptions=rw\nTimeoutSec=10s\n"))
+			Expect(string(unit)).NotTo(ContainSubstring("Before=local-fs.target"))
+
+			automount, err := cfg.Config.Fs.ReadFile(filepath.Join(unitDir, `data-my\x2ddisk.automount`))
+			Expect(err).To(BeNil())
+			Expect(string(automount)).To(ContainSubstring("[Automount]\nWhere=/data/my-disk\nTimeoutIdleSec=30\n"))
+
+			dropIn, err := cfg.Config.Fs.ReadFile(filepath.Join(unitDir, "local-fs.target.d", "elemental-mounts.conf"))
+			Expect(err).To(BeNil())
+			Expect(string(dropIn)).To(ContainSubstring(`Wants=data-my\x2ddisk.automount`))
+			dropIn, err = cfg.Config.Fs.ReadFile(filepath.Join(unitDir, "multi-user.target.d", "elemental-mounts.conf"))
+			Expect(err).To(BeNil())
+			Expect(string(dropIn)).To(ContainSubstring(`Wants=data-my\x2ddisk.automount`))
+
+			Expect(memLog.String()).To(ContainSubstring("Ignoring unsupported option 'x-systemd.makefs'"))
+		})
+		It("Removes units generated in a previous run", func() {
+			Expect(utils.MkdirAll(fs, filepath.Join(unitDir, "multi-user.target.d"), constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(unitDir, "old.mount"), []byte("# Generated by elemental mount, do not edit\n"), 0644)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(unitDir, "custom.mount"), []byte("[Unit]\n"), 0644)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(unitDir, "multi-user.target.d", "elemental-mounts.conf"), []byte{}, 0644)).To(Succeed())
+
+			Expect(action.WriteFstab(cfg, spec, "")).To(Succeed())
+
+			Expect(utils.Exists(fs, filepath.Join(unitDir, "old.mount"))).To(BeFalse())
+			Expect(utils.Exists(fs, filepath.Join(unitDir, "multi-user.target.d", "elemental-mounts.conf"))).To(BeFalse())
+			Expect(utils.Exists(fs, filepath.Join(unitDir, "custom.mount"))).To(BeTrue())
+			Expect(utils.Exists(fs, filepath.Join(unitDir, "some-path.mount"))).To(BeTrue())
+		})
+	})
 	Describe("Mount Volumes", func() {
 		It("mounts expected volumes without errors", func() {
 			spec.Volumes = append(spec.Volumes,
@@ -187,6 +304,76 @@ var _ = Describe("Mount Action", func() {
 			Expect(list[3].Device).To(Equal("/dev/somedevice"))
 			Expect(list[4].Device).To(Equal("/dev/persistentdev"))
 		})
+		It("unlocks encrypted volumes before mounting them", func() {
+			spec.Persistent.Volume.Encryption = &types.VolumeEncryption{TPM: true}
+			Expect(action.MountVolumes(cfg, spec)).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{{
+				constants.SystemdCryptsetup, "attach", "persistent", "/dev/persistentdev", "-", "tpm2-device=auto",
+			}})).To(Succeed())
+			list, _ := mounter.List()
+			Expect(list[1].Device).To(Equal("/dev/mapper/persistent"))
+		})
+		It("assembles RAID arrays before mounting a missing array device", func() {
+			spec.Persistent.Volume.Device = "/dev/md/persistent"
+			spec.Volumes = append(spec.Volumes, &types.VolumeMount{
+				Device:     "/dev/md/oem",
+				Mountpoint: constants.OEMPath,
+			})
+			Expect(action.MountVolumes(cfg, spec)).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{{"mdadm", "--assemble", "--scan"}})).To(Succeed())
+			list, _ := mounter.List()
+			Expect(list[2].Device).To(Equal("/dev/md/persistent"))
+		})
+		It("unlocks encrypted volumes with the key file recorded in the installation state", func() {
+			keyFile := filepath.Join(constants.InitrdKeysDir, "luks.key")
+			enc := &types.Encryption{KeySlots: []types.KeySlot{{Passphrase: "secret"}, {KeyFile: keyFile}}}
+			state := &types.InstallState{Partitions: map[string]*types.PartitionState{
+				constants.PersistentPartName: {FSLabel: constants.PersistentLabel, Encryption: enc.VolumeEncryption()},
+			}}
+			Expect(utils.MkdirAll(fs, constants.RunningStateDir, constants.DirPerm)).To(Succeed())
+			stateFile := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
+			Expect(cfg.WriteInstallState(state, stateFile, "")).To(Succeed())
+			Expect(utils.MkdirAll(fs, constants.InitrdKeysDir, constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(keyFile, []byte("key"), constants.FilePerm)).To(Succeed())
+
+			spec = config.NewMountSpec(cfg.Config)
+			Expect(spec.Persistent.Volume.Encryption).To(Equal(&types.VolumeEncryption{KeyFile: keyFile}))
+
+			Expect(action.MountVolumes(cfg, spec)).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{{
+				constants.SystemdCryptsetup, "attach", "persistent", "/dev/disk/by-partlabel/persistent", keyFile,
+			}})).To(Succeed())
+		})
+		It("fails to unlock if the key file is not in the initrd", func() {
+			spec.Persistent.Volume.Encryption = &types.VolumeEncryption{KeyFile: "/etc/elemental/keys/luks.key"}
+			// The key file within the system image is never used
+			Expect(utils.MkdirAll(fs, "/sysroot/etc/elemental/keys", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/sysroot/etc/elemental/keys/luks.key", []byte("key"), constants.FilePerm)).To(Succeed())
+			Expect(action.MountVolumes(cfg, spec)).To(MatchError(ContainSubstring("not found in the initrd")))
+			Expect(runner.IncludesCmds([][]string{{constants.SystemdCryptsetup}})).NotTo(Succeed())
+		})
+		It("unlocks with TPM2 only if the key file is not in the initrd", func() {
+			spec.Persistent.Volume.Encryption = &types.VolumeEncryption{KeyFile: "/etc/elemental/keys/luks.key", TPM: true}
+			Expect(action.MountVolumes(cfg, spec)).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{{
+				constants.SystemdCryptsetup, "attach", "persistent", "/dev/persistentdev", "-", "tpm2-device=auto",
+			}})).To(Succeed())
+		})
+		It("fails to unlock an encrypted volume", func() {
+			spec.Persistent.Volume.Encryption = &types.VolumeEncryption{KeyFile: "/etc/keyfile"}
+			Expect(utils.MkdirAll(fs, "/etc", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/etc/keyfile", []byte("key"), constants.FilePerm)).To(Succeed())
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == constants.SystemdCryptsetup {
+					return []byte{}, fmt.Errorf("unlock error")
+				}
+				return []byte{}, nil
+			}
+			Expect(action.MountVolumes(cfg, spec)).NotTo(Succeed())
+			Expect(runner.IncludesCmds([][]string{{
+				constants.SystemdCryptsetup, "attach", "persistent", "/dev/persistentdev", "/etc/keyfile",
+			}})).To(Succeed())
+		})
 		It("fails to mount a volume", func() {
 			mounter.ErrorOnMount = true
 			Expect(action.MountVolumes(cfg, spec)).NotTo(Succeed())
@@ -203,7 +390,7 @@ var _ = Describe("Mount Action", func() {
 	})
 	Describe("Mounts ephemeral paths", func() {
 		It("mounts tmpfs overlays paths without errors", func() {
-			spec.Ephemeral.Paths = []string{"/etc"}
+			spec.Ephemeral.Paths = types.NewEphemeralPaths("/etc")
 			Expect(action.MountEphemeral(cfg, spec.Sysroot, spec.Ephemeral)).To(Succeed())
 			list, _ := mounter.List()
 			Expect(list[0].Device).To(Equal("tmpfs"))
@@ -211,7 +398,7 @@ var _ = Describe("Mount Action", func() {
 			Expect(list[1].Device).To(Equal("overlay"))
 		})
 		It("mounts overlays paths on a block device without errors", func() {
-			spec.Ephemeral.Paths = []string{"/etc"}
+			spec.Ephemeral.Paths = types.NewEphemeralPaths("/etc")
 			spec.Ephemeral.Type = "block"
 			spec.Ephemeral.Device = "/dev/some/device"
 			Expect(action.MountEphemeral(cfg, spec.Sysroot, spec.Ephemeral)).To(Succeed())
@@ -220,6 +407,17 @@ var _ = Describe("Mount Action", func() {
 			Expect(list[1].Path).To(Equal("/sysroot/etc"))
 			Expect(list[1].Device).To(Equal("overlay"))
 		})
+		It("mounts a dedicated tmpfs for paths with their own size", func() {
+			spec.Ephemeral.Paths = []types.EphemeralPath{{Path: "/tmp", Size: "512m"}, {Path: "/etc"}}
+			Expect(action.MountEphemeral(cfg, spec.Sysroot, spec.Ephemeral)).To(Succeed())
+			list, _ := mounter.List()
+			Expect(len(list)).To(Equal(4))
+			Expect(list[1].Device).To(Equal("tmpfs"))
+			Expect(list[1].Path).To(Equal("/run/elemental/overlay/tmp.overlay"))
+			Expect(list[1].Opts).To(ContainElement("size=512m"))
+			Expect(list[2].Path).To(Equal("/sysroot/tmp"))
+			Expect(list[3].Path).To(Equal("/sysroot/etc"))
+		})
 		It("fails to mount a volume", func() {
 			mounter.ErrorOnMount = true
 			Expect(action.MountEphemeral(cfg, spec.Sysroot, spec.Ephemeral)).NotTo(Succeed())
@@ -265,6 +463,143 @@ var _ = Describe("Mount Action", func() {
 			err := action.MountPersistent(cfg, spec)
 			Expect(err.Error()).To(ContainSubstring("rsync error"))
 		})
+		It("mounts paths with their own persistence mode", func() {
+			spec.Persistent.Paths = append(spec.Persistent.Paths, types.PersistentPath{Path: "/other", Mode: constants.OverlayMode})
+			Expect(action.MountPersistent(cfg, spec)).To(Succeed())
+			list, _ := mounter.List()
+			Expect(len(list)).To(Equal(2))
+			Expect(list[0].Device).To(ContainSubstring("some-path.bind"))
+			Expect(list[1].Device).To(Equal("overlay"))
+			Expect(list[1].Path).To(Equal("/sysroot/other"))
+		})
+		It("only seeds bind paths once", func() {
+			stateDir := filepath.Join(constants.PersistentDir, constants.PersistentStateDir, "some-path.bind")
+			Expect(utils.MkdirAll(fs, stateDir, constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(stateDir, "file"), []byte("persisted"), 0644)).To(Succeed())
+
+			Expect(action.MountPersistent(cfg, spec)).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{{"rsync"}})).NotTo(Succeed())
+		})
+	})
+	Describe("Sets quotas on persistent paths", Label("quota"), func() {
+		var fsInfo string
+		BeforeEach(func() {
+			fsInfo = "xfs rw,relatime,prjquota"
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "findmnt" {
+					return []byte(fsInfo), nil
+				}
+				return []byte{}, nil
+			}
+			spec.Persistent.Paths = []types.PersistentPath{{Path: "/var/log", Mode: constants.OverlayMode, Size: "2G"}}
+		})
+		It("sets project quotas on xfs", func() {
+			Expect(action.MountPersistent(cfg, spec)).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{
+				{"findmnt", "-n", "-o", "FSTYPE,OPTIONS", "--mountpoint", "/run/elemental/persistent"},
+				{"xfs_quota", "-x", "-c"},
+			})).To(Succeed())
+			Expect(runner.GetCmds()[2][3]).To(HavePrefix("limit -p bhard=2097152k "))
+			list, _ := mounter.List()
+			Expect(list[0].Path).To(Equal("/sysroot/var/log"))
+		})
+		It("sets project quotas on ext4", func() {
+			fsInfo = "ext4 rw,relatime,prjquota"
+			Expect(action.MountPersistent(cfg, spec)).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{
+				{"chattr", "-R", "+P", "-p"},
+				{"setquota", "-P"},
+			})).To(Succeed())
+			Expect(runner.GetCmds()[2][3:]).To(Equal([]string{"0", "2097152", "0", "0", "/run/elemental/persistent"}))
+		})
+		It("fails on filesystems without project quotas", func() {
+			fsInfo = "btrfs rw,relatime"
+			Expect(action.MountPersistent(cfg, spec)).NotTo(Succeed())
+
+			fsInfo = "xfs rw,relatime"
+			Expect(action.MountPersistent(cfg, spec)).NotTo(Succeed())
+
+			list, _ := mounter.List()
+			Expect(list).To(BeEmpty())
+		})
+		It("fails if quota tools are missing", func() {
+			runner.CmdNotFound = "xfs_quota"
+			Expect(action.MountPersistent(cfg, spec)).NotTo(Succeed())
+		})
+	})
+	Describe("Reconciles persistent paths", func() {
+		var stateDir, lower string
+		writeFiles := func(dir string, files map[string]string) {
+			Expect(utils.MkdirAll(fs, dir, constants.DirPerm)).To(Succeed())
+			for name, data := range files {
+				Expect(fs.WriteFile(filepath.Join(dir, name), []byte(data), 0644)).To(Succeed())
+			}
+		}
+		readFile := func(path string) string {
+			data, err := fs.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			return string(data)
+		}
+		BeforeEach(func() {
+			stateDir = filepath.Join(constants.PersistentDir, constants.PersistentStateDir)
+			lower = filepath.Join(spec.Sysroot, "/etc/app")
+			writeFiles(lower, map[string]string{"a.conf": "v1", "b.conf": "v1", "c.conf": "v1"})
+
+			// First boot only records the image defaults
+			Expect(action.ReconcilePersistentPath(cfg, spec.Sysroot, stateDir, "/etc/app", constants.BindMode)).To(Succeed())
+			Expect(readFile(filepath.Join(stateDir, "etc-app.manifest"))).To(ContainSubstring("  a.conf\n"))
+		})
+		It("merges new defaults into bind paths", func() {
+			data := filepath.Join(stateDir, "etc-app.bind")
+			writeFiles(data, map[string]string{"a.conf": "v1", "b.conf": "user", "c.conf": "v1"})
+
+			Expect(fs.Remove(filepath.Join(lower, "c.conf"))).To(Succeed())
+			writeFiles(lower, map[string]string{"a.conf": "v2", "b.conf": "v2", "d.conf": "v2"})
+			Expect(action.ReconcilePersistentPath(cfg, spec.Sysroot, stateDir, "/etc/app", constants.BindMode)).To(Succeed())
+
+			Expect(readFile(filepath.Join(data, "a.conf"))).To(Equal("v2"))
+			Expect(readFile(filepath.Join(data, "b.conf"))).To(Equal("user"))
+			Expect(readFile(filepath.Join(data, "b.conf.elemental-new"))).To(Equal("v2"))
+			Expect(readFile(filepath.Join(data, "d.conf"))).To(Equal("v2"))
+			Expect(utils.Exists(fs, filepath.Join(data, "c.conf"))).To(BeFalse())
+			Expect(readFile(filepath.Join(stateDir, "etc-app.manifest"))).To(ContainSubstring("  d.conf\n"))
+		})
+		It("drops stale copies from overlay upper dirs", func() {
+			upper := filepath.Join(stateDir, "etc-app.overlay", "upper")
+			writeFiles(upper, map[string]string{"a.conf": "v1", "b.conf": "user", "e.conf": "user"})
+
+			writeFiles(lower, map[string]string{"a.conf": "v2", "b.conf": "v2", "d.conf": "v2"})
+			Expect(action.ReconcilePersistentPath(cfg, spec.Sysroot, stateDir, "/etc/app", constants.OverlayMode)).To(Succeed())
+
+			Expect(utils.Exists(fs, filepath.Join(upper, "a.conf"))).To(BeFalse())
+			Expect(readFile(filepath.Join(upper, "b.conf"))).To(Equal("user"))
+			Expect(readFile(filepath.Join(upper, "b.conf.elemental-new"))).To(Equal("v2"))
+			Expect(readFile(filepath.Join(upper, "e.conf"))).To(Equal("user"))
+			Expect(utils.Exists(fs, filepath.Join(upper, "d.conf"))).To(BeFalse())
+		})
+		It("does nothing if the image defaults did not change", func() {
+			data := filepath.Join(stateDir, "etc-app.bind")
+			writeFiles(data, map[string]string{"a.conf": "user"})
+
+			Expect(action.ReconcilePersistentPath(cfg, spec.Sysroot, stateDir, "/etc/app", constants.BindMode)).To(Succeed())
+			Expect(readFile(filepath.Join(data, "a.conf"))).To(Equal("user"))
+			Expect(utils.Exists(fs, filepath.Join(data, "b.conf"))).To(BeFalse())
+		})
+		It("does not hash the image defaults if their sizes and times did not change", func() {
+			manifest := readFile(filepath.Join(stateDir, "etc-app.manifest"))
+			info, err := fs.Stat(filepath.Join(lower, "a.conf"))
+			Expect(err).NotTo(HaveOccurred())
+
+			writeFiles(lower, map[string]string{"a.conf": "v2"})
+			Expect(fs.Chtimes(filepath.Join(lower, "a.conf"), info.ModTime(), info.ModTime())).To(Succeed())
+			Expect(action.ReconcilePersistentPath(cfg, spec.Sysroot, stateDir, "/etc/app", constants.BindMode)).To(Succeed())
+			Expect(readFile(filepath.Join(stateDir, "etc-app.manifest"))).To(Equal(manifest))
+
+			writeFiles(lower, map[string]string{"a.conf": "v3"})
+			Expect(fs.Chtimes(filepath.Join(lower, "a.conf"), info.ModTime(), info.ModTime().Add(time.Second))).To(Succeed())
+			Expect(action.ReconcilePersistentPath(cfg, spec.Sysroot, stateDir, "/etc/app", constants.BindMode)).To(Succeed())
+			Expect(readFile(filepath.Join(stateDir, "etc-app.manifest"))).NotTo(Equal(manifest))
+		})
 	})
 	Describe("Runs selinux relabeling", func() {
 		It("does not run if disabled in the spec", func() {
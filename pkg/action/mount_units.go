@@ -0,0 +1,311 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+const (
+	unitHeader     = "# Generated by elemental mount, do not edit\n"
+	unitDropInFile = "elemental-mounts.conf"
+	localFsTarget  = "local-fs.target"
+	systemdOptPref = "x-systemd."
+)
+
+// mountEntry is a mount set up by elemental, rendered either as an fstab line or as a mount unit
+type mountEntry struct {
+	device  string
+	path    string
+	fstype  string
+	options []string
+	// mountpoint this mount depends on, it is required and ordered before this mount
+	requires string
+}
+
+// mountUnit holds the systemd units generated for a mountEntry
+type mountUnit struct {
+	name      string
+	unit      []string
+	mount     []string
+	automount []string
+	// unit activated by the dependent targets, the automount unit if any
+	activate string
+	nofail   bool
+	// additional targets pulling this unit
+	wantedBy   []string
+	requiredBy []string
+}
+
+// WriteMountUnits writes a systemd mount unit for each of the given entries into the units
+// directory of the sysroot. Dependencies between mounts are set explicitly, so elemental
+// mounts do not depend on fstab ordering. Units are pulled in by local-fs.target through a drop-in.
+// Units generated in previous boots are removed first.
+func WriteMountUnits(cfg *types.RunConfig, sysroot string, entries []mountEntry) error {
+	unitDir := filepath.Join(sysroot, constants.SystemdUnitDir)
+	if err := utils.MkdirAll(cfg.Fs, unitDir, constants.DirPerm); err != nil {
+		return err
+	}
+	if err := removeMountUnits(cfg, unitDir); err != nil {
+		return err
+	}
+
+	// dependencies of each target, keyed by target name
+	requires := map[string][]string{}
+	wants := map[string][]string{}
+
+	for _, entry := range entries {
+		unit := newMountUnit(cfg.Logger, entry)
+
+		files := map[string][]string{unit.name: unit.unit}
+		if len(unit.automount) > 0 {
+			files[unit.activate] = unit.automount
+		}
+		for name, lines := range files {
+			cfg.Logger.Debugf("Writing unit %s", name)
+			data := unitHeader + strings.Join(lines, "\n") + "\n"
+			if err := cfg.Fs.WriteFile(filepath.Join(unitDir, name), []byte(data), 0644); err != nil {
+				return err
+			}
+		}
+
+		if unit.nofail {
+			wants[localFsTarget] = append(wants[localFsTarget], unit.activate)
+		} else {
+			requires[localFsTarget] = append(requires[localFsTarget], unit.activate)
+		}
+		for _, target := range unit.wantedBy {
+			wants[target] = append(wants[target], unit.activate)
+		}
+		for _, target := range unit.requiredBy {
+			requires[target] = append(requires[target], unit.activate)
+		}
+	}
+
+	for _, target := range sortedTargets(requires, wants) {
+		lines := []string{"[Unit]"}
+		for _, unit := range requires[target] {
+			lines = append(lines, fmt.Sprintf("Requires=%s", unit))
+		}
+		for _, unit := range wants[target] {
+			lines = append(lines, fmt.Sprintf("Wants=%s", unit))
+		}
+
+		dropInDir := filepath.Join(unitDir, target+".d")
+		if err := utils.MkdirAll(cfg.Fs, dropInDir, constants.DirPerm); err != nil {
+			return err
+		}
+		cfg.Logger.Debugf("Writing drop-in for %s", target)
+		data := unitHeader + strings.Join(lines, "\n") + "\n"
+		if err := cfg.Fs.WriteFile(filepath.Join(dropInDir, unitDropInFile), []byte(data), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeMountUnits removes the units and drop-ins written by a previous run
+func removeMountUnits(cfg *types.RunConfig, unitDir string) error {
+	dropIns, err := cfg.Fs.Glob(filepath.Join(unitDir, "*.d", unitDropInFile))
+	if err != nil {
+		return err
+	}
+	for _, dropIn := range dropIns {
+		if err = cfg.Fs.Remove(dropIn); err != nil {
+			return err
+		}
+	}
+
+	for _, suffix := range []string{".mount", ".automount"} {
+		units, err := cfg.Fs.Glob(filepath.Join(unitDir, "*"+suffix))
+		if err != nil {
+			return err
+		}
+		for _, unit := range units {
+			data, err := cfg.Fs.ReadFile(unit)
+			if err != nil || !strings.HasPrefix(string(data), unitHeader) {
+				continue
+			}
+			cfg.Logger.Debugf("Removing previously generated unit %s", unit)
+			if err = cfg.Fs.Remove(unit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newMountUnit creates the units for the given entry. x-systemd.* options are translated
+// into unit settings following systemd.mount(5) fstab semantics.
+func newMountUnit(logger types.Logger, entry mountEntry) *mountUnit {
+	unit := &mountUnit{name: unitName(entry.path, ".mount")}
+	unit.activate = unit.name
+
+	unit.unit = []string{"[Unit]", fmt.Sprintf("Description=Elemental mount for %s", entry.path)}
+	if entry.requires != "" {
+		required := unitName(entry.requires, ".mount")
+		unit.unit = append(unit.unit, fmt.Sprintf("Requires=%s", required), fmt.Sprintf("After=%s", required))
+	}
+
+	var automount bool
+	var idleTimeout, mountTimeout string
+	options := []string{}
+	for _, opt := range entry.options {
+		key, val, _ := strings.Cut(opt, "=")
+		switch key {
+		case "nofail":
+			unit.nofail = true
+			continue
+		case "auto", "noauto":
+			continue
+		}
+
+		name, ok := strings.CutPrefix(key, systemdOptPref)
+		if !ok {
+			options = append(options, opt)
+			continue
+		}
+		switch name {
+		case "requires":
+			dep := dependencyUnit(val)
+			unit.unit = append(unit.unit, fmt.Sprintf("Requires=%s", dep), fmt.Sprintf("After=%s", dep))
+		case "wants":
+			unit.unit = append(unit.unit, fmt.Sprintf("Wants=%s", dependencyUnit(val)))
+		case "before":
+			unit.unit = append(unit.unit, fmt.Sprintf("Before=%s", dependencyUnit(val)))
+		case "after":
+			unit.unit = append(unit.unit, fmt.Sprintf("After=%s", dependencyUnit(val)))
+		case "requires-mounts-for":
+			if val != entry.requires {
+				unit.unit = append(unit.unit, fmt.Sprintf("RequiresMountsFor=%s", val))
+			}
+		case "wants-mounts-for":
+			unit.unit = append(unit.unit, fmt.Sprintf("WantsMountsFor=%s", val))
+		case "wanted-by":
+			unit.wantedBy = append(unit.wantedBy, val)
+		case "required-by":
+			unit.requiredBy = append(unit.requiredBy, val)
+		case "mount-timeout":
+			mountTimeout = val
+		case "rw-only":
+			unit.mount = append(unit.mount, "ReadWriteOnly=yes")
+		case "automount":
+			automount = true
+		case "idle-timeout":
+			idleTimeout = val
+		default:
+			logger.Warnf("Ignoring unsupported option '%s' for mount unit %s", opt, unit.name)
+		}
+	}
+
+	if len(options) == 0 {
+		options = []string{"defaults"}
+	}
+	fstype := entry.fstype
+	if fstype == "" {
+		fstype = constants.Autofs
+	}
+	unit.mount = append([]string{
+		"[Mount]",
+		fmt.Sprintf("What=%s", entry.device),
+		fmt.Sprintf("Where=%s", entry.path),
+		fmt.Sprintf("Type=%s", fstype),
+		fmt.Sprintf("Options=%s", strings.Join(options, ",")),
+	}, unit.mount...)
+	if mountTimeout != "" {
+		unit.mount = append(unit.mount, fmt.Sprintf("TimeoutSec=%s", mountTimeout))
+	}
+
+	if automount {
+		unit.activate = unitName(entry.path, ".automount")
+		unit.automount = []string{
+			"[Unit]", fmt.Sprintf("Description=Elemental automount for %s", entry.path),
+		}
+		if !unit.nofail {
+			unit.automount = append(unit.automount, fmt.Sprintf("Before=%s", localFsTarget))
+		}
+		unit.automount = append(unit.automount, "", "[Automount]", fmt.Sprintf("Where=%s", entry.path))
+		if idleTimeout != "" {
+			unit.automount = append(unit.automount, fmt.Sprintf("TimeoutIdleSec=%s", idleTimeout))
+		}
+	} else if !unit.nofail {
+		unit.unit = append(unit.unit, fmt.Sprintf("Before=%s", localFsTarget))
+	}
+
+	unit.unit = append(append(unit.unit, ""), unit.mount...)
+	return unit
+}
+
+// dependencyUnit returns the unit name for a dependency given as an x-systemd.* option,
+// which can be either a unit name or the absolute path of a device or mountpoint
+func dependencyUnit(dep string) string {
+	switch {
+	case strings.HasPrefix(dep, devPref):
+		return unitName(dep, ".device")
+	case strings.HasPrefix(dep, "/"):
+		return unitName(dep, ".mount")
+	default:
+		return dep
+	}
+}
+
+// unitName returns the unit name of the given path with the given suffix, escaped
+// the same way 'systemd-escape --path' does
+func unitName(path, suffix string) string {
+	path = strings.Trim(filepath.Clean(path), "/")
+	if path == "" {
+		return "-" + suffix
+	}
+
+	var name strings.Builder
+	for i, c := range []byte(path) {
+		switch {
+		case c == '/':
+			name.WriteByte('-')
+		case c == '.' && i == 0:
+			fmt.Fprintf(&name, `\x%02x`, c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.':
+			name.WriteByte(c)
+		default:
+			fmt.Fprintf(&name, `\x%02x`, c)
+		}
+	}
+	return name.String() + suffix
+}
+
+// sortedTargets returns the keys of the given dependency maps sorted by name
+func sortedTargets(deps ...map[string][]string) []string {
+	targets := []string{}
+	for _, dep := range deps {
+		for target := range dep {
+			if !slices.Contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
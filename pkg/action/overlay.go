@@ -0,0 +1,358 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// Types of overlay changes
+const (
+	OverlayAdded    = "A"
+	OverlayModified = "M"
+	OverlayDeleted  = "D"
+	OverlayOpaque   = "O"
+)
+
+const (
+	ephemeralKind  = "ephemeral"
+	persistentKind = "persistent"
+	resetSuffix    = ".reset"
+)
+
+// overlayOpaqueXattrs are the extended attributes marking an overlay directory as opaque
+var overlayOpaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// OverlayChange is a change of a single entry on top of the immutable image
+type OverlayChange struct {
+	Type string
+	Path string
+	// Unified diff against the image file, only set for modified files if requested
+	Diff string
+}
+
+// OverlayDiff holds the changes of an ephemeral or persistent path
+type OverlayDiff struct {
+	Path    string
+	Kind    string
+	Mode    string
+	Changes []OverlayChange
+}
+
+// overlayPath is an ephemeral or persistent path and the directory holding its changes
+type overlayPath struct {
+	path    string
+	kind    string
+	mode    string
+	dataDir string
+}
+
+// OverlayDiffAction reports the changes of the ephemeral and persistent paths on top of the immutable image
+type OverlayDiffAction struct {
+	cfg     *types.RunConfig
+	spec    *types.MountSpec
+	paths   []string
+	content bool
+}
+
+type OverlayDiffActionOption func(o *OverlayDiffAction) error
+
+// WithOverlayDiffPaths limits the report to the given paths
+func WithOverlayDiffPaths(paths ...string) func(o *OverlayDiffAction) error {
+	return func(o *OverlayDiffAction) error {
+		o.paths = []string{}
+		for _, path := range paths {
+			o.paths = append(o.paths, filepath.Clean(path))
+		}
+		return nil
+	}
+}
+
+// WithOverlayDiffContent includes the content diffs of modified files
+func WithOverlayDiffContent(content bool) func(o *OverlayDiffAction) error {
+	return func(o *OverlayDiffAction) error {
+		o.content = content
+		return nil
+	}
+}
+
+func NewOverlayDiffAction(cfg *types.RunConfig, spec *types.MountSpec, opts ...OverlayDiffActionOption) *OverlayDiffAction {
+	o := &OverlayDiffAction{cfg: cfg, spec: spec}
+
+	for _, opt := range opts {
+		err := opt(o)
+		if err != nil {
+			cfg.Logger.Errorf("error applying config option: %s", err.Error())
+			return nil
+		}
+	}
+
+	return o
+}
+
+// Run walks the data of each ephemeral and persistent path and returns its changes compared to the
+// image. The image is accessed through a bind mount of the root filesystem, which does not include
+// the overlays mounted on top of it.
+func (o OverlayDiffAction) Run() (diffs []OverlayDiff, err error) {
+	cleanup := utils.NewCleanStack()
+	defer func() { err = cleanup.Cleanup(err) }()
+
+	overlays := overlayPaths(o.spec)
+	for _, path := range o.paths {
+		if !slices.ContainsFunc(overlays, func(p overlayPath) bool { return p.path == path }) {
+			return nil, elementalError.New(fmt.Sprintf("%s is not an ephemeral or persistent path", path), elementalError.OverlayDiff)
+		}
+	}
+
+	lowerRoot := constants.OverlayLowerDir
+	err = utils.MkdirAll(o.cfg.Fs, lowerRoot, constants.DirPerm)
+	if err != nil {
+		return nil, elementalError.NewFromError(err, elementalError.CreateDir)
+	}
+
+	err = o.cfg.Mounter.Mount("/", lowerRoot, "none", []string{"bind", "ro"})
+	if err != nil {
+		o.cfg.Logger.Errorf("failed mounting the root filesystem: %v", err)
+		return nil, elementalError.NewFromError(err, elementalError.OverlayDiff)
+	}
+	cleanup.Push(func() error { return o.cfg.Mounter.Unmount(lowerRoot) })
+
+	for _, ovl := range overlays {
+		if len(o.paths) > 0 && !slices.Contains(o.paths, ovl.path) {
+			continue
+		}
+		diff := OverlayDiff{Path: ovl.path, Kind: ovl.kind, Mode: ovl.mode}
+		if exists, _ := utils.Exists(o.cfg.Fs, ovl.dataDir); !exists {
+			o.cfg.Logger.Debugf("No data found for %s at %s", ovl.path, ovl.dataDir)
+			diffs = append(diffs, diff)
+			continue
+		}
+
+		lower := filepath.Join(lowerRoot, ovl.path)
+		if ovl.mode == constants.BindMode {
+			diff.Changes, err = o.bindChanges(ovl, lower)
+		} else {
+			diff.Changes, err = o.overlayChanges(ovl, lower)
+		}
+		if err != nil {
+			o.cfg.Logger.Errorf("failed reading changes of %s: %v", ovl.path, err)
+			return nil, elementalError.NewFromError(err, elementalError.OverlayDiff)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// overlayChanges walks the upper dir of an overlay. Whiteouts are reported as deleted entries
+// and opaque directories, which hide the image directory, as opaque entries.
+func (o OverlayDiffAction) overlayChanges(ovl overlayPath, lower string) ([]OverlayChange, error) {
+	changes := []OverlayChange{}
+	err := utils.WalkDirFs(o.cfg.Fs, ovl.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == ovl.dataDir {
+			return err
+		}
+		rel, _ := filepath.Rel(ovl.dataDir, path)
+		change := OverlayChange{Path: filepath.Join(ovl.path, rel)}
+		lowerExists, _ := utils.Exists(o.cfg.Fs, filepath.Join(lower, rel), true)
+
+		switch {
+		case d.Type()&fs.ModeCharDevice != 0:
+			change.Type = OverlayDeleted
+		case d.IsDir() && o.isOpaque(path):
+			change.Type = OverlayOpaque
+		case !lowerExists:
+			change.Type = OverlayAdded
+		case d.IsDir():
+			return nil
+		default:
+			change.Type = OverlayModified
+			change.Diff = o.contentDiff(filepath.Join(lower, rel), path)
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	return changes, err
+}
+
+// bindChanges compares the persisted data of a bind mounted path with the image
+func (o OverlayDiffAction) bindChanges(ovl overlayPath, lower string) ([]OverlayChange, error) {
+	changes := []OverlayChange{}
+	err := utils.WalkDirFs(o.cfg.Fs, ovl.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == ovl.dataDir {
+			return err
+		}
+		rel, _ := filepath.Rel(ovl.dataDir, path)
+		change := OverlayChange{Path: filepath.Join(ovl.path, rel)}
+		lowerPath := filepath.Join(lower, rel)
+
+		if exists, _ := utils.Exists(o.cfg.Fs, lowerPath, true); !exists {
+			change.Type = OverlayAdded
+		} else if d.IsDir() {
+			return nil
+		} else {
+			sum, err := defaultSum(o.cfg.Fs, path)
+			if err != nil {
+				return err
+			}
+			if lowerSum, _ := defaultSum(o.cfg.Fs, lowerPath); lowerSum == sum {
+				return nil
+			}
+			change.Type = OverlayModified
+			change.Diff = o.contentDiff(lowerPath, path)
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return changes, err
+	}
+
+	err = utils.WalkDirFs(o.cfg.Fs, lower, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == lower {
+			return err
+		}
+		rel, _ := filepath.Rel(lower, path)
+		if exists, _ := utils.Exists(o.cfg.Fs, filepath.Join(ovl.dataDir, rel), true); exists {
+			return nil
+		}
+		changes = append(changes, OverlayChange{Type: OverlayDeleted, Path: filepath.Join(ovl.path, rel)})
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return changes, nil
+	}
+	return changes, err
+}
+
+// isOpaque checks if the given upper dir is marked as opaque
+func (o OverlayDiffAction) isOpaque(path string) bool {
+	if raw, err := o.cfg.Fs.RawPath(path); err == nil {
+		path = raw
+	}
+	value := make([]byte, 1)
+	for _, attr := range overlayOpaqueXattrs {
+		if n, err := o.cfg.Syscall.Getxattr(path, attr, value); err == nil && n == 1 && value[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+// contentDiff returns the unified diff between the image file and the changed file, if requested
+func (o OverlayDiffAction) contentDiff(lower, upper string) string {
+	if !o.content {
+		return ""
+	}
+	if info, err := o.cfg.Fs.Lstat(upper); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	lowerRaw, _ := o.cfg.Fs.RawPath(lower)
+	upperRaw, _ := o.cfg.Fs.RawPath(upper)
+	// diff exits with 1 when files differ, hence only the output is relevant
+	out, _ := o.cfg.Runner.Run("diff", "-u", lowerRaw, upperRaw)
+	return string(out)
+}
+
+// ResetPersistentPath drops the persisted data of the given path on the next boot, so it
+// falls back to the image contents
+func ResetPersistentPath(cfg *types.RunConfig, spec *types.MountSpec, path string) error {
+	path = filepath.Clean(path)
+	for _, ovl := range overlayPaths(spec) {
+		if ovl.path != path {
+			continue
+		}
+		if ovl.kind == ephemeralKind {
+			return elementalError.New(fmt.Sprintf("%s is an ephemeral path, it is reset on every boot", path), elementalError.OverlayDiff)
+		}
+		stateDir := filepath.Join(spec.Persistent.Volume.Mountpoint, constants.PersistentStateDir)
+		marker := filepath.Join(stateDir, persistentPathName(path)+resetSuffix)
+		err := cfg.Fs.WriteFile(marker, []byte{}, constants.FilePerm)
+		if err != nil {
+			cfg.Logger.Errorf("failed flagging %s for reset: %v", path, err)
+			return elementalError.NewFromError(err, elementalError.CreateFile)
+		}
+		cfg.Logger.Infof("Persistent data of %s will be reset on next boot", path)
+		return nil
+	}
+	return elementalError.New(fmt.Sprintf("%s is not a persistent path", path), elementalError.OverlayDiff)
+}
+
+// resetPersistentPath removes the persisted data of the given path if it was flagged for reset
+func resetPersistentPath(cfg *types.RunConfig, stateDir, path string) error {
+	name := persistentPathName(path)
+	marker := filepath.Join(stateDir, name+resetSuffix)
+	if exists, _ := utils.Exists(cfg.Fs, marker); !exists {
+		return nil
+	}
+
+	cfg.Logger.Infof("Resetting persistent path %s", path)
+	for _, suffix := range []string{overlaySuffix, ".bind", manifestSuffix, resetSuffix} {
+		if err := cfg.Fs.RemoveAll(filepath.Join(stateDir, name+suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overlayPaths returns the ephemeral and persistent paths of the running system
+func overlayPaths(spec *types.MountSpec) []overlayPath {
+	paths := []overlayPath{}
+	for _, path := range spec.Ephemeral.Paths {
+		paths = append(paths, overlayPath{
+			path:    filepath.Clean(path.Path),
+			kind:    ephemeralKind,
+			mode:    constants.OverlayMode,
+			dataDir: filepath.Join(ephemeralPathDir(path.Path), "upper"),
+		})
+	}
+
+	if !spec.HasPersistent() {
+		return paths
+	}
+	stateDir := filepath.Join(spec.Persistent.Volume.Mountpoint, constants.PersistentStateDir)
+	for _, path := range spec.Persistent.Paths {
+		ovl := overlayPath{
+			path:    filepath.Clean(path.Path),
+			kind:    persistentKind,
+			mode:    persistenceMode(spec, path),
+			dataDir: filepath.Join(stateDir, persistentPathName(path.Path)+".bind"),
+		}
+		if ovl.mode == constants.OverlayMode {
+			ovl.dataDir = filepath.Join(stateDir, persistentPathName(path.Path)+overlaySuffix, "upper")
+		}
+		paths = append(paths, ovl)
+	}
+	return paths
+}
+
+// persistentPathName returns the name used for the data directories of the given path
+func persistentPathName(path string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(path, "/"), "/", "-")
+}
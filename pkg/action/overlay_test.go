@@ -0,0 +1,164 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action_test
+
+import (
+	"bytes"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	conf "github.com/rancher/elemental-toolkit/v2/pkg/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+var _ = Describe("Overlay diff action tests", Label("overlay"), func() {
+	var config *types.RunConfig
+	var runner *mocks.FakeRunner
+	var syscall *mocks.FakeSyscall
+	var fs vfs.FS
+	var mounter *mocks.FakeMounter
+	var cleanup func()
+	var spec *types.MountSpec
+
+	stateDir := filepath.Join(constants.PersistentDir, constants.PersistentStateDir)
+	writeFiles := func(dir string, files map[string]string) {
+		Expect(utils.MkdirAll(fs, dir, constants.DirPerm)).To(Succeed())
+		for name, data := range files {
+			Expect(utils.MkdirAll(fs, filepath.Dir(filepath.Join(dir, name)), constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(dir, name), []byte(data), 0644)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		runner = mocks.NewFakeRunner()
+		mounter = mocks.NewFakeMounter()
+		syscall = &mocks.FakeSyscall{}
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{})
+		Expect(err).Should(BeNil())
+
+		config = conf.NewRunConfig(
+			conf.WithFs(fs),
+			conf.WithRunner(runner),
+			conf.WithSyscall(syscall),
+			conf.WithLogger(types.NewBufferLogger(&bytes.Buffer{})),
+			conf.WithMounter(mounter),
+		)
+
+		spec = &types.MountSpec{
+			Ephemeral: types.EphemeralMounts{
+				Type:  constants.Tmpfs,
+				Paths: types.NewEphemeralPaths("/etc"),
+			},
+			Persistent: types.PersistentMounts{
+				Mode:  constants.OverlayMode,
+				Paths: types.NewPersistentPaths("/home", "/var/lib/app:bind"),
+				Volume: types.VolumeMount{
+					Mountpoint: constants.PersistentDir,
+					Device:     "/dev/persistentdev",
+				},
+			},
+		}
+
+		// image contents
+		writeFiles(constants.OverlayLowerDir, map[string]string{
+			"etc/passwd": "root", "etc/conf.d/a": "a",
+			"var/lib/app/data": "v1", "var/lib/app/gone": "v1",
+		})
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+	It("reports the changes of overlay and bind paths", func() {
+		upper := filepath.Join(constants.OverlayDir, "etc.overlay", "upper")
+		writeFiles(upper, map[string]string{"passwd": "root,user", "hostname": "node", "conf.d/b": "b"})
+		rawConfD, err := fs.RawPath(filepath.Join(upper, "conf.d"))
+		Expect(err).NotTo(HaveOccurred())
+		syscall.Xattrs = map[string]map[string]string{rawConfD: {"trusted.overlay.opaque": "y"}}
+
+		writeFiles(filepath.Join(stateDir, "var-lib-app.bind"), map[string]string{"data": "v2", "new": "v1"})
+
+		diffs, err := action.NewOverlayDiffAction(config, spec).Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diffs).To(HaveLen(3))
+
+		Expect(diffs[0].Path).To(Equal("/etc"))
+		Expect(diffs[0].Kind).To(Equal("ephemeral"))
+		Expect(diffs[0].Changes).To(Equal([]action.OverlayChange{
+			{Type: action.OverlayOpaque, Path: "/etc/conf.d"},
+			{Type: action.OverlayAdded, Path: "/etc/conf.d/b"},
+			{Type: action.OverlayAdded, Path: "/etc/hostname"},
+			{Type: action.OverlayModified, Path: "/etc/passwd"},
+		}))
+
+		// no data persisted yet
+		Expect(diffs[1].Path).To(Equal("/home"))
+		Expect(diffs[1].Changes).To(BeEmpty())
+
+		Expect(diffs[2].Mode).To(Equal(constants.BindMode))
+		Expect(diffs[2].Changes).To(Equal([]action.OverlayChange{
+			{Type: action.OverlayModified, Path: "/var/lib/app/data"},
+			{Type: action.OverlayAdded, Path: "/var/lib/app/new"},
+			{Type: action.OverlayDeleted, Path: "/var/lib/app/gone"},
+		}))
+
+		Expect(mounter.IsLikelyNotMountPoint(constants.OverlayLowerDir)).To(BeTrue())
+	})
+	It("includes content diffs of the requested paths", func() {
+		writeFiles(filepath.Join(constants.OverlayDir, "etc.overlay", "upper"), map[string]string{"passwd": "root,user"})
+		runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+			if cmd == "diff" {
+				return []byte("-root\n+root,user\n"), nil
+			}
+			return []byte{}, nil
+		}
+
+		diffs, err := action.NewOverlayDiffAction(
+			config, spec, action.WithOverlayDiffPaths("/etc/"), action.WithOverlayDiffContent(true),
+		).Run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diffs).To(HaveLen(1))
+		Expect(diffs[0].Changes[0].Diff).To(Equal("-root\n+root,user\n"))
+		Expect(runner.IncludesCmds([][]string{{"diff", "-u"}})).To(Succeed())
+	})
+	It("fails for unknown paths", func() {
+		_, err := action.NewOverlayDiffAction(config, spec, action.WithOverlayDiffPaths("/srv")).Run()
+		Expect(err).To(HaveOccurred())
+	})
+	It("resets persistent paths on next boot", func() {
+		upper := filepath.Join(stateDir, "home.overlay", "upper")
+		writeFiles(upper, map[string]string{"user/file": "data"})
+		Expect(action.ResetPersistentPath(config, spec, "/home")).To(Succeed())
+		Expect(utils.Exists(fs, filepath.Join(stateDir, "home.reset"))).To(BeTrue())
+
+		Expect(action.ResetPersistentPath(config, spec, "/etc")).NotTo(Succeed())
+		Expect(action.ResetPersistentPath(config, spec, "/srv")).NotTo(Succeed())
+
+		spec.Sysroot = "/sysroot"
+		Expect(action.MountPersistent(config, spec)).To(Succeed())
+		Expect(utils.Exists(fs, filepath.Join(upper, "user/file"))).To(BeFalse())
+		Expect(utils.Exists(fs, filepath.Join(stateDir, "home.reset"))).To(BeFalse())
+	})
+})
@@ -0,0 +1,303 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
+	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
+	"github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// RepartitionAction resizes partitions and adds new ones on an existing installation
+type RepartitionAction struct {
+	cfg  *types.RunConfig
+	spec *types.RepartitionSpec
+}
+
+// resizeOp is a partition resize already checked against the current disk layout
+type resizeOp struct {
+	part   *types.Partition
+	number int
+	fs     string
+}
+
+func NewRepartitionAction(cfg *types.RunConfig, spec *types.RepartitionSpec) *RepartitionAction {
+	return &RepartitionAction{cfg: cfg, spec: spec}
+}
+
+// Run applies the requested layout changes. All changes are checked against the current
+// layout before touching the disk. Filesystems are shrunk before growing any other partition and
+// new partitions are added at the end, so they can make use of the freed space.
+func (r RepartitionAction) Run() error {
+	disk := partitioner.NewDisk(
+		r.spec.Target,
+		partitioner.WithRunner(r.cfg.Runner),
+		partitioner.WithFS(r.cfg.Fs),
+		partitioner.WithLogger(r.cfg.Logger),
+		partitioner.WithMounter(r.cfg.Mounter),
+	)
+	if !disk.Exists() {
+		return elementalError.New(fmt.Sprintf("disk %s does not exist", r.spec.Target), elementalError.InvalidTarget)
+	}
+	err := disk.Reload()
+	if err != nil {
+		r.cfg.Logger.Errorf("failed reading partition table of %s", r.spec.Target)
+		return elementalError.NewFromError(err, elementalError.RepartitionDevice)
+	}
+
+	resizes, additions, err := r.plan(disk)
+	if err != nil {
+		r.cfg.Logger.Errorf("invalid repartition request: %v", err)
+		return elementalError.NewFromError(err, elementalError.RepartitionDevice)
+	}
+
+	for _, op := range resizes {
+		r.cfg.Logger.Infof("Resizing partition %s", op.part.Name)
+		out, err := disk.ResizePartition(op.number, op.part.Size, op.fs)
+		if err != nil {
+			r.cfg.Logger.Errorf("failed resizing partition %s: %s", op.part.Name, out)
+			return elementalError.NewFromError(err, elementalError.RepartitionDevice)
+		}
+	}
+
+	for _, part := range additions {
+		r.cfg.Logger.Infof("Adding partition %s", part.Name)
+		err = elemental.CreateAndFormatPartition(r.cfg.Config, disk, part)
+		if err != nil {
+			r.cfg.Logger.Errorf("failed adding partition %s", part.Name)
+			return elementalError.NewFromError(err, elementalError.RepartitionDevice)
+		}
+	}
+
+	err = r.recordLayout(disk, additions)
+	if err != nil {
+		r.cfg.Logger.Errorf("failed recording the new layout: %v", err)
+		return elementalError.NewFromError(err, elementalError.CreateFile)
+	}
+
+	r.cfg.Logger.Info("Repartition finished successfully")
+	return nil
+}
+
+// plan matches the requested changes with the partitions of the target disk and checks them
+// against the current layout. Returns the resizes, shrinks first, and the partitions to add.
+func (r RepartitionAction) plan(disk *partitioner.Disk) ([]resizeOp, types.PartitionList, error) {
+	parts := disk.GetPartitions()
+	sectorS := disk.GetSectorSize()
+	lastS := disk.GetLastSector()
+
+	// last sector of each partition once resized
+	ends := map[int]uint{}
+	for _, p := range parts {
+		ends[p.Number] = p.StartS + p.SizeS - 1
+	}
+
+	shrinks, grows := []resizeOp{}, []resizeOp{}
+	additions := types.PartitionList{}
+	for _, part := range r.spec.Changes() {
+		current := findDiskPartition(parts, part.Name)
+		if current == nil {
+			if !slices.Contains(r.spec.ExtraPartitions, part) {
+				return nil, nil, fmt.Errorf("partition %s not found in %s", part.Name, r.spec.Target)
+			}
+			additions = append(additions, part)
+			continue
+		}
+
+		var newEnd uint
+		next := nextDiskPartition(parts, current)
+		switch {
+		case part.Size > 0:
+			newEnd = current.StartS + partitioner.MiBToSectors(part.Size, sectorS) - 1
+		case next != nil:
+			newEnd = next.StartS - 1
+		default:
+			newEnd = lastS - 1
+		}
+		if next != nil && newEnd >= next.StartS {
+			return nil, nil, fmt.Errorf("partition %s can't grow over partition %s", part.Name, next.PLabel)
+		}
+		if newEnd >= lastS {
+			return nil, nil, fmt.Errorf("partition %s can't grow beyond the end of the disk", part.Name)
+		}
+		if newEnd == ends[current.Number] {
+			r.cfg.Logger.Infof("Partition %s already has the requested size", part.Name)
+			continue
+		}
+
+		dev, err := disk.FindPartitionDevice(current.Number)
+		if err != nil {
+			return nil, nil, err
+		}
+		if mnt := r.mountPoint(dev); mnt != "" {
+			return nil, nil, fmt.Errorf("partition %s is mounted at %s, it must be unmounted to be resized", part.Name, mnt)
+		}
+		op := resizeOp{part: part, number: current.Number, fs: r.filesystem(dev)}
+		switch op.fs {
+		case constants.LuksFs, "linux_raid_member":
+			return nil, nil, fmt.Errorf("resizing partition %s is not supported, it is an %s device", part.Name, op.fs)
+		}
+		if newEnd < ends[current.Number] {
+			if !partitioner.SupportsShrink(op.fs) {
+				return nil, nil, fmt.Errorf("partition %s can't be shrunk, shrinking '%s' filesystems is not supported", part.Name, op.fs)
+			}
+			shrinks = append(shrinks, op)
+		} else {
+			if !partitioner.SupportsGrow(op.fs) {
+				return nil, nil, fmt.Errorf("partition %s can't be grown, growing '%s' filesystems is not supported", part.Name, op.fs)
+			}
+			grows = append(grows, op)
+		}
+		ends[current.Number] = newEnd
+	}
+
+	if len(additions) > 0 {
+		var lastEnd, required uint
+		for _, end := range ends {
+			lastEnd = max(lastEnd, end)
+		}
+		for i, part := range additions {
+			if part.Size == 0 && i != len(additions)-1 {
+				return nil, nil, fmt.Errorf("only the last new partition can take all the available space")
+			}
+			required += partitioner.MiBToSectors(part.Size, sectorS)
+		}
+		if lastEnd+required >= lastS-1 {
+			return nil, nil, fmt.Errorf("not enough free space in %s to add new partitions", r.spec.Target)
+		}
+	}
+
+	return append(shrinks, grows...), additions, nil
+}
+
+// recordLayout stores the resulting partition layout in the state files of the state and recovery partitions
+func (r RepartitionAction) recordLayout(disk *partitioner.Disk, additions types.PartitionList) (err error) {
+	cleanup := utils.NewCleanStack()
+	defer func() { err = cleanup.Cleanup(err) }()
+
+	mountPoints := map[string]string{}
+	for _, name := range []string{constants.StatePartName, constants.RecoveryPartName} {
+		current := findDiskPartition(disk.GetPartitions(), name)
+		if current == nil {
+			r.cfg.Logger.Warnf("%s partition not found, the new layout is not recorded", name)
+			return nil
+		}
+		dev, err := disk.FindPartitionDevice(current.Number)
+		if err != nil {
+			return err
+		}
+		mountPoint := r.mountPoint(dev)
+		if mountPoint == "" {
+			mountPoint, err = utils.TempDir(r.cfg.Fs, "", "elemental-"+name)
+			if err != nil {
+				return err
+			}
+			cleanup.Push(func() error { return r.cfg.Fs.RemoveAll(mountPoint) })
+		}
+		umount, err := elemental.MountRWPartition(r.cfg.Config, &types.Partition{Name: name, Path: dev, MountPoint: mountPoint})
+		if err != nil {
+			return err
+		}
+		cleanup.Push(umount)
+		mountPoints[name] = mountPoint
+	}
+
+	stateFile := filepath.Join(mountPoints[constants.StatePartName], constants.InstallStateFile)
+	installState, err := r.cfg.LoadInstallStateFile(stateFile)
+	if err != nil {
+		r.cfg.Logger.Warnf("could not load the install state, the new layout is not recorded: %v", err)
+		return nil
+	}
+	if installState.Partitions == nil {
+		installState.Partitions = map[string]*types.PartitionState{}
+	}
+
+	labels := map[string]string{}
+	for _, part := range additions {
+		labels[part.Name] = part.FilesystemLabel
+	}
+	sectorsPerMiB := partitioner.MiBToSectors(1, disk.GetSectorSize())
+	for _, p := range disk.GetPartitions() {
+		pState := installState.Partitions[p.PLabel]
+		if pState == nil {
+			label, ok := labels[p.PLabel]
+			if !ok {
+				continue
+			}
+			pState = &types.PartitionState{FSLabel: label}
+			installState.Partitions[p.PLabel] = pState
+		}
+		pState.Layout = &types.PartitionLayout{
+			Number: p.Number,
+			Start:  p.StartS / sectorsPerMiB,
+			Size:   p.SizeS / sectorsPerMiB,
+		}
+	}
+
+	return r.cfg.WriteInstallState(
+		installState, stateFile,
+		filepath.Join(mountPoints[constants.RecoveryPartName], constants.InstallStateFile),
+	)
+}
+
+// mountPoint returns the first mountpoint of the given device, if mounted
+func (r RepartitionAction) mountPoint(dev string) string {
+	out, err := r.cfg.Runner.Run("findmnt", "-n", "-o", "TARGET", "--source", dev)
+	if err != nil {
+		return ""
+	}
+	return strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+}
+
+// filesystem returns the filesystem type found in the given device, empty if none is found
+func (r RepartitionAction) filesystem(dev string) string {
+	out, err := r.cfg.Runner.Run("blkid", "-p", "-s", "TYPE", "-o", "value", dev)
+	if err != nil {
+		r.cfg.Logger.Debugf("no filesystem found in %s: %s", dev, strings.TrimSpace(string(out)))
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// findDiskPartition returns the disk partition with the given partition label
+func findDiskPartition(parts []partitioner.Partition, name string) *partitioner.Partition {
+	for i := range parts {
+		if parts[i].PLabel == name {
+			return &parts[i]
+		}
+	}
+	return nil
+}
+
+// nextDiskPartition returns the partition located right after the given one, if any
+func nextDiskPartition(parts []partitioner.Partition, part *partitioner.Partition) *partitioner.Partition {
+	var next *partitioner.Partition
+	for i := range parts {
+		if parts[i].StartS > part.StartS && (next == nil || parts[i].StartS < next.StartS) {
+			next = &parts[i]
+		}
+	}
+	return next
+}
@@ -0,0 +1,231 @@
+/*
+   Copyright © 2022 - 2025 SUSE LLC
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package action_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/action"
+	conf "github.com/rancher/elemental-toolkit/v2/pkg/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// fakePart is a partition of the fake partition table used for repartition tests
+type fakePart struct {
+	start, end uint
+	fs, name   string
+}
+
+var _ = Describe("Repartition action tests", Label("repartition"), func() {
+	var config *types.RunConfig
+	var runner *mocks.FakeRunner
+	var fs vfs.FS
+	var mounter *mocks.FakeMounter
+	var cleanup func()
+	var spec *types.RepartitionSpec
+	var parts []*fakePart
+	var fsType string
+	var mounted map[string]string
+
+	const lastS = 50593792
+	statePath := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
+
+	partedPrint := func() string {
+		out := fmt.Sprintf("BYT;\n/some/device:%ds:loopback:512:512:gpt:Loopback device:;", lastS)
+		for i, p := range parts {
+			out += fmt.Sprintf("\n%d:%ds:%ds:%ds:%s:%s:;", i+1, p.start, p.end, p.end-p.start+1, p.fs, p.name)
+		}
+		return out
+	}
+
+	BeforeEach(func() {
+		runner = mocks.NewFakeRunner()
+		mounter = mocks.NewFakeMounter()
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{})
+		Expect(err).Should(BeNil())
+
+		config = conf.NewRunConfig(
+			conf.WithFs(fs),
+			conf.WithRunner(runner),
+			conf.WithLogger(types.NewBufferLogger(&bytes.Buffer{})),
+			conf.WithMounter(mounter),
+		)
+
+		parts = []*fakePart{
+			{start: 2048, end: 133119, fs: "fat16", name: constants.BootPartName},
+			{start: 133120, end: 264191, fs: "ext4", name: constants.OEMPartName},
+			{start: 264192, end: 8652799, fs: "ext4", name: constants.RecoveryPartName},
+			{start: 8652800, end: 25430015, fs: "ext4", name: constants.StatePartName},
+			{start: 25430016, end: 50593758, fs: "ext4", name: constants.PersistentPartName},
+		}
+		Expect(utils.MkdirAll(fs, "/some", constants.DirPerm)).To(Succeed())
+		_, err = fs.Create("/some/device")
+		Expect(err).To(Succeed())
+		for i := range parts {
+			_, err = fs.Create(fmt.Sprintf("/some/device%d", i+1))
+			Expect(err).To(Succeed())
+		}
+
+		Expect(utils.MkdirAll(fs, constants.RunningStateDir, constants.DirPerm)).To(Succeed())
+		Expect(fs.WriteFile(statePath, []byte("state:\n  label: COS_STATE\n"), constants.FilePerm)).To(Succeed())
+		mounted = map[string]string{
+			"/some/device3": constants.RecoveryDir,
+			"/some/device4": constants.RunningStateDir,
+		}
+		fsType = "ext4"
+
+		runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+			switch cmd {
+			case "parted":
+				for i := 0; i < len(args); i++ {
+					switch args[i] {
+					case "resizepart":
+						num, _ := strconv.Atoi(args[i+1])
+						end, err := strconv.Atoi(args[i+2])
+						if err != nil {
+							end = lastS - 34
+						}
+						parts[num-1].end = uint(end)
+					case "mkpart":
+						start, _ := strconv.Atoi(args[i+3])
+						end, err := strconv.Atoi(args[i+4])
+						if err != nil {
+							end = lastS - 34
+						}
+						parts = append(parts, &fakePart{start: uint(start), end: uint(end), fs: args[i+2], name: args[i+1]})
+						_, _ = fs.Create(fmt.Sprintf("/some/device%d", len(parts)))
+					}
+				}
+				return []byte(partedPrint()), nil
+			case "blkid":
+				return []byte(fsType), nil
+			case "findmnt":
+				if mnt, ok := mounted[args[len(args)-1]]; ok {
+					return []byte(mnt), nil
+				}
+				return []byte{}, fmt.Errorf("not mounted")
+			default:
+				return []byte{}, nil
+			}
+		}
+
+		spec = &types.RepartitionSpec{Target: "/some/device"}
+	})
+
+	AfterEach(func() { cleanup() })
+
+	It("Shrinks the persistent partition and adds a new one in the freed space", func() {
+		spec.Partitions.Persistent = &types.Partition{Size: 8192}
+		spec.ExtraPartitions = types.PartitionList{{Name: "data", FS: "ext4", FilesystemLabel: "DATA"}}
+		Expect(spec.Sanitize()).To(Succeed())
+
+		Expect(action.NewRepartitionAction(config, spec).Run()).To(Succeed())
+		Expect(runner.MatchMilestones([][]string{
+			{"e2fsck", "-fy", "/some/device5"},
+			{"resize2fs", "/some/device5", "8388608K"},
+			{"parted", "--script", "--machine", "--", "/some/device", "unit", "s", "resizepart", "5", "42207231"},
+			{"parted", "--script", "--machine", "--", "/some/device", "unit", "s", "mkpart", "data", "ext4", "42207232", "100%"},
+			{"mkfs.ext4", "-L", "DATA", "/some/device6"},
+		})).To(Succeed())
+
+		state, err := config.LoadInstallStateFile(statePath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.Partitions[constants.PersistentPartName]).To(BeNil())
+		Expect(state.Partitions[constants.StatePartName].Layout).To(Equal(&types.PartitionLayout{Number: 4, Start: 4225, Size: 8192}))
+		Expect(state.Partitions["data"].FSLabel).To(Equal("DATA"))
+		Expect(state.Partitions["data"].Layout.Start).To(Equal(uint(20609)))
+		Expect(state.Partitions["data"].Layout.Number).To(Equal(6))
+		recoveryState, err := config.LoadInstallStateFile(filepath.Join(constants.RecoveryDir, constants.InstallStateFile))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recoveryState.Partitions["data"]).NotTo(BeNil())
+	})
+
+	It("Grows the last partition up to the end of the disk", func() {
+		parts[4].end = 42207231
+		spec.Partitions.Persistent = &types.Partition{}
+		Expect(spec.Sanitize()).To(Succeed())
+
+		Expect(action.NewRepartitionAction(config, spec).Run()).To(Succeed())
+		Expect(runner.MatchMilestones([][]string{
+			{"parted", "--script", "--machine", "--", "/some/device", "unit", "s", "resizepart", "5", "100%"},
+			{"e2fsck", "-fy", "/some/device5"},
+			{"resize2fs", "/some/device5"},
+		})).To(Succeed())
+	})
+
+	It("Fails to resize a mounted partition", func() {
+		spec.Partitions.State = &types.Partition{Size: 4096}
+		Expect(spec.Sanitize()).To(Succeed())
+
+		Expect(action.NewRepartitionAction(config, spec).Run()).NotTo(Succeed())
+		Expect(runner.IncludesCmds([][]string{{"parted", "--script", "--machine", "--", "/some/device", "unit", "s", "resizepart"}})).NotTo(Succeed())
+	})
+
+	It("Fails to grow a partition over the next one without changing the disk", func() {
+		spec.Partitions.OEM = &types.Partition{Size: 1024}
+		spec.Partitions.Persistent = &types.Partition{Size: 8192}
+		Expect(spec.Sanitize()).To(Succeed())
+
+		Expect(action.NewRepartitionAction(config, spec).Run()).NotTo(Succeed())
+		Expect(runner.IncludesCmds([][]string{{"resize2fs"}})).NotTo(Succeed())
+	})
+
+	It("Fails to shrink a filesystem not supporting it", func() {
+		fsType = "xfs"
+		spec.Partitions.Persistent = &types.Partition{Size: 8192}
+		Expect(spec.Sanitize()).To(Succeed())
+
+		Expect(action.NewRepartitionAction(config, spec).Run()).NotTo(Succeed())
+		Expect(runner.IncludesCmds([][]string{{"parted", "--script", "--machine", "--", "/some/device", "unit", "s", "resizepart"}})).NotTo(Succeed())
+	})
+
+	It("Fails to grow a filesystem not supporting it without changing the disk", func() {
+		fsType = "vfat"
+		parts[4].end = 42207231
+		spec.Partitions.Persistent = &types.Partition{}
+		Expect(spec.Sanitize()).To(Succeed())
+
+		Expect(action.NewRepartitionAction(config, spec).Run()).To(MatchError(ContainSubstring("can't be grown")))
+		Expect(runner.IncludesCmds([][]string{{"parted", "--script", "--machine", "--", "/some/device", "unit", "s", "resizepart"}})).NotTo(Succeed())
+	})
+
+	It("Fails to add partitions if there is not enough free space", func() {
+		spec.ExtraPartitions = types.PartitionList{{Name: "data", Size: 1024, FS: "ext4"}}
+		Expect(spec.Sanitize()).To(Succeed())
+
+		Expect(action.NewRepartitionAction(config, spec).Run()).NotTo(Succeed())
+		Expect(runner.IncludesCmds([][]string{{"mkfs.ext4"}})).NotTo(Succeed())
+	})
+
+	It("Fails if the target device does not exist", func() {
+		spec.Target = "/some/missing"
+		spec.Partitions.Persistent = &types.Partition{}
+		Expect(action.NewRepartitionAction(config, spec).Run()).NotTo(Succeed())
+	})
+})
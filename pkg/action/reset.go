@@ -142,7 +142,8 @@ func (r *ResetAction) updateInstallState(cleanup *utils.CleanStack) error {
 		Snapshotter: r.cfg.Snapshotter,
 		Partitions: map[string]*types.PartitionState{
 			constants.StatePartName: {
-				FSLabel: r.spec.Partitions.State.FilesystemLabel,
+				FSLabel:    r.spec.Partitions.State.FilesystemLabel,
+				RAIDDevice: r.raidDevice(constants.StatePartName),
 				Snapshots: map[int]*types.SystemState{
 					r.snapshot.ID: {
 						Source:     src,
@@ -151,6 +152,7 @@ func (r *ResetAction) updateInstallState(cleanup *utils.CleanStack) error {
 						Labels:     r.spec.SnapshotLabels,
 						Date:       date,
 						FromAction: constants.ActionReset,
+						RootHash:   r.snapshot.RootHash,
 					},
 				},
 			},
@@ -158,16 +160,27 @@ func (r *ResetAction) updateInstallState(cleanup *utils.CleanStack) error {
 	}
 	if r.spec.Partitions.OEM != nil {
 		installState.Partitions[constants.OEMPartName] = &types.PartitionState{
-			FSLabel: r.spec.Partitions.OEM.FilesystemLabel,
+			FSLabel:    r.spec.Partitions.OEM.FilesystemLabel,
+			RAIDDevice: r.raidDevice(constants.OEMPartName),
 		}
 	}
 	if r.spec.Partitions.Persistent != nil {
-		installState.Partitions[constants.PersistentPartName] = &types.PartitionState{
-			FSLabel: r.spec.Partitions.Persistent.FilesystemLabel,
+		pState := &types.PartitionState{
+			FSLabel:    r.spec.Partitions.Persistent.FilesystemLabel,
+			RAIDDevice: r.raidDevice(constants.PersistentPartName),
 		}
+		if r.spec.FormatPersistent {
+			pState.Encryption = r.spec.Partitions.Persistent.Encryption.VolumeEncryption()
+		} else if r.spec.State != nil && r.spec.State.Partitions[constants.PersistentPartName] != nil {
+			pState.Encryption = r.spec.State.Partitions[constants.PersistentPartName].Encryption
+		}
+		installState.Partitions[constants.PersistentPartName] = pState
 	}
 	if r.spec.State != nil && r.spec.State.Partitions != nil {
 		installState.Partitions[constants.RecoveryPartName] = r.spec.State.Partitions[constants.RecoveryPartName]
+		if boot := r.spec.State.Partitions[constants.BootPartName]; boot != nil {
+			installState.Partitions[constants.BootPartName] = boot
+		}
 	}
 
 	umount, err := elemental.MountRWPartition(r.cfg.Config, r.spec.Partitions.Recovery)
@@ -183,6 +196,35 @@ func (r *ResetAction) updateInstallState(cleanup *utils.CleanStack) error {
 	)
 }
 
+// raidDevice returns the RAID array device of the given partition as recorded in the
+// current install state, if any
+func (r *ResetAction) raidDevice(partName string) string {
+	if r.spec.State == nil || r.spec.State.Partitions[partName] == nil {
+		return ""
+	}
+	return r.spec.State.Partitions[partName].RAIDDevice
+}
+
+// unlockPersistent unlocks the encrypted persistent partition so it can be mounted
+// during the reset. If it can't be unlocked the partition is not mounted.
+func (r *ResetAction) unlockPersistent(cleanup *utils.CleanStack) {
+	persistent := r.spec.Partitions.Persistent
+	enc := &types.VolumeEncryption{}
+	if r.spec.State != nil && r.spec.State.Partitions[constants.PersistentPartName] != nil &&
+		r.spec.State.Partitions[constants.PersistentPartName].Encryption != nil {
+		enc = r.spec.State.Partitions[constants.PersistentPartName].Encryption
+	}
+
+	dev, err := elemental.UnlockDevice(r.cfg.Config, persistent.Path, persistent.Name, enc)
+	if err != nil {
+		r.cfg.Logger.Warnf("could not unlock the persistent partition, it will not be mounted: %v", err)
+		persistent.MountPoint = ""
+		return
+	}
+	persistent.Path = dev
+	cleanup.Push(func() error { return elemental.CloseEncryptedPartition(r.cfg.Config, persistent) })
+}
+
 // ResetRun will reset the cos system to by following several steps
 func (r ResetAction) Run() (err error) {
 	cleanup := utils.NewCleanStack()
@@ -201,14 +243,26 @@ func (r ResetAction) Run() (err error) {
 	}
 
 	// Reformat persistent partition
-	if r.spec.FormatPersistent {
-		persistent := r.spec.Partitions.Persistent
-		if persistent != nil {
-			err = elemental.FormatPartition(r.cfg.Config, persistent)
+	persistent := r.spec.Partitions.Persistent
+	if r.spec.FormatPersistent && persistent != nil {
+		if persistent.Encryption != nil {
+			// Setting up a new LUKS volume re-keys the persistent partition
+			err = elemental.CloseEncryptedPartition(r.cfg.Config, persistent)
 			if err != nil {
-				return elementalError.NewFromError(err, elementalError.FormatPartitions)
+				return elementalError.NewFromError(err, elementalError.EncryptPartition)
 			}
+			err = elemental.EncryptPartition(r.cfg.Config, persistent)
+			if err != nil {
+				return elementalError.NewFromError(err, elementalError.EncryptPartition)
+			}
+			cleanup.Push(func() error { return elemental.CloseEncryptedPartition(r.cfg.Config, persistent) })
 		}
+		err = elemental.FormatPartition(r.cfg.Config, persistent)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.FormatPartitions)
+		}
+	} else if persistent != nil && persistent.Encryption != nil {
+		r.unlockPersistent(cleanup)
 	}
 
 	// Reformat OEM
@@ -284,6 +338,13 @@ func (r ResetAction) Run() (err error) {
 		return elementalError.NewFromError(err, elementalError.CreateFile)
 	}
 
+	// Replicate the updated EFI partition to all mirrored disks
+	err = syncBootMirrors(&r.cfg.Config, r.spec.Partitions.Boot, r.spec.State)
+	if err != nil {
+		r.cfg.Logger.Errorf("failed mirroring EFI partition: %v", err)
+		return err
+	}
+
 	// Do not reboot/poweroff on cleanup errors
 	err = cleanup.Cleanup(err)
 	if err != nil {
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/jaypipes/ghw/pkg/block"
 	. "github.com/onsi/ginkgo/v2"
@@ -191,6 +192,44 @@ var _ = Describe("Reset action tests", func() {
 		It("Successfully resets from a channel package", Label("channel"), func() {
 			Expect(reset.Run()).To(BeNil())
 		})
+		It("Successfully resets and syncs the EFI partition mirrors", Label("mirror"), func() {
+			mirror := "/dev/disk/by-partuuid/efi-mirror"
+			spec.State = &types.InstallState{
+				Partitions: map[string]*types.PartitionState{
+					constants.BootPartName: {
+						FSLabel: constants.BootLabel,
+						Mirrors: []string{mirror},
+					},
+				},
+			}
+			Expect(reset.Run()).To(BeNil())
+			Expect(memLog).To(ContainSubstring("Mirroring EFI partition to " + mirror))
+
+			// Mirrors are kept in the new state yaml file
+			state, err := config.LoadInstallStateFile(
+				filepath.Join(spec.Partitions.State.MountPoint, constants.InstallStateFile),
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(state.Partitions[constants.BootPartName].Mirrors).To(Equal([]string{mirror}))
+		})
+		It("Fails syncing the EFI partition mirrors", Label("mirror"), func() {
+			spec.State = &types.InstallState{
+				Partitions: map[string]*types.PartitionState{
+					constants.BootPartName: {Mirrors: []string{"/dev/disk/by-partuuid/efi-mirror"}},
+				},
+			}
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "rsync" && strings.Contains(args[len(args)-1], "elemental-efi-mirror") {
+					return []byte{}, fmt.Errorf("rsync failed")
+				}
+				if cmd == "cat" {
+					return []byte(bootedFrom), nil
+				}
+				return []byte{}, nil
+			}
+			Expect(reset.Run()).NotTo(BeNil())
+			Expect(memLog).To(ContainSubstring("failed mirroring EFI partition"))
+		})
 		It("Fails setting the persistent grub variables", func() {
 			bootloader.ErrorSetPersistentVariables = true
 			err = reset.Run()
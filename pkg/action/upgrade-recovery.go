@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/rancher/elemental-toolkit/v2/pkg/bootloader"
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
 	elementalError "github.com/rancher/elemental-toolkit/v2/pkg/error"
@@ -35,6 +36,7 @@ var ErrUpgradeRecoveryFromRecovery = errors.New("can not upgrade recovery from r
 type UpgradeRecoveryAction struct {
 	cfg                *types.RunConfig
 	spec               *types.UpgradeSpec
+	bootloader         types.Bootloader
 	updateInstallState bool
 }
 
@@ -47,6 +49,13 @@ func WithUpdateInstallState(updateInstallState bool) func(u *UpgradeRecoveryActi
 	}
 }
 
+func WithUpgradeRecoveryBootloader(bootloader types.Bootloader) func(u *UpgradeRecoveryAction) error {
+	return func(u *UpgradeRecoveryAction) error {
+		u.bootloader = bootloader
+		return nil
+	}
+}
+
 func NewUpgradeRecoveryAction(config *types.RunConfig, spec *types.UpgradeSpec, opts ...UpgradeRecoveryActionOption) (*UpgradeRecoveryAction, error) {
 	var err error
 
@@ -69,6 +78,10 @@ func NewUpgradeRecoveryAction(config *types.RunConfig, spec *types.UpgradeSpec,
 		return nil, fmt.Errorf("undefined recovery partition")
 	}
 
+	if u.bootloader == nil {
+		u.bootloader = bootloader.NewGrub(&config.Config, bootloader.WithGrubDisableBootEntry(true))
+	}
+
 	if u.updateInstallState {
 		if u.spec.Partitions.State == nil {
 			return nil, fmt.Errorf("undefined state partition")
@@ -107,9 +120,29 @@ func (u *UpgradeRecoveryAction) mountRWPartitions(cleanup *utils.CleanStack) err
 	}
 	cleanup.Push(umount)
 
+	if u.spec.Partitions.Boot != nil {
+		umount, err = elemental.MountRWPartition(u.cfg.Config, u.spec.Partitions.Boot)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.MountBootPartition)
+		}
+		cleanup.Push(umount)
+	}
+
 	return nil
 }
 
+// currentRecoveryRootHash returns the dm-verity root hash of the installed recovery image
+func (u *UpgradeRecoveryAction) currentRecoveryRootHash() string {
+	if u.spec.State == nil {
+		return ""
+	}
+	recoveryPart := u.spec.State.Partitions[constants.RecoveryPartName]
+	if recoveryPart == nil || recoveryPart.RecoveryImage == nil {
+		return ""
+	}
+	return recoveryPart.RecoveryImage.RootHash
+}
+
 func (u *UpgradeRecoveryAction) upgradeInstallStateYaml() error {
 	u.spec.State.Date = time.Now().Format(time.RFC3339)
 
@@ -125,6 +158,7 @@ func (u *UpgradeRecoveryAction) upgradeInstallStateYaml() error {
 				Labels:     u.spec.SnapshotLabels,
 				Date:       u.spec.State.Date,
 				FromAction: constants.ActionUpgradeRecovery,
+				RootHash:   u.spec.RecoverySystem.RootHash,
 			},
 		}
 		u.spec.State.Partitions[constants.RecoveryPartName] = recoveryPart
@@ -132,6 +166,7 @@ func (u *UpgradeRecoveryAction) upgradeInstallStateYaml() error {
 		recoveryPart.RecoveryImage.Date = u.spec.State.Date
 		recoveryPart.RecoveryImage.Labels = u.spec.SnapshotLabels
 		recoveryPart.RecoveryImage.FromAction = constants.ActionUpgradeRecovery
+		recoveryPart.RecoveryImage.RootHash = u.spec.RecoverySystem.RootHash
 	}
 
 	// State partition is mounted in three different locations.
@@ -173,6 +208,17 @@ func (u *UpgradeRecoveryAction) Run() (err error) {
 		return elementalError.NewFromError(err, elementalError.DeployImage)
 	}
 
+	// Accept both recovery images at boot until the new one is in place
+	if u.spec.Partitions.Boot != nil {
+		err = setRecoveryRootHash(
+			&u.cfg.Config, u.bootloader, u.spec.Partitions.Boot.MountPoint,
+			u.spec.RecoverySystem.RootHash, u.currentRecoveryRootHash(),
+		)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.SetGrubVariables)
+		}
+	}
+
 	// Switch places on /boot and transition-dir
 	bootDir := filepath.Join(u.spec.Partitions.Recovery.MountPoint, constants.BootPath)
 	oldBootDir := filepath.Join(u.spec.Partitions.Recovery.MountPoint, constants.OldBootPath)
@@ -215,13 +261,27 @@ func (u *UpgradeRecoveryAction) Run() (err error) {
 		u.Warnf("failed removing old recovery image: %s", err.Error())
 	}
 
-	// Update state.yaml file on recovery and state partitions
+	if u.spec.Partitions.Boot != nil {
+		err = setRecoveryRootHash(&u.cfg.Config, u.bootloader, u.spec.Partitions.Boot.MountPoint, u.spec.RecoverySystem.RootHash)
+		if err != nil {
+			return elementalError.NewFromError(err, elementalError.SetGrubVariables)
+		}
+	}
+
+	// Update state.yaml file on recovery and state partitions, otherwise the calling action
+	// is responsible of it and of replicating the EFI partition
 	if u.updateInstallState {
 		err = u.upgradeInstallStateYaml()
 		if err != nil {
 			u.Errorf("failed upgrading installation metadata: %s", err.Error())
 			return err
 		}
+
+		err = syncBootMirrors(&u.cfg.Config, u.spec.Partitions.Boot, u.spec.State)
+		if err != nil {
+			u.Errorf("failed mirroring EFI partition: %s", err.Error())
+			return err
+		}
 	}
 
 	u.Infof("Recovery upgrade completed")
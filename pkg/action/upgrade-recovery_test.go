@@ -214,6 +214,44 @@ var _ = Describe("Upgrade Recovery Actions", func() {
 				Expect(spec.State.Partitions["recovery"].RecoveryImage.FromAction).To(Equal(constants.ActionUpgradeRecovery))
 				Expect(spec.State.Partitions["recovery"].RecoveryImage.Labels["foo"]).To(Equal("bar"))
 			})
+			It("Accepts both recovery root hashes until the new image is in place", Label("verity"), func() {
+				spec := PrepareTestRecoveryImage(config, constants.LiveDir, fs, runner)
+				spec.State.Partitions[constants.RecoveryPartName].RecoveryImage.RootHash = "0ac"
+				spec.RecoverySystem.Verity = true
+				sideEffect := runner.SideEffect
+				runner.SideEffect = func(command string, args ...string) ([]byte, error) {
+					if command == "veritysetup" {
+						return []byte("Root hash:      \t4f1d\n"), nil
+					}
+					return sideEffect(command, args...)
+				}
+				bootloader := &mocks.FakeBootloader{}
+
+				upgradeRecovery, err = action.NewUpgradeRecoveryAction(
+					config, spec, action.WithUpdateInstallState(true), action.WithUpgradeRecoveryBootloader(bootloader),
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(upgradeRecovery.Run()).To(Succeed())
+				Expect(bootloader.Variables).To(Equal([]map[string]string{
+					{constants.GrubRecoveryVerity: "4f1d,0ac"},
+					{constants.GrubRecoveryVerity: "4f1d"},
+				}))
+			})
+			It("Keeps the current recovery image if its root hash can't be updated", Label("verity"), func() {
+				recoveryImgPath := filepath.Join(constants.LiveDir, constants.BootPath, constants.RecoveryImgFile)
+				spec := PrepareTestRecoveryImage(config, constants.LiveDir, fs, runner)
+				bootloader := &mocks.FakeBootloader{ErrorSetPersistentVariables: true}
+
+				upgradeRecovery, err = action.NewUpgradeRecoveryAction(
+					config, spec, action.WithUpdateInstallState(true), action.WithUpgradeRecoveryBootloader(bootloader),
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(upgradeRecovery.Run()).NotTo(Succeed())
+
+				f, err := fs.ReadFile(recoveryImgPath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f).To(ContainSubstring("recovery"))
+			})
 			It("Successfully skips updateInstallState", Label("docker"), func() {
 				recoveryImgPath := filepath.Join(constants.LiveDir, constants.BootPath, constants.RecoveryImgFile)
 				spec := PrepareTestRecoveryImage(config, constants.LiveDir, fs, runner)
@@ -183,6 +183,7 @@ func (u *UpgradeAction) upgradeInstallStateYaml() error {
 		Labels:     u.spec.SnapshotLabels,
 		Date:       u.spec.State.Date,
 		FromAction: constants.ActionUpgrade,
+		RootHash:   u.snapshot.RootHash,
 	}
 
 	if statePart.Snapshots[oldActiveID] != nil {
@@ -208,6 +209,7 @@ func (u *UpgradeAction) upgradeInstallStateYaml() error {
 					Labels:     u.spec.SnapshotLabels,
 					Date:       u.spec.State.Date,
 					FromAction: constants.ActionUpgrade,
+					RootHash:   u.spec.RecoverySystem.RootHash,
 				},
 			}
 			u.spec.State.Partitions[constants.RecoveryPartName] = recoveryPart
@@ -215,6 +217,7 @@ func (u *UpgradeAction) upgradeInstallStateYaml() error {
 			recoveryPart.RecoveryImage.Date = u.spec.State.Date
 			recoveryPart.RecoveryImage.Labels = u.spec.SnapshotLabels
 			recoveryPart.RecoveryImage.FromAction = constants.ActionUpgrade
+			recoveryPart.RecoveryImage.RootHash = u.spec.RecoverySystem.RootHash
 		}
 	}
 
@@ -326,7 +329,9 @@ func (u *UpgradeAction) Run() (err error) {
 			}
 			recoverySystem.Source.SetDigest(u.spec.System.GetDigest())
 		}
-		upgradeRecoveryAction, err := NewUpgradeRecoveryAction(u.cfg, u.spec, WithUpdateInstallState(false))
+		upgradeRecoveryAction, err := NewUpgradeRecoveryAction(
+			u.cfg, u.spec, WithUpdateInstallState(false), WithUpgradeRecoveryBootloader(u.bootloader),
+		)
 		if err != nil {
 			u.Error("Could not initialize Recovery upgrade: %s", err)
 			return elementalError.NewFromError(err, elementalError.UpgradeRecovery)
@@ -350,6 +355,13 @@ func (u *UpgradeAction) Run() (err error) {
 		return err
 	}
 
+	// Replicate the updated EFI partition to all mirrored disks
+	err = syncBootMirrors(&u.cfg.Config, u.spec.Partitions.Boot, u.spec.State)
+	if err != nil {
+		u.Error("failed mirroring EFI partition")
+		return err
+	}
+
 	u.Info("Upgrade completed")
 
 	// Do not reboot/poweroff on cleanup errors
@@ -262,6 +262,44 @@ var _ = Describe("Runtime Actions", func() {
 				Expect(state.Partitions[constants.StatePartName].Snapshots[1]).
 					To(BeNil())
 			})
+			It("Successfully upgrades and syncs the EFI partition mirrors", Label("mirror"), func() {
+				Expect(mocks.FakeLoopDeviceSnapshotsStatus(fs, constants.RunningStateDir, 1)).To(Succeed())
+				mirror := "/dev/disk/by-partuuid/efi-mirror"
+				statePath := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
+				installState := &types.InstallState{
+					Partitions: map[string]*types.PartitionState{
+						constants.BootPartName: {
+							FSLabel: constants.BootLabel,
+							Mirrors: []string{mirror},
+						},
+					},
+				}
+				Expect(config.WriteInstallState(installState, statePath, statePath)).To(Succeed())
+
+				// Create a new spec to load state yaml
+				spec, err = conf.NewUpgradeSpec(config.Config)
+				Expect(err).NotTo(HaveOccurred())
+				spec.System = types.NewDockerSrc("alpine")
+				upgrade, err = action.NewUpgradeAction(config, spec)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(upgrade.Run()).To(Succeed())
+
+				// The EFI partition is synced to the mirror and unmounted afterwards
+				Expect(memLog).To(ContainSubstring("Mirroring EFI partition to " + mirror))
+				Expect(runner.MatchMilestones([][]string{
+					{"grub2-editenv", filepath.Join(spec.Partitions.Boot.MountPoint, constants.GrubOEMEnv)},
+					{"rsync"},
+				})).To(Succeed())
+				lst, _ := mounter.List()
+				for _, m := range lst {
+					Expect(m.Device).NotTo(Equal(mirror))
+				}
+
+				// Mirrors are kept in the upgraded state yaml file
+				state, err := config.LoadInstallState()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(state.Partitions[constants.BootPartName].Mirrors).To(Equal([]string{mirror}))
+			})
 			It("Successfully reboots after upgrade from docker image", func() {
 				Expect(mocks.FakeLoopDeviceSnapshotsStatus(fs, constants.RunningStateDir, 1)).To(Succeed())
 				spec.System = types.NewDockerSrc("alpine")
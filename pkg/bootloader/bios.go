@@ -0,0 +1,244 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootloader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+const (
+	biosSectorSize = 512
+	// Offsets of the grub boot.img fields, see grub's include/grub/i386/pc/boot.h
+	bootBPBStart     = 0x03
+	bootBPBEnd       = 0x5a
+	bootKernelSector = 0x5c
+	bootDriveCheck   = 0x66
+	bootCodeEnd      = 440
+	// Offset of the first blocklist in the first sector of core.img (diskboot.img)
+	coreBlocklist = biosSectorSize - 12
+	coreSegment   = 0x820
+	mbrPartTable  = 446
+	mbrSignature  = 0xAA55
+	loadCfgFile   = "load.cfg"
+)
+
+// biosCoreModules are the modules embedded in the core image to find and read the boot partition
+var biosCoreModules = []string{"biosdisk", "part_gpt", "part_msdos", "fat", "search"}
+
+// installBIOSModules copies the grub i386-pc modules directory of the OS image in rootDir to
+// the grub BIOS prefix of the given directory. Returns the path of the copied modules.
+func (g *Grub) installBIOSModules(rootDir, dstDir string) (string, error) {
+	var patterns []string
+	for _, pattern := range constants.GetGrubBIOSModulesPatterns() {
+		patterns = append(patterns, filepath.Join(pattern, constants.GrubBIOSBootImg))
+	}
+	bootImg, err := utils.FindFile(g.fs, rootDir, patterns...)
+	if err != nil {
+		g.logger.Errorf("failed to find grub %s modules", constants.GrubBIOSTarget)
+		return "", err
+	}
+
+	modDir := filepath.Join(dstDir, constants.GrubBIOSPath, constants.GrubBIOSTarget)
+	err = utils.MkdirAll(g.fs, modDir, constants.DirPerm)
+	if err != nil {
+		return "", fmt.Errorf("error creating destination folder: %v", err)
+	}
+
+	files, err := g.fs.ReadDir(filepath.Dir(bootImg))
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		src := filepath.Join(filepath.Dir(bootImg), file.Name())
+		g.logger.Debugf("Copying %s to %s", src, modDir)
+		err = utils.CopyFile(g.fs, src, filepath.Join(modDir, file.Name()))
+		if err != nil {
+			return "", fmt.Errorf("error copying %s to %s: %s", src, modDir, err.Error())
+		}
+	}
+	return modDir, nil
+}
+
+// mkimage runs grub2-mkimage, or grub-mkimage if not found, with the given arguments
+func (g *Grub) mkimage(args ...string) error {
+	cmd := "grub2-mkimage"
+	if !g.runner.CommandExists(cmd) {
+		cmd = "grub-mkimage"
+	}
+	out, err := g.runner.Run(cmd, args...)
+	if err != nil {
+		g.logger.Errorf("Failed creating grub image: %s", string(out))
+		return err
+	}
+	return nil
+}
+
+// InstallBIOS installs the grub modules, configuration and core image for legacy BIOS
+// into bootDir. rootDir is the root of the OS image and bootLabel the filesystem label
+// of the partition mounted at bootDir, which is where the core image looks for its prefix.
+func (g *Grub) InstallBIOS(rootDir, bootDir, bootLabel string) error {
+	modDir, err := g.installBIOSModules(rootDir, bootDir)
+	if err != nil {
+		return err
+	}
+
+	// Installed BIOS modules make the grub configuration to be also copied to the BIOS prefix
+	err = g.InstallConfig(rootDir, bootDir)
+	if err != nil {
+		return err
+	}
+
+	loadCfg := filepath.Join(modDir, loadCfgFile)
+	early := fmt.Sprintf(
+		"search --no-floppy --label --set=root %s\nset prefix=($root)%s\n", bootLabel, constants.GrubBIOSPath,
+	)
+	err = g.fs.WriteFile(loadCfg, []byte(early), constants.FilePerm)
+	if err != nil {
+		return fmt.Errorf("error writing grub early config: %s", err.Error())
+	}
+
+	g.logger.Infof("Creating grub %s core image", constants.GrubBIOSTarget)
+	args := []string{
+		"-O", constants.GrubBIOSTarget, "-d", modDir, "-p", constants.GrubBIOSPath, "-c", loadCfg,
+		"-o", filepath.Join(modDir, constants.GrubBIOSCoreImg),
+	}
+	return g.mkimage(append(args, biosCoreModules...)...)
+}
+
+// InstallBIOSEltorito installs the grub modules and El Torito boot image for legacy BIOS into
+// the given ISO root. The grub configuration is expected to be at the BIOS prefix of the ISO.
+func (g *Grub) InstallBIOSEltorito(rootDir, isoDir string) error {
+	modDir, err := g.installBIOSModules(rootDir, isoDir)
+	if err != nil {
+		return err
+	}
+
+	g.logger.Infof("Creating grub %s El Torito image", constants.GrubBIOSTarget)
+	return g.mkimage(
+		"-O", constants.GrubBIOSTarget+"-eltorito", "-d", modDir, "-p", constants.GrubBIOSPath,
+		"-o", filepath.Join(modDir, constants.GrubBIOSEltoritoImg), "biosdisk", "iso9660",
+	)
+}
+
+// SetupBIOS writes the grub boot image into the MBR of the given disk and embeds the core image
+// created by InstallBIOS in bootDir. The core image is embedded in the BIOS boot partition of GPT
+// disks or in the gap between the MBR and the first partition of MSDOS disks.
+func (g *Grub) SetupBIOS(bootDir, disk string) error {
+	modDir := filepath.Join(bootDir, constants.GrubBIOSPath, constants.GrubBIOSTarget)
+	bootImg, err := g.fs.ReadFile(filepath.Join(modDir, constants.GrubBIOSBootImg))
+	if err != nil {
+		return err
+	}
+	if len(bootImg) != biosSectorSize {
+		return fmt.Errorf("invalid grub boot image size: %d bytes", len(bootImg))
+	}
+	coreImg, err := g.fs.ReadFile(filepath.Join(modDir, constants.GrubBIOSCoreImg))
+	if err != nil {
+		return err
+	}
+	if len(coreImg) < biosSectorSize {
+		return fmt.Errorf("invalid grub core image size: %d bytes", len(coreImg))
+	}
+
+	f, err := g.fs.OpenFile(disk, os.O_RDWR, constants.FilePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mbr := make([]byte, biosSectorSize)
+	_, err = f.ReadAt(mbr, 0)
+	if err != nil {
+		return fmt.Errorf("failed reading the MBR of %s: %s", disk, err.Error())
+	}
+
+	embedStart, embedSectors, err := biosEmbedArea(f, mbr)
+	if err != nil {
+		return fmt.Errorf("can't embed grub core image in %s: %s", disk, err.Error())
+	}
+	coreSectors := uint64((len(coreImg) + biosSectorSize - 1) / biosSectorSize)
+	if coreSectors > embedSectors {
+		return fmt.Errorf(
+			"grub core image of %d sectors does not fit in the %d sectors available in %s",
+			coreSectors, embedSectors, disk,
+		)
+	}
+
+	g.logger.Infof("Embedding grub core image at sector %d of %s", embedStart, disk)
+	core := make([]byte, coreSectors*biosSectorSize)
+	copy(core, coreImg)
+	binary.LittleEndian.PutUint64(core[coreBlocklist:], embedStart+1)
+	binary.LittleEndian.PutUint16(core[coreBlocklist+8:], uint16(coreSectors-1))
+	binary.LittleEndian.PutUint16(core[coreBlocklist+10:], coreSegment)
+	_, err = f.WriteAt(core, int64(embedStart*biosSectorSize))
+	if err != nil {
+		return fmt.Errorf("failed writing grub core image: %s", err.Error())
+	}
+
+	// Keep any BPB of the current MBR and hard disks workaround of the BIOS drive check,
+	// the partition table and the boot signature are not modified
+	boot := make([]byte, biosSectorSize)
+	copy(boot, bootImg)
+	copy(boot[bootBPBStart:bootBPBEnd], mbr[bootBPBStart:bootBPBEnd])
+	binary.LittleEndian.PutUint64(boot[bootKernelSector:], embedStart)
+	boot[bootDriveCheck] = 0x90
+	boot[bootDriveCheck+1] = 0x90
+	_, err = f.WriteAt(boot[:bootCodeEnd], 0)
+	if err != nil {
+		return fmt.Errorf("failed writing grub boot image: %s", err.Error())
+	}
+	return f.Sync()
+}
+
+// biosEmbedArea returns the first sector and the number of sectors available to embed the grub core image
+func biosEmbedArea(f *os.File, mbr []byte) (uint64, uint64, error) {
+	if binary.LittleEndian.Uint16(mbr[510:]) != mbrSignature {
+		return 0, 0, fmt.Errorf("no partition table found")
+	}
+
+	if _, entries, err := partitioner.ReadGPT(f, biosSectorSize); err == nil {
+		for i, entry := range entries {
+			if entry.IsUsed() && entry.ToPartition(i+1).TypeGUID == partitioner.GPTBIOSBootType {
+				return entry.StartingLBA, entry.EndingLBA - entry.StartingLBA + 1, nil
+			}
+		}
+		return 0, 0, fmt.Errorf("no BIOS boot partition found")
+	}
+
+	var firstStart uint64
+	for i := 0; i < 4; i++ {
+		entry := mbr[mbrPartTable+i*16 : mbrPartTable+(i+1)*16]
+		start := uint64(binary.LittleEndian.Uint32(entry[8:]))
+		if entry[4] != 0 && start > 0 && (firstStart == 0 || start < firstStart) {
+			firstStart = start
+		}
+	}
+	if firstStart <= 1 {
+		return 0, 0, fmt.Errorf("no partitions found")
+	}
+	return 1, firstStart - 1, nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootloader_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/bootloader"
+	"github.com/rancher/elemental-toolkit/v2/pkg/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+var _ = Describe("BIOS bootloader", Label("bootloader", "grub", "bios"), func() {
+	var fs vfs.FS
+	var runner *mocks.FakeRunner
+	var cleanup func()
+	var grub *bootloader.Grub
+	var rootDir, bootDir, modDir string
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{})
+		Expect(err).Should(BeNil())
+		runner = mocks.NewFakeRunner()
+
+		rootDir = "/some/working/directory"
+		bootDir = "/some/boot/directory"
+		modDir = filepath.Join(bootDir, constants.GrubBIOSPath, constants.GrubBIOSTarget)
+		Expect(utils.MkdirAll(fs, bootDir, constants.DirPerm)).To(Succeed())
+
+		// Grub i386-pc modules
+		Expect(utils.MkdirAll(fs, filepath.Join(rootDir, "/usr/share/grub2/i386-pc"), constants.DirPerm)).To(Succeed())
+		Expect(fs.WriteFile(filepath.Join(rootDir, "/usr/share/grub2/i386-pc/boot.img"), []byte("boot"), constants.FilePerm)).To(Succeed())
+		Expect(fs.WriteFile(filepath.Join(rootDir, "/usr/share/grub2/i386-pc/normal.mod"), []byte(""), constants.FilePerm)).To(Succeed())
+
+		// Grub config file
+		Expect(utils.MkdirAll(fs, filepath.Join(rootDir, constants.GrubCfgPath), constants.DirPerm)).To(Succeed())
+		Expect(fs.WriteFile(filepath.Join(rootDir, constants.GrubCfgPath, constants.GrubCfg), []byte("grub configuration"), constants.FilePerm)).To(Succeed())
+
+		cfg := config.NewConfig(
+			config.WithLogger(types.NewNullLogger()),
+			config.WithRunner(runner),
+			config.WithFs(fs),
+			config.WithPlatform("linux/amd64"),
+		)
+		grub = bootloader.NewGrub(cfg)
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("installs grub modules, configuration and core image", func() {
+		Expect(grub.InstallBIOS(rootDir, bootDir, constants.BootLabel)).To(Succeed())
+
+		Expect(fs.ReadFile(filepath.Join(modDir, "boot.img"))).To(Equal([]byte("boot")))
+		Expect(utils.Exists(fs, filepath.Join(modDir, "normal.mod"))).To(BeTrue())
+		Expect(fs.ReadFile(filepath.Join(bootDir, "grub2/grub.cfg"))).To(Equal([]byte("grub configuration")))
+		loadCfg, err := fs.ReadFile(filepath.Join(modDir, "load.cfg"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(loadCfg)).To(ContainSubstring("--set=root COS_GRUB"))
+		Expect(string(loadCfg)).To(ContainSubstring("set prefix=($root)/grub2"))
+
+		Expect(runner.CmdsMatch([][]string{{
+			"grub2-mkimage", "-O", "i386-pc", "-d", modDir, "-p", "/grub2", "-c", filepath.Join(modDir, "load.cfg"),
+			"-o", filepath.Join(modDir, "core.img"), "biosdisk", "part_gpt", "part_msdos", "fat", "search",
+		}})).To(Succeed())
+	})
+
+	It("uses grub-mkimage if grub2-mkimage is not found", func() {
+		runner.CmdNotFound = "grub2-mkimage"
+		Expect(grub.InstallBIOSEltorito(rootDir, bootDir)).To(Succeed())
+		Expect(runner.CmdsMatch([][]string{{
+			"grub-mkimage", "-O", "i386-pc-eltorito", "-d", modDir, "-p", "/grub2",
+			"-o", filepath.Join(modDir, "eltorito.img"), "biosdisk", "iso9660",
+		}})).To(Succeed())
+	})
+
+	It("fails to install if the i386-pc modules are not found", func() {
+		Expect(fs.RemoveAll(filepath.Join(rootDir, "/usr/share/grub2/i386-pc"))).To(Succeed())
+		Expect(grub.InstallBIOS(rootDir, bootDir, constants.BootLabel)).NotTo(Succeed())
+		Expect(runner.GetCmds()).To(BeEmpty())
+	})
+
+	Describe("setting up a disk", func() {
+		var bootImg, coreImg []byte
+		const disk = "/disk.img"
+
+		BeforeEach(func() {
+			bootImg = bytes.Repeat([]byte{0xAB}, 512)
+			coreImg = bytes.Repeat([]byte{0xCD}, 3*512+100)
+			Expect(utils.MkdirAll(fs, modDir, constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(modDir, "boot.img"), bootImg, constants.FilePerm)).To(Succeed())
+			Expect(fs.WriteFile(filepath.Join(modDir, "core.img"), coreImg, constants.FilePerm)).To(Succeed())
+			Expect(fs.WriteFile(disk, []byte{}, constants.FilePerm)).To(Succeed())
+			Expect(fs.Truncate(disk, 16*1024*1024)).To(Succeed())
+		})
+
+		readDisk := func(offset, size int) []byte {
+			data, err := fs.ReadFile(disk)
+			Expect(err).ToNot(HaveOccurred())
+			return data[offset : offset+size]
+		}
+
+		checkEmbedded := func(embedStart uint64) {
+			mbr := readDisk(0, 512)
+			Expect(mbr[:3]).To(Equal(bootImg[:3]))
+			Expect(binary.LittleEndian.Uint64(mbr[0x5c:])).To(Equal(embedStart))
+			Expect(mbr[0x66:0x68]).To(Equal([]byte{0x90, 0x90}))
+			Expect(binary.LittleEndian.Uint16(mbr[510:])).To(Equal(uint16(0xAA55)))
+
+			core := readDisk(int(embedStart)*512, 512)
+			Expect(binary.LittleEndian.Uint64(core[0x1f4:])).To(Equal(embedStart + 1))
+			Expect(binary.LittleEndian.Uint16(core[0x1fc:])).To(Equal(uint16(3)))
+			Expect(binary.LittleEndian.Uint16(core[0x1fe:])).To(Equal(uint16(0x820)))
+			Expect(readDisk(int(embedStart+1)*512, 512)).To(Equal(coreImg[512:1024]))
+		}
+
+		It("embeds the core image in the BIOS boot partition of GPT disks", func() {
+			f, err := fs.OpenFile(disk, os.O_RDWR, constants.FilePerm)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(partitioner.WriteGPT(f, 512, []*partitioner.Partition{
+				{Number: 1, StartS: 2048, SizeS: 2048, TypeGUID: partitioner.GPTBIOSBootType},
+				{Number: 2, StartS: 4096, SizeS: 8192},
+			})).To(Succeed())
+			Expect(f.Close()).To(Succeed())
+
+			Expect(grub.SetupBIOS(bootDir, disk)).To(Succeed())
+			checkEmbedded(2048)
+
+			// The protective MBR partition is preserved
+			Expect(readDisk(446+4, 1)).To(Equal([]byte{partitioner.MBRProtectiveType}))
+		})
+
+		It("fails on GPT disks without BIOS boot partition", func() {
+			f, err := fs.OpenFile(disk, os.O_RDWR, constants.FilePerm)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(partitioner.WriteGPT(f, 512, []*partitioner.Partition{{Number: 1, StartS: 2048, SizeS: 2048}})).To(Succeed())
+			Expect(f.Close()).To(Succeed())
+
+			Expect(grub.SetupBIOS(bootDir, disk)).To(MatchError(ContainSubstring("no BIOS boot partition")))
+		})
+
+		It("embeds the core image after the MBR of MSDOS disks", func() {
+			mbr := make([]byte, 512)
+			mbr[0x0b] = 0x42
+			mbr[446+4] = 0x83
+			binary.LittleEndian.PutUint32(mbr[446+8:], 2048)
+			binary.LittleEndian.PutUint32(mbr[446+12:], 8192)
+			binary.LittleEndian.PutUint16(mbr[510:], 0xAA55)
+			f, err := fs.OpenFile(disk, os.O_RDWR, constants.FilePerm)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = f.WriteAt(mbr, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+
+			Expect(grub.SetupBIOS(bootDir, disk)).To(Succeed())
+			checkEmbedded(1)
+
+			// BPB and partition table are preserved
+			Expect(readDisk(0x0b, 1)).To(Equal([]byte{0x42}))
+			Expect(readDisk(446, 16)).To(Equal(mbr[446:462]))
+		})
+
+		It("fails if the core image does not fit before the first partition", func() {
+			mbr := make([]byte, 512)
+			mbr[446+4] = 0x83
+			binary.LittleEndian.PutUint32(mbr[446+8:], 3)
+			binary.LittleEndian.PutUint16(mbr[510:], 0xAA55)
+			Expect(fs.WriteFile(disk, mbr, constants.FilePerm)).To(Succeed())
+
+			Expect(grub.SetupBIOS(bootDir, disk)).To(MatchError(ContainSubstring("does not fit")))
+		})
+
+		It("fails on disks without partition table", func() {
+			Expect(grub.SetupBIOS(bootDir, disk)).To(MatchError(ContainSubstring("no partition table")))
+		})
+	})
+})
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"slices"
 
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
@@ -305,6 +306,22 @@ func (g *Grub) DoEFIEntries(shimName, efiDir string) error {
 	return g.CreateEntry(shimName, filepath.Join(efiDir, constants.EntryEFIPath), efivars)
 }
 
+// AddEFIEntry creates an additional boot entry for the EFI binaries of the given EFI partition, existing
+// entries are kept. Used for EFI partitions replicated over mirror disks. Requires a previous Install call.
+func (g *Grub) AddEFIEntry(efiDir string) error {
+	if g.disableBootEntry {
+		return nil
+	}
+	image := g.grubEfiImg
+	if g.secureBoot {
+		image = g.shimImg
+	}
+	if image == "" {
+		return fmt.Errorf("EFI images not found, bootloader is not installed")
+	}
+	return g.CreateEntry(filepath.Base(image), filepath.Join(efiDir, constants.EntryEFIPath), eleefi.RealEFIVariables{})
+}
+
 // clearEntry will go over the BootXXXX efi vars and remove any that matches our name
 // Used in install as we re-create the partitions, so the UUID of those partitions is no longer valid for the old entry
 // And we don't want to leave a broken entry around
@@ -436,7 +453,14 @@ func (g *Grub) Install(rootDir, bootDir string) (err error) {
 // rootDir is the root of the OS image, bootDir is the folder grub read the
 // configuration from, usually EFI partition mountpoint
 func (g Grub) InstallConfig(rootDir, bootDir string) error {
-	for _, path := range g.grubPrefixes {
+	prefixes := append([]string{}, g.grubPrefixes...)
+	// Keep the legacy BIOS configuration up to date if grub is also installed for BIOS
+	biosModules := filepath.Join(bootDir, constants.GrubBIOSPath, constants.GrubBIOSTarget)
+	if ok, _ := utils.Exists(g.fs, biosModules); ok && !slices.Contains(prefixes, constants.GrubBIOSPath) {
+		prefixes = append(prefixes, constants.GrubBIOSPath)
+	}
+
+	for _, path := range prefixes {
 		grubFile := filepath.Join(rootDir, g.elementalCfg)
 		if exists, _ := utils.Exists(g.fs, grubFile); !exists {
 			grubFile = filepath.Join(rootDir, g.legacyElementalCfg)
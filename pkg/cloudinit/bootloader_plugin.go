@@ -0,0 +1,124 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/rancher/yip/pkg/logger"
+	"github.com/rancher/yip/pkg/plugins"
+	"github.com/rancher/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v4"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+)
+
+// kernelArgsVars maps the 'kernel_args' keys to the GRUB variables appended to the kernel command line
+var kernelArgsVars = map[string]string{
+	"all":      "extra_cmdline",
+	"active":   "extra_active_cmdline",
+	"passive":  "extra_passive_cmdline",
+	"recovery": "extra_recovery_cmdline",
+}
+
+// defaultEntry is the boot entry to use by default or only on next boot
+type defaultEntry struct {
+	Entry    string `yaml:"entry,omitempty"`
+	Snapshot int    `yaml:"snapshot,omitempty"`
+	Once     bool   `yaml:"once,omitempty"`
+}
+
+// menuEntry returns the GRUB menu entry ID of the default entry
+func (d defaultEntry) menuEntry() (string, error) {
+	switch d.Entry {
+	case constants.ActiveImgName, constants.RecoveryImgName:
+		if d.Snapshot != 0 {
+			return "", fmt.Errorf("snapshots can only be set for the passive entry")
+		}
+		return d.Entry, nil
+	case constants.PassiveImgName:
+		if d.Snapshot <= 0 {
+			return "", fmt.Errorf("the passive entry requires a snapshot ID")
+		}
+		return fmt.Sprintf("%s%d", d.Entry, d.Snapshot), nil
+	default:
+		return "", fmt.Errorf("invalid default entry '%s', it must be active, passive or recovery", d.Entry)
+	}
+}
+
+// grubEnvFile returns the GRUB environment file loaded at boot from the OEM partition
+func grubEnvFile() string {
+	return filepath.Join(constants.OEMPath, constants.GrubEnv)
+}
+
+// kernelArgsPlugin persistently sets the extra kernel command line arguments of the
+// stage 'kernel_args' map in the GRUB environment
+func kernelArgsPlugin(l logger.Interface, s schema.Stage, _ vfs.FS, console plugins.Console) error {
+	elemConsole, ok := console.(*cloudInitConsole)
+	if !ok {
+		return errors.New("provided console is not an instance of 'cloudInitConsole' type")
+	}
+	args := elemConsole.getExtension(s).KernelArgs
+	if len(args) == 0 {
+		return nil
+	}
+
+	vars := map[string]string{}
+	for key, value := range args {
+		grubVar, ok := kernelArgsVars[key]
+		if !ok {
+			return fmt.Errorf("invalid kernel_args key '%s', it must be all, active, passive or recovery", key)
+		}
+		vars[grubVar] = value
+	}
+	if elemConsole.bootloader == nil {
+		return errors.New("no bootloader available to set kernel arguments")
+	}
+
+	l.Infof("Setting kernel arguments in %s", grubEnvFile())
+	return elemConsole.bootloader.SetPersistentVariables(grubEnvFile(), vars)
+}
+
+// defaultEntryPlugin sets the boot entry of the stage 'default_entry' as the GRUB default
+// entry or as the entry for the next boot only
+func defaultEntryPlugin(l logger.Interface, s schema.Stage, _ vfs.FS, console plugins.Console) error {
+	elemConsole, ok := console.(*cloudInitConsole)
+	if !ok {
+		return errors.New("provided console is not an instance of 'cloudInitConsole' type")
+	}
+	entry := elemConsole.getExtension(s).DefaultEntry
+	if entry == nil {
+		return nil
+	}
+
+	menuEntry, err := entry.menuEntry()
+	if err != nil {
+		return err
+	}
+	if elemConsole.bootloader == nil {
+		return errors.New("no bootloader available to set the default entry")
+	}
+
+	grubVar := "saved_entry"
+	if entry.Once {
+		grubVar = "next_entry"
+	}
+	l.Infof("Setting %s to %s in %s", grubVar, menuEntry, grubEnvFile())
+	return elemConsole.bootloader.SetPersistentVariables(grubEnvFile(), map[string]string{grubVar: menuEntry})
+}
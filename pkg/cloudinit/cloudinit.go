@@ -18,41 +18,62 @@ package cloudinit
 
 import (
 	"path/filepath"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/rancher/yip/pkg/executor"
 	"github.com/rancher/yip/pkg/plugins"
 	"github.com/rancher/yip/pkg/schema"
+	yipUtils "github.com/rancher/yip/pkg/utils"
 	"github.com/twpayne/go-vfs/v4"
 	"gopkg.in/yaml.v3"
 
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/http"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
 
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 )
 
 type YipCloudInitRunner struct {
-	exec    executor.Executor
-	fs      vfs.FS
-	console plugins.Console
+	exec         executor.Executor
+	fs           vfs.FS
+	console      *cloudInitConsole
+	plugins      []executor.Plugin
+	conditionals []executor.Plugin
+	tracer       *stageTracer
+}
+
+// YipCloudInitOptions configures the YipCloudInitRunner
+type YipCloudInitOptions func(y *YipCloudInitRunner) error
+
+// WithHTTPClient sets the HTTP client used by the fetch plugin
+func WithHTTPClient(client types.HTTPClient) YipCloudInitOptions {
+	return func(y *YipCloudInitRunner) error {
+		y.console.client = client
+		return nil
+	}
+}
+
+// WithBootloader sets the bootloader used by the kernel arguments and default entry plugins
+func WithBootloader(bootloader types.Bootloader) YipCloudInitOptions {
+	return func(y *YipCloudInitRunner) error {
+		y.console.bootloader = bootloader
+		return nil
+	}
 }
 
 // NewYipCloudInitRunner returns a default yip cloud init executor with the Elemental plugin set.
 // It accepts a logger which is used inside the runner.
-func NewYipCloudInitRunner(l types.Logger, r types.Runner, fs vfs.FS) *YipCloudInitRunner {
+func NewYipCloudInitRunner(l types.Logger, r types.Runner, fs vfs.FS, opts ...YipCloudInitOptions) *YipCloudInitRunner {
 	y := &YipCloudInitRunner{
 		fs: fs, console: newCloudInitConsole(l, r),
-	}
-	exec := executor.NewExecutor(
-		executor.WithConditionals(
-			plugins.NodeConditional,
-			plugins.IfConditional,
-		),
-		executor.WithLogger(l),
-		executor.WithPlugins(
+		plugins: []executor.Plugin{
 			// Note, the plugin execution order depends on the order passed here
+			extensionsPlugin,
 			plugins.DNS,
 			plugins.Download,
+			fetchPlugin,
 			plugins.Entities,
 			plugins.EnsureDirectories,
 			plugins.EnsureFiles,
@@ -68,18 +89,68 @@ func NewYipCloudInitRunner(l types.Logger, r types.Runner, fs vfs.FS) *YipCloudI
 			plugins.SystemdFirstboot,
 			plugins.DataSources,
 			layoutPlugin,
-		),
+			kernelArgsPlugin,
+			defaultEntryPlugin,
+		},
+		conditionals: conditionals(),
+	}
+	y.console.client = http.NewClient()
+	for _, o := range opts {
+		if err := o(y); err != nil {
+			l.Errorf("error applying config option: %s", err.Error())
+			return nil
+		}
+	}
+
+	exec := executor.NewExecutor(
+		executor.WithConditionals(y.conditionals...),
+		executor.WithLogger(l),
+		executor.WithPlugins(y.plugins...),
 	)
+	// Stage extensions are only known by elemental, they are read while loading the files
+	exec.Modifier(y.console.extensions.modifier(nil))
 	y.exec = exec
 	return y
 }
 
+// conditionals returns the yip conditionals deciding whether a step is run
+func conditionals() []executor.Plugin {
+	return []executor.Plugin{
+		plugins.NodeConditional,
+		plugins.IfConditional,
+	}
+}
+
 func (ci YipCloudInitRunner) Run(stage string, args ...string) error {
-	return ci.exec.Run(stage, ci.fs, ci.console, args...)
+	if ci.tracer == nil {
+		return ci.exec.Run(stage, ci.fs, ci.console, args...)
+	}
+
+	// Sources are run one by one, as yip does, so each of them is traced on its own
+	var errs error
+	var traces []StageTrace
+	for _, source := range args {
+		// Sources are classified as yip does, anything not being a file or a URL is inline content
+		name := source
+		if _, err := ci.fs.Stat(source); err != nil && !yipUtils.IsUrl(source) {
+			name = inlineSource
+		}
+		ci.tracer.begin(ci.fs, stage, source)
+		start := time.Now()
+		err := ci.exec.Run(stage, ci.fs, ci.console, source)
+		traces = append(traces, ci.tracer.end(stage, name, start, err))
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if err := ci.tracer.write(ci.fs, traces...); err != nil {
+		ci.console.logger.Warnf("Failed writing stage traces: %s", err.Error())
+	}
+	return errs
 }
 
 func (ci *YipCloudInitRunner) SetModifier(m schema.Modifier) {
-	ci.exec.Modifier(m)
+	ci.exec.Modifier(ci.console.extensions.modifier(m))
 }
 
 // Useful for testing purposes
@@ -87,6 +158,13 @@ func (ci *YipCloudInitRunner) SetFs(fs vfs.FS) {
 	ci.fs = fs
 }
 
+// SetTraceFile times each stage, file and step run and appends their traces to the given file
+func (ci *YipCloudInitRunner) SetTraceFile(path string) {
+	ci.tracer = newStageTracer(path)
+	ci.exec.Conditionals(ci.tracer.wrapAll(ci.conditionals, true))
+	ci.exec.Plugins(ci.tracer.wrapAll(ci.plugins, false))
+}
+
 func (ci *YipCloudInitRunner) CloudInitFileRender(target string, config *schema.YipConfig) error {
 	out, err := yaml.Marshal(config)
 	if err != nil {
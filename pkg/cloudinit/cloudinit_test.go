@@ -18,11 +18,16 @@ package cloudinit_test
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/jaypipes/ghw/pkg/block"
 	"github.com/rancher/yip/pkg/schema"
@@ -30,8 +35,11 @@ import (
 	"github.com/twpayne/go-vfs/v4"
 	"github.com/twpayne/go-vfs/v4/vfst"
 
+	"github.com/rancher/elemental-toolkit/v2/pkg/bootloader"
 	. "github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
+	"github.com/rancher/elemental-toolkit/v2/pkg/config"
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalhttp "github.com/rancher/elemental-toolkit/v2/pkg/http"
 	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
@@ -184,6 +192,7 @@ stages:
 			Expect(err).To(BeNil())
 
 			runner = mocks.NewFakeRunner()
+			cmdFail = ""
 
 			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
 				if cmd == cmdFail {
@@ -323,6 +332,198 @@ stages:
 			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
 			Expect(cloudRunner.Run("test", "/some/yip")).NotTo(BeNil())
 		})
+		It("Resizes a partition and grows its filesystem", func() {
+			partNum = 3
+			_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
+			Expect(err).To(BeNil())
+			err = afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Resizing partition
+    layout:
+      device:
+        path: %s
+      resize_partitions:
+      - fsLabel: DATA
+        filesystem: ext4
+`, device)), constants.FilePerm)
+			Expect(err).To(BeNil())
+			ghwTest := mocks.GhwMock{}
+			disk := block.Disk{Name: "device", Partitions: []*block.Partition{
+				{
+					Name:            fmt.Sprintf("device%d", partNum),
+					FilesystemLabel: "DATA",
+					Type:            "ext4",
+				},
+			}}
+			ghwTest.AddDisk(disk)
+			ghwTest.CreateDevices()
+			defer ghwTest.Clean()
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{
+				{"parted", "--script", "--machine", "--", device, "unit", "s", "resizepart", "3", "100%"},
+				{"resize2fs", fmt.Sprintf("%s%d", device, partNum)},
+			})).To(Succeed())
+		})
+		It("Does not resize an already expanded partition on later runs", func() {
+			partNum = 3
+			_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
+			Expect(err).To(BeNil())
+			err = afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Resizing partition
+    layout:
+      device:
+        path: %s
+      resize_partitions:
+      - fsLabel: DATA
+        filesystem: ext4
+`, device)), constants.FilePerm)
+			Expect(err).To(BeNil())
+			ghwTest := mocks.GhwMock{}
+			disk := block.Disk{Name: "device", Partitions: []*block.Partition{
+				{
+					Name:            fmt.Sprintf("device%d", partNum),
+					FilesystemLabel: "DATA",
+					Type:            "ext4",
+				},
+			}}
+			ghwTest.AddDisk(disk)
+			ghwTest.CreateDevices()
+			defer ghwTest.Clean()
+
+			// Once resized the last partition spans up to the end of the disk
+			table := printOutput
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "parted" {
+					if slices.Contains(args, "resizepart") {
+						table = strings.Replace(printOutput, "3:29394944s:45019135s:15624192s", "3:29394944s:50593791s:21198848s", 1)
+					}
+					return []byte(table), nil
+				}
+				return []byte{}, nil
+			}
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{
+				{"parted", "--script", "--machine", "--", device, "unit", "s", "resizepart", "3", "100%"},
+				{"e2fsck", "-fy", fmt.Sprintf("%s%d", device, partNum)},
+				{"resize2fs", fmt.Sprintf("%s%d", device, partNum)},
+			})).To(Succeed())
+
+			runner.ClearCmds()
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			for _, cmd := range runner.GetCmds() {
+				Expect(cmd).NotTo(ContainElement("resizepart"))
+				Expect(cmd[0]).NotTo(BeElementOf("e2fsck", "resize2fs", "mount"))
+			}
+		})
+		It("Adds an LVM partition at an explicit position", func() {
+			partNum = 4
+			_, err := afs.Create(fmt.Sprintf("%s%d", device, partNum))
+			Expect(err).To(BeNil())
+			err = afs.WriteFile("/some/yip/layout.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Adding LVM partition
+    layout:
+      device:
+        path: %s
+      add_partitions:
+      - pLabel: containers
+        size: 1024
+        start: 22528
+        filesystem: lvm
+`, device)), constants.FilePerm)
+			Expect(err).To(BeNil())
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.IncludesCmds([][]string{{
+				"parted", "--script", "--machine", "--", device, "unit", "s",
+				"mkpart", "primary", "ext4", "46137344", "48234495", "set", "4", "lvm", "on",
+			}})).To(Succeed())
+			Expect(runner.IncludesCmds([][]string{{"mkfs.ext4"}})).NotTo(Succeed())
+		})
+		It("Creates LVM volumes", func() {
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				switch cmd {
+				case "pvs", "vgs", "lvs":
+					return []byte{}, errors.New("not found")
+				default:
+					return []byte{}, nil
+				}
+			}
+			err := afs.WriteFile("/some/yip/layout.yaml", []byte(`
+stages:
+  test:
+  - name: Container storage
+    layout:
+      lvm:
+      - name: containers
+        physical_volumes: [/dev/sdb]
+        logical_volumes:
+        - name: images
+          pool: thinpool
+          size: 102400
+          filesystem: xfs
+          fsLabel: IMAGES
+        - name: thinpool
+          thinpool: true
+`), constants.FilePerm)
+			Expect(err).To(BeNil())
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.CmdsMatch([][]string{
+				{"pvs", "--noheadings", "-o", "vg_name", "/dev/sdb"},
+				{"pvcreate", "--yes", "/dev/sdb"},
+				{"vgs", "containers"},
+				{"vgcreate", "containers", "/dev/sdb"},
+				{"lvs", "containers/thinpool"},
+				{"lvcreate", "--yes", "-n", "thinpool", "--type", "thin-pool", "-l", "100%FREE", "containers"},
+				{"lvs", "containers/images"},
+				{"lvcreate", "--yes", "-n", "images", "--type", "thin", "-V", "102400M", "--thinpool", "thinpool", "containers"},
+				{"mkfs.xfs", "-L", "IMAGES", "/dev/containers/images"},
+			})).To(Succeed())
+		})
+		It("Keeps existing LVM volumes", func() {
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "pvs" {
+					return []byte("  containers\n"), nil
+				}
+				return []byte{}, nil
+			}
+			err := afs.WriteFile("/some/yip/layout.yaml", []byte(`
+stages:
+  test:
+  - name: Container storage
+    layout:
+      lvm:
+      - name: containers
+        physical_volumes: [/dev/sdb]
+        logical_volumes:
+        - name: thinpool
+          thinpool: true
+`), constants.FilePerm)
+			Expect(err).To(BeNil())
+			cloudRunner := NewYipCloudInitRunner(logger, runner, afs)
+			Expect(cloudRunner.Run("test", "/some/yip")).To(BeNil())
+			Expect(runner.CmdsMatch([][]string{
+				{"pvs", "--noheadings", "-o", "vg_name", "/dev/sdb"},
+				{"vgs", "containers"},
+				{"lvs", "containers/thinpool"},
+			})).To(Succeed())
+
+			runner.ClearCmds()
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "pvs" {
+					return []byte("  other\n"), nil
+				}
+				return []byte{}, nil
+			}
+			Expect(cloudRunner.Run("test", "/some/yip")).NotTo(Succeed())
+		})
 		It("Fails to find device by path", func() {
 			err := afs.WriteFile("/some/yip/layout.yaml", []byte(`
 stages:
@@ -350,4 +551,149 @@ stages:
 			Expect(cloudRunner.Run("test", "/some/yip")).NotTo(BeNil())
 		})
 	})
+	Describe("elemental plugins execution", func() {
+		var runner *mocks.FakeRunner
+		var afs *vfst.TestFS
+		var cleanup func()
+		var server *httptest.Server
+		var cloudRunner *YipCloudInitRunner
+		logger := types.NewNullLogger()
+		data := "some binary"
+		sum := fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+		BeforeEach(func() {
+			afs, cleanup, _ = vfst.NewTestFS(nil)
+			Expect(utils.MkdirAll(afs, "/some/yip", constants.DirPerm)).To(Succeed())
+			runner = mocks.NewFakeRunner()
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(data))
+			}))
+			cfg := config.NewConfig(config.WithRunner(runner), config.WithFs(afs), config.WithLogger(logger))
+			cloudRunner = NewYipCloudInitRunner(
+				logger, runner, afs, WithHTTPClient(elementalhttp.NewClient()), WithBootloader(bootloader.NewGrub(cfg)),
+			)
+		})
+		AfterEach(func() {
+			server.Close()
+			cleanup()
+		})
+		It("Fetches files verifying their checksum", func() {
+			Expect(afs.WriteFile("/some/yip/fetch.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Fetch binary
+    fetch:
+    - url: %s/bin
+      path: /usr/local/bin/tool
+      sha256: %s
+      permissions: 0755
+`, server.URL, sum)), constants.FilePerm)).To(Succeed())
+			Expect(cloudRunner.Run("test", "/some/yip")).To(Succeed())
+			Expect(afs.ReadFile("/usr/local/bin/tool")).To(Equal([]byte(data)))
+			info, err := afs.Stat("/usr/local/bin/tool")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		})
+		It("Fails on checksum mismatch and keeps the previous file", func() {
+			Expect(utils.MkdirAll(afs, "/usr/local/bin", constants.DirPerm)).To(Succeed())
+			Expect(afs.WriteFile("/usr/local/bin/tool", []byte("previous"), constants.FilePerm)).To(Succeed())
+			Expect(afs.WriteFile("/some/yip/fetch.yaml", []byte(fmt.Sprintf(`
+stages:
+  test:
+  - name: Fetch binary
+    fetch:
+    - url: %s/bin
+      path: /usr/local/bin/tool
+      sha256: 0000
+`, server.URL)), constants.FilePerm)).To(Succeed())
+			Expect(cloudRunner.Run("test", "/some/yip")).NotTo(Succeed())
+			Expect(afs.ReadFile("/usr/local/bin/tool")).To(Equal([]byte("previous")))
+			Expect(utils.Exists(afs, "/usr/local/bin/tool.download")).To(BeFalse())
+		})
+		It("Sets kernel arguments and the default entry in the GRUB environment", func() {
+			Expect(afs.WriteFile("/some/yip/boot.yaml", []byte(`
+stages:
+  test:
+  - name: Kernel arguments
+    kernel_args:
+      all: console=ttyS0
+  - name: Default snapshot
+    default_entry:
+      entry: passive
+      snapshot: 3
+      once: true
+`), constants.FilePerm)).To(Succeed())
+			Expect(cloudRunner.Run("test", "/some/yip")).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{
+				{"grub2-editenv", "/oem/grubenv", "set", "extra_cmdline=console=ttyS0"},
+				{"grub2-editenv", "/oem/grubenv", "set", "next_entry=passive3"},
+			})).To(Succeed())
+		})
+		It("Fails on invalid boot settings", func() {
+			Expect(afs.WriteFile("/some/yip/boot.yaml", []byte(`
+stages:
+  test:
+  - name: Kernel arguments
+    kernel_args:
+      fallback: console=ttyS0
+  - name: Default snapshot
+    default_entry:
+      entry: passive
+`), constants.FilePerm)).To(Succeed())
+			Expect(cloudRunner.Run("test", "/some/yip")).NotTo(Succeed())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("Fails on steps with the same contents and different boot settings", func() {
+			Expect(afs.WriteFile("/some/yip/01_console.yaml", []byte(`
+stages:
+  test:
+  - name: Kernel arguments
+    kernel_args:
+      all: console=ttyS0
+`), constants.FilePerm)).To(Succeed())
+			Expect(afs.WriteFile("/some/yip/02_quiet.yaml", []byte(`
+stages:
+  test:
+  - name: Kernel arguments
+    kernel_args:
+      all: quiet
+`), constants.FilePerm)).To(Succeed())
+			err := cloudRunner.Run("test", "/some/yip")
+			Expect(err).To(MatchError(ContainSubstring("same contents as another step")))
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("Fails on steps with the same contents where only one has boot settings", func() {
+			Expect(afs.WriteFile("/some/yip/01_console.yaml", []byte(`
+stages:
+  test:
+  - name: Kernel arguments
+    kernel_args:
+      all: console=ttyS0
+`), constants.FilePerm)).To(Succeed())
+			Expect(afs.WriteFile("/some/yip/02_plain.yaml", []byte(`
+stages:
+  test:
+  - name: Kernel arguments
+`), constants.FilePerm)).To(Succeed())
+			Expect(cloudRunner.Run("test", "/some/yip")).NotTo(Succeed())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("Applies the boot settings of steps with unique contents", func() {
+			Expect(afs.WriteFile("/some/yip/01_console.yaml", []byte(`
+stages:
+  test:
+  - name: Serial console
+    kernel_args:
+      all: console=ttyS0
+`), constants.FilePerm)).To(Succeed())
+			Expect(afs.WriteFile("/some/yip/02_plain.yaml", []byte(`
+stages:
+  test:
+  - name: Kernel arguments
+`), constants.FilePerm)).To(Succeed())
+			Expect(cloudRunner.Run("test", "/some/yip")).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{
+				{"grub2-editenv", "/oem/grubenv", "set", "extra_cmdline=console=ttyS0"},
+			})).To(Succeed())
+		})
+	})
 })
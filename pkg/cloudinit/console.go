@@ -21,6 +21,7 @@ import (
 	"os/exec"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/rancher/yip/pkg/schema"
 
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 )
@@ -28,14 +29,17 @@ import (
 // cloudInitConsole represents a yip's Console implementations using
 // the elemental types.Runner interface.
 type cloudInitConsole struct {
-	runner types.Runner
-	logger types.Logger
+	runner     types.Runner
+	logger     types.Logger
+	client     types.HTTPClient
+	bootloader types.Bootloader
+	extensions *extensionRegistry
 }
 
 // newCloudInitConsole returns an instance of the cloudInitConsole based on the
 // given types.Runner and types.Logger.
 func newCloudInitConsole(l types.Logger, r types.Runner) *cloudInitConsole {
-	return &cloudInitConsole{logger: l, runner: r}
+	return &cloudInitConsole{logger: l, runner: r, extensions: newExtensionRegistry()}
 }
 
 // getRunner returns the internal runner used within this Console
@@ -43,6 +47,11 @@ func (c cloudInitConsole) getRunner() types.Runner {
 	return c.runner
 }
 
+// getExtension returns the elemental specific settings of the given stage
+func (c cloudInitConsole) getExtension(s schema.Stage) stageExtension {
+	return c.extensions.get(s)
+}
+
 // Run runs a command using the types.Runner internal instance
 func (c cloudInitConsole) Run(command string, opts ...func(cmd *exec.Cmd)) (string, error) {
 	c.logger.Debugf("running command `%s`", command)
@@ -0,0 +1,136 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/rancher/yip/pkg/logger"
+	"github.com/rancher/yip/pkg/plugins"
+	"github.com/rancher/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// stageExtension holds the elemental specific settings of a stage, yip is not aware of them
+type stageExtension struct {
+	Layout       layoutExtension   `yaml:"layout,omitempty"`
+	Fetch        []fetchFile       `yaml:"fetch,omitempty"`
+	KernelArgs   map[string]string `yaml:"kernel_args,omitempty"`
+	DefaultEntry *defaultEntry     `yaml:"default_entry,omitempty"`
+}
+
+// extensionRegistry keeps the stage extensions found while loading the cloud-init files.
+// yip plugins only get the parsed stage, so extensions are looked up by the yip stage contents.
+// Steps with the same contents but different extensions can't be told apart, they are ambiguous.
+type extensionRegistry struct {
+	mutex      sync.RWMutex
+	extensions map[string]stageExtension
+	ambiguous  map[string]bool
+}
+
+// newExtensionRegistry returns an empty extensionRegistry
+func newExtensionRegistry() *extensionRegistry {
+	return &extensionRegistry{extensions: map[string]stageExtension{}, ambiguous: map[string]bool{}}
+}
+
+// stageKey returns the key of the extension of the given stage
+func stageKey(stage schema.Stage) string {
+	key, _ := json.Marshal(stage)
+	return string(key)
+}
+
+// get returns the extension of the given stage, if any. Ambiguous stages have no extension.
+func (r *extensionRegistry) get(stage schema.Stage) stageExtension {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	key := stageKey(stage)
+	if r.ambiguous[key] {
+		return stageExtension{}
+	}
+	return r.extensions[key]
+}
+
+// isAmbiguous returns true if other stages with the same contents define different extensions
+func (r *extensionRegistry) isAmbiguous(stage schema.Stage) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.ambiguous[stageKey(stage)]
+}
+
+// load stores the stage extensions of the given yip config, unparsable configs are ignored
+// as they are reported by yip itself
+func (r *extensionRegistry) load(data []byte) {
+	var config schema.YipConfig
+	var extConfig struct {
+		Stages map[string][]stageExtension `yaml:"stages,omitempty"`
+	}
+	if yaml.Unmarshal(data, &config) != nil || yaml.Unmarshal(data, &extConfig) != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for name, steps := range config.Stages {
+		for i, step := range steps {
+			var ext stageExtension
+			if i < len(extConfig.Stages[name]) {
+				ext = extConfig.Stages[name][i]
+			}
+			key := stageKey(step)
+			if known, ok := r.extensions[key]; ok && !reflect.DeepEqual(known, ext) {
+				r.ambiguous[key] = true
+			}
+			r.extensions[key] = ext
+		}
+	}
+}
+
+// extensionsPlugin fails the steps sharing their contents with other steps with different
+// extensions. Plugins can't tell those steps apart, so none of their extensions are applied.
+func extensionsPlugin(_ logger.Interface, s schema.Stage, _ vfs.FS, console plugins.Console) error {
+	elemConsole, ok := console.(*cloudInitConsole)
+	if !ok {
+		return errors.New("provided console is not an instance of 'cloudInitConsole' type")
+	}
+	if elemConsole.extensions.isAmbiguous(s) {
+		return fmt.Errorf(
+			"step '%s' has the same contents as another step with different elemental settings, give them unique names",
+			s.Name,
+		)
+	}
+	return nil
+}
+
+// modifier returns a yip modifier loading the stage extensions of the data returned by the given modifier
+func (r *extensionRegistry) modifier(m schema.Modifier) schema.Modifier {
+	return func(data []byte) ([]byte, error) {
+		var err error
+		if m != nil {
+			data, err = m(data)
+			if err != nil {
+				return data, err
+			}
+		}
+		r.load(data)
+		return data, nil
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rancher/yip/pkg/logger"
+	"github.com/rancher/yip/pkg/plugins"
+	"github.com/rancher/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v4"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// fetchFile is a file to download and verify
+type fetchFile struct {
+	URL         string `yaml:"url,omitempty"`
+	Path        string `yaml:"path,omitempty"`
+	Sha256      string `yaml:"sha256,omitempty"`
+	Permissions uint32 `yaml:"permissions,omitempty"`
+}
+
+// fetchPlugin downloads the files of the stage 'fetch' list with the elemental HTTP client.
+// Files already matching the given checksum are not downloaded again.
+func fetchPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) error {
+	elemConsole, ok := console.(*cloudInitConsole)
+	if !ok {
+		return errors.New("provided console is not an instance of 'cloudInitConsole' type")
+	}
+	log, ok := l.(types.Logger)
+	if !ok {
+		return errors.New("provided logger is not implementing types.Logger interface")
+	}
+
+	var errs error
+	for _, file := range elemConsole.getExtension(s).Fetch {
+		if err := fetch(log, elemConsole.client, fs, file); err != nil {
+			log.Errorf("Failed fetching %s: %s", file.URL, err.Error())
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// fetch downloads the given file to a temporary file next to its destination and moves it
+// into place once its checksum is verified
+func fetch(log types.Logger, client types.HTTPClient, fs vfs.FS, file fetchFile) error {
+	if file.URL == "" || file.Path == "" {
+		return fmt.Errorf("files to fetch require an url and a path")
+	}
+	sum := strings.ToLower(file.Sha256)
+
+	if sum != "" {
+		if current, err := utils.CalcFileChecksum(fs, file.Path); err == nil && current == sum {
+			log.Debugf("%s is up to date, not downloading it again", file.Path)
+			return setPermissions(fs, file)
+		}
+	}
+
+	if err := utils.MkdirAll(fs, filepath.Dir(file.Path), constants.DirPerm); err != nil {
+		return err
+	}
+	tmpFile := file.Path + ".download"
+	defer func() { _ = fs.Remove(tmpFile) }()
+
+	rawTmpFile, err := fs.RawPath(tmpFile)
+	if err != nil {
+		return err
+	}
+	if err = client.GetURL(log, file.URL, rawTmpFile); err != nil {
+		return err
+	}
+
+	if sum != "" {
+		downloaded, err := utils.CalcFileChecksum(fs, tmpFile)
+		if err != nil {
+			return err
+		}
+		if downloaded != sum {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", file.URL, sum, downloaded)
+		}
+	} else {
+		log.Warnf("No checksum defined for %s, not verifying it", file.URL)
+	}
+
+	if err = fs.Rename(tmpFile, file.Path); err != nil {
+		return err
+	}
+	log.Infof("Fetched %s into %s", file.URL, file.Path)
+	return setPermissions(fs, file)
+}
+
+// setPermissions sets the permissions of the given fetched file, if any
+func setPermissions(fs vfs.FS, file fetchFile) error {
+	if file.Permissions == 0 {
+		return nil
+	}
+	return fs.Chmod(file.Path, os.FileMode(file.Permissions))
+}
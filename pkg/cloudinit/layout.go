@@ -0,0 +1,71 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+// layoutExtension holds the elemental specific settings of a layout stage. They are
+// set under the 'layout' key of the stage along with the yip ones, which are not aware of them.
+type layoutExtension struct {
+	Parts  []partitionExtension `yaml:"add_partitions,omitempty"`
+	Resize []partitionResize    `yaml:"resize_partitions,omitempty"`
+	LVM    []volumeGroup        `yaml:"lvm,omitempty"`
+}
+
+// partitionExtension extends a partition of the yip 'add_partitions' list
+type partitionExtension struct {
+	// Start is the partition start offset in MiB, by default partitions are appended
+	Start uint `yaml:"start,omitempty"`
+}
+
+// partitionResize is an existing partition to resize along with its filesystem
+type partitionResize struct {
+	FSLabel    string `yaml:"fsLabel,omitempty"`
+	PLabel     string `yaml:"pLabel,omitempty"`
+	FileSystem string `yaml:"filesystem,omitempty"`
+	// Size in MiB, zero grows the partition up to the next partition or the end of the disk
+	Size uint `yaml:"size,omitempty"`
+}
+
+// volumeGroup is an LVM volume group with its physical and logical volumes
+type volumeGroup struct {
+	Name            string          `yaml:"name,omitempty"`
+	PhysicalVolumes []string        `yaml:"physical_volumes,omitempty"`
+	LogicalVolumes  []logicalVolume `yaml:"logical_volumes,omitempty"`
+}
+
+// logicalVolume is an LVM logical volume, a thin pool or a thin volume of a pool
+type logicalVolume struct {
+	Name string `yaml:"name,omitempty"`
+	// Size in MiB, zero takes all the free space of the volume group. It is the virtual size of thin volumes.
+	Size       uint   `yaml:"size,omitempty"`
+	ThinPool   bool   `yaml:"thinpool,omitempty"`
+	Pool       string `yaml:"pool,omitempty"`
+	FileSystem string `yaml:"filesystem,omitempty"`
+	FSLabel    string `yaml:"fsLabel,omitempty"`
+}
+
+// hasChanges returns true if any elemental specific setting is defined
+func (l layoutExtension) hasChanges() bool {
+	return len(l.Parts) > 0 || len(l.Resize) > 0 || len(l.LVM) > 0
+}
+
+// partition returns the extension of the yip partition at the given index
+func (l layoutExtension) partition(i int) partitionExtension {
+	if i < len(l.Parts) {
+		return l.Parts[i]
+	}
+	return partitionExtension{}
+}
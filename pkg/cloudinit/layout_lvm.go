@@ -0,0 +1,131 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+// lvmFs is the filesystem value of partitions used as LVM physical volumes
+const lvmFs = "lvm"
+
+// applyLVM creates the given volume groups and their volumes. Existing physical volumes,
+// volume groups and logical volumes are kept, so it can be applied on every boot.
+func applyLVM(log types.Logger, runner types.Runner, vgs []volumeGroup) error {
+	for _, vg := range vgs {
+		if vg.Name == "" || len(vg.PhysicalVolumes) == 0 {
+			return fmt.Errorf("volume groups require a name and at least one physical volume")
+		}
+
+		var free []string
+		for _, pv := range vg.PhysicalVolumes {
+			out, err := runner.Run("pvs", "--noheadings", "-o", "vg_name", pv)
+			if err != nil {
+				log.Infof("Creating LVM physical volume %s", pv)
+				if out, err = runner.Run("pvcreate", "--yes", pv); err != nil {
+					return fmt.Errorf("failed creating physical volume %s: %s", pv, strings.TrimSpace(string(out)))
+				}
+				out = nil
+			}
+			switch owner := strings.TrimSpace(string(out)); owner {
+			case "":
+				free = append(free, pv)
+			case vg.Name:
+			default:
+				return fmt.Errorf("physical volume %s already belongs to volume group %s", pv, owner)
+			}
+		}
+
+		if _, err := runner.Run("vgs", vg.Name); err != nil {
+			log.Infof("Creating LVM volume group %s", vg.Name)
+			out, err := runner.Run("vgcreate", append([]string{vg.Name}, free...)...)
+			if err != nil {
+				return fmt.Errorf("failed creating volume group %s: %s", vg.Name, strings.TrimSpace(string(out)))
+			}
+		} else if len(free) > 0 {
+			log.Infof("Extending LVM volume group %s with %s", vg.Name, strings.Join(free, ", "))
+			out, err := runner.Run("vgextend", append([]string{vg.Name}, free...)...)
+			if err != nil {
+				return fmt.Errorf("failed extending volume group %s: %s", vg.Name, strings.TrimSpace(string(out)))
+			}
+		}
+
+		// Thin pools are created first, so thin volumes can be defined in any order
+		for _, thinPools := range []bool{true, false} {
+			for _, lv := range vg.LogicalVolumes {
+				if lv.ThinPool != thinPools {
+					continue
+				}
+				if err := createLogicalVolume(log, runner, vg.Name, lv); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// createLogicalVolume creates and formats the given logical volume if it does not exist
+func createLogicalVolume(log types.Logger, runner types.Runner, vg string, lv logicalVolume) error {
+	if lv.Name == "" {
+		return fmt.Errorf("logical volumes of volume group %s require a name", vg)
+	}
+	if _, err := runner.Run("lvs", filepath.Join(vg, lv.Name)); err == nil {
+		log.Debugf("Logical volume %s/%s already exists, ignoring", vg, lv.Name)
+		return nil
+	}
+
+	args := []string{"--yes", "-n", lv.Name}
+	switch {
+	case lv.ThinPool && lv.Pool != "":
+		return fmt.Errorf("logical volume %s/%s can't be a thin pool and a thin volume", vg, lv.Name)
+	case lv.Pool != "":
+		if lv.Size == 0 {
+			return fmt.Errorf("thin volume %s/%s requires a size", vg, lv.Name)
+		}
+		args = append(args, "--type", "thin", "-V", fmt.Sprintf("%dM", lv.Size), "--thinpool", lv.Pool)
+	case lv.ThinPool:
+		args = append(args, "--type", "thin-pool")
+		fallthrough
+	default:
+		if lv.Size == 0 {
+			args = append(args, "-l", "100%FREE")
+		} else {
+			args = append(args, "-L", fmt.Sprintf("%dM", lv.Size))
+		}
+	}
+
+	log.Infof("Creating LVM logical volume %s/%s", vg, lv.Name)
+	out, err := runner.Run("lvcreate", append(args, vg)...)
+	if err != nil {
+		return fmt.Errorf("failed creating logical volume %s/%s: %s", vg, lv.Name, strings.TrimSpace(string(out)))
+	}
+
+	if lv.FileSystem != "" && !lv.ThinPool {
+		device := filepath.Join("/dev", vg, lv.Name)
+		log.Infof("Formatting logical volume %s", device)
+		if err = partitioner.FormatDevice(runner, device, lv.FileSystem, lv.FSLabel); err != nil {
+			return fmt.Errorf("failed formatting logical volume %s: %w", device, err)
+		}
+	}
+	return nil
+}
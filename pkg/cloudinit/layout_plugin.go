@@ -19,6 +19,7 @@ package cloudinit
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/rancher/yip/pkg/logger"
@@ -36,11 +37,6 @@ import (
 // layoutPlugin is the elemental's implementation of Layout yip's plugin based
 // on partitioner package
 func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) (err error) {
-	if s.Layout.Device == nil {
-		return nil
-	}
-
-	var dev *partitioner.Disk
 	elemConsole, ok := console.(*cloudInitConsole)
 	if !ok {
 		return errors.New("provided console is not an instance of 'cloudInitConsole' type")
@@ -50,7 +46,14 @@ func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins
 	if !ok {
 		return errors.New("provided logger is not implementing types.Logger interface")
 	}
+	ext := elemConsole.getExtension(s).Layout
+
+	if s.Layout.Device == nil {
+		// LVM volumes can be set up on existing devices
+		return applyLVM(log, runner, ext.LVM)
+	}
 
+	var dev *partitioner.Disk
 	if len(strings.TrimSpace(s.Layout.Device.Label)) > 0 {
 		partDevice, err := utils.GetFullDeviceByLabel(runner, s.Layout.Device.Label, 5)
 		if err != nil {
@@ -89,11 +92,26 @@ func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins
 		}
 	}
 
-	for _, part := range s.Layout.Parts {
-		_, err := utils.GetFullDeviceByLabel(runner, part.FSLabel, 1)
-		if err == nil {
-			l.Warnf("Partition with FSLabel: %s already exists, ignoring", part.FSLabel)
-			continue
+	for _, resize := range ext.Resize {
+		err = resizePartition(dev, runner, resize)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, part := range s.Layout.Parts {
+		if part.FileSystem == lvmFs {
+			// LVM physical volumes have no filesystem label, they are identified by the partition label
+			if hasPartition(dev, part.PLabel) {
+				l.Warnf("Partition with PLabel: %s already exists, ignoring", part.PLabel)
+				continue
+			}
+		} else {
+			_, err := utils.GetFullDeviceByLabel(runner, part.FSLabel, 1)
+			if err == nil {
+				l.Warnf("Partition with FSLabel: %s already exists, ignoring", part.FSLabel)
+				continue
+			}
 		}
 
 		// Set default filesystem
@@ -101,8 +119,23 @@ func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins
 			part.FileSystem = constants.LinuxFs
 		}
 
+		opts := []partitioner.PartitionOptions{}
+		if start := ext.partition(i).Start; start > 0 {
+			opts = append(opts, partitioner.WithPartitionStart(start))
+		}
+
+		if part.FileSystem == lvmFs {
+			l.Infof("Creating %s LVM partition", part.PLabel)
+			opts = append(opts, partitioner.WithPartitionFlags(lvmFs))
+			_, err := dev.AddPartition(part.Size, constants.LinuxFs, part.PLabel, opts...)
+			if err != nil {
+				return fmt.Errorf("Failed creating partitions: %w", err)
+			}
+			continue
+		}
+
 		l.Infof("Creating %s partition", part.FSLabel)
-		partNum, err := dev.AddPartition(part.Size, part.FileSystem, part.PLabel)
+		partNum, err := dev.AddPartition(part.Size, part.FileSystem, part.PLabel, opts...)
 		if err != nil {
 			return fmt.Errorf("Failed creating partitions: %w", err)
 		}
@@ -111,5 +144,69 @@ func layoutPlugin(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins
 			return fmt.Errorf("Formatting partition failed: %s\nError: %w", out, err)
 		}
 	}
+
+	return applyLVM(log, runner, ext.LVM)
+}
+
+// hasPartition returns true if the disk has a partition with the given partition label
+func hasPartition(dev *partitioner.Disk, pLabel string) bool {
+	if dev.Reload() != nil {
+		return false
+	}
+	for _, part := range dev.GetPartitions() {
+		if part.PLabel == pLabel {
+			return true
+		}
+	}
+	return false
+}
+
+// resizePartition resizes the partition matching the given labels and grows its filesystem.
+// Partitions already having the requested size are left untouched.
+func resizePartition(dev *partitioner.Disk, runner types.Runner, resize partitionResize) error {
+	if err := dev.Reload(); err != nil {
+		return err
+	}
+
+	partNum := 0
+	switch {
+	case resize.FSLabel != "":
+		part, err := utils.GetFullDeviceByLabel(runner, resize.FSLabel, 1)
+		if err != nil {
+			return fmt.Errorf("partition with FSLabel %s not found", resize.FSLabel)
+		}
+		num := strings.TrimPrefix(strings.TrimPrefix(part.Path, dev.String()), "p")
+		partNum, err = strconv.Atoi(num)
+		if err != nil || part.Disk != dev.String() {
+			return fmt.Errorf("partition with FSLabel %s is not a partition of %s", resize.FSLabel, dev)
+		}
+	case resize.PLabel != "":
+		for _, part := range dev.GetPartitions() {
+			if part.PLabel == resize.PLabel {
+				partNum = part.Number
+			}
+		}
+		if partNum == 0 {
+			return fmt.Errorf("partition with PLabel %s not found", resize.PLabel)
+		}
+	default:
+		return fmt.Errorf("partitions to resize require a fsLabel or a pLabel")
+	}
+
+	fileSystem := resize.FileSystem
+	if fileSystem == "" {
+		pDev, err := dev.FindPartitionDevice(partNum)
+		if err != nil {
+			return err
+		}
+		fileSystem, err = utils.GetPartitionFS(pDev)
+		if err != nil {
+			return err
+		}
+	}
+	out, err := dev.ResizePartition(partNum, resize.Size, strings.TrimSpace(fileSystem))
+	if err != nil {
+		return fmt.Errorf("Resizing partition %d failed: %s\nError: %w", partNum, out, err)
+	}
 	return nil
 }
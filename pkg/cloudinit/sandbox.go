@@ -0,0 +1,286 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rancher/yip/pkg/executor"
+	"github.com/rancher/yip/pkg/logger"
+	"github.com/rancher/yip/pkg/plugins"
+	"github.com/rancher/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v4"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// SandboxReport lists the changes a simulated stage would apply on the system. Conditionals lists the `if`
+// checks of the steps, they are not run and assumed to be true.
+type SandboxReport struct {
+	Files        []string
+	Users        []string
+	Units        []string
+	Commands     []string
+	Conditionals []string
+	Skipped      []string
+}
+
+// recordingRunner is a types.Runner recording the commands instead of executing them
+type recordingRunner struct {
+	logger       types.Logger
+	commands     []string
+	conditionals []string
+	skipped      []string
+	// conditional is set while a step conditional is evaluated
+	conditional bool
+}
+
+var _ types.Runner = (*recordingRunner)(nil)
+
+func (r *recordingRunner) InitCmd(command string, args ...string) *exec.Cmd {
+	return exec.Command(command, args...)
+}
+
+func (r *recordingRunner) Run(command string, args ...string) ([]byte, error) {
+	return r.RunCmd(r.InitCmd(command, args...))
+}
+
+func (r *recordingRunner) RunCmd(cmd *exec.Cmd) ([]byte, error) {
+	args := cmd.Args
+	// Console commands are run within a shell, record the command itself
+	if len(args) == 3 && args[0] == "sh" && args[1] == "-c" {
+		args = args[2:]
+	}
+	if r.conditional {
+		r.conditionals = append(r.conditionals, strings.Join(args, " "))
+		return []byte{}, nil
+	}
+	r.commands = append(r.commands, strings.Join(args, " "))
+	return []byte{}, nil
+}
+
+func (r *recordingRunner) CommandExists(_ string) bool {
+	return true
+}
+
+func (r *recordingRunner) GetLogger() types.Logger {
+	return r.logger
+}
+
+func (r *recordingRunner) SetLogger(logger types.Logger) {
+	r.logger = logger
+}
+
+// withSandbox sets the plugins which only change the given filesystem or run commands through the console,
+// the other plugins act on the live system and are recorded as skipped. Conditionals are recorded apart from
+// the commands and always pass.
+func withSandbox() YipCloudInitOptions {
+	return func(y *YipCloudInitRunner) error {
+		y.conditionals = nil
+		for _, c := range conditionals() {
+			y.conditionals = append(y.conditionals, sandboxConditional(c))
+		}
+		y.plugins = []executor.Plugin{
+			extensionsPlugin,
+			sandboxSkipPlugin,
+			plugins.EnsureDirectories,
+			plugins.EnsureFiles,
+			plugins.Commands,
+			plugins.Sysctl,
+			plugins.User,
+			plugins.SSH,
+			plugins.Timesyncd,
+			plugins.Systemctl,
+			plugins.Environment,
+			plugins.SystemdFirstboot,
+		}
+		return nil
+	}
+}
+
+// sandboxConditional wraps a conditional plugin so the commands it runs are recorded as conditionals
+func sandboxConditional(p executor.Plugin) executor.Plugin {
+	return func(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) error {
+		runner, err := sandboxRunner(console)
+		if err != nil {
+			return err
+		}
+		runner.conditional = true
+		defer func() { runner.conditional = false }()
+		return p(l, s, fs, console)
+	}
+}
+
+// sandboxRunner returns the recording runner of the given console
+func sandboxRunner(console plugins.Console) (*recordingRunner, error) {
+	elemConsole, ok := console.(*cloudInitConsole)
+	if !ok {
+		return nil, errors.New("provided console is not an instance of 'cloudInitConsole' type")
+	}
+	runner, ok := elemConsole.runner.(*recordingRunner)
+	if !ok {
+		return nil, errors.New("sandbox runner is not recording commands")
+	}
+	return runner, nil
+}
+
+// sandboxSkipPlugin records the stage keys not applied within a sandbox
+func sandboxSkipPlugin(l logger.Interface, s schema.Stage, _ vfs.FS, console plugins.Console) error {
+	runner, err := sandboxRunner(console)
+	if err != nil {
+		return err
+	}
+
+	ext := console.(*cloudInitConsole).getExtension(s)
+	keys := []struct {
+		name string
+		set  bool
+	}{
+		{"dns", len(s.Dns.Nameservers) > 0},
+		{"downloads", len(s.Downloads) > 0},
+		{"fetch", len(ext.Fetch) > 0},
+		{"ensure_entities", len(s.EnsureEntities) > 0},
+		{"delete_entities", len(s.DeleteEntities) > 0},
+		{"hostname", s.Hostname != ""},
+		{"datasource", len(s.DataSources.Providers) > 0},
+		{"layout", s.Layout.Device != nil || ext.Layout.hasChanges()},
+		{"kernel_args", len(ext.KernelArgs) > 0},
+		{"default_entry", ext.DefaultEntry != nil},
+	}
+	for _, key := range keys {
+		if key.set {
+			l.Warnf("Skipping '%s' of step '%s', it can't be simulated", key.name, s.Name)
+			runner.skipped = append(runner.skipped, s.Name+": "+key.name)
+		}
+	}
+	return nil
+}
+
+// sandboxFS is the filesystem of a sandbox root, roots without a kernel command line are handled as an empty one
+type sandboxFS struct {
+	*vfs.PathFS
+}
+
+func (s sandboxFS) ReadFile(name string) ([]byte, error) {
+	data, err := s.PathFS.ReadFile(name)
+	if name == "/proc/cmdline" && os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+// sandboxEntry is the state of a file used to detect changes
+type sandboxEntry struct {
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+}
+
+// sandboxSnapshot returns the state of all the files under the given filesystem root
+func sandboxSnapshot(fs vfs.FS) (map[string]sandboxEntry, error) {
+	entries := map[string]sandboxEntry{}
+	err := vfs.Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			entries[path] = sandboxEntry{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// sandboxUsers returns the users defined in the passwd file of the given filesystem
+func sandboxUsers(fs vfs.FS) []string {
+	var users []string
+	data, err := fs.ReadFile("/etc/passwd")
+	if err != nil {
+		return users
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, _, found := strings.Cut(line, ":"); found && name != "" {
+			users = append(users, name)
+		}
+	}
+	return users
+}
+
+// SimulateStage runs the given stage against the root directory instead of the live system. Commands are
+// recorded instead of executed and the changes applied under the root directory are reported. Step conditionals
+// are not evaluated, all the steps are simulated as if their conditionals were true.
+func SimulateStage(cfg types.Config, rootDir, stage string, strict bool, cloudInitPaths ...string) (*SandboxReport, error) {
+	rootFs := sandboxFS{vfs.NewPathFS(vfs.OSFS, rootDir)}
+	runner := &recordingRunner{logger: cfg.Logger}
+
+	ci := NewYipCloudInitRunner(cfg.Logger, runner, vfs.OSFS, withSandbox())
+	ci.SetFs(rootFs)
+
+	before, err := sandboxSnapshot(rootFs)
+	if err != nil {
+		return nil, err
+	}
+	users := sandboxUsers(rootFs)
+
+	cfg.Fs = rootFs
+	cfg.Runner = runner
+	cfg.CloudInitRunner = ci
+	// Paths given on top of the default ones are only run once
+	var paths []string
+	for _, path := range cloudInitPaths {
+		if local, _ := utils.IsLocalURI(path); local {
+			path = filepath.Clean(path)
+		}
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	runErr := utils.RunStage(&cfg, stage, strict, paths...)
+
+	after, err := sandboxSnapshot(rootFs)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SandboxReport{Commands: runner.commands, Conditionals: runner.conditionals, Skipped: runner.skipped}
+	for path, entry := range after {
+		if prev, ok := before[path]; !ok || prev != entry {
+			report.Files = append(report.Files, path)
+		}
+	}
+	slices.Sort(report.Files)
+
+	for _, user := range sandboxUsers(rootFs) {
+		if !slices.Contains(users, user) {
+			report.Users = append(report.Users, user)
+		}
+	}
+
+	for _, command := range runner.commands {
+		if unit, found := strings.CutPrefix(command, "systemctl enable "); found {
+			report.Units = append(report.Units, strings.TrimSpace(unit))
+		}
+	}
+	return report, runErr
+}
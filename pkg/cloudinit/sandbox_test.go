@@ -0,0 +1,111 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
+	"github.com/rancher/elemental-toolkit/v2/pkg/config"
+	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SimulateStage", Label("cloud-init", "sandbox"), func() {
+	var root string
+	var runner *mocks.FakeRunner
+	var cfg *types.Config
+
+	writeFile := func(path, content string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, path), []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		runner = mocks.NewFakeRunner()
+		cfg = config.NewConfig(
+			config.WithRunner(runner),
+			config.WithLogger(types.NewBufferLogger(bytes.NewBuffer(nil))),
+		)
+		writeFile("/etc/passwd", "root:x:0:0:root:/root:/bin/sh\n")
+		writeFile("/etc/group", "root:x:0:\n")
+		writeFile("/etc/shadow", "root:*:19000::::::\n")
+		writeFile("/oem/01_sandbox.yaml", `stages:
+  boot:
+  - name: sandbox
+    files:
+    - path: /etc/motd
+      content: welcome
+      permissions: 0644
+    systemctl:
+      enable:
+      - sshd
+    commands:
+    - rm -rf /var/lib/foo
+    hostname: foo
+`)
+	})
+
+	It("reports the changes without touching the live system", func() {
+		report, err := SimulateStage(*cfg, root, "boot", true, "/oem", "/oem/")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(report.Files).To(Equal([]string{"/etc/motd"}))
+		Expect(report.Units).To(Equal([]string{"sshd"}))
+		Expect(report.Commands).To(Equal([]string{"rm -rf /var/lib/foo", "systemctl enable sshd"}))
+		Expect(report.Skipped).To(Equal([]string{"sandbox: hostname"}))
+
+		data, err := os.ReadFile(filepath.Join(root, "/etc/motd"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("welcome"))
+		Expect(runner.GetCmds()).To(BeEmpty())
+	})
+
+	It("reports created users", func() {
+		writeFile("/oem/02_user.yaml", `stages:
+  boot:
+  - users:
+      sandboxuser:
+        passwd: foo
+`)
+		report, err := SimulateStage(*cfg, root, "boot", false, "/oem")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Users).To(Equal([]string{"sandboxuser"}))
+		Expect(report.Files).To(ContainElements("/etc/motd", "/etc/passwd", "/etc/shadow"))
+	})
+	It("reports conditionals apart from the commands", func() {
+		writeFile("/oem/02_conditional.yaml", `stages:
+  boot:
+  - name: conditional
+    if: '[ -e /etc/conditional ]'
+    commands:
+    - touch /etc/conditional
+`)
+		report, err := SimulateStage(*cfg, root, "boot", true, "/oem")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Conditionals).To(Equal([]string{"[ -e /etc/conditional ]"}))
+		Expect(report.Commands).To(Equal([]string{
+			"rm -rf /var/lib/foo", "systemctl enable sshd", "touch /etc/conditional",
+		}))
+	})
+})
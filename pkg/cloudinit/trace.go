@@ -0,0 +1,289 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/yip/pkg/executor"
+	"github.com/rancher/yip/pkg/logger"
+	"github.com/rancher/yip/pkg/plugins"
+	"github.com/rancher/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v4"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// inlineSource is the source name of cloud-init configs not read from a file or URL
+const inlineSource = "<inline>"
+
+// BootTrace holds the execution traces of the stages run during a boot
+type BootTrace struct {
+	BootID string       `json:"boot_id,omitempty"`
+	Stages []StageTrace `json:"stages"`
+}
+
+// StageTrace is the execution trace of a stage for a single cloud-init source
+type StageTrace struct {
+	Stage    string        `json:"stage"`
+	Source   string        `json:"source"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Steps    []StepTrace   `json:"steps,omitempty"`
+}
+
+// StepTrace is the execution trace of a cloud-init step
+type StepTrace struct {
+	Name     string        `json:"name"`
+	File     string        `json:"file,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Errors   []string      `json:"errors,omitempty"`
+}
+
+// Failed returns true if the stage failed for its source
+func (s StageTrace) Failed() bool {
+	return s.Error != ""
+}
+
+// Failed returns true if any plugin of the step failed
+func (s StepTrace) Failed() bool {
+	return len(s.Errors) > 0
+}
+
+// stepLocation is the file and yip operation name of a step
+type stepLocation struct {
+	file string
+	name string
+}
+
+// stageTracer records the steps run by the yip executor. yip only passes the step to plugins
+// and runs the steps of different files concurrently, so steps are identified by their contents.
+type stageTracer struct {
+	mutex     sync.Mutex
+	path      string
+	locations map[string]stepLocation
+	steps     map[string]*StepTrace
+	order     []string
+}
+
+// newStageTracer returns a stageTracer writing the traces to the given file
+func newStageTracer(path string) *stageTracer {
+	return &stageTracer{path: path}
+}
+
+// begin resets the recorded steps and finds the steps defined in the given source
+func (t *stageTracer) begin(fs vfs.FS, stage, source string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.steps = map[string]*StepTrace{}
+	t.order = nil
+	t.locations = map[string]stepLocation{}
+
+	var files []string
+	if info, err := fs.Stat(source); err == nil && info.IsDir() {
+		_ = vfs.Walk(fs, source, func(path string, info os.FileInfo, err error) error {
+			ext := filepath.Ext(path)
+			if err == nil && !info.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+	} else if err == nil {
+		files = []string{source}
+	}
+
+	for _, file := range files {
+		config, err := schema.Load(file, fs, schema.FromFile, nil)
+		if err != nil {
+			continue
+		}
+		rootName := file
+		if config.Name != "" {
+			rootName = config.Name
+		}
+		steps := config.Stages[stage]
+		duplicated := checkDuplicatedSteps(steps)
+		for i, step := range steps {
+			name := step.Name
+			if duplicated {
+				name = fmt.Sprintf("%s.%d", step.Name, i)
+			}
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			key := stageKey(step)
+			if _, ok := t.locations[key]; !ok {
+				t.locations[key] = stepLocation{file: file, name: rootName + "." + name}
+			}
+		}
+	}
+}
+
+// step returns the trace of the given step, creating it on its first call
+func (t *stageTracer) step(s schema.Stage) *StepTrace {
+	key := stageKey(s)
+	if trace, ok := t.steps[key]; ok {
+		return trace
+	}
+	location, ok := t.locations[key]
+	if !ok {
+		location = stepLocation{name: s.Name}
+	}
+	trace := &StepTrace{Name: location.name, File: location.file, Start: time.Now()}
+	t.steps[key] = trace
+	t.order = append(t.order, key)
+	return trace
+}
+
+// wrap returns the given plugin recording its duration and error in the step trace
+func (t *stageTracer) wrap(p executor.Plugin, conditional bool) executor.Plugin {
+	return func(l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) error {
+		t.mutex.Lock()
+		trace := t.step(s)
+		t.mutex.Unlock()
+
+		start := time.Now()
+		err := p(l, s, fs, console)
+
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		trace.Duration += time.Since(start)
+		switch {
+		case err != nil && conditional:
+			trace.Skipped = true
+		case err != nil:
+			trace.Errors = append(trace.Errors, err.Error())
+		}
+		return err
+	}
+}
+
+// wrapAll returns the given plugins wrapped to record their execution
+func (t *stageTracer) wrapAll(list []executor.Plugin, conditional bool) []executor.Plugin {
+	var wrapped []executor.Plugin
+	for _, p := range list {
+		wrapped = append(wrapped, t.wrap(p, conditional))
+	}
+	return wrapped
+}
+
+// end returns the trace of the stage run for the given source
+func (t *stageTracer) end(stage, source string, start time.Time, err error) StageTrace {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	trace := StageTrace{Stage: stage, Source: source, Start: start, Duration: time.Since(start)}
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	for _, key := range t.order {
+		trace.Steps = append(trace.Steps, *t.steps[key])
+	}
+	return trace
+}
+
+// write appends the given stage traces to the trace file of the current boot
+func (t *stageTracer) write(fs vfs.FS, traces ...StageTrace) error {
+	bootID, _ := fs.ReadFile(constants.BootIDFile)
+	current := BootTrace{BootID: strings.TrimSpace(string(bootID))}
+
+	if prev, err := ReadBootTrace(fs, t.path); err == nil && prev.BootID == current.BootID {
+		current.Stages = prev.Stages
+	}
+	current.Stages = append(current.Stages, traces...)
+
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = utils.MkdirAll(fs, filepath.Dir(t.path), constants.DirPerm); err != nil {
+		return err
+	}
+	return fs.WriteFile(t.path, data, constants.FilePerm)
+}
+
+// ReadBootTrace reads the stage traces from the given trace file
+func ReadBootTrace(fs vfs.FS, path string) (*BootTrace, error) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	trace := &BootTrace{}
+	if err = json.Unmarshal(data, trace); err != nil {
+		return nil, fmt.Errorf("invalid trace file %s: %w", path, err)
+	}
+	return trace, nil
+}
+
+// SlowestSteps returns the steps of all stages sorted by duration, up to the given number of steps
+func (b BootTrace) SlowestSteps(limit int) []StepReport {
+	var steps []StepReport
+	for _, stage := range b.Stages {
+		for _, step := range stage.Steps {
+			if !step.Skipped {
+				steps = append(steps, StepReport{Stage: stage.Stage, StepTrace: step})
+			}
+		}
+	}
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Duration > steps[j].Duration
+	})
+	if limit > 0 && len(steps) > limit {
+		steps = steps[:limit]
+	}
+	return steps
+}
+
+// Failures returns the failed steps of all stages
+func (b BootTrace) Failures() []StepReport {
+	var steps []StepReport
+	for _, stage := range b.Stages {
+		for _, step := range stage.Steps {
+			if step.Failed() {
+				steps = append(steps, StepReport{Stage: stage.Stage, StepTrace: step})
+			}
+		}
+	}
+	return steps
+}
+
+// Duration returns the accumulated duration of all stages
+func (b BootTrace) Duration() time.Duration {
+	var total time.Duration
+	for _, stage := range b.Stages {
+		total += stage.Duration
+	}
+	return total
+}
+
+// StepReport is a step trace along with the stage it belongs to
+type StepReport struct {
+	StepTrace
+	Stage string
+}
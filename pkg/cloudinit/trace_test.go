@@ -0,0 +1,130 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	. "github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stage traces", Label("cloud-init", "trace"), func() {
+	var fs vfs.FS
+	var cleanup func()
+	var runner *mocks.FakeRunner
+	var cloudRunner *YipCloudInitRunner
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{
+			constants.BootIDFile: "boot-1\n",
+			"/oem/01_first.yaml": `name: first
+stages:
+  test:
+  - name: setup
+    commands:
+    - echo setup
+  - name: failing
+    commands:
+    - false
+`,
+			"/oem/02_second.yaml": `stages:
+  test:
+  - name: skipped
+    if: '[ -f /nonexisting ]'
+    commands:
+    - echo skipped
+  other:
+  - commands:
+    - echo other
+`,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		runner = mocks.NewFakeRunner()
+		runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+			command := strings.Join(args, " ")
+			if strings.Contains(command, "false") || strings.Contains(command, "/nonexisting") {
+				return []byte{}, fmt.Errorf("exit status 1")
+			}
+			return []byte{}, nil
+		}
+		logger := types.NewBufferLogger(bytes.NewBuffer(nil))
+		cloudRunner = NewYipCloudInitRunner(logger, runner, fs)
+		cloudRunner.SetTraceFile(constants.StageTraceFile)
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("records each stage, file and step", func() {
+		Expect(cloudRunner.Run("test", "/oem")).NotTo(Succeed())
+
+		trace, err := ReadBootTrace(fs, constants.StageTraceFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trace.BootID).To(Equal("boot-1"))
+		Expect(trace.Stages).To(HaveLen(1))
+
+		stage := trace.Stages[0]
+		Expect(stage.Stage).To(Equal("test"))
+		Expect(stage.Source).To(Equal("/oem"))
+		Expect(stage.Failed()).To(BeTrue())
+		Expect(stage.Steps).To(HaveLen(3))
+
+		steps := map[string]StepTrace{}
+		for _, step := range stage.Steps {
+			steps[step.Name] = step
+		}
+		Expect(steps["first.setup"].File).To(Equal("/oem/01_first.yaml"))
+		Expect(steps["first.setup"].Failed()).To(BeFalse())
+		Expect(steps["first.failing"].Failed()).To(BeTrue())
+		Expect(steps["/oem/02_second.yaml.skipped"].Skipped).To(BeTrue())
+
+		Expect(trace.Failures()).To(HaveLen(1))
+		Expect(trace.Failures()[0].Name).To(Equal("first.failing"))
+		Expect(trace.SlowestSteps(0)).To(HaveLen(2))
+		Expect(trace.SlowestSteps(1)).To(HaveLen(1))
+	})
+
+	It("appends the stages of the same boot and resets them on a new boot", func() {
+		Expect(cloudRunner.Run("other", "/oem", "stages:\n  other:\n  - commands:\n    - echo inline\n")).To(Succeed())
+		Expect(cloudRunner.Run("other", "/oem")).To(Succeed())
+
+		trace, err := ReadBootTrace(fs, constants.StageTraceFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trace.Stages).To(HaveLen(3))
+		Expect(trace.Stages[1].Source).To(Equal("<inline>"))
+
+		Expect(fs.WriteFile(constants.BootIDFile, []byte("boot-2\n"), constants.FilePerm)).To(Succeed())
+		Expect(cloudRunner.Run("other", "/oem")).To(Succeed())
+		trace, err = ReadBootTrace(fs, constants.StageTraceFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trace.BootID).To(Equal("boot-2"))
+		Expect(trace.Stages).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,489 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/yip/pkg/schema"
+	legacy "github.com/rancher/yip/pkg/schema/cloudinit"
+	"github.com/twpayne/go-vfs/v4"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// ValidationIssue is an issue found while validating a cloud-init file
+type ValidationIssue struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (i ValidationIssue) String() string {
+	switch {
+	case i.Line > 0 && i.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", i.File, i.Line, i.Column, i.Message)
+	case i.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+	default:
+		return fmt.Sprintf("%s: %s", i.File, i.Message)
+	}
+}
+
+// Validator checks cloud-init files against the yip schema and the elemental extensions
+// without running them
+type Validator struct {
+	fs      vfs.FS
+	runner  types.Runner
+	stages  []string
+	rootDir string
+}
+
+// ValidatorOptions configures a Validator
+type ValidatorOptions func(v *Validator)
+
+// WithStages adds the given stages to the stages known by elemental
+func WithStages(stages ...string) ValidatorOptions {
+	return func(v *Validator) {
+		v.stages = append(v.stages, stages...)
+	}
+}
+
+// WithRootDir checks the executables referenced by commands exist in the given root
+func WithRootDir(rootDir string) ValidatorOptions {
+	return func(v *Validator) {
+		v.rootDir = rootDir
+	}
+}
+
+// NewValidator returns a Validator of cloud-init files, the runner is used to check
+// the syntax of 'if' conditionals
+func NewValidator(fs vfs.FS, runner types.Runner, opts ...ValidatorOptions) *Validator {
+	v := &Validator{fs: fs, runner: runner, stages: constants.GetCloudInitStages()}
+	for _, o := range opts {
+		o(v)
+	}
+	return v
+}
+
+// validatedStep is a step of a validated file, kept to check the dependencies across files
+type validatedStep struct {
+	file  string
+	node  *yaml.Node
+	stage string
+	after []string
+}
+
+// Validate checks the given cloud-init files or directories, it returns the issues found sorted by file and line
+func (v Validator) Validate(paths ...string) ([]ValidationIssue, error) {
+	var issues []ValidationIssue
+	var steps []validatedStep
+	opNames := map[string]bool{}
+
+	for _, path := range paths {
+		if local, _ := utils.IsLocalURI(path); !local {
+			issues = append(issues, ValidationIssue{File: path, Message: "remote sources can't be validated offline"})
+			continue
+		}
+		files, err := v.findFiles(path)
+		if err != nil {
+			issues = append(issues, ValidationIssue{File: path, Message: err.Error()})
+			continue
+		}
+		for _, file := range files {
+			fileIssues, fileSteps, names := v.validateFile(file)
+			issues = append(issues, fileIssues...)
+			steps = append(steps, fileSteps...)
+			for _, name := range names {
+				opNames[name] = true
+			}
+		}
+	}
+
+	for _, step := range steps {
+		for _, dep := range step.after {
+			if !opNames[step.stage+"/"+dep] {
+				issues = append(issues, nodeIssue(step.file, stepKey(step.node, "after"), "unknown dependency '%s', it must be '<file or config name>.<step name>' of the same stage", dep))
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Line < issues[j].Line
+	})
+	return issues, nil
+}
+
+// findFiles returns the given file or the yaml files found under the given directory as yip loads them
+func (v Validator) findFiles(path string) ([]string, error) {
+	info, err := v.fs.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("file not found")
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = vfs.Walk(v.fs, path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(file)
+		if !info.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// validateFile checks a single cloud-init file, it returns the issues found, the steps with dependencies
+// and the names of the steps as referenced by other steps
+func (v Validator) validateFile(file string) (issues []ValidationIssue, steps []validatedStep, names []string) {
+	data, err := v.fs.ReadFile(file)
+	if err != nil {
+		return []ValidationIssue{{File: file, Message: err.Error()}}, nil, nil
+	}
+
+	if legacy.IsCloudConfig(string(data)) {
+		// Legacy cloud-config files are converted by yip, they are only checked to load
+		if _, err = schema.Load(string(data), v.fs, nil, nil); err != nil {
+			issues = append(issues, yamlIssue(file, err))
+		}
+		return issues, nil, nil
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return []ValidationIssue{yamlIssue(file, err)}, nil, nil
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return []ValidationIssue{nodeIssue(file, root, "expected a mapping with 'name' and 'stages' keys")}, nil, nil
+	}
+
+	rootName := file
+	var stages *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "name":
+			if value.Value != "" {
+				rootName = value.Value
+			}
+		case "stages":
+			stages = value
+		default:
+			issues = append(issues, nodeIssue(file, key, "unknown key '%s'", key.Value))
+		}
+	}
+	if stages == nil {
+		return issues, nil, nil
+	}
+	if stages.Kind != yaml.MappingNode {
+		return append(issues, nodeIssue(file, stages, "stages must be a mapping of stage names")), nil, nil
+	}
+
+	for i := 0; i+1 < len(stages.Content); i += 2 {
+		key, value := stages.Content[i], stages.Content[i+1]
+		if !v.knownStage(key.Value) {
+			issues = append(issues, nodeIssue(file, key, "unknown stage '%s'", key.Value))
+		}
+		if value.Kind != yaml.SequenceNode {
+			issues = append(issues, nodeIssue(file, value, "stage '%s' must be a list of steps", key.Value))
+			continue
+		}
+
+		var stageSteps []schema.Stage
+		for _, item := range value.Content {
+			issues = append(issues, walkSchema(file, item, reflect.TypeOf(schema.Stage{}), reflect.TypeOf(stageExtension{}))...)
+			var step schema.Stage
+			if item.Decode(&step) != nil {
+				// Type errors are already reported
+				continue
+			}
+			stageSteps = append(stageSteps, step)
+			issues = append(issues, v.validateStep(file, item, step)...)
+			if len(step.After) > 0 {
+				after := []string{}
+				for _, dep := range step.After {
+					after = append(after, dep.Name)
+				}
+				steps = append(steps, validatedStep{file: file, node: item, stage: key.Value, after: after})
+			}
+		}
+
+		// Step names as generated by yip
+		duplicated := checkDuplicatedSteps(stageSteps)
+		for j, step := range stageSteps {
+			name := step.Name
+			if duplicated {
+				name = fmt.Sprintf("%s.%d", step.Name, j)
+			}
+			if name == "" {
+				name = strconv.Itoa(j)
+			}
+			names = append(names, key.Value+"/"+rootName+"."+name)
+		}
+	}
+	return issues, steps, names
+}
+
+// validateStep checks the conditionals, the paths of the files written and the referenced executables of a step
+func (v Validator) validateStep(file string, node *yaml.Node, step schema.Stage) []ValidationIssue {
+	var issues []ValidationIssue
+	if step.Node != "" {
+		if _, err := regexp.Compile(step.Node); err != nil {
+			issues = append(issues, nodeIssue(file, stepKey(node, "node"), "invalid node regular expression: %s", err.Error()))
+		}
+	}
+	if step.If != "" && v.runner != nil {
+		if out, err := v.runner.Run("sh", "-n", "-c", step.If); err != nil {
+			issues = append(issues, nodeIssue(file, stepKey(node, "if"), "invalid if conditional: %s", strings.TrimSpace(string(out))))
+		}
+	}
+	issues = append(issues, validatePaths(file, node)...)
+	if v.rootDir != "" {
+		for _, command := range step.Commands {
+			fields := strings.Fields(command)
+			if len(fields) == 0 || !filepath.IsAbs(fields[0]) {
+				continue
+			}
+			if ok, _ := utils.Exists(v.fs, filepath.Join(v.rootDir, fields[0])); !ok {
+				issues = append(issues, nodeIssue(file, stepKey(node, "commands"), "command '%s' not found in %s", fields[0], v.rootDir))
+			}
+		}
+	}
+	return issues
+}
+
+// validatePaths checks the files, directories, downloads and fetch entries of a step set an absolute path,
+// downloads and fetch entries also require an url
+func validatePaths(file string, node *yaml.Node) []ValidationIssue {
+	var issues []ValidationIssue
+	for _, key := range []string{"files", "directories", "downloads", "fetch"} {
+		list := stepValue(node, key)
+		if list == nil || list.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, item := range list.Content {
+			if item.Kind != yaml.MappingNode {
+				// Type errors are already reported
+				continue
+			}
+			path := stepValue(item, "path")
+			switch {
+			case path == nil || path.Value == "":
+				issues = append(issues, nodeIssue(file, item, "%s entries require a path", key))
+			case !filepath.IsAbs(path.Value):
+				issues = append(issues, nodeIssue(file, path, "path '%s' must be absolute", path.Value))
+			}
+			if key != "downloads" && key != "fetch" {
+				continue
+			}
+			if url := stepValue(item, "url"); url == nil || url.Value == "" {
+				issues = append(issues, nodeIssue(file, item, "%s entries require an url", key))
+			}
+		}
+	}
+	return issues
+}
+
+// knownStage returns true if the given stage, or the stage it runs before or after, is known
+func (v Validator) knownStage(stage string) bool {
+	stage = strings.TrimSuffix(strings.TrimSuffix(stage, ".before"), ".after")
+	return slices.Contains(v.stages, stage)
+}
+
+// walkSchema checks the given node matches any of the given types. Struct fields are matched by their
+// yaml names, so unknown keys are reported, and scalars are decoded to report type errors.
+func walkSchema(file string, node *yaml.Node, types ...reflect.Type) []ValidationIssue {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return nil
+	}
+
+	var structs, others []reflect.Type
+	for _, t := range types {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if reflect.PointerTo(t).Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()) {
+			// Types with custom unmarshalling can't be checked
+			return nil
+		}
+		if t.Kind() == reflect.Struct {
+			structs = append(structs, t)
+		} else {
+			others = append(others, t)
+		}
+	}
+
+	var issues []ValidationIssue
+	switch {
+	case len(structs) > 0:
+		if node.Kind != yaml.MappingNode {
+			return []ValidationIssue{nodeIssue(file, node, "expected a mapping")}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			var fieldTypes []reflect.Type
+			for _, t := range structs {
+				if ft, ok := yamlFields(t)[key.Value]; ok {
+					fieldTypes = append(fieldTypes, ft)
+				}
+			}
+			if len(fieldTypes) == 0 {
+				issues = append(issues, nodeIssue(file, key, "unknown key '%s'", key.Value))
+				continue
+			}
+			issues = append(issues, walkSchema(file, value, fieldTypes...)...)
+		}
+	case len(others) == 0:
+	case others[0].Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return []ValidationIssue{nodeIssue(file, node, "expected a mapping")}
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			issues = append(issues, walkSchema(file, node.Content[i], elemTypes(others)...)...)
+		}
+	case others[0].Kind() == reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return []ValidationIssue{nodeIssue(file, node, "expected a list")}
+		}
+		for _, item := range node.Content {
+			issues = append(issues, walkSchema(file, item, elemTypes(others)...)...)
+		}
+	case others[0].Kind() == reflect.Interface:
+	default:
+		if err := node.Decode(reflect.New(others[0]).Interface()); err != nil {
+			msg := err.Error()
+			if e, ok := err.(*yaml.TypeError); ok && len(e.Errors) > 0 {
+				msg = e.Errors[0]
+			}
+			issues = append(issues, nodeIssue(file, node, "invalid value: %s", strings.TrimPrefix(yamlErrorLine.ReplaceAllString(msg, ""), ": ")))
+		}
+	}
+	return issues
+}
+
+// yamlFields returns the fields of the given struct type by their yaml key
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") && field.Type.Kind() == reflect.Struct {
+			for key, ft := range yamlFields(field.Type) {
+				fields[key] = ft
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// elemTypes returns the element types of the given map or slice types
+func elemTypes(types []reflect.Type) []reflect.Type {
+	var elems []reflect.Type
+	for _, t := range types {
+		if t.Kind() == reflect.Map || t.Kind() == reflect.Slice {
+			elems = append(elems, t.Elem())
+		}
+	}
+	return elems
+}
+
+// stepKey returns the key node of the given step key, or the step node if not found
+func stepKey(step *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(step.Content); i += 2 {
+		if step.Content[i].Value == key {
+			return step.Content[i]
+		}
+	}
+	return step
+}
+
+// stepValue returns the value node of the given key of a mapping node, nil if not found
+func stepValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// checkDuplicatedSteps returns true if any step name is repeated, yip then adds the step index to the names
+func checkDuplicatedSteps(steps []schema.Stage) bool {
+	seen := map[string]bool{}
+	for _, step := range steps {
+		if seen[step.Name] {
+			return true
+		}
+		seen[step.Name] = true
+	}
+	return false
+}
+
+// nodeIssue returns an issue located at the given node
+func nodeIssue(file string, node *yaml.Node, format string, args ...interface{}) ValidationIssue {
+	return ValidationIssue{File: file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+// yamlIssue returns an issue from a yaml parsing error, located at the line reported by the parser
+func yamlIssue(file string, err error) ValidationIssue {
+	issue := ValidationIssue{File: file, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+	if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		issue.Line, _ = strconv.Atoi(match[1])
+		issue.Message = strings.TrimPrefix(yamlErrorLine.ReplaceAllString(issue.Message, ""), ": ")
+	}
+	return issue
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit_test
+
+import (
+	"fmt"
+
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	. "github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
+	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func issueStrings(issues []ValidationIssue) []string {
+	var strs []string
+	for _, issue := range issues {
+		strs = append(strs, issue.String())
+	}
+	return strs
+}
+
+var _ = Describe("Validator", Label("cloud-init", "validate"), func() {
+	var runner *mocks.FakeRunner
+	var cleanup func()
+	var validator *Validator
+
+	newValidator := func(files map[string]interface{}) {
+		fs, clean, err := vfst.NewTestFS(files)
+		Expect(err).ToNot(HaveOccurred())
+		cleanup = clean
+		validator = NewValidator(fs, runner, WithStages("custom"), WithRootDir("/root"))
+	}
+
+	BeforeEach(func() {
+		runner = mocks.NewFakeRunner()
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("accepts valid files with elemental extensions", func() {
+		newValidator(map[string]interface{}{
+			"/root/usr/bin/true": "",
+			"/oem/01_valid.yaml": `name: valid
+stages:
+  initramfs:
+  - name: setup
+    if: '[ -f /run/cos/active_mode ]'
+    node: "^node[0-9]+$"
+    commands:
+    - /usr/bin/true
+    layout:
+      device:
+        label: COS_OEM
+      add_partitions:
+      - fsLabel: DATA
+        size: 100
+        start: 2048
+    kernel_args:
+      all: console=ttyS0
+  - name: after
+    after:
+    - name: valid.setup
+  custom:
+  - fetch:
+    - url: https://example.com/file
+      path: /oem/file
+`,
+		})
+		issues, err := validator.Validate("/oem")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(issues).To(BeEmpty())
+		Expect(runner.IncludesCmds([][]string{{"sh", "-n", "-c", "[ -f /run/cos/active_mode ]"}})).To(Succeed())
+	})
+
+	It("reports unknown keys, stages and type errors with their lines", func() {
+		newValidator(map[string]interface{}{
+			"/oem/invalid.yaml": `name: invalid
+stages:
+  initramfs:
+  - name: step
+    commandz:
+    - echo
+    layout:
+      add_partitions:
+      - fsLabel: DATA
+        size: big
+  unknown:
+  - name: other
+`,
+		})
+		issues, err := validator.Validate("/oem/invalid.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(issueStrings(issues)).To(Equal([]string{
+			"/oem/invalid.yaml:5:5: unknown key 'commandz'",
+			"/oem/invalid.yaml:10:15: invalid value: cannot unmarshal !!str `big` into uint",
+			"/oem/invalid.yaml:11:3: unknown stage 'unknown'",
+		}))
+	})
+
+	It("reports invalid conditionals, dependencies and missing commands", func() {
+		runner.SideEffect = func(cmd string, _ ...string) ([]byte, error) {
+			if cmd == "sh" {
+				return []byte("sh: syntax error: unexpected end of file"), fmt.Errorf("exit status 2")
+			}
+			return []byte{}, nil
+		}
+		newValidator(map[string]interface{}{
+			"/oem/invalid.yaml": `stages:
+  boot:
+  - name: step
+    node: "node[0-9"
+    if: '[ -f /foo'
+    commands:
+    - /usr/bin/missing --flag
+    after:
+    - name: unknown.step
+`,
+		})
+		issues, err := validator.Validate("/oem/invalid.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(issueStrings(issues)).To(ConsistOf(
+			"/oem/invalid.yaml:8:5: unknown dependency 'unknown.step', it must be '<file or config name>.<step name>' of the same stage",
+			"/oem/invalid.yaml:4:5: invalid node regular expression: error parsing regexp: missing closing ]: `[0-9`",
+			"/oem/invalid.yaml:5:5: invalid if conditional: sh: syntax error: unexpected end of file",
+			"/oem/invalid.yaml:6:5: command '/usr/bin/missing' not found in /root",
+		))
+	})
+
+	It("reports relative and missing paths of the files written", func() {
+		newValidator(map[string]interface{}{
+			"/oem/paths.yaml": `stages:
+  boot:
+  - name: step
+    files:
+    - path: etc/motd
+      content: welcome
+    directories:
+    - permissions: 0755
+    downloads:
+    - path: /etc/file
+    fetch:
+    - url: https://example.com/file
+      path: var/file
+`,
+		})
+		issues, err := validator.Validate("/oem/paths.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(issueStrings(issues)).To(ConsistOf(
+			"/oem/paths.yaml:5:13: path 'etc/motd' must be absolute",
+			"/oem/paths.yaml:8:7: directories entries require a path",
+			"/oem/paths.yaml:10:7: downloads entries require an url",
+			"/oem/paths.yaml:13:13: path 'var/file' must be absolute",
+		))
+	})
+
+	It("reports syntax errors and missing files", func() {
+		newValidator(map[string]interface{}{
+			"/oem/broken.yaml": "stages:\n  boot:\n  - name: [\n",
+		})
+		issues, err := validator.Validate("/oem/broken.yaml", "/oem/missing.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(issues).To(HaveLen(2))
+		Expect(issues[0].File).To(Equal("/oem/broken.yaml"))
+		Expect(issues[0].Line).To(Equal(3))
+		Expect(issues[1].String()).To(Equal("/oem/missing.yaml: file not found"))
+	})
+})
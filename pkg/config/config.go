@@ -24,6 +24,7 @@ import (
 
 	"github.com/twpayne/go-vfs/v4"
 
+	"github.com/rancher/elemental-toolkit/v2/pkg/bootloader"
 	"github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	"github.com/rancher/elemental-toolkit/v2/pkg/features"
@@ -152,7 +153,11 @@ func NewConfig(opts ...GenericOptions) *types.Config {
 	// at the start of NewRunConfig, as WithLogger can be passed on init, and that would result in 2 different logger
 	// instances, on the config.Logger and the other on config.CloudInitRunner
 	if c.CloudInitRunner == nil {
-		c.CloudInitRunner = cloudinit.NewYipCloudInitRunner(c.Logger, c.Runner, vfs.OSFS)
+		c.CloudInitRunner = cloudinit.NewYipCloudInitRunner(
+			c.Logger, c.Runner, vfs.OSFS,
+			cloudinit.WithHTTPClient(c.Client),
+			cloudinit.WithBootloader(bootloader.NewGrub(c)),
+		)
 	}
 
 	if c.Mounter == nil {
@@ -208,6 +213,13 @@ func NewInstallSpec(cfg types.Config) *types.InstallSpec {
 	}
 }
 
+// NewAutoInstallSpec returns an AutoInstallSpec struct all based on defaults
+func NewAutoInstallSpec() *types.AutoInstallSpec {
+	return &types.AutoInstallSpec{
+		Countdown: constants.AutoInstallCountdown,
+	}
+}
+
 // NewInitSpec returns an InitSpec struct all based on defaults
 func NewInitSpec() *types.InitSpec {
 	return &types.InitSpec{
@@ -228,14 +240,30 @@ func NewMountSpec(cfg types.Config) *types.MountSpec {
 		selinuxRelabel = true
 	}
 
+	var persistentEnc *types.VolumeEncryption
+	oemDev := fmt.Sprintf("PARTLABEL=%s", constants.OEMPartName)
+	persistentDev := fmt.Sprintf("PARTLABEL=%s", constants.PersistentPartName)
+	state, _ := cfg.LoadInstallState()
+	if state != nil && state.Partitions[constants.PersistentPartName] != nil {
+		persistentEnc = state.Partitions[constants.PersistentPartName].Encryption
+		if state.Partitions[constants.PersistentPartName].RAIDDevice != "" {
+			persistentDev = state.Partitions[constants.PersistentPartName].RAIDDevice
+		}
+	}
+	// Partitions on mirrored disks are mounted from their RAID array
+	if state != nil && state.Partitions[constants.OEMPartName] != nil && state.Partitions[constants.OEMPartName].RAIDDevice != "" {
+		oemDev = state.Partitions[constants.OEMPartName].RAIDDevice
+	}
+
 	return &types.MountSpec{
 		Sysroot:        "/sysroot",
 		WriteFstab:     true,
+		Output:         constants.FstabOutput,
 		SelinuxRelabel: selinuxRelabel,
 		Volumes: []*types.VolumeMount{
 			{
 				Mountpoint: constants.OEMPath,
-				Device:     fmt.Sprintf("PARTLABEL=%s", constants.OEMPartName),
+				Device:     oemDev,
 				Options:    []string{"rw", "defaults"},
 			}, {
 				Mountpoint: constants.BootDir,
@@ -246,15 +274,17 @@ func NewMountSpec(cfg types.Config) *types.MountSpec {
 		Ephemeral: types.EphemeralMounts{
 			Type:  constants.Tmpfs,
 			Size:  "25%",
-			Paths: []string{"/var", "/etc", "/srv"},
+			Paths: types.NewEphemeralPaths("/var", "/etc", "/srv"),
 		},
 		Persistent: types.PersistentMounts{
-			Mode:  constants.OverlayMode,
-			Paths: []string{"/etc/systemd", "/etc/ssh", "/home", "/opt", "/root", "/var/log"},
+			Mode:      constants.OverlayMode,
+			Paths:     types.NewPersistentPaths("/etc/systemd", "/etc/ssh", "/home", "/opt", "/root", "/var/log"),
+			Reconcile: true,
 			Volume: types.VolumeMount{
 				Mountpoint: constants.PersistentDir,
-				Device:     fmt.Sprintf("PARTLABEL=%s", constants.PersistentPartName),
+				Device:     persistentDev,
 				Options:    []string{"rw", "defaults"},
+				Encryption: persistentEnc,
 			},
 		},
 	}
@@ -459,6 +489,11 @@ func NewResetSpec(cfg types.Config) (*types.ResetSpec, error) {
 			ep.Persistent.MountPoint = constants.PersistentDir
 		}
 		ep.Persistent.Name = constants.PersistentPartName
+		// An encrypted persistent partition does not expose its filesystem
+		if ep.Persistent.FS == constants.LuksFs {
+			ep.Persistent.FS = constants.LinuxFs
+			ep.Persistent.Encryption = &types.Encryption{}
+		}
 	} else {
 		cfg.Logger.Warnf("no Persistent partition found")
 	}
@@ -484,6 +519,23 @@ func NewResetSpec(cfg types.Config) (*types.ResetSpec, error) {
 	}, nil
 }
 
+// NewRepartitionSpec returns a RepartitionSpec targeting the disk of the current installation, if found
+func NewRepartitionSpec(cfg types.Config) *types.RepartitionSpec {
+	spec := &types.RepartitionSpec{}
+
+	parts, err := utils.GetAllPartitions()
+	if err != nil {
+		cfg.Logger.Warnf("could not read host partitions: %v", err)
+		return spec
+	}
+	installState, _ := cfg.LoadInstallState()
+	ep := types.NewElementalPartitionsFromList(parts, installState)
+	if ep.State != nil {
+		spec.Target = ep.State.Disk
+	}
+	return spec
+}
+
 func NewDiskElementalPartitions(workdir string) types.ElementalPartitions {
 	partitions := types.ElementalPartitions{}
 
@@ -556,12 +608,17 @@ func NewDisk(cfg *types.BuildConfig) *types.DiskSpec {
 	)
 
 	return &types.DiskSpec{
+		Firmware:       types.EFI,
 		Partitions:     NewDiskElementalPartitions(workdir),
 		GrubConf:       filepath.Join(constants.GrubCfgPath, constants.GrubCfg),
 		System:         types.NewEmptySrc(),
 		RecoverySystem: recoveryImg,
 		Type:           constants.RawType,
 		DeployCmd:      []string{"elemental", "--debug", "reset", "--reboot"},
+		VM: types.VMSpec{
+			CPUs:   constants.DefaultVMCPUs,
+			Memory: constants.DefaultVMMemory,
+		},
 	}
 }
 
@@ -577,6 +634,13 @@ func NewISO() *types.LiveISO {
 	}
 }
 
+func NewNetboot() *types.Netboot {
+	return &types.Netboot{
+		GrubEntry:    constants.GrubDefEntry,
+		ExtraCmdline: constants.ISODefaultExtraCmdline,
+	}
+}
+
 func NewBuildConfig(opts ...GenericOptions) *types.BuildConfig {
 	b := &types.BuildConfig{
 		Config:      *NewConfig(opts...),
@@ -42,6 +42,7 @@ const (
 	SquashFs           = "squashfs"
 	BootFs             = "vfat"
 	Btrfs              = "btrfs"
+	LuksFs             = "crypto_LUKS"
 	BiosFs             = ""
 	MinPartSize        = uint(64)
 	BootSize           = MinPartSize
@@ -65,6 +66,38 @@ const (
 	Block              = "block"
 	EfivarsMountPath   = "/sys/firmware/efi/efivars"
 
+	// Output formats of the mount command
+	FstabOutput        = "fstab"
+	SystemdUnitsOutput = "systemd-units"
+	SystemdUnitDir     = "/etc/systemd/system"
+
+	// Mount option enabling project quotas on xfs and ext4 filesystems
+	PrjQuotaOpt = "prjquota"
+
+	// LUKS encryption constants
+	DevMapperDir      = "/dev/mapper"
+	SystemdCryptsetup = "/usr/lib/systemd/systemd-cryptsetup"
+	DefaultTPMPCRs    = "7"
+	// Key files in this directory are embedded into the initrd to unlock volumes at boot
+	InitrdKeysDir = "/etc/elemental/keys"
+
+	// dm-verity hash tree file suffix, hash trees are stored next to the image file
+	VerityHashSuffix = ".verity"
+
+	// mdraid constants, arrays use metadata 1.0 so members are also readable by firmware and bootloader
+	RAIDDevDir        = "/dev/md"
+	RAIDMetadata      = "1.0"
+	LinuxRAIDTypeGUID = "A19D880F-05FC-4D3B-A006-743F0F84911E"
+	// EFI partitions on mirrored disks are recorded by their GPT partition UUID
+	DiskByPartUUIDDir = "/dev/disk/by-partuuid"
+
+	// Verified copies of remote cloud-init configs, kept for offline boots
+	CloudInitCacheDir = "/oem/.cloud-init-cache"
+
+	// Stage traces of the current boot, identified by the kernel boot ID
+	StageTraceFile = "/run/elemental/stages.json"
+	BootIDFile     = "/proc/sys/kernel/random/boot_id"
+
 	// Maxium number of nested symlinks to resolve
 	MaxLinkDepth = 4
 
@@ -90,7 +123,17 @@ const (
 	GrubFallback           = "default_fallback"
 	GrubPassiveSnapshots   = "passive_snaps"
 	GrubActiveSnapshot     = "active_snap"
+	GrubActiveVerity       = "verity_active"
+	GrubRecoveryVerity     = "verity_recovery"
+	GrubSnapshotVerity     = "verity_snap_%d"
+	GrubRAIDCmdline        = "raid_cmdline"
 	ElementalBootloaderBin = "/usr/lib/elemental/bootloader"
+	GrubBIOSPath           = "/grub2"
+	GrubBIOSTarget         = "i386-pc"
+	GrubBIOSBootImg        = "boot.img"
+	GrubBIOSCoreImg        = "core.img"
+	GrubBIOSHybridImg      = "boot_hybrid.img"
+	GrubBIOSEltoritoImg    = "eltorito.img"
 
 	// Mountpoints or links to images and partitions
 	RunElementalBuildLink = "/run/elemental-build"
@@ -105,6 +148,7 @@ const (
 	WorkingImgDir         = "/run/elemental/workingtree"
 	WorkingImgBuildLink   = RunElementalBuildLink + "/workingtree"
 	OverlayDir            = "/run/elemental/overlay"
+	OverlayLowerDir       = "/run/elemental/lower"
 	PersistentStateDir    = ".state"
 	RunningStateDir       = "/run/initramfs/elemental-state" // TODO: converge this constant with StateDir/RecoveryDir when moving to elemental-rootfs as default rootfs feature.
 
@@ -156,6 +200,10 @@ const (
 	ISOEFIImg              = "uefi.img"
 	ISOLabel               = "COS_LIVE"
 	ISOCloudInitPath       = LiveDir + "/iso-config"
+	ISOAutoInstallDir      = "autoinstall"
+	ISOAutoInstallPath     = LiveDir + "/" + ISOAutoInstallDir
+	ISOAutoInstallCfgFile  = "90_autoinstall.yaml"
+	AutoInstallCountdown   = 10
 	ISODefaultExtraCmdline = "security=selinux enforcing=0 console=tty1 console=ttyS0"
 
 	MountLayoutPath = "/run/elemental/mount-layout.env"
@@ -165,6 +213,26 @@ const (
 	RawType     = "raw"
 	AzureType   = "azure"
 	GCEType     = "gce"
+	QCOW2Type   = "qcow2"
+	VMDKType    = "vmdk"
+	OVAType     = "ova"
+	VHDType     = "vhd"
+	VHDXType    = "vhdx"
+
+	// Board profiles of disk images, Raspberry Pi boots U-Boot from the FAT firmware partition
+	RPiBoard     = "rpi"
+	RPiBootSize  = uint(256)
+	RPiConfigTxt = "config.txt"
+	UBootBin     = "u-boot.bin"
+
+	// Virtual machine defaults of appliance disk images, memory in MiB
+	DefaultVMCPUs   = 2
+	DefaultVMMemory = 4096
+
+	// Compression types of disk images
+	NoCompression   = "none"
+	ZlibCompression = "zlib"
+	ZstdCompression = "zstd"
 
 	// Default directory and file fileModes
 	DirPerm        = os.ModeDir | os.ModePerm
@@ -288,10 +356,54 @@ func GetDefaultGrubModulesPatterns() []string {
 	}
 }
 
+// GetGrubBIOSModulesPatterns returns the patterns of the grub modules directory for legacy BIOS
+func GetGrubBIOSModulesPatterns() []string {
+	return []string{
+		"/usr/share/grub2/" + GrubBIOSTarget,
+		"/usr/lib/grub/" + GrubBIOSTarget,
+		"/boot/grub2/" + GrubBIOSTarget,
+	}
+}
+
+// GetRPiFirmwarePatterns returns the patterns of the Raspberry Pi firmware files in the OS image
+func GetRPiFirmwarePatterns() []string {
+	return []string{
+		"/boot/vc/start*.elf",
+		"/usr/lib/raspi-firmware/start*.elf",
+	}
+}
+
+// GetUBootRPiPatterns returns the patterns of the U-Boot binary for Raspberry Pi boards in the OS image
+func GetUBootRPiPatterns() []string {
+	return []string{
+		"/boot/vc/" + UBootBin,
+		"/usr/lib/u-boot/rpi_arm64/" + UBootBin,
+		"/usr/share/uboot/rpi_arm64/" + UBootBin,
+	}
+}
+
+// GetRPiDefaultConfig returns the Raspberry Pi firmware configuration used if the OS image
+// does not provide one. It boots U-Boot in 64 bit mode, which then chains to grub over EFI.
+func GetRPiDefaultConfig() string {
+	return "arm_64bit=1\nenable_uart=1\nkernel=" + UBootBin + "\n"
+}
+
 func GetCloudInitPaths() []string {
 	return []string{"/system/oem", "/oem/", "/usr/local/cloud-config/"}
 }
 
+// GetCloudInitStages returns the stages run by elemental on boot and on its actions,
+// each of them also runs its '.before' and '.after' stages
+func GetCloudInitStages() []string {
+	return []string{
+		"default", "pre-rootfs", "rootfs", "initramfs", "fs", "network", "boot", "reconcile",
+		BeforeInstallHook, PostInstallHook, AfterInstallChrootHook, AfterInstallHook,
+		BeforeResetHook, PostResetHook, AfterResetChrootHook, AfterResetHook,
+		BeforeUpgradeHook, PostUpgradeHook, AfterUpgradeChrootHook, AfterUpgradeHook,
+		BeforeDiskHook, PostDiskHook, AfterDiskChrootHook, AfterDiskHook,
+	}
+}
+
 func GetSquashfsNoCompressionOptions() []string {
 	return []string{"-no-compression"}
 }
@@ -326,6 +438,7 @@ func GetMountKeyEnvMap() map[string]string {
 	return map[string]string{
 		"write-fstab": "WRITE_FSTAB",
 		"sysroot":     "SYSROOT",
+		"output":      "OUTPUT",
 	}
 }
 
@@ -359,6 +472,13 @@ func GetResetKeyEnvMap() map[string]string {
 	}
 }
 
+// GetRepartitionKeyEnvMap returns environment variable bindings to RepartitionSpec data
+func GetRepartitionKeyEnvMap() map[string]string {
+	return map[string]string{
+		"target": "TARGET",
+	}
+}
+
 // GetUpgradeKeyEnvMap returns environment variable bindings to UpgradeSpec data
 func GetUpgradeKeyEnvMap() map[string]string {
 	return map[string]string{
@@ -382,6 +502,20 @@ func GetISOKeyEnvMap() map[string]string {
 	return map[string]string{}
 }
 
+// GetNetbootKeyEnvMap returns environment variable bindings to Netboot data
+func GetNetbootKeyEnvMap() map[string]string {
+	// None for the time being
+	return map[string]string{}
+}
+
+// GetAutoInstallKeyEnvMap returns environment variable bindings to AutoInstallSpec data
+func GetAutoInstallKeyEnvMap() map[string]string {
+	return map[string]string{
+		"countdown": "COUNTDOWN",
+		"overwrite": "OVERWRITE",
+	}
+}
+
 // GetDiskKeyEnvMap returns environment variable bindings to RawDisk data
 func GetDiskKeyEnvMap() map[string]string {
 	// None for the time being
@@ -42,65 +42,182 @@ func FormatPartition(c types.Config, part *types.Partition, opts ...string) erro
 
 // PartitionAndFormatDevice creates a new empty partition table on target disk
 // and applies the configured disk layout by creating and formatting all
-// required partitions
+// required partitions. If mirror targets are configured the same layout is applied
+// on each of them, firmware partitions are replicated and the rest are assembled as RAID1 arrays.
 func PartitionAndFormatDevice(c types.Config, i *types.InstallSpec) error {
-	disk := partitioner.NewDisk(
-		i.Target,
+	parts := i.Partitions.PartitionsByLayout(i.PartitionOrder, i.ExtraPartitions)
+	targets := append([]string{i.Target}, i.MirrorTargets...)
+
+	diskOpts := []partitioner.DiskOptions{
 		partitioner.WithRunner(c.Runner),
 		partitioner.WithFS(c.Fs),
 		partitioner.WithLogger(c.Logger),
 		partitioner.WithMounter(c.Mounter),
-	)
+	}
+	// Minimal installer images might not include parted, GPT tables can be written natively
+	if i.PartTable == types.GPT && !c.Runner.CommandExists("parted") {
+		c.Logger.Infof("parted not found, using the native GPT partitioner")
+		diskOpts = append(diskOpts, partitioner.WithNative())
+	}
+
+	for t, target := range targets {
+		disk := partitioner.NewDisk(target, diskOpts...)
+
+		if !disk.Exists() {
+			c.Logger.Errorf("Disk %s does not exist", target)
+			return fmt.Errorf("disk %s does not exist", target)
+		}
+
+		c.Logger.Infof("Partitioning device %s...", target)
+		out, err := disk.NewPartitionTable(i.PartTable)
+		if err != nil {
+			c.Logger.Errorf("Failed creating new partition table: %s", out)
+			return err
+		}
+
+		if len(targets) == 1 {
+			return createPartitions(c, disk, parts)
+		}
 
-	if !disk.Exists() {
-		c.Logger.Errorf("Disk %s does not exist", i.Target)
-		return fmt.Errorf("disk %s does not exist", i.Target)
+		for _, part := range parts {
+			partDev, err := createPartition(c, disk, part, t == 0, !isFirmwarePartition(i.Partitions, part))
+			if err != nil {
+				return err
+			}
+			part.Mirrors = append(part.Mirrors, partDev)
+		}
 	}
 
-	c.Logger.Infof("Partitioning device...")
-	out, err := disk.NewPartitionTable(i.PartTable)
-	if err != nil {
-		c.Logger.Errorf("Failed creating new partition table: %s", out)
-		return err
+	for _, part := range parts {
+		if isFirmwarePartition(i.Partitions, part) {
+			for _, partDev := range part.Mirrors {
+				if err := formatPartition(c, part, partDev); err != nil {
+					return err
+				}
+			}
+			part.Path = part.Mirrors[0]
+			continue
+		}
+		if err := CreateRAIDArray(c, part); err != nil {
+			c.Logger.Errorf("Failed creating RAID array for partition %s", part.Name)
+			return err
+		}
+		if err := encryptAndFormatPartition(c, part, part.RAID.Device); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// isFirmwarePartition checks if the given partition is read by the firmware, hence it can't be part of a RAID array
+func isFirmwarePartition(ep types.ElementalPartitions, part *types.Partition) bool {
+	return part == ep.Boot || part == ep.BIOS
+}
 
-	parts := i.Partitions.PartitionsByInstallOrder(i.ExtraPartitions)
-	return createPartitions(c, disk, parts)
+// partitionOptions returns the partitioner options matching the layout settings of the given partition
+func partitionOptions(part *types.Partition) ([]partitioner.PartitionOptions, error) {
+	attrs, err := part.GPTAttributes()
+	if err != nil {
+		return nil, err
+	}
+	return []partitioner.PartitionOptions{
+		partitioner.WithPartitionFlags(part.Flags...),
+		partitioner.WithPartitionStart(part.Start),
+		partitioner.WithPartitionAlignment(part.Align),
+		partitioner.WithPartitionType(part.TypeGUID),
+		partitioner.WithPartitionUUID(part.UUID),
+		partitioner.WithPartitionAttributes(attrs...),
+	}, nil
 }
 
-func createAndFormatPartition(c types.Config, disk *partitioner.Disk, part *types.Partition) error {
+// createPartition adds the given partition to the disk and returns its device. The partition UUID is only
+// set on the primary disk as it is expected to be unique. RAID members are flagged with the Linux RAID
+// partition type unless a custom type is given.
+func createPartition(
+	c types.Config, disk *partitioner.Disk, part *types.Partition, primary, raid bool, extraOpts ...partitioner.PartitionOptions,
+) (string, error) {
 	c.Logger.Debugf("Adding partition %s", part.Name)
-	num, err := disk.AddPartition(part.Size, part.FS, part.Name, part.Flags...)
+	opts, err := partitionOptions(part)
+	if err != nil {
+		return "", err
+	}
+	opts = append(opts, extraOpts...)
+	if !primary {
+		opts = append(opts, partitioner.WithPartitionUUID(""))
+	}
+	if raid && part.TypeGUID == "" {
+		opts = append(opts, partitioner.WithPartitionType(cnst.LinuxRAIDTypeGUID))
+	}
+	num, err := disk.AddPartition(part.Size, part.FS, part.Name, opts...)
 	if err != nil {
 		c.Logger.Errorf("Failed creating %s partition", part.Name)
+		return "", err
+	}
+	return disk.FindPartitionDevice(num)
+}
+
+// CreateAndFormatPartition adds the given partition to the disk, right after the last existing partition
+// unless a start is set, and sets up its encryption and filesystem
+func CreateAndFormatPartition(c types.Config, disk *partitioner.Disk, part *types.Partition) error {
+	partDev, err := createPartition(c, disk, part, true, false)
+	if err != nil {
 		return err
 	}
-	partDev, err := disk.FindPartitionDevice(num)
+	return encryptAndFormatPartition(c, part, partDev)
+}
+
+// encryptAndFormatPartition sets up the encryption, if any, and formats the given partition device.
+// The partition path is updated to the device holding the filesystem.
+func encryptAndFormatPartition(c types.Config, part *types.Partition, partDev string) error {
+	if part.Encryption != nil {
+		part.Path = partDev
+		err := EncryptPartition(c, part)
+		if err != nil {
+			c.Logger.Errorf("Failed encrypting partition %s", part.Name)
+			return err
+		}
+		partDev = part.Path
+	}
+	err := formatPartition(c, part, partDev)
 	if err != nil {
 		return err
 	}
+	part.Path = partDev
+	return nil
+}
+
+// formatPartition formats the given partition device, partitions without filesystem are wiped
+func formatPartition(c types.Config, part *types.Partition, partDev string) error {
 	if part.FS != "" {
 		c.Logger.Debugf("Formatting partition with label %s", part.FilesystemLabel)
-		err = partitioner.FormatDevice(c.Runner, partDev, part.FS, part.FilesystemLabel)
+		err := partitioner.FormatDevice(c.Runner, partDev, part.FS, part.FilesystemLabel)
 		if err != nil {
 			c.Logger.Errorf("Failed formatting partition %s", part.Name)
 			return err
 		}
 	} else {
 		c.Logger.Debugf("Wipe file system on %s", part.Name)
-		err = disk.WipeFsOnPartition(partDev)
+		_, err := c.Runner.Run("wipefs", "--all", partDev)
 		if err != nil {
 			c.Logger.Errorf("Failed to wipe filesystem of partition %s", partDev)
 			return err
 		}
 	}
-	part.Path = partDev
 	return nil
 }
 
 func createPartitions(c types.Config, disk *partitioner.Disk, parts types.PartitionList) error {
-	for _, part := range parts {
-		err := createAndFormatPartition(c, disk, part)
+	for i, part := range parts {
+		var opts []partitioner.PartitionOptions
+		// MSDOS tables only hold four primary partitions, from the fourth on partitions are logical
+		if disk.GetLabel() == types.MSDOS && len(parts) > 4 && i >= 3 {
+			opts = append(opts, partitioner.WithLogicalPartition())
+		}
+		partDev, err := createPartition(c, disk, part, true, false, opts...)
+		if err != nil {
+			return err
+		}
+		err = encryptAndFormatPartition(c, part, partDev)
 		if err != nil {
 			return err
 		}
@@ -320,17 +437,25 @@ func CreateFileSystemImage(c types.Config, img *types.Image, rootDir string, pre
 	}
 
 	extraOpts := []string{}
-	r := regexp.MustCompile("ext[2-4]")
-	match := r.MatchString(img.FS)
-	if preload && match {
+	extFS := regexp.MustCompile("ext[2-4]").MatchString(img.FS)
+	fatFS := regexp.MustCompile("fat|vfat").MatchString(img.FS)
+	switch {
+	case !preload:
+	case extFS:
 		extraOpts = []string{"-d", rootDir}
-	}
-	if preload && !match {
-		c.Logger.Errorf("Preloaded filesystem images are only supported for ext2-4 filesystems")
+	case img.FS == cnst.Btrfs:
+		extraOpts = []string{"--rootdir", rootDir}
+	case fatFS:
+		// FAT images are preloaded with mtools once formatted
+	default:
+		c.Logger.Errorf("Preloaded filesystem images are only supported for ext2-4, btrfs and fat filesystems")
 		return fmt.Errorf("unexpected filesystem: %s", img.FS)
 	}
 	mkfs := partitioner.NewMkfsCall(img.File, img.FS, img.Label, c.Runner, extraOpts...)
 	_, err = mkfs.Apply()
+	if err == nil && preload && fatFS {
+		err = copyTreeToFatImage(c, img.File, rootDir)
+	}
 	if err != nil {
 		c.Logger.Errorf("failed formatting file %s with %s", img.File, img.FS)
 		_ = c.Fs.RemoveAll(img.File)
@@ -339,10 +464,28 @@ func CreateFileSystemImage(c types.Config, img *types.Image, rootDir string, pre
 	return nil
 }
 
+// copyTreeToFatImage copies the content of the given root tree into the root of the given FAT image using mtools
+func copyTreeToFatImage(c types.Config, file, rootDir string) error {
+	entries, err := c.Fs.ReadDir(rootDir)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	args := []string{"-s", "-p", "-n", "-o", "-i", file}
+	for _, entry := range entries {
+		args = append(args, filepath.Join(rootDir, entry.Name()))
+	}
+	_, err = c.Runner.Run("mcopy", append(args, "::")...)
+	return err
+}
+
 // CreateImageFromTree creates the given image including the given root tree. If preload flag is true
 // it attempts to preload the root tree at filesystem format time. This allows creating images with the
-// given root tree without the need of mounting them.
+// given root tree without the need of mounting them, which is always the case for rootless configurations.
+// If the image has verity enabled a dm-verity hash tree is also created and the image root hash is updated.
 func CreateImageFromTree(c types.Config, img *types.Image, rootDir string, preload bool, cleaners ...func() error) (err error) {
+	// Images can't be mounted without root privileges, so they are always preloaded
+	preload = preload || c.Rootless
+
 	defer func() {
 		for _, cleaner := range cleaners {
 			if cleaner == nil {
@@ -355,6 +498,13 @@ func CreateImageFromTree(c types.Config, img *types.Image, rootDir string, prelo
 		}
 	}()
 
+	// The hash tree is computed once the image is already unmounted and no further changes are expected
+	defer func() {
+		if err == nil && img.Verity {
+			err = CreateVerityHashTree(c, img)
+		}
+	}()
+
 	if img.FS == cnst.SquashFs {
 		c.Logger.Infof("Creating squashfs image for file %s", img.File)
 
@@ -22,6 +22,7 @@ import (
 	iofs "io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -33,6 +34,7 @@ import (
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	"github.com/rancher/elemental-toolkit/v2/pkg/elemental"
 	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	"github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
 )
@@ -479,6 +481,32 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 				Expect(runner.MatchMilestones(append(efiPartCmds, partCmds...))).To(BeNil())
 			})
 
+			It("Successfully creates partitions following a declarative layout", func() {
+				install.PartTable = types.GPT
+				install.Firmware = types.EFI
+				install.Partitions.SetFirmwarePartitions(types.EFI, types.GPT)
+				install.ExtraPartitions = types.PartitionList{{
+					Name: "firmware", Size: 4, Start: 8,
+					TypeGUID: "21686148-6449-6E6F-744E-656564454649", Attributes: []string{"required"},
+				}}
+				install.PartitionOrder = []string{"firmware"}
+				install.Partitions.Boot.UUID = "7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f"
+				Expect(elemental.PartitionAndFormatDevice(*config, install)).To(BeNil())
+				Expect(runner.MatchMilestones([][]string{
+					{
+						"parted", "--script", "--machine", "--", "/some/device", "unit", "s",
+						"mkpart", "firmware", "", "16384", "24575",
+					}, {
+						"sgdisk", "-t=1:21686148-6449-6E6F-744E-656564454649", "-A=1:set:0", "/some/device",
+					}, {"wipefs", "--all", "/some/device1"}, {
+						"parted", "--script", "--machine", "--", "/some/device", "unit", "s",
+						"mkpart", "efi", "fat32", "24576", "155647", "set", "2", "esp", "on",
+					}, {
+						"sgdisk", "-u=2:7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f", "/some/device",
+					}, {"mkfs.vfat", "-n", "COS_GRUB", "/some/device2"},
+				})).To(BeNil())
+			})
+
 			It("Successfully creates partitions and formats them, BIOS boot", func() {
 				install.PartTable = types.GPT
 				install.Firmware = types.BIOS
@@ -486,6 +514,136 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 				Expect(elemental.PartitionAndFormatDevice(*config, install)).To(BeNil())
 				Expect(runner.MatchMilestones(biosPartCmds)).To(BeNil())
 			})
+
+			It("Successfully creates partitions with the native partitioner if parted is not found", func() {
+				install.PartTable = types.GPT
+				install.Firmware = types.EFI
+				install.Partitions.SetFirmwarePartitions(types.EFI, types.GPT)
+				runner.CmdNotFound = "parted"
+				Expect(fs.Truncate("/some/device", 16*1024*1024*1024)).To(Succeed())
+				for i := 1; i <= 5; i++ {
+					_, err := fs.Create(fmt.Sprintf("/some/device%d", i))
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				Expect(elemental.PartitionAndFormatDevice(*config, install)).To(BeNil())
+				Expect(runner.MatchMilestones([][]string{
+					{"mkfs.vfat", "-n", "COS_GRUB", "/some/device1"},
+					{"mkfs.ext4", "-L", "COS_OEM", "/some/device2"},
+					{"mkfs.ext4", "-L", "COS_RECOVERY", "/some/device3"},
+					{"mkfs.ext4", "-L", "COS_STATE", "/some/device4"},
+					{"mkfs.ext4", "-L", "COS_PERSISTENT", "/some/device5"},
+				})).To(BeNil())
+				Expect(runner.IncludesCmds([][]string{{"parted"}})).NotTo(BeNil())
+
+				pc := partitioner.NewNativePartitioner("/some/device", fs, runner)
+				out, err := pc.Print()
+				Expect(err).ToNot(HaveOccurred())
+				parts := pc.GetPartitions(out)
+				Expect(parts).To(HaveLen(5))
+				Expect(parts[0].PLabel).To(Equal("efi"))
+				Expect(parts[0].StartS).To(Equal(uint(2048)))
+				Expect(parts[0].TypeGUID).To(Equal("C12A7328-F81F-11D2-BA4B-00A0C93EC93B"))
+				Expect(parts[4].PLabel).To(Equal("persistent"))
+				Expect(parts[4].StartS).To(Equal(uint(25430016)))
+			})
+		})
+
+		Describe("Mirrored run", func() {
+			var partNums map[string]int
+			var printOuts map[string]string
+			var mdadmFail bool
+			BeforeEach(func() {
+				mdadmFail = false
+				install.PartTable = types.GPT
+				install.Firmware = types.EFI
+				install.MirrorTargets = []string{"/some/mirror"}
+				install.Partitions.SetFirmwarePartitions(types.EFI, types.GPT)
+				_, err := fs.Create("/some/mirror")
+				Expect(err).ToNot(HaveOccurred())
+
+				partNums = map[string]int{}
+				printOuts = map[string]string{}
+				runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+					switch cmd {
+					case "parted":
+						dev := args[3]
+						if _, ok := printOuts[dev]; !ok {
+							printOuts[dev] = printOutput
+						}
+						idx := 0
+						for i, arg := range args {
+							if arg == "mkpart" {
+								idx = i
+								break
+							}
+						}
+						if idx > 0 {
+							partNums[dev]++
+							printOuts[dev] += fmt.Sprintf(partTmpl, partNums[dev], args[idx+3], args[idx+4])
+							_, _ = fs.Create(fmt.Sprintf("%s%d", dev, partNums[dev]))
+						}
+						return []byte(printOuts[dev]), nil
+					case "mdadm":
+						if mdadmFail {
+							return []byte{}, errors.New("mdadm failure")
+						}
+						if args[0] == "--detail" {
+							return []byte("MD_LEVEL=raid1\nMD_DEVICES=2\nMD_UUID=8a5b2c1d:3e4f5a6b:7c8d9e0f:1a2b3c4d\n"), nil
+						}
+						return []byte{}, nil
+					default:
+						return []byte{}, nil
+					}
+				}
+			})
+
+			It("Successfully partitions all disks and creates RAID1 arrays", func() {
+				Expect(elemental.PartitionAndFormatDevice(*config, install)).To(BeNil())
+				Expect(runner.MatchMilestones([][]string{
+					{
+						"parted", "--script", "--machine", "--", "/some/device", "unit", "s",
+						"mkpart", "oem", "ext4", "133120", "264191",
+					}, {
+						"sgdisk", fmt.Sprintf("-t=2:%s", constants.LinuxRAIDTypeGUID), "/some/device",
+					}, {
+						"parted", "--script", "--machine", "--", "/some/mirror", "unit", "s",
+						"mklabel", "gpt",
+					}, {
+						"parted", "--script", "--machine", "--", "/some/mirror", "unit", "s",
+						"mkpart", "efi", "fat32", "2048", "133119", "set", "1", "esp", "on",
+					}, {"mkfs.vfat", "-n", "COS_GRUB", "/some/device1"},
+					{"mkfs.vfat", "-n", "COS_GRUB", "/some/mirror1"},
+					{"wipefs", "--all", "/some/device2"},
+					{"wipefs", "--all", "/some/mirror2"},
+					{
+						"mdadm", "--create", "/dev/md/oem", "--run", "--level=1", "--metadata=1.0",
+						"--homehost=any", "--name=oem", "--raid-devices=2", "/some/device2", "/some/mirror2",
+					},
+					{"mdadm", "--detail", "--export", "/dev/md/oem"},
+					{"mkfs.ext4", "-L", "COS_OEM", "/dev/md/oem"},
+				})).To(BeNil())
+
+				Expect(install.Partitions.Boot.Path).To(Equal("/some/device1"))
+				Expect(install.Partitions.Boot.Mirrors).To(Equal([]string{"/some/device1", "/some/mirror1"}))
+				Expect(install.Partitions.Boot.RAID).To(BeNil())
+				Expect(install.Partitions.State.Path).To(Equal("/dev/md/state"))
+				Expect(install.Partitions.State.RAID.UUID).To(Equal("8a5b2c1d:3e4f5a6b:7c8d9e0f:1a2b3c4d"))
+				Expect(elemental.RAIDKernelCmdline(install.Partitions.PartitionsByInstallOrder(nil))).To(
+					ContainSubstring("rd.md.uuid=8a5b2c1d:3e4f5a6b:7c8d9e0f:1a2b3c4d"),
+				)
+			})
+
+			It("Fails if a mirror target does not exist", func() {
+				install.MirrorTargets = []string{"/some/missing"}
+				Expect(elemental.PartitionAndFormatDevice(*config, install)).NotTo(BeNil())
+			})
+
+			It("Fails to create a RAID array", func() {
+				mdadmFail = true
+				Expect(elemental.PartitionAndFormatDevice(*config, install)).NotTo(BeNil())
+				Expect(install.Partitions.OEM.RAID).To(BeNil())
+			})
 		})
 
 		Describe("Run with failures", func() {
@@ -537,6 +695,86 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			})
 		})
 	})
+	Describe("CreateRAIDArray", Label("raid"), func() {
+		It("Fails with a single member device", func() {
+			part := &types.Partition{Name: "state", Mirrors: []string{"/dev/sda4"}}
+			Expect(elemental.CreateRAIDArray(*config, part)).NotTo(BeNil())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("Fails if the array UUID can't be determined", func() {
+			part := &types.Partition{Name: "state", Mirrors: []string{"/dev/sda4", "/dev/sdb4"}}
+			Expect(elemental.CreateRAIDArray(*config, part)).NotTo(BeNil())
+			Expect(runner.IncludesCmds([][]string{{"mdadm", "--detail", "--export", "/dev/md/state"}})).To(BeNil())
+			Expect(part.RAID).To(BeNil())
+		})
+	})
+	Describe("SyncBootMirrors", Label("raid", "mirror"), func() {
+		var boot *types.Partition
+		BeforeEach(func() {
+			boot = &types.Partition{Name: constants.BootPartName, FS: constants.BootFs, MountPoint: "/efi"}
+			Expect(utils.MkdirAll(fs, boot.MountPoint, constants.DirPerm)).To(Succeed())
+		})
+		It("records the partition UUID path of a mirror", func() {
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "blkid" {
+					return []byte("1234-abcd\n"), nil
+				}
+				return []byte{}, nil
+			}
+			Expect(elemental.MirrorDevicePath(*config, "/dev/sdb1")).To(Equal("/dev/disk/by-partuuid/1234-abcd"))
+		})
+		It("falls back to the device path if the partition UUID is unknown", func() {
+			Expect(elemental.MirrorDevicePath(*config, "/dev/sdb1")).To(Equal("/dev/sdb1"))
+		})
+		It("syncs the EFI partition to every mirror", func() {
+			var mirrorDirs []string
+			err := elemental.SyncBootMirrors(*config, boot, []string{"/dev/sdb1", "/dev/sdc1"}, func(dir string) error {
+				mirrorDirs = append(mirrorDirs, dir)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mirrorDirs).To(HaveLen(2))
+			Expect(runner.MatchMilestones([][]string{{"rsync"}, {"rsync"}})).To(Succeed())
+			lst, _ := mounter.List()
+			Expect(lst).To(BeEmpty())
+		})
+		It("removes files deleted from the EFI partition on the mirrors", func() {
+			Expect(utils.MkdirAll(fs, "/efi/EFI/boot", constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/efi/EFI/boot/grub.cfg", []byte("new"), constants.FilePerm)).To(Succeed())
+
+			// Emulates rsync over a mirror holding files already removed from the EFI partition
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd != "rsync" {
+					return []byte{}, nil
+				}
+				src, dst := args[len(args)-2], args[len(args)-1]
+				Expect(os.MkdirAll(filepath.Join(dst, "EFI", "old"), constants.DirPerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dst, "EFI", "old", "shim.efi"), []byte("stale"), constants.FilePerm)).To(Succeed())
+				if slices.Contains(args, "--delete") {
+					Expect(os.RemoveAll(filepath.Join(dst, "EFI", "old"))).To(Succeed())
+				}
+				Expect(os.MkdirAll(filepath.Join(dst, "EFI", "boot"), constants.DirPerm)).To(Succeed())
+				data, err := os.ReadFile(filepath.Join(src, "EFI", "boot", "grub.cfg"))
+				Expect(err).NotTo(HaveOccurred())
+				return []byte{}, os.WriteFile(filepath.Join(dst, "EFI", "boot", "grub.cfg"), data, constants.FilePerm)
+			}
+
+			err := elemental.SyncBootMirrors(*config, boot, []string{"/dev/sdb1"}, func(dir string) error {
+				ok, _ := utils.Exists(fs, filepath.Join(dir, "EFI", "old", "shim.efi"))
+				Expect(ok).To(BeFalse())
+				data, err := fs.ReadFile(filepath.Join(dir, "EFI", "boot", "grub.cfg"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(Equal("new"))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("fails if a mirror can't be mounted", func() {
+			mounter.ErrorOnMount = true
+			Expect(elemental.SyncBootMirrors(*config, boot, []string{"/dev/sdb1"}, nil)).NotTo(Succeed())
+			Expect(runner.IncludesCmds([][]string{{"rsync"}})).NotTo(Succeed())
+		})
+	})
 	Describe("MirrorRoot", func() {
 		var destDir string
 		var syncFunc func(l types.Logger, r types.Runner, f types.FS, src string, dst string, excl ...string) error
@@ -677,6 +915,32 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			Expect(img.Size).To(Equal(uint(64)))
 			Expect(runner.IncludesCmds([][]string{{"rsync"}}))
 		})
+		It("Preloads the root tree on rootless configurations", Label("rootless"), func() {
+			config.Rootless = true
+			mounter.ErrorOnMount = true
+			err := elemental.CreateImageFromTree(*config, img, root, false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(runner.CmdsMatch([][]string{
+				{"mkfs.ext2", "-d", root, imgFile},
+			})).To(Succeed())
+		})
+		It("Preloads btrfs and fat images with the root tree", Label("rootless"), func() {
+			img.FS = constants.Btrfs
+			Expect(elemental.CreateImageFromTree(*config, img, root, true)).To(Succeed())
+			Expect(fs.Remove(imgFile)).To(Succeed())
+			img.FS = constants.BootFs
+			img.File = "/efi.img"
+			Expect(elemental.CreateImageFromTree(*config, img, root, true)).To(Succeed())
+			Expect(runner.CmdsMatch([][]string{
+				{"mkfs.btrfs", "--rootdir", root, "-f", imgFile},
+				{"mkfs.vfat", "/efi.img"},
+				{"mcopy", "-s", "-p", "-n", "-o", "-i", "/efi.img", filepath.Join(root, "somefile"), "::"},
+			})).To(Succeed())
+		})
+		It("Fails to preload filesystems not supporting it", Label("rootless"), func() {
+			img.FS = "xfs"
+			Expect(elemental.CreateImageFromTree(*config, img, root, true)).NotTo(Succeed())
+		})
 		It("Fails to mount created filesystem image", func() {
 			mounter.ErrorOnUnmount = true
 			err := elemental.CreateImageFromTree(*config, img, root, false)
@@ -685,6 +949,44 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			Expect(cleaned).To(BeFalse())
 			Expect(runner.IncludesCmds([][]string{{"rsync"}}))
 		})
+		It("Creates a squashfs image including a dm-verity hash tree", func() {
+			img.FS = constants.SquashFs
+			img.Verity = true
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "veritysetup" {
+					return []byte("VERITY header information for " + imgFile + ".verity\nRoot hash:      \t4A1F0c\n"), nil
+				}
+				return []byte{}, nil
+			}
+			err := elemental.CreateImageFromTree(*config, img, root, false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(img.RootHash).To(Equal("4a1f0c"))
+			Expect(runner.MatchMilestones([][]string{
+				{"mksquashfs"},
+				{"veritysetup", "format", imgFile, imgFile + ".verity"},
+			})).To(Succeed())
+		})
+		It("Creates the dm-verity hash tree once the image is unmounted", func() {
+			img.Verity = true
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "veritysetup" {
+					lst, _ := mounter.List()
+					Expect(lst).To(BeEmpty())
+					return []byte("Root hash: 4a1f0c\n"), nil
+				}
+				return []byte{}, nil
+			}
+			err := elemental.CreateImageFromTree(*config, img, root, false)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(img.RootHash).To(Equal("4a1f0c"))
+		})
+		It("Fails if the dm-verity root hash is not found", func() {
+			img.FS = constants.SquashFs
+			img.Verity = true
+			err := elemental.CreateImageFromTree(*config, img, root, false)
+			Expect(err).Should(HaveOccurred())
+			Expect(img.RootHash).To(BeEmpty())
+		})
 	})
 	Describe("CopyImgFile", Label("copyimg"), func() {
 		var imgFile, srcFile string
@@ -924,6 +1226,82 @@ var _ = Describe("Elemental", Label("elemental"), func() {
 			Expect(err).To(BeNil())
 		})
 	})
+	Describe("EncryptPartition", Label("encryption", "partition"), func() {
+		var part *types.Partition
+		BeforeEach(func() {
+			part = &types.Partition{
+				Name: constants.PersistentPartName,
+				Path: "/dev/device5",
+				Encryption: &types.Encryption{
+					KeySlots: []types.KeySlot{
+						{TPM: true},
+						{KeyFile: "/etc/luks.key"},
+						{Passphrase: "secret"},
+					},
+				},
+			}
+		})
+		It("formats, enrolls key slots and opens the encrypted volume", func() {
+			Expect(elemental.EncryptPartition(*config, part)).To(Succeed())
+			Expect(runner.MatchMilestones([][]string{
+				{"cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2", "--key-file"},
+				{"systemd-cryptenroll"},
+				{"cryptsetup", "luksAddKey", "--batch-mode", "--key-file"},
+				{"cryptsetup", "luksAddKey", "--batch-mode", "--key-file"},
+				{"cryptsetup", "open", "--key-file"},
+				{"cryptsetup", "luksRemoveKey", "--batch-mode", "/dev/device5"},
+			})).To(Succeed())
+			Expect(part.Path).To(Equal("/dev/mapper/persistent"))
+		})
+		It("enrolls the TPM key slot with the default PCRs", func() {
+			Expect(elemental.EncryptPartition(*config, part)).To(Succeed())
+			var enroll []string
+			for _, cmd := range runner.GetCmds() {
+				if cmd[0] == "systemd-cryptenroll" {
+					enroll = cmd
+				}
+			}
+			Expect(enroll).To(ContainElements("--tpm2-device=auto", "--tpm2-pcrs=7", "/dev/device5"))
+		})
+		It("fails on invalid key slots", func() {
+			part.Encryption.KeySlots = []types.KeySlot{{TPM: true, Passphrase: "secret"}}
+			Expect(elemental.EncryptPartition(*config, part)).NotTo(Succeed())
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("fails if the volume can't be formatted", func() {
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				if cmd == "cryptsetup" && args[0] == "luksFormat" {
+					return []byte{}, fmt.Errorf("luksFormat failed")
+				}
+				return []byte{}, nil
+			}
+			Expect(elemental.EncryptPartition(*config, part)).NotTo(Succeed())
+			Expect(part.Path).To(Equal("/dev/device5"))
+		})
+	})
+	Describe("UnlockDevice", Label("encryption"), func() {
+		It("unlocks a device with a TPM2 token", func() {
+			path, err := elemental.UnlockDevice(*config, "/dev/device5", "persistent", &types.VolumeEncryption{TPM: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/dev/mapper/persistent"))
+			Expect(runner.CmdsMatch([][]string{{
+				constants.SystemdCryptsetup, "attach", "persistent", "/dev/device5", "-", "tpm2-device=auto",
+			}})).To(Succeed())
+		})
+		It("does nothing if the device is already unlocked", func() {
+			Expect(utils.MkdirAll(fs, constants.DevMapperDir, constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile("/dev/mapper/persistent", []byte{}, constants.FilePerm)).To(Succeed())
+			path, err := elemental.UnlockDevice(*config, "/dev/device5", "persistent", &types.VolumeEncryption{TPM: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/dev/mapper/persistent"))
+			Expect(runner.GetCmds()).To(BeEmpty())
+		})
+		It("fails to unlock a device", func() {
+			runner.ReturnError = fmt.Errorf("attach failed")
+			_, err := elemental.UnlockDevice(*config, "/dev/device5", "persistent", &types.VolumeEncryption{KeyFile: "/etc/luks.key"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
 	Describe("DeactivateDevices", Label("blkdeactivate"), func() {
 		It("calls blkdeactivat", func() {
 			err := elemental.DeactivateDevices(*config)
@@ -0,0 +1,168 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elemental
+
+import (
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	cnst "github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+const luksKeySize = 64
+
+// EncryptPartition sets up a LUKS2 volume on the given partition device, enrolls all the
+// configured key slots and opens it. The partition path is updated to the unlocked
+// device mapper path, so any further format or mount operation applies to the encrypted volume.
+// The volume is formatted with a transient random key which is removed once all key slots are enrolled.
+func EncryptPartition(c types.Config, part *types.Partition) (err error) {
+	if part.Encryption == nil {
+		return fmt.Errorf("no encryption defined for partition %s", part.Name)
+	}
+	if err = part.Encryption.Sanitize(); err != nil {
+		return err
+	}
+
+	c.Logger.Infof("Encrypting '%s' partition", part.Name)
+	tmpDir, err := utils.TempDir(c.Fs, "", "elemental-luks")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		rErr := c.Fs.RemoveAll(tmpDir)
+		if rErr != nil && err == nil {
+			err = rErr
+		}
+	}()
+
+	key := make([]byte, luksKeySize)
+	if _, err = rand.Read(key); err != nil {
+		return err
+	}
+	keyFile := filepath.Join(tmpDir, "transient.key")
+	if err = c.Fs.WriteFile(keyFile, key, 0600); err != nil {
+		return err
+	}
+
+	out, err := c.Runner.Run("cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2", "--key-file", keyFile, part.Path)
+	if err != nil {
+		c.Logger.Errorf("failed formatting LUKS volume on %s: %s", part.Path, string(out))
+		return err
+	}
+
+	for i, slot := range part.Encryption.KeySlots {
+		switch {
+		case slot.TPM:
+			pcrs := slot.TPMPCRs
+			if pcrs == "" {
+				pcrs = cnst.DefaultTPMPCRs
+			}
+			out, err = c.Runner.Run(
+				"systemd-cryptenroll", fmt.Sprintf("--unlock-key-file=%s", keyFile),
+				"--tpm2-device=auto", fmt.Sprintf("--tpm2-pcrs=%s", pcrs), part.Path,
+			)
+		case slot.KeyFile != "":
+			out, err = c.Runner.Run("cryptsetup", "luksAddKey", "--batch-mode", "--key-file", keyFile, part.Path, slot.KeyFile)
+		default:
+			passFile := filepath.Join(tmpDir, fmt.Sprintf("slot%d.key", i))
+			if err = c.Fs.WriteFile(passFile, []byte(slot.Passphrase), 0600); err != nil {
+				return err
+			}
+			out, err = c.Runner.Run("cryptsetup", "luksAddKey", "--batch-mode", "--key-file", keyFile, part.Path, passFile)
+		}
+		if err != nil {
+			c.Logger.Errorf("failed enrolling key slot %d on %s: %s", i, part.Path, string(out))
+			return err
+		}
+	}
+
+	out, err = c.Runner.Run("cryptsetup", "open", "--key-file", keyFile, part.Path, part.Name)
+	if err != nil {
+		c.Logger.Errorf("failed opening LUKS volume %s: %s", part.Path, string(out))
+		return err
+	}
+
+	out, err = c.Runner.Run("cryptsetup", "luksRemoveKey", "--batch-mode", part.Path, keyFile)
+	if err != nil {
+		c.Logger.Errorf("failed removing transient key from %s: %s", part.Path, string(out))
+		_ = CloseEncryptedPartition(c, part)
+		return err
+	}
+
+	part.Path = filepath.Join(cnst.DevMapperDir, part.Name)
+	return nil
+}
+
+// CloseEncryptedPartition closes the LUKS2 device mapper of the given partition, if it is open.
+func CloseEncryptedPartition(c types.Config, part *types.Partition) error {
+	mapper := filepath.Join(cnst.DevMapperDir, part.Name)
+	if ok, _ := utils.Exists(c.Fs, mapper); !ok {
+		c.Logger.Debugf("Not closing %s, device mapper not found", mapper)
+		return nil
+	}
+	c.Logger.Debugf("Closing encrypted device %s", mapper)
+	out, err := c.Runner.Run("cryptsetup", "close", part.Name)
+	if err != nil {
+		c.Logger.Errorf("failed closing encrypted device %s: %s", mapper, string(out))
+	}
+	return err
+}
+
+// CloseEncryptedPartitions closes the device mappers of all encrypted partitions in the list.
+func CloseEncryptedPartitions(c types.Config, parts types.PartitionList) error {
+	for _, part := range parts {
+		if part.Encryption == nil {
+			continue
+		}
+		if err := CloseEncryptedPartition(c, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnlockDevice opens the LUKS2 volume of the given device as the given device mapper name. Unlocking is
+// delegated to systemd-cryptsetup so TPM2 tokens and interactive passphrases are supported. Returns
+// the path of the unlocked device.
+func UnlockDevice(c types.Config, device, name string, enc *types.VolumeEncryption) (string, error) {
+	mapper := filepath.Join(cnst.DevMapperDir, name)
+	if ok, _ := utils.Exists(c.Fs, mapper); ok {
+		c.Logger.Debugf("Encrypted device %s already unlocked", device)
+		return mapper, nil
+	}
+
+	key := "-"
+	if enc.KeyFile != "" {
+		key = enc.KeyFile
+	}
+	args := []string{"attach", name, device, key}
+	if enc.TPM {
+		args = append(args, "tpm2-device=auto")
+	}
+
+	c.Logger.Infof("Unlocking encrypted device %s", device)
+	out, err := c.Runner.Run(cnst.SystemdCryptsetup, args...)
+	if err != nil {
+		c.Logger.Errorf("failed unlocking %s: %s", device, strings.TrimSpace(string(out)))
+		return "", err
+	}
+	return mapper, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elemental
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	cnst "github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// CreateRAIDArray creates a RAID1 array named after the partition from all its mirror devices. The array
+// is created with homehost 'any' so it is assembled with the same name regardless of the hostname.
+func CreateRAIDArray(c types.Config, part *types.Partition) error {
+	if len(part.Mirrors) < 2 {
+		return fmt.Errorf("at least two devices are required to create a RAID1 array for partition %s", part.Name)
+	}
+
+	array := &types.RAIDArray{
+		Device:  filepath.Join(cnst.RAIDDevDir, part.Name),
+		Members: part.Mirrors,
+	}
+
+	for _, member := range array.Members {
+		out, err := c.Runner.Run("wipefs", "--all", member)
+		if err != nil {
+			c.Logger.Errorf("failed wiping RAID member %s: %s", member, string(out))
+			return err
+		}
+	}
+
+	c.Logger.Infof("Creating RAID1 array %s", array.Device)
+	args := []string{
+		"--create", array.Device, "--run", "--level=1",
+		fmt.Sprintf("--metadata=%s", cnst.RAIDMetadata), "--homehost=any",
+		fmt.Sprintf("--name=%s", part.Name), fmt.Sprintf("--raid-devices=%d", len(array.Members)),
+	}
+	out, err := c.Runner.Run("mdadm", append(args, array.Members...)...)
+	if err != nil {
+		c.Logger.Errorf("failed creating RAID array %s: %s", array.Device, string(out))
+		return err
+	}
+
+	out, err = c.Runner.Run("mdadm", "--detail", "--export", array.Device)
+	if err != nil {
+		c.Logger.Errorf("failed reading RAID array %s details: %s", array.Device, string(out))
+		return err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		if uuid, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "MD_UUID="); ok {
+			array.UUID = uuid
+		}
+	}
+	if array.UUID == "" {
+		return fmt.Errorf("could not determine the UUID of RAID array %s", array.Device)
+	}
+
+	part.RAID = array
+	return nil
+}
+
+// RAIDKernelCmdline returns the kernel command line arguments required to assemble the
+// RAID arrays of the given partitions at boot
+func RAIDKernelCmdline(parts types.PartitionList) string {
+	args := []string{}
+	for _, part := range parts {
+		if part != nil && part.RAID != nil {
+			args = append(args, fmt.Sprintf("rd.md.uuid=%s", part.RAID.UUID))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// AssembleRAIDArrays assembles any RAID array found in the system which is not already active.
+// Best effort, nothing is done if mdadm is not installed.
+func AssembleRAIDArrays(c types.Config) {
+	if !c.Runner.CommandExists("mdadm") {
+		c.Logger.Debugf("mdadm not found, not assembling RAID arrays")
+		return
+	}
+	c.Logger.Infof("Assembling RAID arrays")
+	out, err := c.Runner.Run("mdadm", "--assemble", "--scan")
+	if err != nil {
+		// mdadm fails if there is nothing to assemble, hence just logged
+		c.Logger.Debugf("mdadm assemble output: %s", strings.TrimSpace(string(out)))
+	}
+}
+
+// MirrorDevicePath returns a stable path of the given partition device based on its GPT partition UUID,
+// so it can be recorded in the installation state. Returns the given device if the UUID can't be read.
+func MirrorDevicePath(c types.Config, dev string) string {
+	out, err := c.Runner.Run("blkid", "-p", "-s", "PART_ENTRY_UUID", "-o", "value", dev)
+	uuid := strings.TrimSpace(string(out))
+	if err != nil || uuid == "" {
+		c.Logger.Warnf("could not read the partition UUID of %s, recording the device path", dev)
+		return dev
+	}
+	return filepath.Join(cnst.DiskByPartUUIDDir, uuid)
+}
+
+// SyncBootMirrors mirrors the content of the given mounted EFI partition to each of the given mirror EFI
+// partition devices, files missing in the EFI partition are removed from the mirrors. The given function, if any, is called for each mirror while it is still mounted.
+func SyncBootMirrors(c types.Config, boot *types.Partition, mirrors []string, onMirror func(mirrorDir string) error) error {
+	for _, dev := range mirrors {
+		err := syncBootMirror(c, boot, dev, onMirror)
+		if err != nil {
+			c.Logger.Errorf("failed mirroring EFI partition to %s: %v", dev, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func syncBootMirror(c types.Config, boot *types.Partition, dev string, onMirror func(mirrorDir string) error) (err error) {
+	tmpDir, err := utils.TempDir(c.Fs, "", "elemental-efi-mirror")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Fs.RemoveAll(tmpDir) }()
+
+	c.Logger.Infof("Mirroring EFI partition to %s", dev)
+	err = c.Mounter.Mount(dev, tmpDir, boot.FS, []string{"rw"})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		uErr := c.Mounter.Unmount(tmpDir)
+		if err == nil {
+			err = uErr
+		}
+	}()
+
+	err = utils.MirrorData(c.Logger, c.Runner, c.Fs, boot.MountPoint, tmpDir)
+	if err != nil || onMirror == nil {
+		return err
+	}
+	return onMirror(tmpDir)
+}
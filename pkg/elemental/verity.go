@@ -0,0 +1,62 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elemental
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	cnst "github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+var rootHashRegexp = regexp.MustCompile(`^Root hash:\s+([0-9a-fA-F]+)$`)
+
+// VerityHashFile returns the path of the dm-verity hash tree file of the given image
+func VerityHashFile(img *types.Image) string {
+	return img.File + cnst.VerityHashSuffix
+}
+
+// CreateVerityHashTree computes the dm-verity hash tree of the given image file. The hash tree is
+// stored next to the image and the resulting root hash is set to the image RootHash field.
+// Any later change on the image file invalidates the hash tree.
+func CreateVerityHashTree(c types.Config, img *types.Image) error {
+	hashFile := VerityHashFile(img)
+
+	c.Logger.Infof("Creating dm-verity hash tree for image %s", img.File)
+	_ = c.Fs.Remove(hashFile)
+	out, err := c.Runner.Run("veritysetup", "format", img.File, hashFile)
+	if err != nil {
+		c.Logger.Errorf("failed creating dm-verity hash tree for %s: %s", img.File, string(out))
+		return err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		match := rootHashRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match != nil {
+			img.RootHash = strings.ToLower(match[1])
+			c.Logger.Debugf("Root hash of image %s: %s", img.File, img.RootHash)
+			return nil
+		}
+	}
+
+	_ = c.Fs.Remove(hashFile)
+	return fmt.Errorf("could not find the root hash of %s in veritysetup output", img.File)
+}
@@ -265,5 +265,26 @@ const UpgradeRecovery = 88
 // Error displaying installation state
 const DisplayingInstallationState = 89
 
+// Error setting up partition encryption
+const EncryptPartition = 90
+
+// Error replicating EFI partition on mirror targets
+const MirrorBootPartition = 91
+
+// Error resizing or adding partitions on an existing installation
+const RepartitionDevice = 92
+
+// Error reporting or resetting the changes on top of the immutable image
+const OverlayDiff = 93
+
+// Cloud-init files with validation issues
+const CloudInitValidate = 94
+
+// Error reporting the stage traces of the current boot
+const StageReport = 95
+
+// Error selecting the target device of an unattended installation
+const SelectTarget = 96
+
 // Unknown error
 const Unknown int = 255
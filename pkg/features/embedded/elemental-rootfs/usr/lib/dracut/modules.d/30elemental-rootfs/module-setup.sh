@@ -28,6 +28,21 @@ install() {
 
     inst_simple "/etc/elemental/config.yaml"
 
+    # Optional tools to unlock LUKS2 encrypted volumes, including TPM2 tokens
+    inst_multiple -o cryptsetup "$systemdutildir"/systemd-cryptsetup
+    inst_libdir_file "cryptsetup/libcryptsetup-token-systemd-tpm2.so"
+
+    # Key files to unlock LUKS2 encrypted volumes at boot
+    if [ -d /etc/elemental/keys ]; then
+        inst_dir /etc/elemental/keys
+        for key in /etc/elemental/keys/*; do
+            [ -f "$key" ] && inst_simple "$key"
+        done
+    fi
+
+    # Optional tools to set project quotas on persistent paths
+    inst_multiple -o xfs_quota setquota chattr
+
     inst_simple "/etc/systemd/system/elemental-rootfs.service" \
         "${systemdsystemunitdir}/elemental-rootfs.service"
     mkdir -p "${initdir}/${systemdsystemunitdir}/initrd-fs.target.wants"
@@ -0,0 +1,25 @@
+#!/bin/bash
+
+# Opens the given image as a dm-verity device verified against the given root hash.
+# The hash tree is expected next to the image file with the '.verity' suffix.
+# Several comma separated root hashes can be given, the image is opened with the first one matching it.
+
+PATH=/usr/sbin:/usr/bin:/sbin:/bin
+
+image=$(readlink -f "$1")
+roothashes="$2"
+
+if [ ! -f "${image}" ] || [ ! -f "${image}.verity" ]; then
+    echo "image ${image} or its hash tree not found" >&2
+    exit 1
+fi
+
+IFS=',' read -r -a hashes <<< "${roothashes}"
+for roothash in "${hashes[@]}"; do
+    if veritysetup open "${image}" elemental-verity "${image}.verity" "${roothash}"; then
+        exit 0
+    fi
+done
+
+echo "image ${image} does not match any of the given root hashes" >&2
+exit 1
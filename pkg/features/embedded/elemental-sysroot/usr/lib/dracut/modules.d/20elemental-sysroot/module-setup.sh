@@ -19,11 +19,15 @@ install() {
     declare systemdsystemunitdir=${systemdsystemunitdir}
 
     inst_multiple \
-        "$systemdutildir"/systemd-fsck ln mkdir mount umount systemd-escape e2fsck lsblk basename
+        "$systemdutildir"/systemd-fsck ln mkdir mount umount systemd-escape e2fsck lsblk basename readlink
+
+    # veritysetup is optional, only required for dm-verity protected images
+    inst_multiple -o veritysetup
 
     inst_hook cmdline 30 "${moddir}/elemental-cmdline.sh"
 
     inst_script "${moddir}/elemental-fsck.sh" "/sbin/elemental-fsck"
+    inst_script "${moddir}/elemental-verity.sh" "/sbin/elemental-verity"
     ln_r "$systemdutildir"/systemd-fsck \
         "/sbin/systemd-fsck"
 
@@ -14,6 +14,7 @@ elemental_img=$(getarg elemental.image=)
 root=$(getarg root=)
 rootok=0
 snapshotter=$(getarg elemental.snapshotter=)
+verity_roothash=$(getarg elemental.verity.roothash=)
 
 GENERATOR_DIR="$2"
 [ -z "$GENERATOR_DIR" ] && exit 1
@@ -120,16 +121,42 @@ else
         echo "Options=defaults"
     } > "$GENERATOR_DIR/${state_unit}"
 
-    {
-        echo "[Unit]"
-        echo "Before=initrd-root-fs.target"
-        echo "DefaultDependencies=no"
-        echo "RequiresMountsFor=${root_part_mnt}"
-        echo "[Mount]"
-        echo "Where=/sysroot"
-        echo "What=${root_part_mnt}/${image}"
-        echo "Options=ro"
-    } > "$GENERATOR_DIR"/sysroot.mount
+    if [ -n "${verity_roothash}" ]; then
+        {
+            echo "[Unit]"
+            echo "Description=Elemental dm-verity system image setup"
+            echo "Before=initrd-root-fs.target"
+            echo "DefaultDependencies=no"
+            echo "RequiresMountsFor=${root_part_mnt}"
+            echo "[Service]"
+            echo "Type=oneshot"
+            echo "RemainAfterExit=yes"
+            echo "ExecStart=/sbin/elemental-verity ${root_part_mnt}/${image} ${verity_roothash}"
+        } > "$GENERATOR_DIR"/elemental-verity.service
+
+        {
+            echo "[Unit]"
+            echo "Before=initrd-root-fs.target"
+            echo "DefaultDependencies=no"
+            echo "Requires=elemental-verity.service"
+            echo "After=elemental-verity.service"
+            echo "[Mount]"
+            echo "Where=/sysroot"
+            echo "What=/dev/mapper/elemental-verity"
+            echo "Options=ro"
+        } > "$GENERATOR_DIR"/sysroot.mount
+    else
+        {
+            echo "[Unit]"
+            echo "Before=initrd-root-fs.target"
+            echo "DefaultDependencies=no"
+            echo "RequiresMountsFor=${root_part_mnt}"
+            echo "[Mount]"
+            echo "Where=/sysroot"
+            echo "What=${root_part_mnt}/${image}"
+            echo "Options=ro"
+        } > "$GENERATOR_DIR"/sysroot.mount
+    fi
 fi
 
 mkdir -p "$GENERATOR_DIR"/initrd-root-fs.target.requires
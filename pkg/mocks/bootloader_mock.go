@@ -18,6 +18,7 @@ package mocks
 
 import (
 	"fmt"
+	"maps"
 
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 )
@@ -28,10 +29,18 @@ type FakeBootloader struct {
 	ErrorInstall                bool
 	ErrorInstallConfig          bool
 	ErrorDoEFIEntries           bool
+	ErrorAddEFIEntry            bool
 	ErrorInstallEFI             bool
 	ErrorInstallEFIBinaries     bool
 	ErrorSetPersistentVariables bool
 	ErrorSetDefaultEntry        bool
+	ErrorInstallBIOS            bool
+	ErrorInstallBIOSEltorito    bool
+	ErrorSetupBIOS              bool
+	// BIOSDisks lists the disks legacy BIOS boot code was embedded into
+	BIOSDisks []string
+	// Variables lists the variables of each SetPersistentVariables call
+	Variables []map[string]string
 }
 
 func (f *FakeBootloader) Install(_, _ string) error {
@@ -69,10 +78,18 @@ func (f *FakeBootloader) DoEFIEntries(_, _ string) error {
 	return nil
 }
 
-func (f *FakeBootloader) SetPersistentVariables(_ string, _ map[string]string) error {
+func (f *FakeBootloader) AddEFIEntry(_ string) error {
+	if f.ErrorAddEFIEntry {
+		return fmt.Errorf("error adding efi entry")
+	}
+	return nil
+}
+
+func (f *FakeBootloader) SetPersistentVariables(_ string, vars map[string]string) error {
 	if f.ErrorSetPersistentVariables {
 		return fmt.Errorf("error setting persistent variables")
 	}
+	f.Variables = append(f.Variables, maps.Clone(vars))
 	return nil
 }
 
@@ -82,3 +99,25 @@ func (f *FakeBootloader) SetDefaultEntry(_, _, _ string) error {
 	}
 	return nil
 }
+
+func (f *FakeBootloader) InstallBIOS(_, _, _ string) error {
+	if f.ErrorInstallBIOS {
+		return fmt.Errorf("error installing bios bootloader")
+	}
+	return nil
+}
+
+func (f *FakeBootloader) InstallBIOSEltorito(_, _ string) error {
+	if f.ErrorInstallBIOSEltorito {
+		return fmt.Errorf("error installing bios el torito image")
+	}
+	return nil
+}
+
+func (f *FakeBootloader) SetupBIOS(_, disk string) error {
+	if f.ErrorSetupBIOS {
+		return fmt.Errorf("error setting up bios bootloader")
+	}
+	f.BIOSDisks = append(f.BIOSDisks, disk)
+	return nil
+}
@@ -80,6 +80,16 @@ func (g *GhwMock) CreateDevices() {
 		// For each dir we create the /sys/block/DISK_NAME
 		diskPath := filepath.Join(g.paths.SysBlock, disk.Name)
 		_ = os.Mkdir(diskPath, 0755)
+		// Create the /sys/block/DISK_NAME/size file which contains the number of 512 bytes sectors
+		if disk.SizeBytes > 0 {
+			_ = os.WriteFile(filepath.Join(diskPath, "size"), []byte(fmt.Sprintf("%d\n", disk.SizeBytes/512)), 0644)
+		}
+		// Create the disk dev file and udev data if the disk has a model or serial number
+		if disk.Model != "" || disk.SerialNumber != "" {
+			_ = os.WriteFile(filepath.Join(diskPath, "dev"), []byte(fmt.Sprintf("%d:0\n", indexDisk)), 0644)
+			data := fmt.Sprintf("E:ID_MODEL=%s\nE:ID_SERIAL_SHORT=%s\n", disk.Model, disk.SerialNumber)
+			_ = os.WriteFile(filepath.Join(g.paths.RunUdevData, fmt.Sprintf("b%d:0", indexDisk)), []byte(data), 0644)
+		}
 		for indexPart, partition := range disk.Partitions {
 			// For each partition we create the /sys/block/DISK_NAME/PARTITION_NAME
 			_ = os.Mkdir(filepath.Join(diskPath, partition.Name), 0755)
@@ -16,13 +16,18 @@ limitations under the License.
 
 package mocks
 
-import "errors"
+import (
+	"errors"
+	"syscall"
+)
 
 // FakeSyscall is a test helper method to track calls to syscall
 // It can also fail on Chroot command
 type FakeSyscall struct {
 	chrootHistory []string // Track calls to chroot
 	ErrorOnChroot bool
+	// Extended attributes returned by Getxattr, keyed by path and attribute name
+	Xattrs map[string]map[string]string
 }
 
 // Chroot will store the chroot call
@@ -39,6 +44,15 @@ func (f *FakeSyscall) Chdir(_ string) error {
 	return nil
 }
 
+// Getxattr returns the extended attributes set in Xattrs, ENODATA is returned for any other attribute
+func (f *FakeSyscall) Getxattr(path string, attr string, dest []byte) (int, error) {
+	value, ok := f.Xattrs[path][attr]
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+	return copy(dest, value), nil
+}
+
 // WasChrootCalledWith is a helper method to check if Chroot was called with the given path
 func (f *FakeSyscall) WasChrootCalledWith(path string) bool {
 	for _, c := range f.chrootHistory {
@@ -93,6 +93,15 @@ func FormatDevice(runner types.Runner, device string, fileSystem string, label s
 	return err
 }
 
+// newPartitioner returns the partitioner of the disk backend, the native backend
+// operates through the filesystem of the disk
+func (dev Disk) newPartitioner() Partitioner {
+	if dev.partBackend == Native {
+		return newNativeCall(dev.String(), dev.fs, dev.runner)
+	}
+	return NewPartitioner(dev.String(), dev.runner, dev.partBackend)
+}
+
 func (dev Disk) String() string {
 	return dev.device
 }
@@ -109,6 +118,10 @@ func (dev Disk) GetLabel() string {
 	return dev.label
 }
 
+func (dev Disk) GetPartitions() []Partition {
+	return dev.parts
+}
+
 func (dev *Disk) Exists() bool {
 	fi, err := dev.fs.Stat(dev.device)
 	if err != nil {
@@ -126,7 +139,7 @@ func (dev *Disk) Exists() bool {
 }
 
 func (dev *Disk) Reload() error {
-	pc := NewPartitioner(dev.String(), dev.runner, dev.partBackend)
+	pc := dev.newPartitioner()
 
 	prnt, err := pc.Print()
 	if err != nil {
@@ -217,7 +230,7 @@ func (dev Disk) computeFreeSpaceWithoutLast() uint {
 }
 
 func (dev *Disk) NewPartitionTable(label string) (string, error) {
-	pc := NewPartitioner(dev.String(), dev.runner, dev.partBackend)
+	pc := dev.newPartitioner()
 
 	err := pc.SetPartitionTableLabel(label)
 	if err != nil {
@@ -237,9 +250,17 @@ func (dev *Disk) NewPartitionTable(label string) (string, error) {
 }
 
 // AddPartition adds a partition. Size is expressed in MiB here
-// Size is expressed in MiB here
-func (dev *Disk) AddPartition(size uint, fileSystem string, pLabel string, flags ...string) (int, error) {
-	pc := NewPartitioner(dev.String(), dev.runner, dev.partBackend)
+// Size is expressed in MiB here. By default the partition is placed right after the last
+// existing one, the start offset, alignment, flags and GPT settings can be set with options.
+func (dev *Disk) AddPartition(size uint, fileSystem string, pLabel string, opts ...PartitionOptions) (int, error) {
+	pc := dev.newPartitioner()
+
+	spec := &partitionSpec{}
+	for _, opt := range opts {
+		if err := opt(spec); err != nil {
+			return 0, err
+		}
+	}
 
 	//Check we have loaded partition table data
 	if dev.sectorS == 0 {
@@ -266,8 +287,36 @@ func (dev *Disk) AddPartition(size uint, fileSystem string, pLabel string, flags
 		startS = 1024 * 1024 / dev.sectorS
 	}
 
-	size = MiBToSectors(size, dev.sectorS)
 	freeS := dev.computeFreeSpace()
+	firstFreeS := startS
+
+	// MSDOS tables only hold four primary partitions, logical partitions are held by an extended
+	// partition created as the fourth one up to the end of the disk. Each logical partition is
+	// preceded by its extended boot record, hence it is not placed right after the previous one.
+	if dev.label == types.MSDOS && (partNum > 4 || partNum == 3 && spec.logical) {
+		if partNum == 3 {
+			partNum++
+			pc.CreatePartition(&Partition{Number: partNum, StartS: startS, FileSystem: extended})
+		}
+		startS += MiBToSectors(1, dev.sectorS)
+	}
+	if spec.start > 0 {
+		reqStartS := MiBToSectors(spec.start, dev.sectorS)
+		if reqStartS < startS {
+			return 0, fmt.Errorf("requested start at %dMiB overlaps with existing partitions", spec.start)
+		}
+		startS = reqStartS
+	}
+	if spec.align > 0 {
+		alignS := MiBToSectors(spec.align, dev.sectorS)
+		startS = (startS + alignS - 1) / alignS * alignS
+	}
+	if startS-firstFreeS >= freeS {
+		return 0, fmt.Errorf("requested partition start sector %d is beyond the end of the disk", startS)
+	}
+	freeS -= startS - firstFreeS
+
+	size = MiBToSectors(size, dev.sectorS)
 	if size > freeS {
 		return 0, fmt.Errorf("not enough free space in disk. Required: %d sectors; Available %d sectors", size, freeS)
 	}
@@ -279,10 +328,13 @@ func (dev *Disk) AddPartition(size uint, fileSystem string, pLabel string, flags
 		SizeS:      size,
 		PLabel:     pLabel,
 		FileSystem: fileSystem,
+		TypeGUID:   spec.typeGUID,
+		UUID:       spec.uuid,
+		Attributes: spec.attributes,
 	}
 
 	pc.CreatePartition(&part)
-	for _, flag := range flags {
+	for _, flag := range spec.flags {
 		pc.SetPartitionFlag(partNum, flag, true)
 	}
 
@@ -341,7 +393,7 @@ func (dev Disk) FindPartitionDevice(partNum int) (string, error) {
 // ExpandLastPartition expands the latest partition in the disk. Size is expressed in MiB here
 // Size is expressed in MiB here
 func (dev *Disk) ExpandLastPartition(size uint) (string, error) {
-	pc := NewPartitioner(dev.String(), dev.runner, dev.partBackend)
+	pc := dev.newPartitioner()
 
 	//Check we have loaded partition table data
 	if dev.sectorS == 0 {
@@ -391,16 +443,119 @@ func (dev *Disk) ExpandLastPartition(size uint) (string, error) {
 	return dev.expandFilesystem(pDev)
 }
 
-func (dev Disk) expandFilesystem(device string) (outStr string, err error) {
-	var out []byte
-	var tmpDir, fs string
-
-	fs, err = utils.GetPartitionFS(device)
+func (dev Disk) expandFilesystem(device string) (string, error) {
+	fs, err := utils.GetPartitionFS(device)
 	if err != nil {
 		return fs, err
 	}
+	return dev.growFilesystem(device, strings.TrimSpace(fs))
+}
+
+// ResizePartition resizes the given partition and its filesystem. Size is expressed in MiB here,
+// a zero size grows the partition up to the next partition or up to the end of the disk.
+// Filesystems are shrunk before the partition and grown after it, so data is not lost
+// if any of the steps fails. Only supported by the parted backend.
+func (dev *Disk) ResizePartition(partNum int, size uint, fileSystem string) (string, error) {
+	if dev.partBackend != Parted {
+		return "", fmt.Errorf("resizing partitions is only supported by the parted backend")
+	}
+
+	//Check we have loaded partition table data
+	if dev.sectorS == 0 {
+		err := dev.Reload()
+		if err != nil {
+			dev.logger.Errorf("Failed analyzing disk: %v\n", err)
+			return "", err
+		}
+	}
+
+	var part, next *Partition
+	for i := range dev.parts {
+		if dev.parts[i].Number == partNum {
+			part = &dev.parts[i]
+		}
+	}
+	if part == nil {
+		return "", fmt.Errorf("partition %d not found in %s", partNum, dev)
+	}
+	for i := range dev.parts {
+		if dev.parts[i].StartS > part.StartS && (next == nil || dev.parts[i].StartS < next.StartS) {
+			next = &dev.parts[i]
+		}
+	}
+
+	resized := *part
+	switch {
+	case size > 0:
+		resized.SizeS = MiBToSectors(size, dev.sectorS)
+		if next != nil && resized.StartS+resized.SizeS > next.StartS {
+			return "", fmt.Errorf("not enough space to resize partition %d up to %d sectors, it overlaps with partition %d", partNum, resized.SizeS, next.Number)
+		}
+		if resized.StartS+resized.SizeS > dev.lastS {
+			return "", fmt.Errorf("not enough space to resize partition %d up to %d sectors", partNum, resized.SizeS)
+		}
+	case next != nil:
+		resized.SizeS = next.StartS - resized.StartS
+	default:
+		// Size set to zero on the last partition means all space available. Less than 1MiB
+		// left up to the last sector means it is already expanded, GPT keeps its backup
+		// partition table at the end of the disk.
+		target := dev.lastS - part.StartS
+		if target < part.SizeS+MiBToSectors(1, dev.sectorS) {
+			dev.logger.Infof("Partition %d already has the requested size", partNum)
+			return "", nil
+		}
+		resized.SizeS = 0
+	}
 
-	switch strings.TrimSpace(fs) {
+	if resized.SizeS == part.SizeS {
+		dev.logger.Infof("Partition %d already has the requested size", partNum)
+		return "", nil
+	}
+
+	pDev, err := dev.FindPartitionDevice(partNum)
+	if err != nil {
+		return "", err
+	}
+
+	shrink := resized.SizeS > 0 && resized.SizeS < part.SizeS
+	if shrink {
+		out, err := dev.shrinkFilesystem(pDev, fileSystem, resized.SizeS*dev.sectorS)
+		if err != nil {
+			dev.logger.Errorf("Failed shrinking filesystem of %s: %s", pDev, out)
+			return out, err
+		}
+	}
+
+	pc := newPartedCall(dev.String(), dev.runner)
+	err = pc.SetPartitionTableLabel(dev.label)
+	if err != nil {
+		return "", err
+	}
+	pc.ResizePartition(&resized)
+	out, err := pc.WriteChanges()
+	dev.logger.Debugf("partitioner output: %s", out)
+	if err != nil {
+		dev.logger.Errorf("Failed resizing partition: %v", err)
+		return out, err
+	}
+	err = dev.Reload()
+	if err != nil {
+		return "", err
+	}
+
+	if !shrink && fileSystem != "" {
+		return dev.growFilesystem(pDev, fileSystem)
+	}
+	return "", nil
+}
+
+// growFilesystem grows the filesystem of the given device to fill the whole partition
+func (dev Disk) growFilesystem(device, fs string) (outStr string, err error) {
+	var out []byte
+	var tmpDir string
+
+	switch fs {
 	case "ext2", "ext3", "ext4":
 		out, err = dev.runner.Run("e2fsck", "-fy", device)
 		if err != nil {
@@ -431,7 +586,7 @@ func (dev Disk) expandFilesystem(device string) (outStr string, err error) {
 				err = err2
 			}
 		}()
-		if strings.TrimSpace(fs) == "xfs" {
+		if fs == "xfs" {
 			out, err = dev.runner.Run("xfs_growfs", tmpDir)
 			if err != nil {
 				return string(out), err
@@ -448,3 +603,73 @@ func (dev Disk) expandFilesystem(device string) (outStr string, err error) {
 
 	return "", nil
 }
+
+// SupportsGrow returns true if the given filesystem can be grown. Partitions without a
+// filesystem can always be grown, only the partition is resized.
+func SupportsGrow(fileSystem string) bool {
+	switch fileSystem {
+	case "", "ext2", "ext3", "ext4", "xfs", "btrfs":
+		return true
+	default:
+		return false
+	}
+}
+
+// SupportsShrink returns true if the given filesystem can be shrunk
+func SupportsShrink(fileSystem string) bool {
+	switch fileSystem {
+	case "ext2", "ext3", "ext4", "btrfs":
+		return true
+	default:
+		return false
+	}
+}
+
+// shrinkFilesystem shrinks the filesystem of the given device to the given size in bytes.
+// The filesystem tools refuse to shrink below the space used by the current data.
+func (dev Disk) shrinkFilesystem(device, fs string, size uint) (outStr string, err error) {
+	var out []byte
+	var tmpDir string
+
+	switch fs {
+	case "ext2", "ext3", "ext4":
+		out, err = dev.runner.Run("e2fsck", "-fy", device)
+		if err != nil {
+			return string(out), err
+		}
+		out, err = dev.runner.Run("resize2fs", device, fmt.Sprintf("%dK", size/1024))
+		if err != nil {
+			return string(out), err
+		}
+	case "btrfs":
+		// btrfs can only be resized while mounted
+		tmpDir, err = utils.TempDir(dev.fs, "", "partitioner")
+		defer func(fs types.FS, path string) {
+			_ = fs.RemoveAll(path)
+		}(dev.fs, tmpDir)
+
+		if err != nil {
+			return string(out), err
+		}
+		err = dev.mounter.Mount(device, tmpDir, "auto", []string{})
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			err2 := dev.mounter.Unmount(tmpDir)
+			if err2 != nil && err == nil {
+				err = err2
+			}
+		}()
+		out, err = dev.runner.Run("btrfs", "filesystem", "resize", fmt.Sprintf("%d", size), tmpDir)
+		if err != nil {
+			return string(out), err
+		}
+	case "":
+		return "", fmt.Errorf("refusing to shrink %s, its filesystem is unknown", device)
+	default:
+		return "", fmt.Errorf("shrinking %s filesystems is not supported", fs)
+	}
+
+	return "", nil
+}
@@ -0,0 +1,442 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package partitioner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	uuidPkg "github.com/distribution/distribution/uuid"
+)
+
+const (
+	GPTSignature     = "EFI PART"
+	GPTRevision      = 0x00010000
+	GPTHeaderSize    = 92
+	GPTEntrySize     = 128
+	GPTEntries       = 128
+	GPTMaxNameLength = 36
+
+	// Protective MBR partition type and boot signature
+	MBRProtectiveType = 0xEE
+	MBRSignature      = 0xAA55
+	// MBR partition types of hybrid MBR partitions
+	MBRFAT32Type      = 0x0C
+	MBRLinuxType      = 0x83
+	mbrPartitionTable = 446
+
+	// GPT partition types of EFI system partitions and BIOS boot partitions
+	GPTEFISystemType = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+	GPTBIOSBootType  = "21686148-6449-6E6F-744E-656564454649"
+)
+
+// GPT partition type GUIDs of the sgdisk type codes
+var gptTypeGUIDs = map[string]string{
+	efiType:   GPTEFISystemType,
+	biosType:  GPTBIOSBootType,
+	linuxType: "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+}
+
+// GPTHeader is the GUID Partition Table header, as defined in the UEFI specification
+type GPTHeader struct {
+	Signature                [8]byte
+	Revision                 uint32
+	HeaderSize               uint32
+	HeaderCRC32              uint32
+	Reserved                 uint32
+	MyLBA                    uint64
+	AlternateLBA             uint64
+	FirstUsableLBA           uint64
+	LastUsableLBA            uint64
+	DiskGUID                 [16]byte
+	PartitionEntryLBA        uint64
+	NumberOfPartitionEntries uint32
+	SizeOfPartitionEntry     uint32
+	PartitionEntryArrayCRC32 uint32
+}
+
+// GPTEntry is a GUID Partition Table entry, as defined in the UEFI specification
+type GPTEntry struct {
+	PartitionTypeGUID   [16]byte
+	UniquePartitionGUID [16]byte
+	StartingLBA         uint64
+	EndingLBA           uint64
+	Attributes          uint64
+	PartitionName       [GPTMaxNameLength]uint16
+}
+
+// GPTGUID encodes the given GUID string in the mixed endian byte order used by GPT
+func GPTGUID(guid string) ([16]byte, error) {
+	var out [16]byte
+	b, err := hex.DecodeString(strings.ReplaceAll(guid, "-", ""))
+	if err != nil || len(b) != 16 {
+		return out, fmt.Errorf("invalid GUID '%s'", guid)
+	}
+	binary.LittleEndian.PutUint32(out[0:], binary.BigEndian.Uint32(b[0:]))
+	binary.LittleEndian.PutUint16(out[4:], binary.BigEndian.Uint16(b[4:]))
+	binary.LittleEndian.PutUint16(out[6:], binary.BigEndian.Uint16(b[6:]))
+	copy(out[8:], b[8:])
+	return out, nil
+}
+
+// gptPartitionType returns the partition type GUID of the given partition. Defaults match
+// the ones of sgdisk: FAT partitions are EFI system partitions, anything else is Linux data
+func gptPartitionType(p *Partition) string {
+	switch {
+	case p.TypeGUID != "" && gptTypeGUIDs[strings.ToUpper(p.TypeGUID)] != "":
+		return gptTypeGUIDs[strings.ToUpper(p.TypeGUID)]
+	case p.TypeGUID != "":
+		return p.TypeGUID
+	case regexp.MustCompile("fat|vfat").MatchString(p.FileSystem):
+		return gptTypeGUIDs[efiType]
+	default:
+		return gptTypeGUIDs[linuxType]
+	}
+}
+
+// newGPTEntry returns the GPT entry of the given partition. A zero size partition spans up to
+// the last usable sector.
+func newGPTEntry(p *Partition, firstUsable, lastUsable uint64) (GPTEntry, error) {
+	entry := GPTEntry{StartingLBA: uint64(p.StartS)}
+
+	end := lastUsable
+	if p.SizeS > 0 {
+		end = uint64(p.StartS) + uint64(p.SizeS) - 1
+	}
+	if entry.StartingLBA < firstUsable || end > lastUsable || end < entry.StartingLBA {
+		return entry, fmt.Errorf(
+			"partition %d (sectors %d-%d) does not fit in the usable sectors %d-%d",
+			p.Number, entry.StartingLBA, end, firstUsable, lastUsable,
+		)
+	}
+	entry.EndingLBA = end
+
+	var err error
+	entry.PartitionTypeGUID, err = GPTGUID(gptPartitionType(p))
+	if err != nil {
+		return entry, err
+	}
+	uuid := p.UUID
+	if uuid == "" {
+		uuid = uuidPkg.Generate().String()
+	}
+	entry.UniquePartitionGUID, err = GPTGUID(uuid)
+	if err != nil {
+		return entry, err
+	}
+	for _, bit := range p.Attributes {
+		entry.Attributes |= 1 << bit
+	}
+	name := utf16.Encode([]rune(p.PLabel))
+	if len(name) > GPTMaxNameLength {
+		return entry, fmt.Errorf("partition label '%s' is too long", p.PLabel)
+	}
+	copy(entry.PartitionName[:], name)
+	return entry, nil
+}
+
+// protectiveMBR returns the protective MBR of a GPT disk of the given sectors
+func protectiveMBR(sectors uint64) []byte {
+	mbr := make([]byte, 512)
+	entry := mbr[mbrPartitionTable:]
+	// Starting CHS 0/0/2, ending CHS is the maximum as the disk size does not fit in CHS
+	copy(entry[1:4], []byte{0x00, 0x02, 0x00})
+	entry[4] = MBRProtectiveType
+	copy(entry[5:8], []byte{0xff, 0xff, 0xff})
+	binary.LittleEndian.PutUint32(entry[8:], 1)
+	binary.LittleEndian.PutUint32(entry[12:], uint32(min(sectors-1, 0xffffffff)))
+	binary.LittleEndian.PutUint16(mbr[510:], MBRSignature)
+	return mbr
+}
+
+// gptGeometry returns the number of sectors and the first and last usable sectors of a GPT
+// partition table with the given sector size on the given disk
+func gptGeometry(f *os.File, sectorSize uint) (sectors, firstUsable, lastUsable uint64, err error) {
+	if sectorSize < 512 {
+		return 0, 0, 0, fmt.Errorf("invalid sector size %d", sectorSize)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	size, err := deviceSize(f, info)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	secSize := uint64(sectorSize)
+	sectors = uint64(size) / secSize
+	arraySectors := uint64(GPTEntries*GPTEntrySize+sectorSize-1) / secSize
+	if sectors < 2*arraySectors+3 {
+		return 0, 0, 0, fmt.Errorf("disk of %d bytes is too small for a GPT partition table", size)
+	}
+	return sectors, 2 + arraySectors, sectors - 2 - arraySectors, nil
+}
+
+// WriteGPT writes a new GPT partition table including the given partitions into the given disk
+// or disk image, any previous partition table is overwritten. It writes the protective MBR and the
+// primary and backup headers and partition entry arrays. Partitions are placed at the table entry
+// of their number and, as sgdisk does, a zero size partition spans up to the last usable sector.
+func WriteGPT(f *os.File, sectorSize uint, parts []*Partition) error {
+	diskGUID, err := GPTGUID(uuidPkg.Generate().String())
+	if err != nil {
+		return err
+	}
+	return writeGPT(f, sectorSize, diskGUID, parts)
+}
+
+// writeGPT writes a GPT partition table with the given disk GUID and partitions, see WriteGPT.
+// The backup header is always placed at the last sector of the disk.
+func writeGPT(f *os.File, sectorSize uint, diskGUID [16]byte, parts []*Partition) error {
+	sectors, firstUsable, lastUsable, err := gptGeometry(f, sectorSize)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]GPTEntry, GPTEntries)
+	for _, p := range parts {
+		if p.Number < 1 || p.Number > GPTEntries {
+			return fmt.Errorf("invalid partition number %d", p.Number)
+		}
+		if entries[p.Number-1].IsUsed() {
+			return fmt.Errorf("duplicated partition number %d", p.Number)
+		}
+		entries[p.Number-1], err = newGPTEntry(p, firstUsable, lastUsable)
+		if err != nil {
+			return err
+		}
+	}
+	for i, a := range entries {
+		for _, b := range entries[i+1:] {
+			if a.IsUsed() && b.IsUsed() && a.StartingLBA <= b.EndingLBA && b.StartingLBA <= a.EndingLBA {
+				return fmt.Errorf("overlapping partitions at sectors %d-%d and %d-%d", a.StartingLBA, a.EndingLBA, b.StartingLBA, b.EndingLBA)
+			}
+		}
+	}
+	array := new(bytes.Buffer)
+	_ = binary.Write(array, binary.LittleEndian, entries)
+
+	primary := GPTHeader{
+		Revision:                 GPTRevision,
+		HeaderSize:               GPTHeaderSize,
+		MyLBA:                    1,
+		AlternateLBA:             sectors - 1,
+		FirstUsableLBA:           firstUsable,
+		LastUsableLBA:            lastUsable,
+		DiskGUID:                 diskGUID,
+		PartitionEntryLBA:        2,
+		NumberOfPartitionEntries: GPTEntries,
+		SizeOfPartitionEntry:     GPTEntrySize,
+		PartitionEntryArrayCRC32: crc32.ChecksumIEEE(array.Bytes()),
+	}
+	copy(primary.Signature[:], GPTSignature)
+	backup := primary
+	backup.MyLBA, backup.AlternateLBA = primary.AlternateLBA, primary.MyLBA
+	backup.PartitionEntryLBA = lastUsable + 1
+
+	secSize := uint64(sectorSize)
+	writes := []struct {
+		lba  uint64
+		data []byte
+	}{
+		{0, protectiveMBR(sectors)},
+		{primary.MyLBA, gptHeaderSector(primary, sectorSize)},
+		{primary.PartitionEntryLBA, array.Bytes()},
+		{backup.PartitionEntryLBA, array.Bytes()},
+		{backup.MyLBA, gptHeaderSector(backup, sectorSize)},
+	}
+	for _, w := range writes {
+		if _, err = f.WriteAt(w.data, int64(w.lba*secSize)); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// WriteHybridMBR replaces the protective MBR of the GPT disk or disk image with a hybrid MBR including
+// the GPT partitions of the given numbers, so firmwares only reading MBR partition tables can find them.
+// EFI system partitions are typed as FAT32 (LBA) and any other partition as Linux. A protective partition
+// covers the GPT headers up to the first hybrid partition. The boot code of the current MBR is preserved.
+func WriteHybridMBR(f *os.File, sectorSize uint, numbers ...int) error {
+	if len(numbers) == 0 || len(numbers) > 3 {
+		return fmt.Errorf("a hybrid MBR requires from 1 to 3 partitions, got %d", len(numbers))
+	}
+	_, entries, err := ReadGPT(f, sectorSize)
+	if err != nil {
+		return err
+	}
+
+	mbr := make([]byte, 512)
+	if _, err = f.ReadAt(mbr[:mbrPartitionTable], 0); err != nil {
+		return err
+	}
+	firstStart := uint64(0xffffffff)
+	for i, num := range numbers {
+		if num < 1 || num > len(entries) || !entries[num-1].IsUsed() {
+			return fmt.Errorf("partition %d not found", num)
+		}
+		e := entries[num-1]
+		if e.EndingLBA > 0xffffffff {
+			return fmt.Errorf("partition %d ends beyond the MBR addressable sectors", num)
+		}
+		entry := mbr[mbrPartitionTable+i*16:]
+		// CHS addresses are set to their maximum value, only LBA addresses are used
+		copy(entry[1:4], []byte{0xfe, 0xff, 0xff})
+		entry[4] = MBRLinuxType
+		if GPTGUIDString(e.PartitionTypeGUID) == GPTEFISystemType {
+			entry[4] = MBRFAT32Type
+		}
+		copy(entry[5:8], []byte{0xfe, 0xff, 0xff})
+		binary.LittleEndian.PutUint32(entry[8:], uint32(e.StartingLBA))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(e.EndingLBA-e.StartingLBA+1))
+		firstStart = min(firstStart, e.StartingLBA)
+	}
+	entry := mbr[mbrPartitionTable+len(numbers)*16:]
+	copy(entry[1:4], []byte{0x00, 0x02, 0x00})
+	entry[4] = MBRProtectiveType
+	copy(entry[5:8], []byte{0xfe, 0xff, 0xff})
+	binary.LittleEndian.PutUint32(entry[8:], 1)
+	binary.LittleEndian.PutUint32(entry[12:], uint32(firstStart-1))
+	binary.LittleEndian.PutUint16(mbr[510:], MBRSignature)
+
+	if _, err = f.WriteAt(mbr, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// ReadGPT reads the GPT partition table of the given disk or disk image. The primary header is
+// used unless it is corrupted, in that case the backup header at the last sector of the disk is used.
+// Returns an error if no valid GPT partition table is found.
+func ReadGPT(f *os.File, sectorSize uint) (*GPTHeader, []GPTEntry, error) {
+	sectors, _, _, err := gptGeometry(f, sectorSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, entries, err := readGPTHeader(f, sectorSize, 1)
+	if err != nil {
+		var bErr error
+		header, entries, bErr = readGPTHeader(f, sectorSize, sectors-1)
+		if bErr != nil {
+			return nil, nil, err
+		}
+	}
+	return header, entries, nil
+}
+
+// readGPTHeader reads and verifies the GPT header at the given sector and its partition entries
+func readGPTHeader(f *os.File, sectorSize uint, lba uint64) (*GPTHeader, []GPTEntry, error) {
+	secSize := int64(sectorSize)
+	sector := make([]byte, sectorSize)
+	if _, err := f.ReadAt(sector, int64(lba)*secSize); err != nil {
+		return nil, nil, err
+	}
+	header := &GPTHeader{}
+	_ = binary.Read(bytes.NewReader(sector), binary.LittleEndian, header)
+	if string(header.Signature[:]) != GPTSignature {
+		return nil, nil, fmt.Errorf("no GPT header found at sector %d", lba)
+	}
+	if header.HeaderSize < GPTHeaderSize || header.HeaderSize > uint32(sectorSize) || header.MyLBA != lba {
+		return nil, nil, fmt.Errorf("invalid GPT header at sector %d", lba)
+	}
+	checksum := header.HeaderCRC32
+	binary.LittleEndian.PutUint32(sector[16:], 0)
+	if crc32.ChecksumIEEE(sector[:header.HeaderSize]) != checksum {
+		return nil, nil, fmt.Errorf("invalid GPT header checksum at sector %d", lba)
+	}
+	if header.SizeOfPartitionEntry != GPTEntrySize || header.NumberOfPartitionEntries > 1024 {
+		return nil, nil, fmt.Errorf("unsupported GPT partition entries at sector %d", lba)
+	}
+
+	array := make([]byte, header.NumberOfPartitionEntries*header.SizeOfPartitionEntry)
+	if _, err := f.ReadAt(array, int64(header.PartitionEntryLBA)*secSize); err != nil {
+		return nil, nil, err
+	}
+	if crc32.ChecksumIEEE(array) != header.PartitionEntryArrayCRC32 {
+		return nil, nil, fmt.Errorf("invalid GPT partition entries checksum at sector %d", lba)
+	}
+	entries := make([]GPTEntry, header.NumberOfPartitionEntries)
+	_ = binary.Read(bytes.NewReader(array), binary.LittleEndian, entries)
+	return header, entries, nil
+}
+
+// GPTGUIDString decodes the given GUID in the mixed endian byte order used by GPT
+func GPTGUIDString(guid [16]byte) string {
+	return fmt.Sprintf(
+		"%08X-%04X-%04X-%X-%X", binary.LittleEndian.Uint32(guid[0:]), binary.LittleEndian.Uint16(guid[4:]),
+		binary.LittleEndian.Uint16(guid[6:]), guid[8:10], guid[10:],
+	)
+}
+
+// IsUsed returns true if the entry defines a partition
+func (e GPTEntry) IsUsed() bool {
+	return e.PartitionTypeGUID != [16]byte{}
+}
+
+// ToPartition returns the partition defined by the entry with the given number
+func (e GPTEntry) ToPartition(number int) Partition {
+	name := e.PartitionName[:]
+	for len(name) > 0 && name[len(name)-1] == 0 {
+		name = name[:len(name)-1]
+	}
+	var attributes []uint
+	for bit := uint(0); bit < 64; bit++ {
+		if e.Attributes&(1<<bit) != 0 {
+			attributes = append(attributes, bit)
+		}
+	}
+	return Partition{
+		Number:     number,
+		StartS:     uint(e.StartingLBA),
+		SizeS:      uint(e.EndingLBA - e.StartingLBA + 1),
+		PLabel:     string(utf16.Decode(name)),
+		TypeGUID:   GPTGUIDString(e.PartitionTypeGUID),
+		UUID:       GPTGUIDString(e.UniquePartitionGUID),
+		Attributes: attributes,
+	}
+}
+
+// gptHeaderSector serializes the given header into a full sector, including its checksum
+func gptHeaderSector(header GPTHeader, sectorSize uint) []byte {
+	header.HeaderCRC32 = 0
+	buffer := new(bytes.Buffer)
+	_ = binary.Write(buffer, binary.LittleEndian, header)
+	header.HeaderCRC32 = crc32.ChecksumIEEE(buffer.Bytes())
+	buffer.Reset()
+	_ = binary.Write(buffer, binary.LittleEndian, header)
+	return append(buffer.Bytes(), make([]byte, int(sectorSize)-GPTHeaderSize)...)
+}
+
+// deviceSize returns the size of the given file, for block devices the size of the device
+func deviceSize(f *os.File, info os.FileInfo) (int64, error) {
+	if info.Mode()&os.ModeDevice == 0 {
+		return info.Size(), nil
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return size, err
+}
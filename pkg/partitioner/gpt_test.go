@@ -0,0 +1,193 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package partitioner_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"unicode/utf16"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	part "github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
+)
+
+// readGPTHeader parses and checks the GPT header at the given sector and returns it with its entries
+func readGPTHeader(disk []byte, lba uint64, sectorSize uint64) (part.GPTHeader, []part.GPTEntry) {
+	header := part.GPTHeader{}
+	Expect(binary.Read(bytes.NewReader(disk[lba*sectorSize:]), binary.LittleEndian, &header)).To(Succeed())
+	Expect(string(header.Signature[:])).To(Equal(part.GPTSignature))
+	Expect(header.MyLBA).To(Equal(lba))
+
+	raw := bytes.Clone(disk[lba*sectorSize : lba*sectorSize+part.GPTHeaderSize])
+	binary.LittleEndian.PutUint32(raw[16:], 0)
+	Expect(header.HeaderCRC32).To(Equal(crc32.ChecksumIEEE(raw)))
+
+	start := header.PartitionEntryLBA * sectorSize
+	array := disk[start : start+uint64(header.NumberOfPartitionEntries*header.SizeOfPartitionEntry)]
+	Expect(header.PartitionEntryArrayCRC32).To(Equal(crc32.ChecksumIEEE(array)))
+	entries := make([]part.GPTEntry, header.NumberOfPartitionEntries)
+	Expect(binary.Read(bytes.NewReader(array), binary.LittleEndian, entries)).To(Succeed())
+	return header, entries
+}
+
+// gptName decodes the name of the given GPT entry
+func gptName(entry part.GPTEntry) string {
+	name := entry.PartitionName[:]
+	for len(name) > 0 && name[len(name)-1] == 0 {
+		name = name[:len(name)-1]
+	}
+	return string(utf16.Decode(name))
+}
+
+var _ = Describe("GPT", Label("gpt"), func() {
+	var fs vfs.FS
+	var cleanup func()
+	var disk *os.File
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fs.WriteFile("/disk.img", []byte{}, constants.FilePerm)).To(Succeed())
+		disk, err = fs.OpenFile("/disk.img", os.O_RDWR, constants.FilePerm)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disk.Truncate(64 * 1024 * 1024)).To(Succeed())
+	})
+	AfterEach(func() {
+		disk.Close()
+		cleanup()
+	})
+
+	It("writes a GPT partition table into an image file", func() {
+		parts := []*part.Partition{
+			{Number: 1, StartS: 2048, SizeS: 20480, PLabel: "efi", FileSystem: "vfat"},
+			{
+				Number: 2, StartS: 22528, SizeS: 40960, PLabel: "oem", FileSystem: "ext4",
+				UUID: "2DC27766-F623-4200-9D64-115E9BFD4A08", Attributes: []uint{2, 63},
+			},
+			{Number: 3, StartS: 63488, PLabel: "recovery", TypeGUID: "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709"},
+		}
+		Expect(part.WriteGPT(disk, 512, parts)).To(Succeed())
+
+		data, err := fs.ReadFile("/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(HaveLen(64 * 1024 * 1024))
+		sectors := uint64(len(data) / 512)
+
+		// Protective MBR covering the whole disk
+		Expect(binary.LittleEndian.Uint16(data[510:])).To(Equal(uint16(part.MBRSignature)))
+		Expect(data[446+4]).To(Equal(byte(part.MBRProtectiveType)))
+		Expect(binary.LittleEndian.Uint32(data[446+8:])).To(Equal(uint32(1)))
+		Expect(binary.LittleEndian.Uint32(data[446+12:])).To(Equal(uint32(sectors - 1)))
+
+		primary, entries := readGPTHeader(data, 1, 512)
+		Expect(primary.AlternateLBA).To(Equal(sectors - 1))
+		Expect(primary.FirstUsableLBA).To(Equal(uint64(34)))
+		Expect(primary.LastUsableLBA).To(Equal(sectors - 34))
+		Expect(primary.PartitionEntryLBA).To(Equal(uint64(2)))
+
+		backup, backupEntries := readGPTHeader(data, sectors-1, 512)
+		Expect(backup.AlternateLBA).To(Equal(uint64(1)))
+		Expect(backup.PartitionEntryLBA).To(Equal(sectors - 33))
+		Expect(backup.DiskGUID).To(Equal(primary.DiskGUID))
+		Expect(backupEntries).To(Equal(entries))
+
+		efiType, _ := part.GPTGUID("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+		Expect(entries[0].PartitionTypeGUID).To(Equal(efiType))
+		Expect(entries[0].StartingLBA).To(Equal(uint64(2048)))
+		Expect(entries[0].EndingLBA).To(Equal(uint64(22527)))
+		Expect(gptName(entries[0])).To(Equal("efi"))
+
+		linuxType, _ := part.GPTGUID("0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+		uuid, _ := part.GPTGUID("2DC27766-F623-4200-9D64-115E9BFD4A08")
+		Expect(entries[1].PartitionTypeGUID).To(Equal(linuxType))
+		Expect(entries[1].UniquePartitionGUID).To(Equal(uuid))
+		Expect(entries[1].Attributes).To(Equal(uint64(1<<2 | 1<<63)))
+		Expect(gptName(entries[1])).To(Equal("oem"))
+
+		// Zero size partitions take the rest of the disk
+		customType, _ := part.GPTGUID("4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709")
+		Expect(entries[2].PartitionTypeGUID).To(Equal(customType))
+		Expect(entries[2].EndingLBA).To(Equal(primary.LastUsableLBA))
+		Expect(entries[3]).To(Equal(part.GPTEntry{}))
+	})
+
+	It("encodes GUIDs in mixed endian byte order", func() {
+		guid, err := part.GPTGUID("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(guid).To(Equal([16]byte{
+			0x28, 0x73, 0x2a, 0xc1, 0x1f, 0xf8, 0xd2, 0x11, 0xba, 0x4b, 0x00, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b,
+		}))
+		_, err = part.GPTGUID("not-a-guid")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails on partitions out of the usable sectors", func() {
+		err := part.WriteGPT(disk, 512, []*part.Partition{{Number: 1, StartS: 2048, SizeS: 64 * 2048}})
+		Expect(err).To(HaveOccurred())
+		err = part.WriteGPT(disk, 512, []*part.Partition{{Number: 1, StartS: 10, SizeS: 2048}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("writes a hybrid MBR including the given GPT partitions", func() {
+		Expect(part.WriteGPT(disk, 512, []*part.Partition{
+			{Number: 1, StartS: 2048, SizeS: 20480, PLabel: "efi", FileSystem: "vfat"},
+			{Number: 2, StartS: 22528, SizeS: 40960, PLabel: "oem", FileSystem: "ext4"},
+		})).To(Succeed())
+		_, err := disk.WriteAt([]byte{0xEB, 0x63, 0x90}, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(part.WriteHybridMBR(disk, 512, 1)).To(Succeed())
+
+		data, err := fs.ReadFile("/disk.img")
+		Expect(err).ToNot(HaveOccurred())
+		// Boot code is preserved
+		Expect(data[:3]).To(Equal([]byte{0xEB, 0x63, 0x90}))
+		Expect(binary.LittleEndian.Uint16(data[510:])).To(Equal(uint16(part.MBRSignature)))
+		// FAT partition first, then the protective partition up to it
+		Expect(data[446+4]).To(Equal(byte(part.MBRFAT32Type)))
+		Expect(binary.LittleEndian.Uint32(data[446+8:])).To(Equal(uint32(2048)))
+		Expect(binary.LittleEndian.Uint32(data[446+12:])).To(Equal(uint32(20480)))
+		Expect(data[462+4]).To(Equal(byte(part.MBRProtectiveType)))
+		Expect(binary.LittleEndian.Uint32(data[462+8:])).To(Equal(uint32(1)))
+		Expect(binary.LittleEndian.Uint32(data[462+12:])).To(Equal(uint32(2047)))
+		Expect(data[478:510]).To(Equal(make([]byte, 32)))
+
+		// GPT is still valid
+		_, entries, err := part.ReadGPT(disk, 512)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[1].StartingLBA).To(Equal(uint64(22528)))
+
+		Expect(part.WriteHybridMBR(disk, 512, 3)).To(MatchError(ContainSubstring("not found")))
+		Expect(part.WriteHybridMBR(disk, 512)).NotTo(Succeed())
+	})
+
+	It("fails on overlapping partitions", func() {
+		err := part.WriteGPT(disk, 512, []*part.Partition{
+			{Number: 1, StartS: 2048, SizeS: 4096},
+			{Number: 2, StartS: 4096, SizeS: 4096},
+		})
+		Expect(err).To(MatchError(ContainSubstring("overlapping")))
+	})
+})
@@ -0,0 +1,345 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package partitioner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	uuidPkg "github.com/distribution/distribution/uuid"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+const (
+	// Block device ioctls to get the logical sector size and to reread the partition table
+	blkSSZGet  = 0x1268
+	blkRRPart  = 0x125F
+	defSecSize = 512
+	// GPT attribute bit of legacy BIOS bootable partitions
+	legacyBootBit = 2
+)
+
+// GPT partition type GUIDs of the parted flags setting a partition type, as parted does on GPT disks
+var nativeFlagTypes = map[string]string{
+	"esp":             GPTEFISystemType,
+	"boot":            GPTEFISystemType,
+	"bios_grub":       GPTBIOSBootType,
+	"lvm":             "E6D6D379-F507-44C2-A23C-238F2A3DF928",
+	"raid":            "A19D880F-05FC-4D3B-A006-743F0F84911E",
+	"swap":            "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F",
+	"linux-home":      "933AC7E1-2EB4-4F13-B844-0E14E2AEF915",
+	"bls_boot":        "BC13C2FF-59E6-4262-A352-B275FD6F7172",
+	"prep":            "9E1A2D38-C612-4316-AA26-8B49521E5A8B",
+	"msftdata":        "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7",
+	"msftres":         "E3C9E316-0B5C-4DB8-817D-F92DF00215AE",
+	"diag":            "DE94BBA4-06D1-4D40-A16A-BFD50179D6AC",
+	"irst":            "D3BFE2DE-3DAF-11DF-BA40-E3A556D89593",
+	"hp-service":      "E2A1E728-32E3-11D6-A682-7B03A0000000",
+	"atvrecv":         "5265636F-7665-11AA-AA11-00306543ECAC",
+	"chromeos_kernel": "FE3A2A5D-4F32-41A7-B725-ACCC3285A309",
+}
+
+// GPT attribute bits of the parted flags setting a partition attribute, as parted does on GPT disks
+var nativeFlagAttributes = map[string]uint{
+	"hidden":       0,
+	"legacy_boot":  legacyBootBit,
+	"no_automount": 63,
+}
+
+// nativeCall reads and writes GPT partition tables directly on a block device or
+// a disk image, it does not require any partitioning tool
+type nativeCall struct {
+	dev       string
+	fs        types.FS
+	runner    types.Runner
+	wipe      bool
+	parts     []*Partition
+	deletions []int
+	flags     map[int]map[string]bool
+}
+
+var _ Partitioner = (*nativeCall)(nil)
+
+func newNativeCall(dev string, fs types.FS, runner types.Runner) *nativeCall {
+	return &nativeCall{
+		dev:       dev,
+		fs:        fs,
+		runner:    runner,
+		parts:     []*Partition{},
+		deletions: []int{},
+		flags:     map[int]map[string]bool{},
+	}
+}
+
+// NewNativePartitioner returns a partitioner writing GPT partition tables natively on the given
+// device or disk image file through the given filesystem
+func NewNativePartitioner(dev string, fs types.FS, runner types.Runner) Partitioner {
+	return newNativeCall(dev, fs, runner)
+}
+
+// open opens the device and returns it with its logical sector size
+func (nc nativeCall) open(flag int) (*os.File, uint, error) {
+	f, err := nc.fs.OpenFile(nc.dev, flag, constants.FilePerm)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	if info.Mode()&os.ModeDevice == 0 {
+		return f, defSecSize, nil
+	}
+	var secSize int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkSSZGet, uintptr(unsafe.Pointer(&secSize)))
+	if errno != 0 || secSize <= 0 {
+		return f, defSecSize, nil
+	}
+	return f, uint(secSize), nil
+}
+
+func (nc *nativeCall) WriteChanges() (string, error) {
+	f, secSize, err := nc.open(os.O_RDWR)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var diskGUID [16]byte
+	parts := map[int]*Partition{}
+	if header, entries, rErr := ReadGPT(f, secSize); rErr == nil && !nc.wipe {
+		diskGUID = header.DiskGUID
+		for i, entry := range entries {
+			if entry.IsUsed() {
+				part := entry.ToPartition(i + 1)
+				parts[i+1] = &part
+			}
+		}
+	} else {
+		diskGUID, err = GPTGUID(uuidPkg.Generate().String())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, num := range nc.deletions {
+		delete(parts, num)
+	}
+	for _, part := range nc.parts {
+		if _, ok := parts[part.Number]; ok {
+			return "", fmt.Errorf("partition %d already exists", part.Number)
+		}
+		p := *part
+		parts[part.Number] = &p
+	}
+	for num, flags := range nc.flags {
+		part, ok := parts[num]
+		if !ok {
+			return "", fmt.Errorf("can't set flags, partition %d not found", num)
+		}
+		for flag, active := range flags {
+			if err = setNativeFlag(part, flag, active); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	list := []*Partition{}
+	for _, part := range parts {
+		list = append(list, part)
+	}
+	err = writeGPT(f, secSize, diskGUID, list)
+	if err != nil {
+		return "", err
+	}
+
+	// Notify kernel of partition table changes, just a best effort for block devices
+	if info, sErr := f.Stat(); sErr == nil && info.Mode()&os.ModeDevice != 0 {
+		_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkRRPart, 0)
+		_, _ = nc.runner.Run("partx", "-u", nc.dev)
+	}
+
+	nc.wipe = false
+	nc.parts = []*Partition{}
+	nc.deletions = []int{}
+	nc.flags = map[int]map[string]bool{}
+	return fmt.Sprintf("The operation has completed successfully, %d partitions written to %s", len(list), nc.dev), nil
+}
+
+// setNativeFlag maps the given parted flag to the GPT partition type or attributes
+func setNativeFlag(part *Partition, flag string, active bool) error {
+	if typeGUID, ok := nativeFlagTypes[flag]; ok {
+		setNativeType(part, typeGUID, active)
+		return nil
+	}
+
+	bit, ok := nativeFlagAttributes[flag]
+	if !ok {
+		return fmt.Errorf("unsupported partition flag '%s'", flag)
+	}
+	attributes := []uint{}
+	for _, b := range part.Attributes {
+		if b != bit {
+			attributes = append(attributes, b)
+		}
+	}
+	if active {
+		attributes = append(attributes, bit)
+	}
+	part.Attributes = attributes
+	return nil
+}
+
+// setNativeType sets the given partition type GUID, or resets it to the linux type if not active
+func setNativeType(part *Partition, typeGUID string, active bool) {
+	if active {
+		part.TypeGUID = typeGUID
+	} else if gptPartitionType(part) == typeGUID {
+		part.TypeGUID = gptTypeGUIDs[linuxType]
+	}
+}
+
+func (nc *nativeCall) SetPartitionTableLabel(label string) error {
+	if label != types.GPT {
+		return fmt.Errorf("invalid partition table type (%s), only GPT is supported by the native partitioner", label)
+	}
+	return nil
+}
+
+func (nc *nativeCall) CreatePartition(p *Partition) {
+	nc.parts = append(nc.parts, p)
+}
+
+func (nc *nativeCall) DeletePartition(num int) {
+	nc.deletions = append(nc.deletions, num)
+}
+
+func (nc *nativeCall) SetPartitionFlag(num int, flag string, active bool) {
+	if _, ok := nc.flags[num]; !ok {
+		nc.flags[num] = map[string]bool{}
+	}
+	nc.flags[num][flag] = active
+}
+
+func (nc *nativeCall) WipeTable(wipe bool) {
+	nc.wipe = wipe
+}
+
+// Print describes the partition table of the device. The usable sectors are computed
+// for the current device size, so tables of expanded disks are relocated on the next write.
+func (nc nativeCall) Print() (string, error) {
+	f, secSize, err := nc.open(os.O_RDONLY)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sectors, firstUsable, lastUsable, err := gptGeometry(f, secSize)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "Disk %s: %d sectors\n", nc.dev, sectors)
+	fmt.Fprintf(&out, "Sector size (logical): %d bytes\n", secSize)
+	fmt.Fprintf(&out, "First usable sector is %d, last usable sector is %d\n", firstUsable, lastUsable)
+
+	header, entries, err := ReadGPT(f, secSize)
+	if err != nil {
+		fmt.Fprintf(&out, "Partition table: unknown\n")
+		return out.String(), nil
+	}
+	fmt.Fprintf(&out, "Partition table: %s\n", types.GPT)
+	fmt.Fprintf(&out, "Disk identifier (GUID): %s\n", GPTGUIDString(header.DiskGUID))
+	fmt.Fprintf(&out, "Number Start End Type UUID Attributes Name\n")
+	for i, entry := range entries {
+		if entry.IsUsed() {
+			part := entry.ToPartition(i + 1)
+			fmt.Fprintf(
+				&out, "%d %d %d %s %s %016X %s\n", part.Number, entry.StartingLBA, entry.EndingLBA,
+				part.TypeGUID, part.UUID, entry.Attributes, part.PLabel,
+			)
+		}
+	}
+	return out.String(), nil
+}
+
+// Parses the output of a nativeCall.Print call
+func (nc nativeCall) GetLastSector(printOut string) (uint, error) {
+	re := regexp.MustCompile(`last usable sector is (\d+)`)
+	match := re.FindStringSubmatch(printOut)
+	if match != nil {
+		endS, err := strconv.ParseUint(match[1], 10, 0)
+		return uint(endS), err
+	}
+	return 0, errors.New("could not determine last usable sector")
+}
+
+// Parses the output of a nativeCall.Print call
+func (nc nativeCall) GetSectorSize(printOut string) (uint, error) {
+	re := regexp.MustCompile(`Sector size \(logical\): (\d+) bytes`)
+	match := re.FindStringSubmatch(printOut)
+	if match != nil {
+		size, err := strconv.ParseUint(match[1], 10, 0)
+		return uint(size), err
+	}
+	return 0, errors.New("could not determine sector size")
+}
+
+// Parses the output of a nativeCall.Print call
+func (nc nativeCall) GetPartitionTableLabel(printOut string) (string, error) {
+	re := regexp.MustCompile(`(?m)^Partition table: (\S+)$`)
+	match := re.FindStringSubmatch(printOut)
+	if match != nil {
+		return match[1], nil
+	}
+	return "", errors.New("could not determine partition table type")
+}
+
+// Parses the output of a nativeCall.Print call
+func (nc nativeCall) GetPartitions(printOut string) []Partition {
+	re := regexp.MustCompile(`^(\d+) (\d+) (\d+) (\S+) (\S+) ([0-9A-F]{16}) ?(.*)$`)
+	var partitions []Partition
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimSpace(printOut)))
+	for scanner.Scan() {
+		match := re.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		partNum, _ := strconv.Atoi(match[1])
+		start, _ := strconv.ParseUint(match[2], 10, 0)
+		end, _ := strconv.ParseUint(match[3], 10, 0)
+		attrs, _ := strconv.ParseUint(match[6], 16, 64)
+		part := GPTEntry{StartingLBA: start, EndingLBA: end, Attributes: attrs}.ToPartition(partNum)
+		part.TypeGUID = match[4]
+		part.UUID = match[5]
+		part.PLabel = match[7]
+		partitions = append(partitions, part)
+	}
+	return partitions
+}
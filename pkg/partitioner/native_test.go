@@ -0,0 +1,266 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package partitioner_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	mocks "github.com/rancher/elemental-toolkit/v2/pkg/mocks"
+	part "github.com/rancher/elemental-toolkit/v2/pkg/partitioner"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+const (
+	efiGUID   = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+	biosGUID  = "21686148-6449-6E6F-744E-656564454649"
+	linuxGUID = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+)
+
+var _ = Describe("Native partitioner", Label("native", "partitioner"), func() {
+	var fs vfs.FS
+	var cleanup func()
+	var runner *mocks.FakeRunner
+	var nc part.Partitioner
+
+	// sectors of the 64MiB test disk, the last usable sector is 33 sectors before the backup header
+	const sectors = 64 * 2048
+	const lastUsable = sectors - 34
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fs.WriteFile("/disk.img", []byte{}, constants.FilePerm)).To(Succeed())
+		Expect(fs.Truncate("/disk.img", sectors*512)).To(Succeed())
+		runner = mocks.NewFakeRunner()
+		nc = part.NewNativePartitioner("/disk.img", fs, runner)
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("is selectable by NewPartitioner", func() {
+		Expect(part.NewPartitioner("/dev/sda", runner, part.Native)).ToNot(BeNil())
+	})
+
+	It("reports a disk without partition table", func() {
+		out, err := nc.Print()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nc.GetSectorSize(out)).To(Equal(uint(512)))
+		Expect(nc.GetLastSector(out)).To(Equal(uint(lastUsable)))
+		Expect(nc.GetPartitionTableLabel(out)).To(Equal("unknown"))
+		Expect(nc.GetPartitions(out)).To(BeEmpty())
+	})
+
+	It("only supports GPT partition tables", func() {
+		Expect(nc.SetPartitionTableLabel(types.GPT)).To(Succeed())
+		Expect(nc.SetPartitionTableLabel(types.MSDOS)).NotTo(Succeed())
+	})
+
+	It("creates partitions and reads them back", func() {
+		nc.WipeTable(true)
+		nc.CreatePartition(&part.Partition{Number: 1, StartS: 2048, SizeS: 2048, PLabel: "efi", FileSystem: "vfat"})
+		nc.CreatePartition(&part.Partition{
+			Number: 2, StartS: 4096, SizeS: 8192, PLabel: "my state", FileSystem: "ext4",
+			UUID: "2DC27766-F623-4200-9D64-115E9BFD4A08", Attributes: []uint{2, 60},
+		})
+		nc.CreatePartition(&part.Partition{Number: 3, StartS: 12288, PLabel: "persistent"})
+		_, err := nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		// Partition table changes of image files are not notified to the kernel
+		Expect(runner.GetCmds()).To(BeEmpty())
+
+		out, err := nc.Print()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nc.GetPartitionTableLabel(out)).To(Equal(types.GPT))
+		Expect(nc.GetPartitions(out)).To(Equal([]part.Partition{
+			{Number: 1, StartS: 2048, SizeS: 2048, PLabel: "efi", TypeGUID: efiGUID, UUID: nc.GetPartitions(out)[0].UUID},
+			{
+				Number: 2, StartS: 4096, SizeS: 8192, PLabel: "my state", TypeGUID: linuxGUID,
+				UUID: "2DC27766-F623-4200-9D64-115E9BFD4A08", Attributes: []uint{2, 60},
+			},
+			{
+				Number: 3, StartS: 12288, SizeS: lastUsable - 12288 + 1, PLabel: "persistent", TypeGUID: linuxGUID,
+				UUID: nc.GetPartitions(out)[2].UUID,
+			},
+		}))
+	})
+
+	It("deletes and recreates partitions keeping the rest of the table", func() {
+		nc.CreatePartition(&part.Partition{Number: 1, StartS: 2048, SizeS: 2048, PLabel: "efi", FileSystem: "vfat"})
+		nc.CreatePartition(&part.Partition{Number: 2, StartS: 4096, SizeS: 2048, PLabel: "state", FileSystem: "ext4"})
+		_, err := nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		out, _ := nc.Print()
+		parts := nc.GetPartitions(out)
+		Expect(parts).To(HaveLen(2))
+
+		// Expand the last partition up to the end of the disk
+		last := parts[1]
+		last.SizeS = 0
+		nc.DeletePartition(last.Number)
+		nc.CreatePartition(&last)
+		_, err = nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+
+		out, _ = nc.Print()
+		expanded := nc.GetPartitions(out)
+		Expect(expanded).To(HaveLen(2))
+		Expect(expanded[0]).To(Equal(parts[0]))
+		Expect(expanded[1].UUID).To(Equal(parts[1].UUID))
+		Expect(expanded[1].SizeS).To(Equal(uint(lastUsable - 4096 + 1)))
+
+		nc.DeletePartition(1)
+		_, err = nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		out, _ = nc.Print()
+		Expect(nc.GetPartitions(out)).To(Equal(expanded[1:]))
+	})
+
+	It("fails to create an already existing partition", func() {
+		nc.CreatePartition(&part.Partition{Number: 1, StartS: 2048, SizeS: 2048})
+		_, err := nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		nc.CreatePartition(&part.Partition{Number: 1, StartS: 8192, SizeS: 2048})
+		_, err = nc.WriteChanges()
+		Expect(err).To(MatchError(ContainSubstring("already exists")))
+	})
+
+	It("sets partition flags", func() {
+		nc.CreatePartition(&part.Partition{Number: 1, StartS: 2048, SizeS: 2048, FileSystem: "ext4"})
+		nc.CreatePartition(&part.Partition{Number: 2, StartS: 4096, SizeS: 2048, FileSystem: "ext4"})
+		nc.SetPartitionFlag(1, "bios_grub", true)
+		nc.SetPartitionFlag(2, "esp", true)
+		nc.SetPartitionFlag(2, "legacy_boot", true)
+		_, err := nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		out, _ := nc.Print()
+		parts := nc.GetPartitions(out)
+		Expect(parts[0].TypeGUID).To(Equal(biosGUID))
+		Expect(parts[1].TypeGUID).To(Equal(efiGUID))
+		Expect(parts[1].Attributes).To(Equal([]uint{2}))
+
+		nc.SetPartitionFlag(2, "esp", false)
+		nc.SetPartitionFlag(2, "legacy_boot", false)
+		_, err = nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		out, _ = nc.Print()
+		parts = nc.GetPartitions(out)
+		Expect(parts[1].TypeGUID).To(Equal(linuxGUID))
+		Expect(parts[1].Attributes).To(BeEmpty())
+
+		nc.SetPartitionFlag(1, "lvm", true)
+		nc.SetPartitionFlag(2, "raid", true)
+		nc.SetPartitionFlag(2, "no_automount", true)
+		_, err = nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		out, _ = nc.Print()
+		parts = nc.GetPartitions(out)
+		Expect(parts[0].TypeGUID).To(Equal("E6D6D379-F507-44C2-A23C-238F2A3DF928"))
+		Expect(parts[1].TypeGUID).To(Equal("A19D880F-05FC-4D3B-A006-743F0F84911E"))
+		Expect(parts[1].Attributes).To(Equal([]uint{63}))
+
+		nc.SetPartitionFlag(1, "palo", true)
+		_, err = nc.WriteChanges()
+		Expect(err).To(MatchError(ContainSubstring("unsupported partition flag")))
+	})
+
+	It("reads the backup partition table if the primary header is corrupted", func() {
+		nc.CreatePartition(&part.Partition{Number: 1, StartS: 2048, SizeS: 2048, PLabel: "efi", FileSystem: "vfat"})
+		_, err := nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		out, _ := nc.Print()
+		parts := nc.GetPartitions(out)
+
+		f, err := fs.OpenFile("/disk.img", os.O_RDWR, constants.FilePerm)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.WriteAt([]byte("garbage"), 512+24)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		out, err = nc.Print()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nc.GetPartitionTableLabel(out)).To(Equal(types.GPT))
+		Expect(nc.GetPartitions(out)).To(Equal(parts))
+
+		// Writing changes restores the primary header
+		_, err = nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		f, err = fs.OpenFile("/disk.img", os.O_RDONLY, constants.FilePerm)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		header, _, err := part.ReadGPT(f, 512)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.MyLBA).To(Equal(uint64(1)))
+	})
+
+	It("relocates the backup partition table of expanded disks", func() {
+		nc.CreatePartition(&part.Partition{Number: 1, StartS: 2048, SizeS: 2048})
+		_, err := nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fs.Truncate("/disk.img", 2*sectors*512)).To(Succeed())
+
+		out, _ := nc.Print()
+		Expect(nc.GetLastSector(out)).To(Equal(uint(2*sectors - 34)))
+		Expect(nc.GetPartitions(out)).To(HaveLen(1))
+
+		_, err = nc.WriteChanges()
+		Expect(err).ToNot(HaveOccurred())
+		f, err := fs.OpenFile("/disk.img", os.O_RDONLY, constants.FilePerm)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		header, _, err := part.ReadGPT(f, 512)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.AlternateLBA).To(Equal(uint64(2*sectors - 1)))
+	})
+
+	It("fails to print a missing device", func() {
+		nc = part.NewNativePartitioner("/nonexisting.img", fs, runner)
+		_, err := nc.Print()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("partitions a disk with the native backend", func() {
+		disk := part.NewDisk("/disk.img", part.WithFS(fs), part.WithRunner(runner), part.WithNative())
+		_, err := disk.NewPartitionTable(types.GPT)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disk.GetLabel()).To(Equal(types.GPT))
+
+		num, err := disk.AddPartition(4, "vfat", "efi", part.WithPartitionFlags("esp"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(num).To(Equal(1))
+		num, err = disk.AddPartition(16, "ext4", "state", part.WithPartitionAttributes(2))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(num).To(Equal(2))
+
+		parts := disk.GetPartitions()
+		Expect(parts).To(HaveLen(2))
+		Expect(parts[0].StartS).To(Equal(uint(2048)))
+		Expect(parts[0].SizeS).To(Equal(uint(4 * 2048)))
+		Expect(parts[0].TypeGUID).To(Equal(efiGUID))
+		Expect(parts[1].StartS).To(Equal(uint(5 * 2048)))
+		Expect(parts[1].TypeGUID).To(Equal(linuxGUID))
+		Expect(parts[1].Attributes).To(Equal([]uint{2}))
+		Expect(runner.GetCmds()).To(BeEmpty())
+	})
+})
@@ -17,6 +17,8 @@ limitations under the License.
 package partitioner
 
 import (
+	"fmt"
+
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 )
 
@@ -50,9 +52,94 @@ func WithGdisk() func(d *Disk) error {
 	}
 }
 
+// WithNative sets the native GPT partitioner backend, which does not require any partitioning tool
+func WithNative() func(d *Disk) error {
+	return func(d *Disk) error {
+		d.partBackend = Native
+		return nil
+	}
+}
+
 func WithMounter(mounter types.Mounter) func(d *Disk) error {
 	return func(d *Disk) error {
 		d.mounter = mounter
 		return nil
 	}
 }
+
+// PartitionOptions configures a partition added with Disk.AddPartition
+type PartitionOptions func(p *partitionSpec) error
+
+// partitionSpec holds the settings of a new partition, start and alignment are expressed in MiB
+type partitionSpec struct {
+	start      uint
+	align      uint
+	flags      []string
+	typeGUID   string
+	uuid       string
+	attributes []uint
+	logical    bool
+}
+
+// WithPartitionFlags sets the given flags on the new partition
+func WithPartitionFlags(flags ...string) PartitionOptions {
+	return func(p *partitionSpec) error {
+		p.flags = append(p.flags, flags...)
+		return nil
+	}
+}
+
+// WithPartitionStart sets the start offset in MiB of the new partition. By default partitions are
+// placed right after the last existing partition.
+func WithPartitionStart(start uint) PartitionOptions {
+	return func(p *partitionSpec) error {
+		p.start = start
+		return nil
+	}
+}
+
+// WithPartitionAlignment aligns the start of the new partition to the given size in MiB
+func WithPartitionAlignment(align uint) PartitionOptions {
+	return func(p *partitionSpec) error {
+		p.align = align
+		return nil
+	}
+}
+
+// WithPartitionType sets the GPT partition type GUID of the new partition
+func WithPartitionType(guid string) PartitionOptions {
+	return func(p *partitionSpec) error {
+		p.typeGUID = guid
+		return nil
+	}
+}
+
+// WithPartitionUUID sets the GPT unique partition GUID of the new partition
+func WithPartitionUUID(uuid string) PartitionOptions {
+	return func(p *partitionSpec) error {
+		p.uuid = uuid
+		return nil
+	}
+}
+
+// WithPartitionAttributes sets the given GPT attribute bits on the new partition
+func WithPartitionAttributes(bits ...uint) PartitionOptions {
+	return func(p *partitionSpec) error {
+		for _, bit := range bits {
+			if bit > 63 {
+				return fmt.Errorf("invalid GPT attribute bit %d", bit)
+			}
+		}
+		p.attributes = append(p.attributes, bits...)
+		return nil
+	}
+}
+
+// WithLogicalPartition creates the new partition as a logical partition on MSDOS partition tables.
+// The extended partition holding it is created as the fourth partition if not present yet.
+func WithLogicalPartition() PartitionOptions {
+	return func(p *partitionSpec) error {
+		p.logical = true
+		return nil
+	}
+}
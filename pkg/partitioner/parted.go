@@ -33,6 +33,7 @@ type partedCall struct {
 	wipe      bool
 	parts     []*Partition
 	deletions []int
+	resizes   []*Partition
 	label     string
 	runner    types.Runner
 	flags     []partFlag
@@ -47,7 +48,7 @@ type partFlag struct {
 var _ Partitioner = (*partedCall)(nil)
 
 func newPartedCall(dev string, runner types.Runner) *partedCall {
-	return &partedCall{dev: dev, wipe: false, parts: []*Partition{}, deletions: []int{}, label: "", runner: runner, flags: []partFlag{}}
+	return &partedCall{dev: dev, wipe: false, parts: []*Partition{}, deletions: []int{}, resizes: []*Partition{}, label: "", runner: runner, flags: []partFlag{}}
 }
 
 func (pc partedCall) optionsBuilder() []string {
@@ -67,6 +68,15 @@ func (pc partedCall) optionsBuilder() []string {
 		opts = append(opts, "rm", fmt.Sprintf("%d", partnum))
 	}
 
+	for _, part := range pc.resizes {
+		if part.SizeS == 0 {
+			// Size set to zero means is interperted as all space available
+			opts = append(opts, "resizepart", fmt.Sprintf("%d", part.Number), "100%")
+		} else {
+			opts = append(opts, "resizepart", fmt.Sprintf("%d", part.Number), fmt.Sprintf("%d", part.StartS+part.SizeS-1))
+		}
+	}
+
 	isFat, _ := regexp.Compile("fat|vfat")
 	for _, part := range pc.parts {
 		var pLabel string
@@ -74,6 +84,10 @@ func (pc partedCall) optionsBuilder() []string {
 			pLabel = part.PLabel
 		} else if label == constants.GPT {
 			pLabel = fmt.Sprintf("part%d", part.Number)
+		} else if part.FileSystem == extended {
+			pLabel = extended
+		} else if part.Number > 4 {
+			pLabel = "logical"
 		} else {
 			pLabel = "primary"
 		}
@@ -82,7 +96,7 @@ func (pc partedCall) optionsBuilder() []string {
 
 		if isFat.MatchString(part.FileSystem) {
 			opts = append(opts, "fat32")
-		} else {
+		} else if part.FileSystem != extended {
 			opts = append(opts, part.FileSystem)
 		}
 
@@ -116,15 +130,43 @@ func (pc *partedCall) WriteChanges() (string, error) {
 		return "", nil
 	}
 	out, err := pc.runner.Run("parted", opts...)
+	if err == nil {
+		out, err = pc.writeGPTSettings(out)
+	}
 
 	// Notify kernel of partition table changes, swallows errors, just a best effort call
 	_, _ = pc.runner.Run("partx", "-u", pc.dev)
 	pc.wipe = false
 	pc.parts = []*Partition{}
 	pc.deletions = []int{}
+	pc.resizes = []*Partition{}
 	return string(out), err
 }
 
+// writeGPTSettings applies the partition type GUIDs, partition UUIDs and attributes with sgdisk,
+// as parted does not support them. Only applies on GPT partition tables.
+func (pc partedCall) writeGPTSettings(partedOut []byte) ([]byte, error) {
+	opts := []string{}
+	for _, part := range pc.parts {
+		if !part.hasGPTSettings() {
+			continue
+		}
+		if part.TypeGUID != "" {
+			opts = append(opts, fmt.Sprintf("-t=%d:%s", part.Number, part.TypeGUID))
+		}
+		opts = append(opts, gptAttributesOptions(part)...)
+	}
+	if len(opts) == 0 {
+		return partedOut, nil
+	}
+	if pc.label != "" && pc.label != constants.GPT {
+		return partedOut, fmt.Errorf("partition type GUIDs, UUIDs and attributes are only supported on GPT partition tables")
+	}
+
+	out, err := pc.runner.Run("sgdisk", append(opts, pc.dev)...)
+	return append(partedOut, out...), err
+}
+
 func (pc *partedCall) SetPartitionTableLabel(label string) error {
 	match, _ := regexp.MatchString("msdos|gpt", label)
 	if !match {
@@ -142,6 +184,12 @@ func (pc *partedCall) DeletePartition(num int) {
 	pc.deletions = append(pc.deletions, num)
 }
 
+// ResizePartition moves the end of an existing partition to match the given partition size,
+// the start sector is kept. Any other partition property is preserved.
+func (pc *partedCall) ResizePartition(p *Partition) {
+	pc.resizes = append(pc.resizes, p)
+}
+
 func (pc *partedCall) SetPartitionFlag(num int, flag string, active bool) {
 	pc.flags = append(pc.flags, partFlag{flag: flag, active: active, number: num})
 }
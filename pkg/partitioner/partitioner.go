@@ -17,11 +17,17 @@ limitations under the License.
 package partitioner
 
 import (
+	"github.com/twpayne/go-vfs/v4"
+
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 )
 
 const Parted = "parted"
 const Gdisk = "gdisk"
+const Native = "native"
+
+// extended is the FileSystem of MSDOS extended partitions holding logical partitions
+const extended = "extended"
 
 type Partitioner interface {
 	WriteChanges() (string, error)
@@ -39,12 +45,21 @@ type Partitioner interface {
 
 // We only manage sizes in sectors unit for the Partition structre in parted wrapper
 // FileSystem here is only used by parted to determine the partition ID or type
+// TypeGUID, UUID and Attributes are only applied on GPT partition tables
 type Partition struct {
 	Number     int
 	StartS     uint
 	SizeS      uint
 	PLabel     string
 	FileSystem string
+	TypeGUID   string
+	UUID       string
+	Attributes []uint
+}
+
+// hasGPTSettings returns true if the partition defines any GPT specific setting
+func (p Partition) hasGPTSettings() bool {
+	return p.TypeGUID != "" || p.UUID != "" || len(p.Attributes) > 0
 }
 
 func NewPartitioner(dev string, runner types.Runner, backend string) Partitioner {
@@ -53,6 +68,8 @@ func NewPartitioner(dev string, runner types.Runner, backend string) Partitioner
 		return newPartedCall(dev, runner)
 	case Gdisk:
 		return newGdiskCall(dev, runner)
+	case Native:
+		return newNativeCall(dev, vfs.OSFS, runner)
 	default:
 		return nil
 	}
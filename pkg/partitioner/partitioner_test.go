@@ -18,6 +18,7 @@ package partitioner_test
 
 import (
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/jaypipes/ghw/pkg/block"
@@ -99,6 +100,25 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 			Expect(err).To(BeNil())
 			Expect(runner.CmdsMatch(cmds)).To(BeNil())
 		})
+		It("Creates a partition with GPT type, UUID and attributes", func() {
+			cmds := [][]string{
+				{"sgdisk", "-P", "-n=1:16384:+8192", "-c=1:firmware", "-t=1:21686148-6449-6E6F-744E-656564454649",
+					"-u=1:7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f", "-A=1:set:60", "-A=1:set:63", "/dev/device"},
+				{"sgdisk", "-n=1:16384:+8192", "-c=1:firmware", "-t=1:21686148-6449-6E6F-744E-656564454649",
+					"-u=1:7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f", "-A=1:set:60", "-A=1:set:63", "/dev/device"},
+				{"partx", "-u", "/dev/device"},
+			}
+			partition := part.Partition{
+				Number: 1, StartS: 16384, SizeS: 8192, PLabel: "firmware",
+				TypeGUID:   "21686148-6449-6E6F-744E-656564454649",
+				UUID:       "7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f",
+				Attributes: []uint{60, 63},
+			}
+			gc.CreatePartition(&partition)
+			_, err := gc.WriteChanges()
+			Expect(err).To(BeNil())
+			Expect(runner.CmdsMatch(cmds)).To(BeNil())
+		})
 		It("Set a new partition label", func() {
 			cmds := [][]string{
 				{"sgdisk", "-P", "--zap-all", "/dev/device"},
@@ -183,9 +203,11 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 		})
 		It("Gets partitions info of the disk", func() {
 			parts := gc.GetPartitions(sgdiskPrint)
-			// Ignores swap partition
-			Expect(len(parts)).To(Equal(2))
-			Expect(parts[1].StartS).To(Equal(uint(17303552)))
+			// Includes partitions of any type, so custom type GUIDs are not missed
+			Expect(len(parts)).To(Equal(3))
+			Expect(parts[1].StartS).To(Equal(uint(526336)))
+			Expect(parts[2].StartS).To(Equal(uint(17303552)))
+			Expect(parts[2].SizeS).To(Equal(uint(482814607)))
 		})
 	})
 	Describe("Parted tests", Label("parted"), func() {
@@ -221,6 +243,35 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 			Expect(err).To(BeNil())
 			Expect(runner.CmdsMatch(cmds)).To(BeNil())
 		})
+		It("Applies GPT settings with sgdisk", func() {
+			cmds := [][]string{{
+				"parted", "--script", "--machine", "--", "/dev/device",
+				"unit", "s", "mkpart", "p.data", "xfs", "2048", "206847",
+			}, {
+				"sgdisk", "-t=1:0FC63DAF-8483-4772-8E79-3D69D8477DE4", "-A=1:set:63", "/dev/device",
+			}, {
+				"partx", "-u", "/dev/device",
+			}}
+			Expect(pc.SetPartitionTableLabel(types.GPT)).To(Succeed())
+			partition := part.Partition{
+				Number: 1, StartS: 2048, SizeS: 204800, PLabel: "p.data", FileSystem: "xfs",
+				TypeGUID: "0FC63DAF-8483-4772-8E79-3D69D8477DE4", Attributes: []uint{63},
+			}
+			pc.CreatePartition(&partition)
+			_, err := pc.WriteChanges()
+			Expect(err).To(BeNil())
+			Expect(runner.CmdsMatch(cmds)).To(BeNil())
+		})
+		It("Fails to apply GPT settings on a msdos partition table", func() {
+			Expect(pc.SetPartitionTableLabel(types.MSDOS)).To(Succeed())
+			partition := part.Partition{
+				Number: 1, StartS: 2048, SizeS: 204800, FileSystem: "xfs",
+				UUID: "7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f",
+			}
+			pc.CreatePartition(&partition)
+			_, err := pc.WriteChanges()
+			Expect(err).NotTo(BeNil())
+		})
 		It("Set a new partition label", func() {
 			cmds := [][]string{{
 				"parted", "--script", "--machine", "--", "/dev/device",
@@ -456,17 +507,56 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 			It("Adds a new partition", func() {
 				cmds = [][]string{printCmd, {
 					"parted", "--script", "--machine", "--", "/dev/device",
-					"unit", "s", "mkpart", "primary", "ext4", "50331648", "100%",
+					"unit", "s", "mkpart", "logical", "ext4", "50331648", "100%",
 					"set", "5", "boot", "on",
 				}, {
 					"partx", "-u", "/dev/device",
 				}, printCmd}
 				runner.ReturnValue = []byte(partedPrint)
-				num, err := dev.AddPartition(0, "ext4", "ignored", "boot")
+				num, err := dev.AddPartition(0, "ext4", "ignored", part.WithPartitionFlags("boot"))
+				Expect(err).To(BeNil())
+				Expect(num).To(Equal(5))
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
+			It("Adds a new partition at an aligned start offset", func() {
+				cmds = [][]string{printCmd, {
+					"parted", "--script", "--machine", "--", "/dev/device",
+					"unit", "s", "mkpart", "logical", "", "50335744", "50337791",
+				}, {
+					"partx", "-u", "/dev/device",
+				}, printCmd}
+				runner.ReturnValue = []byte(partedPrint)
+				num, err := dev.AddPartition(1, "", "ignored", part.WithPartitionStart(24577), part.WithPartitionAlignment(2))
 				Expect(err).To(BeNil())
 				Expect(num).To(Equal(5))
 				Expect(runner.CmdsMatch(cmds)).To(BeNil())
 			})
+			It("Adds an extended partition to hold logical partitions on msdos tables", func() {
+				msdosPrint := strings.Join(strings.Split(partedPrint, "\n")[:5], "\n")
+				cmds = [][]string{printCmd, {
+					"parted", "--script", "--machine", "--", "/dev/device",
+					"unit", "s", "mkpart", "extended", "45019136", "100%",
+					"mkpart", "logical", "ext4", "45021184", "45023231",
+				}, {
+					"partx", "-u", "/dev/device",
+				}, printCmd}
+				runner.ReturnValue = []byte(msdosPrint)
+				num, err := dev.AddPartition(1, "ext4", "ignored", part.WithLogicalPartition())
+				Expect(err).To(BeNil())
+				Expect(num).To(Equal(5))
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
+			It("Fails to add a new partition overlapping existing ones", func() {
+				cmds = [][]string{printCmd}
+				runner.ReturnValue = []byte(partedPrint)
+				_, err := dev.AddPartition(1, "ext4", "ignored", part.WithPartitionStart(1))
+				Expect(err).NotTo(BeNil())
+				Expect(runner.CmdsMatch(cmds)).To(BeNil())
+			})
+			It("Fails to add a new partition with an invalid attribute", func() {
+				_, err := dev.AddPartition(1, "ext4", "ignored", part.WithPartitionAttributes(64))
+				Expect(err).NotTo(BeNil())
+			})
 			It("Fails to a new partition if there is not enough space available", func() {
 				cmds = [][]string{printCmd}
 				runner.ReturnValue = []byte(partedPrint)
@@ -508,6 +598,69 @@ var _ = Describe("Partitioner", Label("disk", "partition", "partitioner"), func(
 				runner.ReturnError = errors.New("some error")
 				Expect(dev.WipeFsOnPartition("/dev/device1")).NotTo(BeNil())
 			})
+			Describe("Resizing partitions", func() {
+				BeforeEach(func() {
+					runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+						switch cmd {
+						case "parted":
+							return []byte(partedPrint), nil
+						default:
+							return []byte{}, nil
+						}
+					}
+					_, err := fs.Create("/dev/device3")
+					Expect(err).To(BeNil())
+					_, err = fs.Create("/dev/device4")
+					Expect(err).To(BeNil())
+				})
+				It("Shrinks the filesystem before the partition", func() {
+					_, err := dev.ResizePartition(3, 4096, "ext4")
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch([][]string{
+						printCmd, {"udevadm", "settle"},
+						{"e2fsck", "-fy", "/dev/device3"}, {"resize2fs", "/dev/device3", "4194304K"},
+						{
+							"parted", "--script", "--machine", "--", "/dev/device",
+							"unit", "s", "resizepart", "3", "37783551",
+						}, {"partx", "-u", "/dev/device"}, printCmd,
+					})).To(BeNil())
+				})
+				It("Grows the last partition and then its filesystem", func() {
+					_, err := dev.ResizePartition(4, 0, "xfs")
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch([][]string{
+						printCmd, {"udevadm", "settle"},
+						{
+							"parted", "--script", "--machine", "--", "/dev/device",
+							"unit", "s", "resizepart", "4", "100%",
+						}, {"partx", "-u", "/dev/device"}, printCmd, {"xfs_growfs"},
+					})).To(BeNil())
+				})
+				It("Does nothing if there is no free space after the partition", func() {
+					_, err := dev.ResizePartition(3, 0, "ext4")
+					Expect(err).To(BeNil())
+					Expect(runner.CmdsMatch([][]string{printCmd})).To(BeNil())
+				})
+				It("Fails to grow a partition over the next one", func() {
+					_, err := dev.ResizePartition(2, 20000, "ext4")
+					Expect(err).NotTo(BeNil())
+					Expect(runner.CmdsMatch([][]string{printCmd})).To(BeNil())
+				})
+				It("Fails to shrink filesystems not supporting it", func() {
+					_, err := dev.ResizePartition(3, 4096, "xfs")
+					Expect(err).NotTo(BeNil())
+					Expect(runner.IncludesCmds([][]string{{"parted", "--script", "--machine", "--", "/dev/device", "unit", "s", "resizepart"}})).NotTo(BeNil())
+				})
+				It("Fails to resize an unknown partition", func() {
+					_, err := dev.ResizePartition(7, 4096, "ext4")
+					Expect(err).NotTo(BeNil())
+				})
+				It("Fails to resize partitions with the gdisk backend", func() {
+					dev = part.NewDisk("/dev/device", part.WithRunner(runner), part.WithFS(fs), part.WithGdisk())
+					_, err := dev.ResizePartition(3, 4096, "ext4")
+					Expect(err).NotTo(BeNil())
+				})
+			})
 			Describe("Expanding partitions", func() {
 				BeforeEach(func() {
 					cmds = [][]string{
@@ -80,11 +80,15 @@ func (gd gdiskCall) buildOptions() []string {
 		}
 
 		// Assumes any fat partition is for EFI
-		if isFat.MatchString(part.FileSystem) {
+		if part.TypeGUID != "" {
+			opts = append(opts, fmt.Sprintf("-t=%d:%s", part.Number, part.TypeGUID))
+		} else if isFat.MatchString(part.FileSystem) {
 			opts = append(opts, fmt.Sprintf("-t=%d:%s", part.Number, efiType))
 		} else if part.FileSystem != "" {
 			opts = append(opts, fmt.Sprintf("-t=%d:%s", part.Number, linuxType))
 		}
+
+		opts = append(opts, gptAttributesOptions(part)...)
 	}
 
 	if len(opts) == 0 {
@@ -95,6 +99,18 @@ func (gd gdiskCall) buildOptions() []string {
 	return opts
 }
 
+// gptAttributesOptions returns the sgdisk options to set the partition UUID and attribute bits
+func gptAttributesOptions(part *Partition) []string {
+	opts := []string{}
+	if part.UUID != "" {
+		opts = append(opts, fmt.Sprintf("-u=%d:%s", part.Number, part.UUID))
+	}
+	for _, bit := range part.Attributes {
+		opts = append(opts, fmt.Sprintf("-A=%d:set:%d", part.Number, bit))
+	}
+	return opts
+}
+
 func (gd gdiskCall) Verify() (string, error) {
 	out, err := gd.runner.Run("sgdisk", "--verify", gd.dev)
 	return string(out), err
@@ -180,7 +196,7 @@ func (gd gdiskCall) GetPartitionTableLabel(_ string) (string, error) {
 
 // Parses the output of a gdiskCall.Print call
 func (gd gdiskCall) GetPartitions(printOut string) []Partition { //nolint:dupl
-	re := regexp.MustCompile(`^(\d+)\s+(\d+)\s+(\d+).*(EF02|EF00|8300)\s*(.*)$`)
+	re := regexp.MustCompile(`^(\d+)\s+(\d+)\s+(\d+)\s+\S+\s+\S+\s+([0-9A-F]{4})(?:\s+(.*))?$`)
 	var start uint
 	var end uint
 	var size uint
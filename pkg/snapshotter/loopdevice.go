@@ -163,6 +163,22 @@ func (l *LoopDevice) StartTransaction() (*types.Snapshot, error) {
 		return nil, err
 	}
 
+	snapshot := &types.Snapshot{
+		ID:         nextID,
+		Path:       filepath.Join(snapPath, loopDeviceImgName),
+		WorkDir:    workDir,
+		MountPoint: constants.WorkingImgDir,
+		Label:      fmt.Sprintf(loopDeviceLabelPattern, nextID),
+		InProgress: true,
+	}
+
+	if l.cfg.Rootless {
+		// Without bind mounts the snapshot is only available at its work directory
+		snapshot.MountPoint = workDir
+		l.cfg.Logger.Infof("Transaction for snapshot %d successfully started", nextID)
+		return snapshot, nil
+	}
+
 	err = utils.MkdirAll(l.cfg.Fs, constants.WorkingImgDir, constants.DirPerm)
 	if err != nil {
 		_ = l.cfg.Fs.RemoveAll(snapPath)
@@ -176,15 +192,6 @@ func (l *LoopDevice) StartTransaction() (*types.Snapshot, error) {
 		return nil, err
 	}
 
-	snapshot := &types.Snapshot{
-		ID:         nextID,
-		Path:       filepath.Join(snapPath, loopDeviceImgName),
-		WorkDir:    workDir,
-		MountPoint: constants.WorkingImgDir,
-		Label:      fmt.Sprintf(loopDeviceLabelPattern, nextID),
-		InProgress: true,
-	}
-
 	l.cfg.Logger.Infof("Transaction for snapshot %d successfully started", nextID)
 	return snapshot, nil
 }
@@ -198,7 +205,7 @@ func (l *LoopDevice) CloseTransactionOnError(snapshot *types.Snapshot) error {
 		return nil
 	}
 
-	if snapshot.InProgress {
+	if snapshot.InProgress && !l.cfg.Rootless {
 		err = l.cfg.Mounter.Unmount(snapshot.MountPoint)
 	}
 
@@ -234,24 +241,36 @@ func (l *LoopDevice) CloseTransaction(snapshot *types.Snapshot) (err error) {
 	}
 
 	l.cfg.Logger.Infof("Closing transaction for snapshot %d workdir", snapshot.ID)
-	l.cfg.Logger.Debugf("Unmount %s", snapshot.MountPoint)
-	err = l.cfg.Mounter.Unmount(snapshot.MountPoint)
-	if err != nil {
-		l.cfg.Logger.Errorf("failed umounting snapshot %d workdir bind mount", snapshot.ID)
-		return err
+	if !l.cfg.Rootless {
+		l.cfg.Logger.Debugf("Unmount %s", snapshot.MountPoint)
+		err = l.cfg.Mounter.Unmount(snapshot.MountPoint)
+		if err != nil {
+			l.cfg.Logger.Errorf("failed umounting snapshot %d workdir bind mount", snapshot.ID)
+			return err
+		}
 	}
 
-	err = elemental.CreateImageFromTree(l.cfg, l.snapshotToImage(snapshot), snapshot.WorkDir, false)
+	img := l.snapshotToImage(snapshot)
+	err = elemental.CreateImageFromTree(l.cfg, img, snapshot.WorkDir, false)
 	if err != nil {
 		l.cfg.Logger.Errorf("failed creating image for snapshot %d: %v", snapshot.ID, err)
 		return err
 	}
+	snapshot.RootHash = img.RootHash
 
 	err = l.cfg.Fs.RemoveAll(snapshot.WorkDir)
 	if err != nil {
 		return err
 	}
 
+	// Unlike other bootloader settings, without the root hash the new snapshot can't be booted
+	if l.loopDevCfg.Verity {
+		err = l.setVerityRootHash(snapshot)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Remove old symlink and create a new one
 	activeSnap = filepath.Join(l.rootDir, loopDeviceSnapsPath, constants.ActiveSnapshot)
 	linkDst = fmt.Sprintf("%d/%s", snapshot.ID, loopDeviceImgName)
@@ -317,6 +336,12 @@ func (l *LoopDevice) DeleteSnapshot(id int) error {
 		}
 	}
 
+	// A later snapshot reusing this ID must not be booted with a stale dm-verity root hash
+	err = l.clearVerityRootHash(id)
+	if err != nil {
+		return err
+	}
+
 	snapDir := filepath.Join(l.rootDir, loopDeviceSnapsPath, strconv.Itoa(id))
 	err = l.cfg.Fs.RemoveAll(snapDir)
 	if err != nil {
@@ -448,6 +473,7 @@ func (l *LoopDevice) snapshotToImage(snapshot *types.Snapshot) *types.Image {
 		Label:      snapshot.Label,
 		Size:       l.loopDevCfg.Size,
 		FS:         l.loopDevCfg.FS,
+		Verity:     l.loopDevCfg.Verity,
 		MountPoint: snapshot.MountPoint,
 	}
 }
@@ -511,6 +537,40 @@ func (l *LoopDevice) setBootloader() error {
 	return err
 }
 
+// setVerityRootHash sets the bootloader variables including the dm-verity root hash of the given snapshot,
+// both as the active image and as a passive snapshot for later boots.
+func (l *LoopDevice) setVerityRootHash(snapshot *types.Snapshot) error {
+	l.cfg.Logger.Debugf("Setting bootloader dm-verity root hash for snapshot %d", snapshot.ID)
+	envFile := filepath.Join(l.efiDir, constants.GrubOEMEnv)
+	envs := map[string]string{
+		constants.GrubActiveVerity:                             snapshot.RootHash,
+		fmt.Sprintf(constants.GrubSnapshotVerity, snapshot.ID): snapshot.RootHash,
+	}
+
+	err := l.bootloader.SetPersistentVariables(envFile, envs)
+	if err != nil {
+		l.cfg.Logger.Errorf("failed setting dm-verity root hash in bootloader environment file %s: %v", envFile, err)
+	}
+	return err
+}
+
+// clearVerityRootHash clears the bootloader variable including the dm-verity root hash of the given snapshot ID,
+// if the bootloader environment file exists
+func (l *LoopDevice) clearVerityRootHash(id int) error {
+	envFile := filepath.Join(l.efiDir, constants.GrubOEMEnv)
+	if ok, _ := utils.Exists(l.cfg.Fs, envFile); !ok {
+		return nil
+	}
+
+	l.cfg.Logger.Debugf("Clearing bootloader dm-verity root hash for snapshot %d", id)
+	envs := map[string]string{fmt.Sprintf(constants.GrubSnapshotVerity, id): ""}
+	err := l.bootloader.SetPersistentVariables(envFile, envs)
+	if err != nil {
+		l.cfg.Logger.Errorf("failed clearing dm-verity root hash in bootloader environment file %s: %v", envFile, err)
+	}
+	return err
+}
+
 // getPassiveSnapshots returns a list of available passive snapshots
 func (l *LoopDevice) getPassiveSnapshots() ([]int, error) {
 	allIDs, err := l.GetSnapshots()
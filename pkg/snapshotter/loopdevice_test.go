@@ -185,6 +185,25 @@ var _ = Describe("LoopDevice", Label("snapshotter", "loopdevice"), func() {
 		Expect(lp.StartTransaction()).Error().To(HaveOccurred())
 	})
 
+	It("starts and closes a transaction without mounts on rootless configurations", Label("rootless"), func() {
+		cfg.Rootless = true
+		lp, err := snapshotter.NewSnapshotter(cfg, snapCfg, bootloader)
+		Expect(err).NotTo(HaveOccurred())
+
+		mounter.ErrorOnMount = true
+		mounter.ErrorOnUnmount = true
+
+		Expect(lp.InitSnapshotter(statePart, efiDir)).To(Succeed())
+		snap, err := lp.StartTransaction()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snap.MountPoint).To(Equal(snap.WorkDir))
+
+		Expect(lp.CloseTransaction(snap)).To(Succeed())
+		Expect(runner.IncludesCmds([][]string{
+			{"mkfs.ext2", "-L", "EL_SNAP1", "-d", snap.WorkDir},
+		})).To(Succeed())
+	})
+
 	It("fails to get available snapshots on a not initated system", func() {
 		lp, err := snapshotter.NewSnapshotter(cfg, snapCfg, bootloader)
 		Expect(err).NotTo(HaveOccurred())
@@ -258,6 +277,21 @@ var _ = Describe("LoopDevice", Label("snapshotter", "loopdevice"), func() {
 			Expect(lp.GetSnapshots()).To(Equal([]int{1, 2, 3, 5}))
 		})
 
+		It("deletes a passive snapshot clearing its dm-verity root hash", func() {
+			envFile := filepath.Join(efiDir, constants.GrubOEMEnv)
+			Expect(utils.MkdirAll(fs, efiDir, constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(envFile, []byte{}, constants.FilePerm)).To(Succeed())
+
+			bootloader.ErrorSetPersistentVariables = true
+			Expect(lp.DeleteSnapshot(4)).NotTo(Succeed())
+			Expect(lp.GetSnapshots()).To(Equal([]int{1, 2, 3, 4, 5}))
+
+			bootloader.ErrorSetPersistentVariables = false
+			Expect(lp.DeleteSnapshot(4)).To(Succeed())
+			Expect(memLog.String()).To(ContainSubstring("Clearing bootloader dm-verity root hash for snapshot 4"))
+			Expect(lp.GetSnapshots()).To(Equal([]int{1, 2, 3, 5}))
+		})
+
 		It("fails to delete current snapshot", func() {
 			Expect(lp.DeleteSnapshot(5)).NotTo(Succeed())
 		})
@@ -297,6 +331,62 @@ var _ = Describe("LoopDevice", Label("snapshotter", "loopdevice"), func() {
 			Expect(lp.GetSnapshots()).To(Equal([]int{2, 5, 6}))
 		})
 
+		It("closes a started transaction including a dm-verity hash tree", func() {
+			snapCfg.Config = &types.LoopDeviceConfig{
+				Size:   constants.ImgSize,
+				FS:     constants.LinuxImgFs,
+				Verity: true,
+			}
+			runner.SideEffect = func(cmd string, args ...string) ([]byte, error) {
+				switch cmd {
+				case "losetup":
+					return []byte(".snapshots/5/snapshot.img"), nil
+				case "veritysetup":
+					return []byte("Root hash: 4a1f0c"), nil
+				}
+				return []byte(""), nil
+			}
+			lp, err = snapshotter.NewSnapshotter(cfg, snapCfg, bootloader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lp.InitSnapshotter(statePart, efiDir)).To(Succeed())
+
+			snap, err := lp.StartTransaction()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lp.CloseTransaction(snap)).To(Succeed())
+			Expect(snap.RootHash).To(Equal("4a1f0c"))
+			Expect(runner.IncludesCmds([][]string{{
+				"veritysetup", "format", snap.Path, snap.Path + constants.VerityHashSuffix,
+			}})).To(Succeed())
+		})
+
+		It("fails closing a transaction, can't set the dm-verity root hash", func() {
+			snapCfg.Config = &types.LoopDeviceConfig{
+				Size:   constants.ImgSize,
+				FS:     constants.LinuxImgFs,
+				Verity: true,
+			}
+			lp, err = snapshotter.NewSnapshotter(cfg, snapCfg, bootloader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lp.InitSnapshotter(statePart, efiDir)).To(Succeed())
+
+			snap, err := lp.StartTransaction()
+			Expect(err).NotTo(HaveOccurred())
+			bootloader.ErrorSetPersistentVariables = true
+
+			Expect(lp.CloseTransaction(snap)).NotTo(Succeed())
+			Expect(lp.GetSnapshots()).To(Equal([]int{1, 2, 3, 4, 5}))
+		})
+
+		It("does not set the dm-verity root hash if verity is disabled", func() {
+			snap, err := lp.StartTransaction()
+			Expect(err).NotTo(HaveOccurred())
+			bootloader.ErrorSetPersistentVariables = true
+
+			Expect(lp.CloseTransaction(snap)).To(Succeed())
+			Expect(memLog.String()).NotTo(ContainSubstring("Setting bootloader dm-verity root hash"))
+			Expect(lp.GetSnapshots()).To(Equal([]int{5, 6}))
+		})
+
 		It("closes and drops a started transaction if snapshot is not in progress", func() {
 			Expect(lp.GetSnapshots()).To(Equal([]int{1, 2, 3, 4, 5}))
 			snap, err := lp.StartTransaction()
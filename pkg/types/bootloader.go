@@ -20,8 +20,12 @@ type Bootloader interface {
 	Install(rootDir, bootDir string) (err error)
 	InstallConfig(rootDir, bootDir string) error
 	DoEFIEntries(shimName, efiDir string) error
+	AddEFIEntry(efiDir string) error
 	InstallEFI(rootDir, efiDir string) error
 	InstallEFIBinaries(rootDir, efiDir, efiPath string) error
 	SetPersistentVariables(envFile string, vars map[string]string) error
 	SetDefaultEntry(partMountPoint, imgMountPoint, defaultEntry string) error
+	InstallBIOS(rootDir, bootDir, bootLabel string) error
+	InstallBIOSEltorito(rootDir, isoDir string) error
+	SetupBIOS(bootDir, disk string) error
 }
@@ -18,11 +18,14 @@ package types
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -62,6 +65,8 @@ type Config struct {
 	SquashFsNoCompression     bool      `yaml:"squash-no-compression,omitempty" mapstructure:"squash-no-compression"`
 	CloudInitPaths            []string  `yaml:"cloud-init-paths,omitempty" mapstructure:"cloud-init-paths"`
 	Strict                    bool      `yaml:"strict,omitempty" mapstructure:"strict"`
+	// Rootless sets the images to be created without loop devices, mounts or chroots, so no root privileges are required
+	Rootless bool `yaml:"rootless,omitempty" mapstructure:"rootless"`
 }
 
 // WriteInstallState writes the state.yaml file to the given state and recovery paths
@@ -95,19 +100,23 @@ func (c Config) WriteInstallState(i *InstallState, statePath, recoveryPath strin
 
 // LoadInstallState loads the state.yaml file and unmarshals it to an InstallState object
 func (c Config) LoadInstallState() (*InstallState, error) {
+	stateFile := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
+	if _, err := c.Fs.Stat(stateFile); err != nil {
+		c.Logger.Warnf("Could not read state file %s", stateFile)
+		stateFile = filepath.Join(constants.LegacyStateDir, constants.InstallStateFile)
+		c.Logger.Debugf("Attempting to read state file %s", stateFile)
+	}
+	return c.LoadInstallStateFile(stateFile)
+}
+
+// LoadInstallStateFile loads the given state.yaml file and unmarshals it to an InstallState object
+func (c Config) LoadInstallStateFile(stateFile string) (*InstallState, error) {
 	installState := &InstallState{
 		Snapshotter: NewLoopDevice(),
 	}
-	stateFile := filepath.Join(constants.RunningStateDir, constants.InstallStateFile)
 	data, err := c.Fs.ReadFile(stateFile)
 	if err != nil {
-		c.Logger.Warnf("Could not read state file %s", stateFile)
-		stateFile = filepath.Join(constants.LegacyStateDir, constants.InstallStateFile)
-		c.Logger.Debugf("Attempting to read state file %s", stateFile)
-		data, err = c.Fs.ReadFile(stateFile)
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
 	}
 	err = yaml.Unmarshal(data, installState)
 	if err != nil {
@@ -215,11 +224,13 @@ func KeyValuePairFromData(data interface{}) (KeyValuePair, error) {
 
 // InstallSpec struct represents all the installation action details
 type InstallSpec struct {
-	Target           string `yaml:"target,omitempty" mapstructure:"target"`
-	Firmware         string
-	PartTable        string
+	Target           string              `yaml:"target,omitempty" mapstructure:"target"`
+	Firmware         string              `yaml:"firmware,omitempty" mapstructure:"firmware"`
+	PartTable        string              `yaml:"part-table,omitempty" mapstructure:"part-table"`
 	Partitions       ElementalPartitions `yaml:"partitions,omitempty" mapstructure:"partitions"`
 	ExtraPartitions  PartitionList       `yaml:"extra-partitions,omitempty" mapstructure:"extra-partitions"`
+	PartitionOrder   []string            `yaml:"partition-order,omitempty" mapstructure:"partition-order"`
+	MirrorTargets    []string            `yaml:"mirror-targets,omitempty" mapstructure:"mirror-targets"`
 	NoFormat         bool                `yaml:"no-format,omitempty" mapstructure:"no-format"`
 	Force            bool                `yaml:"force,omitempty" mapstructure:"force"`
 	CloudInit        []string            `yaml:"cloud-init,omitempty" mapstructure:"cloud-init"`
@@ -253,6 +264,21 @@ func (i *InstallSpec) Sanitize() error {
 		i.RecoverySystem.Label = ""
 	}
 
+	// Only partitions not required at boot time can be encrypted
+	for _, p := range []*Partition{i.Partitions.Boot, i.Partitions.OEM, i.Partitions.Recovery, i.Partitions.State} {
+		if p != nil && p.Encryption != nil {
+			return fmt.Errorf("encryption is not supported for the %s partition", p.Name)
+		}
+	}
+	for _, p := range append(PartitionList{i.Partitions.Persistent}, i.ExtraPartitions...) {
+		if p == nil || p.Encryption == nil {
+			continue
+		}
+		if err := p.Encryption.Sanitize(); err != nil {
+			return fmt.Errorf("invalid encryption for partition %s: %w", p.Name, err)
+		}
+	}
+
 	// Check for extra partitions having set its size to 0
 	extraPartsSizeCheck := 0
 	for _, p := range i.ExtraPartitions {
@@ -268,7 +294,85 @@ func (i *InstallSpec) Sanitize() error {
 	if extraPartsSizeCheck == 1 && i.Partitions.Persistent.Size == 0 {
 		return fmt.Errorf("both persistent partition and extra partitions have size set to 0. Only one partition can have its size set to 0 which means that it will take all the available disk space in the device")
 	}
-	return i.Partitions.SetFirmwarePartitions(i.Firmware, i.PartTable)
+	// Mirrored installs replicate the EFI partition and build RAID1 arrays for the rest
+	if len(i.MirrorTargets) > 0 {
+		if i.Firmware != EFI || i.PartTable != GPT {
+			return fmt.Errorf("mirror targets are only supported for EFI firmware on GPT partition tables")
+		}
+		if i.NoFormat {
+			return fmt.Errorf("mirror targets can't be used together with no-format")
+		}
+		targets := map[string]bool{i.Target: true}
+		for _, t := range i.MirrorTargets {
+			if targets[t] {
+				return fmt.Errorf("target %s is set more than once", t)
+			}
+			targets[t] = true
+		}
+	}
+
+	err := i.Partitions.SetFirmwarePartitions(i.Firmware, i.PartTable)
+	if err != nil {
+		return err
+	}
+	return i.Partitions.PartitionsByLayout(i.PartitionOrder, i.ExtraPartitions).ValidateLayout(i.PartitionOrder, i.PartTable)
+}
+
+// AutoInstallSpec struct represents the unattended installation details. The target disk
+// is selected at install time unless the install target is already defined.
+type AutoInstallSpec struct {
+	Target    TargetSelector `yaml:"target,omitempty" mapstructure:"target"`
+	Countdown uint           `yaml:"countdown,omitempty" mapstructure:"countdown"`
+	Overwrite bool           `yaml:"overwrite,omitempty" mapstructure:"overwrite"`
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (a *AutoInstallSpec) Sanitize() error {
+	return a.Target.Sanitize()
+}
+
+// TargetSelector defines the rules to select the target disk of an unattended installation.
+// Device, model and serial are shell patterns, the device is matched against its path
+// (e.g. /dev/sda). Sizes are in MiB. A disk must match all the defined rules.
+type TargetSelector struct {
+	Device  string `yaml:"device,omitempty" mapstructure:"device"`
+	Model   string `yaml:"model,omitempty" mapstructure:"model"`
+	Serial  string `yaml:"serial,omitempty" mapstructure:"serial"`
+	MinSize uint   `yaml:"min-size,omitempty" mapstructure:"min-size"`
+	MaxSize uint   `yaml:"max-size,omitempty" mapstructure:"max-size"`
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (t TargetSelector) Sanitize() error {
+	for _, pattern := range []string{t.Device, t.Model, t.Serial} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid target pattern '%s': %w", pattern, err)
+		}
+	}
+	if t.MaxSize != 0 && t.MinSize > t.MaxSize {
+		return fmt.Errorf("target min-size %dMiB is bigger than max-size %dMiB", t.MinSize, t.MaxSize)
+	}
+	return nil
+}
+
+// Matches checks if a disk with the given device path, model, serial and size in bytes
+// satisfies all the defined rules
+func (t TargetSelector) Matches(device, model, serial string, size uint64) bool {
+	for _, rule := range [][2]string{{t.Device, device}, {t.Model, model}, {t.Serial, serial}} {
+		if rule[0] == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(rule[0], rule[1]); !ok {
+			return false
+		}
+	}
+	sizeMiB := size / (1024 * 1024)
+	if sizeMiB < uint64(t.MinSize) {
+		return false
+	}
+	return t.MaxSize == 0 || sizeMiB <= uint64(t.MaxSize)
 }
 
 // InitSpec struct represents all the init action details
@@ -282,6 +386,7 @@ type InitSpec struct {
 // MountSpec struct represents all the mount action details
 type MountSpec struct {
 	WriteFstab     bool             `yaml:"write-fstab,omitempty" mapstructure:"write-fstab"`
+	Output         string           `yaml:"output,omitempty" mapstructure:"output"`
 	Disable        bool             `yaml:"disable,omitempty" mapstructure:"disable"`
 	Sysroot        string           `yaml:"sysroot,omitempty" mapstructure:"sysroot"`
 	Mode           string           `yaml:"mode,omitempty" mapstructure:"mode"`
@@ -292,32 +397,162 @@ type MountSpec struct {
 }
 
 type VolumeMount struct {
-	Mountpoint string   `yaml:"mountpoint,omitempty" mapstructure:"mountpoint"`
-	Device     string   `yaml:"device,omitempty" mapstructure:"device"`
-	Options    []string `yaml:"options,omitempty" mapstructure:"options"`
-	FSType     string   `yaml:"fs,omitempty" mapstructure:"fs"`
+	Mountpoint string            `yaml:"mountpoint,omitempty" mapstructure:"mountpoint"`
+	Device     string            `yaml:"device,omitempty" mapstructure:"device"`
+	Options    []string          `yaml:"options,omitempty" mapstructure:"options"`
+	FSType     string            `yaml:"fs,omitempty" mapstructure:"fs"`
+	Encryption *VolumeEncryption `yaml:"encryption,omitempty" mapstructure:"encryption"`
+}
+
+// VolumeEncryption holds the data required to unlock a LUKS2 encrypted volume
+type VolumeEncryption struct {
+	KeyFile string `yaml:"key-file,omitempty" mapstructure:"key-file"`
+	TPM     bool   `yaml:"tpm,omitempty" mapstructure:"tpm"`
 }
 
 // PersistentMounts struct contains settings for which paths to mount as
 // persistent
 type PersistentMounts struct {
-	Mode   string      `yaml:"mode,omitempty" mapstructure:"mode"`
-	Paths  []string    `yaml:"paths,omitempty" mapstructure:"paths"`
-	Volume VolumeMount `yaml:"volume,omitempty" mapstructure:"volume"`
+	Mode  string           `yaml:"mode,omitempty" mapstructure:"mode"`
+	Paths []PersistentPath `yaml:"paths,omitempty" mapstructure:"paths"`
+	// Reconcile merges the defaults of a new image into the persisted paths on its first boot
+	Reconcile bool        `yaml:"reconcile,omitempty" mapstructure:"reconcile"`
+	Volume    VolumeMount `yaml:"volume,omitempty" mapstructure:"volume"`
+}
+
+// PersistentPath is a path kept in the persistent volume. Mode overrides the
+// persistence mode of PersistentMounts for this path. Size limits the space the
+// path can use in the persistent volume with a project quota.
+type PersistentPath struct {
+	Path string `yaml:"path,omitempty" mapstructure:"path"`
+	Mode string `yaml:"mode,omitempty" mapstructure:"mode"`
+	Size string `yaml:"size,omitempty" mapstructure:"size"`
+}
+
+// NewPersistentPaths parses the given paths, each one can be given as 'path' or 'path:mode'
+func NewPersistentPaths(paths ...string) []PersistentPath {
+	pPaths := []PersistentPath{}
+	for _, path := range paths {
+		pPaths = append(pPaths, newPersistentPath(path))
+	}
+	return pPaths
+}
+
+func newPersistentPath(path string) PersistentPath {
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		switch mode := path[i+1:]; mode {
+		case constants.BindMode, constants.OverlayMode:
+			return PersistentPath{Path: path[:i], Mode: mode}
+		}
+	}
+	return PersistentPath{Path: path}
+}
+
+// CustomUnmarshal parses a persistent path given as a 'path' or 'path:mode' string or as a
+// single key map such as '/var/lib/rancher: bind' or '/var/log: {mode: overlay, size: 2G}'.
+// Maps including the path key are decoded as usual.
+func (p *PersistentPath) CustomUnmarshal(data interface{}) (bool, error) {
+	switch value := data.(type) {
+	case string:
+		*p = newPersistentPath(value)
+		return false, nil
+	case map[string]interface{}:
+		if _, ok := value["path"]; ok || len(value) != 1 {
+			return true, nil
+		}
+		for path, settings := range value {
+			*p = PersistentPath{Path: path}
+			switch s := settings.(type) {
+			case nil:
+			case string:
+				p.Mode = s
+			case map[string]interface{}:
+				p.Mode, _ = s["mode"].(string)
+				if size := s["size"]; size != nil {
+					p.Size = fmt.Sprint(size)
+				}
+			default:
+				return false, fmt.Errorf("invalid settings for persistent path %s: %v", path, settings)
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("can't unmarshal %+v to a PersistentPath type", data)
+	}
+}
+
+// HasQuotas returns true if any persistent path is limited by a project quota
+func (p PersistentMounts) HasQuotas() bool {
+	return slices.ContainsFunc(p.Paths, func(path PersistentPath) bool { return path.Size != "" })
 }
 
 // EphemeralMounts contains information about the RW overlay mounted over the
 // immutable system.
 type EphemeralMounts struct {
-	Type   string   `yaml:"type,omitempty" mapstructure:"type"`
-	Device string   `yaml:"device,omitempty" mapstructure:"device"`
-	Size   string   `yaml:"size,omitempty" mapstructure:"size"`
-	Paths  []string `yaml:"paths,omitempty" mapstructure:"paths"`
+	Type   string          `yaml:"type,omitempty" mapstructure:"type"`
+	Device string          `yaml:"device,omitempty" mapstructure:"device"`
+	Size   string          `yaml:"size,omitempty" mapstructure:"size"`
+	Paths  []EphemeralPath `yaml:"paths,omitempty" mapstructure:"paths"`
+}
+
+// EphemeralPath is a path mounted as an ephemeral overlay. Paths with a size get their
+// own tmpfs instance of that size instead of sharing the ephemeral overlay.
+type EphemeralPath struct {
+	Path string `yaml:"path,omitempty" mapstructure:"path"`
+	Size string `yaml:"size,omitempty" mapstructure:"size"`
 }
 
+// NewEphemeralPaths returns ephemeral paths sharing the ephemeral overlay
+func NewEphemeralPaths(paths ...string) []EphemeralPath {
+	ePaths := []EphemeralPath{}
+	for _, path := range paths {
+		ePaths = append(ePaths, EphemeralPath{Path: path})
+	}
+	return ePaths
+}
+
+// CustomUnmarshal parses an ephemeral path given as a 'path' string or as a single
+// key map such as '/var/log: 512M'. Maps including the path key are decoded as usual.
+func (p *EphemeralPath) CustomUnmarshal(data interface{}) (bool, error) {
+	switch value := data.(type) {
+	case string:
+		*p = EphemeralPath{Path: value}
+		return false, nil
+	case map[string]interface{}:
+		if _, ok := value["path"]; ok || len(value) != 1 {
+			return true, nil
+		}
+		for path, size := range value {
+			*p = EphemeralPath{Path: path}
+			if size != nil {
+				p.Size = fmt.Sprint(size)
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("can't unmarshal %+v to an EphemeralPath type", data)
+	}
+}
+
+var (
+	// tmpfs sizes are given in bytes, with k, m or g suffixes, or as a percentage of the memory
+	tmpfsSizeRegexp = regexp.MustCompile(`^[0-9]+[kKmMgG%]?$`)
+	// quota sizes are given in bytes or with K, M, G or T binary suffixes
+	quotaSizeRegexp = regexp.MustCompile(`^[0-9]+[KMGT]?$`)
+)
+
 // Sanitize checks the consistency of the struct, returns error
 // if unsolvable inconsistencies are found
 func (spec *MountSpec) Sanitize() error {
+	switch spec.Output {
+	case "":
+		spec.Output = constants.FstabOutput
+	case constants.FstabOutput, constants.SystemdUnitsOutput:
+		break
+	default:
+		return fmt.Errorf("unknown mount output: '%s'", spec.Output)
+	}
+
 	switch spec.Persistent.Mode {
 	case constants.BindMode, constants.OverlayMode:
 		break
@@ -329,12 +564,31 @@ func (spec *MountSpec) Sanitize() error {
 
 	if spec.Persistent.Paths != nil {
 		// Remove empty paths
-		spec.Persistent.Paths = slices.DeleteFunc(spec.Persistent.Paths, func(s string) bool {
-			return s == ""
+		spec.Persistent.Paths = slices.DeleteFunc(spec.Persistent.Paths, func(p PersistentPath) bool {
+			return p.Path == ""
 		})
 
-		sort.Slice(spec.Persistent.Paths, func(i, j int) bool {
-			return strings.Count(spec.Persistent.Paths[i], separator) < strings.Count(spec.Persistent.Paths[j], separator)
+		for i, path := range spec.Persistent.Paths {
+			switch path.Mode {
+			case "":
+				spec.Persistent.Paths[i].Mode = spec.Persistent.Mode
+			case constants.BindMode, constants.OverlayMode:
+				break
+			default:
+				return fmt.Errorf("unknown persistent mode for path %s: '%s'", path.Path, path.Mode)
+			}
+			if path.Size != "" && !quotaSizeRegexp.MatchString(path.Size) {
+				return fmt.Errorf("invalid size for persistent path %s: '%s'", path.Path, path.Size)
+			}
+		}
+
+		// Project quotas must be enabled when mounting the persistent volume
+		if spec.Persistent.HasQuotas() && !slices.Contains(spec.Persistent.Volume.Options, constants.PrjQuotaOpt) {
+			spec.Persistent.Volume.Options = append(spec.Persistent.Volume.Options, constants.PrjQuotaOpt)
+		}
+
+		sort.SliceStable(spec.Persistent.Paths, func(i, j int) bool {
+			return strings.Count(spec.Persistent.Paths[i].Path, separator) < strings.Count(spec.Persistent.Paths[j].Path, separator)
 		})
 	}
 
@@ -347,12 +601,18 @@ func (spec *MountSpec) Sanitize() error {
 
 	if spec.Ephemeral.Paths != nil {
 		// Remove empty paths
-		spec.Ephemeral.Paths = slices.DeleteFunc(spec.Ephemeral.Paths, func(s string) bool {
-			return s == ""
+		spec.Ephemeral.Paths = slices.DeleteFunc(spec.Ephemeral.Paths, func(p EphemeralPath) bool {
+			return p.Path == ""
 		})
 
-		sort.Slice(spec.Ephemeral.Paths, func(i, j int) bool {
-			return strings.Count(spec.Ephemeral.Paths[i], separator) < strings.Count(spec.Ephemeral.Paths[j], separator)
+		for _, path := range spec.Ephemeral.Paths {
+			if path.Size != "" && !tmpfsSizeRegexp.MatchString(path.Size) {
+				return fmt.Errorf("invalid size for ephemeral path %s: '%s'", path.Path, path.Size)
+			}
+		}
+
+		sort.SliceStable(spec.Ephemeral.Paths, func(i, j int) bool {
+			return strings.Count(spec.Ephemeral.Paths[i].Path, separator) < strings.Count(spec.Ephemeral.Paths[j].Path, separator)
 		})
 	}
 
@@ -378,6 +638,8 @@ type ResetSpec struct {
 	State            *InstallState
 	DisableBootEntry bool         `yaml:"disable-boot-entry,omitempty" mapstructure:"disable-boot-entry"`
 	SnapshotLabels   KeyValuePair `yaml:"snapshot-labels,omitempty" mapstructure:"snapshot-labels"`
+	// Encryption key slots used to re-key the persistent partition on reset-persistent
+	Encryption *Encryption `yaml:"encryption,omitempty" mapstructure:"encryption"`
 }
 
 // Sanitize checks the consistency of the struct, returns error
@@ -390,9 +652,85 @@ func (r *ResetSpec) Sanitize() error {
 		return fmt.Errorf("undefined state partition")
 	}
 
+	// Encryption key slots only apply when the persistent partition is formatted
+	persistent := r.Partitions.Persistent
+	if r.FormatPersistent && persistent != nil {
+		if r.Encryption != nil {
+			if err := r.Encryption.Sanitize(); err != nil {
+				return fmt.Errorf("invalid persistent partition encryption: %w", err)
+			}
+			persistent.Encryption = r.Encryption
+		} else if persistent.Encryption != nil {
+			return fmt.Errorf("encrypted persistent partition requires encryption key slots to be reset")
+		}
+	}
+
 	return nil
 }
 
+// RepartitionSpec struct represents all the repartition action details. Only the partitions
+// set in the spec are resized, extra partitions not found in the target device are created.
+type RepartitionSpec struct {
+	Target          string              `yaml:"target,omitempty" mapstructure:"target"`
+	Partitions      ElementalPartitions `yaml:"partitions,omitempty" mapstructure:"partitions"`
+	ExtraPartitions PartitionList       `yaml:"extra-partitions,omitempty" mapstructure:"extra-partitions"`
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (r *RepartitionSpec) Sanitize() error {
+	if r.Target == "" {
+		return fmt.Errorf("undefined target device to repartition")
+	}
+	if r.Partitions.Boot != nil || r.Partitions.BIOS != nil {
+		return fmt.Errorf("resizing the bootloader partition is not supported")
+	}
+
+	names := map[string]bool{}
+	for name, part := range map[string]*Partition{
+		constants.OEMPartName:        r.Partitions.OEM,
+		constants.RecoveryPartName:   r.Partitions.Recovery,
+		constants.StatePartName:      r.Partitions.State,
+		constants.PersistentPartName: r.Partitions.Persistent,
+	} {
+		if part != nil {
+			part.Name = name
+			names[name] = true
+		}
+	}
+	for _, part := range r.ExtraPartitions {
+		if part.Name == "" {
+			return fmt.Errorf("extra partitions require a name")
+		}
+		if names[part.Name] {
+			return fmt.Errorf("partition %s is set more than once", part.Name)
+		}
+		// Partitions are tracked by name in the install state file
+		if part.Name == "date" || part.Name == "snapshotter" {
+			return fmt.Errorf("partition name %s is reserved", part.Name)
+		}
+		if part.Encryption != nil {
+			return fmt.Errorf("encrypted partitions are not supported on repartition")
+		}
+		names[part.Name] = true
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no partition changes requested")
+	}
+	return nil
+}
+
+// Changes returns the list of partitions to resize or create
+func (r RepartitionSpec) Changes() PartitionList {
+	parts := PartitionList{}
+	for _, part := range []*Partition{r.Partitions.OEM, r.Partitions.Recovery, r.Partitions.State, r.Partitions.Persistent} {
+		if part != nil {
+			parts = append(parts, part)
+		}
+	}
+	return append(parts, r.ExtraPartitions...)
+}
+
 type UpgradeSpec struct {
 	RecoveryUpgrade   bool         `yaml:"recovery,omitempty" mapstructure:"recovery"`
 	System            *ImageSource `yaml:"system,omitempty" mapstructure:"system"`
@@ -465,13 +803,141 @@ func (u *UpgradeSpec) SanitizeForRecoveryOnly() error {
 // Partition struct represents a partition with its commonly configurable values, size in MiB
 type Partition struct {
 	Name            string
-	FilesystemLabel string   `yaml:"label,omitempty" mapstructure:"label"`
-	Size            uint     `yaml:"size,omitempty" mapstructure:"size"`
-	FS              string   `yaml:"fs,omitempty" mapstructure:"fs"`
-	Flags           []string `yaml:"flags,omitempty" mapstructure:"flags"`
+	FilesystemLabel string      `yaml:"label,omitempty" mapstructure:"label"`
+	Size            uint        `yaml:"size,omitempty" mapstructure:"size"`
+	FS              string      `yaml:"fs,omitempty" mapstructure:"fs"`
+	Flags           []string    `yaml:"flags,omitempty" mapstructure:"flags"`
+	Encryption      *Encryption `yaml:"encryption,omitempty" mapstructure:"encryption"`
+	Start           uint        `yaml:"start,omitempty" mapstructure:"start"`
+	Align           uint        `yaml:"align,omitempty" mapstructure:"align"`
+	TypeGUID        string      `yaml:"type-guid,omitempty" mapstructure:"type-guid"`
+	UUID            string      `yaml:"uuid,omitempty" mapstructure:"uuid"`
+	Attributes      []string    `yaml:"attributes,omitempty" mapstructure:"attributes"`
 	MountPoint      string
 	Path            string
 	Disk            string
+	Mirrors         []string
+	RAID            *RAIDArray
+}
+
+// RAIDArray represents a RAID1 array assembled from a partition replicated over mirror targets
+type RAIDArray struct {
+	Device  string
+	UUID    string
+	Members []string
+}
+
+var guidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// gptAttributes maps the named GPT partition attributes to their bit
+var gptAttributes = map[string]uint{
+	"required":     0,
+	"no-block-io":  1,
+	"legacy-boot":  2,
+	"read-only":    60,
+	"shadow-copy":  61,
+	"hidden":       62,
+	"no-automount": 63,
+}
+
+// GPTAttributes returns the attribute bits of the partition. Attributes can be
+// set by name or by bit number
+func (p Partition) GPTAttributes() ([]uint, error) {
+	bits := []uint{}
+	for _, attr := range p.Attributes {
+		if bit, ok := gptAttributes[attr]; ok {
+			bits = append(bits, bit)
+			continue
+		}
+		bit, err := strconv.ParseUint(attr, 10, 0)
+		if err != nil || bit > 63 {
+			return nil, fmt.Errorf("invalid GPT attribute '%s' for partition %s", attr, p.Name)
+		}
+		bits = append(bits, uint(bit))
+	}
+	return bits, nil
+}
+
+// Validate checks the layout settings of the partition are consistent with the given partition table type
+func (p Partition) Validate(partTable string) error {
+	if p.TypeGUID != "" && !guidRegexp.MatchString(p.TypeGUID) {
+		return fmt.Errorf("invalid type GUID '%s' for partition %s", p.TypeGUID, p.Name)
+	}
+	if p.UUID != "" && !guidRegexp.MatchString(p.UUID) {
+		return fmt.Errorf("invalid UUID '%s' for partition %s", p.UUID, p.Name)
+	}
+	if _, err := p.GPTAttributes(); err != nil {
+		return err
+	}
+	if partTable != GPT && (p.TypeGUID != "" || p.UUID != "" || len(p.Attributes) > 0) {
+		return fmt.Errorf("type GUID, UUID and attributes of partition %s require a GPT partition table", p.Name)
+	}
+	return nil
+}
+
+// Encryption represents the LUKS2 setup of an encrypted partition
+type Encryption struct {
+	KeySlots []KeySlot `yaml:"key-slots,omitempty" mapstructure:"key-slots"`
+}
+
+// KeySlot represents a LUKS2 key slot. Only one of passphrase, key file or TPM2 is expected per slot.
+type KeySlot struct {
+	Passphrase string `yaml:"passphrase,omitempty" mapstructure:"passphrase"`
+	KeyFile    string `yaml:"key-file,omitempty" mapstructure:"key-file"`
+	TPM        bool   `yaml:"tpm,omitempty" mapstructure:"tpm"`
+	TPMPCRs    string `yaml:"tpm-pcrs,omitempty" mapstructure:"tpm-pcrs"`
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (e *Encryption) Sanitize() error {
+	if len(e.KeySlots) == 0 {
+		return fmt.Errorf("no key slots defined")
+	}
+	for i, slot := range e.KeySlots {
+		set := 0
+		for _, ok := range []bool{slot.Passphrase != "", slot.KeyFile != "", slot.TPM} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("key slot %d must define exactly one of passphrase, key-file or tpm", i)
+		}
+		if slot.TPMPCRs != "" && !slot.TPM {
+			return fmt.Errorf("key slot %d defines tpm-pcrs without tpm", i)
+		}
+	}
+	// Without a TPM slot the volume can only be unlocked unattended with a key file
+	// available in the initrd, any other key file would be read from the unencrypted disk
+	vol := e.VolumeEncryption()
+	if !vol.TPM && vol.KeyFile == "" {
+		for i, slot := range e.KeySlots {
+			if slot.KeyFile != "" {
+				return fmt.Errorf("key slot %d: key files must be within %s to unlock the volume at boot", i, constants.InitrdKeysDir)
+			}
+		}
+	}
+	return nil
+}
+
+// VolumeEncryption returns the unlock data to be stored in the installation state, including
+// the path of the first key file slot embedded into the initrd, if any. Secrets are never included.
+// Returns nil for nil or empty encryption setups.
+func (e *Encryption) VolumeEncryption() *VolumeEncryption {
+	if e == nil || len(e.KeySlots) == 0 {
+		return nil
+	}
+	vol := &VolumeEncryption{}
+	for _, slot := range e.KeySlots {
+		if slot.TPM {
+			vol.TPM = true
+		}
+		if vol.KeyFile == "" && strings.HasPrefix(slot.KeyFile, constants.InitrdKeysDir+"/") {
+			vol.KeyFile = slot.KeyFile
+		}
+	}
+	return vol
 }
 
 type PartitionList []*Partition
@@ -489,6 +955,60 @@ func (p Partition) ToImage() *Image {
 	}
 }
 
+// StartOffsets computes the start offset in MiB of each partition of the list. Partitions are placed
+// one after the other starting at 1MiB, unless an explicit start or alignment is set.
+func (pl PartitionList) StartOffsets() ([]uint, error) {
+	offsets := []uint{}
+	next := uint(1)
+
+	for i, p := range pl {
+		start := next
+		if p.Start > 0 {
+			if p.Start < next {
+				return nil, fmt.Errorf("partition %s starting at %dMiB overlaps with previous partitions", p.Name, p.Start)
+			}
+			start = p.Start
+		}
+		if p.Align > 0 {
+			start = (start + p.Align - 1) / p.Align * p.Align
+		}
+		if p.Size == 0 && i < len(pl)-1 {
+			return nil, fmt.Errorf("only the last partition can have its size set to 0, found %s", p.Name)
+		}
+		offsets = append(offsets, start)
+		next = start + p.Size
+	}
+	return offsets, nil
+}
+
+// ValidateLayout checks the partitions of the list and the given partition order are consistent
+func (pl PartitionList) ValidateLayout(order []string, partTable string) error {
+	names := map[string]bool{}
+	for _, p := range pl {
+		if names[p.Name] {
+			return fmt.Errorf("duplicated partition name %s", p.Name)
+		}
+		names[p.Name] = true
+		if err := p.Validate(partTable); err != nil {
+			return err
+		}
+	}
+
+	ordered := map[string]bool{}
+	for _, name := range order {
+		if !names[name] {
+			return fmt.Errorf("unknown partition %s in partition order", name)
+		}
+		if ordered[name] {
+			return fmt.Errorf("duplicated partition %s in partition order", name)
+		}
+		ordered[name] = true
+	}
+
+	_, err := pl.StartOffsets()
+	return err
+}
+
 // GetByName gets a partitions by its name from the PartitionList
 func (pl PartitionList) GetByName(name string) *Partition {
 	var part *Partition
@@ -545,30 +1065,35 @@ func (ep ElementalPartitions) GetConfigStorage() string {
 	return ""
 }
 
-// SetFirmwarePartitions sets firmware partitions for a given firmware and partition table type
+// SetFirmwarePartitions sets firmware partitions for a given firmware and partition table type.
+// The bootloader partition holds grub for both firmwares, legacy BIOS on GPT partition tables
+// also requires a BIOS boot partition to embed the grub core image.
 func (ep *ElementalPartitions) SetFirmwarePartitions(firmware string, partTable string) error {
-	if firmware == EFI && partTable == GPT {
-		if ep.Boot == nil {
-			return fmt.Errorf("nil efi partition")
-		}
+	if firmware != EFI && firmware != BIOS {
+		return fmt.Errorf("invalid firmware type '%s'", firmware)
+	}
+	if ep.Boot == nil {
+		return fmt.Errorf("nil efi partition")
+	}
+	switch partTable {
+	case GPT:
 		ep.BIOS = nil
-	} else if firmware == BIOS && partTable == GPT {
-		ep.BIOS = &Partition{
-			FilesystemLabel: "",
-			Size:            constants.BiosSize,
-			Name:            constants.BiosPartName,
-			FS:              "",
-			MountPoint:      "",
-			Flags:           []string{bios},
-		}
-		ep.Boot = nil
-	} else {
-		if ep.State == nil {
-			return fmt.Errorf("nil state partition")
+		if firmware == BIOS {
+			ep.BIOS = &Partition{
+				FilesystemLabel: "",
+				Size:            constants.BiosSize,
+				Name:            constants.BiosPartName,
+				FS:              "",
+				MountPoint:      "",
+				Flags:           []string{bios},
+			}
 		}
-		ep.State.Flags = []string{boot}
-		ep.Boot = nil
+	case MSDOS:
+		// Legacy BIOS embeds grub after the MBR, the bootloader partition is set as the active one
 		ep.BIOS = nil
+		ep.Boot.Flags = []string{ESP, boot}
+	default:
+		return fmt.Errorf("invalid partition table type '%s'", partTable)
 	}
 	return nil
 }
@@ -666,6 +1191,46 @@ func (ep ElementalPartitions) PartitionsByInstallOrder(extraPartitions Partition
 	return partitions
 }
 
+// PartitionsByLayout sorts partitions according to the given order of partition names.
+// Partitions not included in the order are appended following the default install order,
+// unknown names are ignored. The partition with 0 size is set last
+func (ep ElementalPartitions) PartitionsByLayout(order []string, extraPartitions PartitionList, excludes ...*Partition) PartitionList {
+	defaults := ep.PartitionsByInstallOrder(extraPartitions, excludes...)
+	if len(order) == 0 || len(defaults) == 0 {
+		return defaults
+	}
+
+	partitions := PartitionList{}
+	var lastPartition *Partition
+	added := map[*Partition]bool{}
+
+	// Default order already sets the partition with 0 size the latest
+	if last := defaults[len(defaults)-1]; last.Size == 0 {
+		lastPartition = last
+		added[last] = true
+	}
+
+	add := func(part *Partition) {
+		if part == nil || added[part] {
+			return
+		}
+		added[part] = true
+		partitions = append(partitions, part)
+	}
+
+	for _, name := range order {
+		add(defaults.GetByName(name))
+	}
+	for _, part := range defaults {
+		add(part)
+	}
+
+	if lastPartition != nil {
+		partitions = append(partitions, lastPartition)
+	}
+	return partitions
+}
+
 // PartitionsByMountPoint sorts partitions according to its mountpoint, ignores nil
 // partitions or partitions with an empty mountpoint
 func (ep ElementalPartitions) PartitionsByMountPoint(descending bool, excludes ...*Partition) PartitionList {
@@ -699,8 +1264,10 @@ type Image struct {
 	Size       uint         `yaml:"size,omitempty" mapstructure:"size"`
 	FS         string       `yaml:"fs,omitempty" mapstructure:"fs"`
 	Source     *ImageSource `yaml:"uri,omitempty" mapstructure:"uri"`
+	Verity     bool         `yaml:"verity,omitempty" mapstructure:"verity"`
 	MountPoint string
 	LoopDevice string
+	RootHash   string
 }
 
 // LiveISO represents the configurations needed for a live ISO image
@@ -713,6 +1280,7 @@ type LiveISO struct {
 	BootloaderInRootFs bool           `yaml:"bootloader-in-rootfs" mapstructure:"bootloader-in-rootfs"`
 	Firmware           string         `yaml:"firmware,omitempty" mapstructure:"firmware"`
 	ExtraCmdline       string         `yaml:"extra-cmdline,omitempty" mapstructure:"extra-cmdline"`
+	AutoInstall        string         `yaml:"autoinstall,omitempty" mapstructure:"autoinstall"`
 }
 
 // Sanitize checks the consistency of the struct, returns error
@@ -733,6 +1301,38 @@ func (i *LiveISO) Sanitize() error {
 			return fmt.Errorf("wrong name of source package for image")
 		}
 	}
+	if i.Firmware != "" && i.Firmware != EFI && i.Firmware != BIOS {
+		return fmt.Errorf("invalid firmware type '%s'", i.Firmware)
+	}
+
+	return nil
+}
+
+// Netboot represents the artifacts to boot a live system over the network (PXE or HTTP boot)
+type Netboot struct {
+	RootFS       []*ImageSource `yaml:"rootfs,omitempty" mapstructure:"rootfs"`
+	BaseURL      string         `yaml:"base-url,omitempty" mapstructure:"base-url"`
+	CloudConfig  string         `yaml:"cloud-config,omitempty" mapstructure:"cloud-config"`
+	GrubEntry    string         `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
+	ExtraCmdline string         `yaml:"extra-cmdline,omitempty" mapstructure:"extra-cmdline"`
+}
+
+// Sanitize checks the consistency of the struct, returns error
+// if unsolvable inconsistencies are found
+func (n *Netboot) Sanitize() error {
+	for _, src := range n.RootFS {
+		if src == nil {
+			return fmt.Errorf("wrong name of source package for rootfs")
+		}
+	}
+	if n.BaseURL == "" {
+		return fmt.Errorf("undefined base URL of the netboot artifacts")
+	}
+	u, err := url.Parse(n.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid base URL '%s', an http or https URL is required", n.BaseURL)
+	}
+	n.BaseURL = strings.TrimSuffix(n.BaseURL, "/")
 
 	return nil
 }
@@ -768,16 +1368,28 @@ func (b *BuildConfig) Sanitize() error {
 }
 
 type DiskSpec struct {
-	Size           uint                `yaml:"size,omitempty" mapstructure:"size"`
-	Partitions     ElementalPartitions `yaml:"partitions,omitempty" mapstructure:"partitions"`
-	Expandable     bool                `yaml:"expandable,omitempty" mapstructure:"expandable"`
-	System         *ImageSource        `yaml:"system,omitempty" mapstructure:"system"`
-	RecoverySystem Image               `yaml:"recovery-system,omitempty" mapstructure:"recovery-system"`
-	GrubConf       string
-	CloudInit      []string `yaml:"cloud-init,omitempty" mapstructure:"cloud-init"`
-	GrubDefEntry   string   `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
-	Type           string   `yaml:"type,omitempty" mapstructure:"type"`
-	DeployCmd      []string `yaml:"deploy-command,omitempty" mapstructure:"deploy-command"`
+	Size            uint                `yaml:"size,omitempty" mapstructure:"size"`
+	Firmware        string              `yaml:"firmware,omitempty" mapstructure:"firmware"`
+	Board           string              `yaml:"board,omitempty" mapstructure:"board"`
+	Partitions      ElementalPartitions `yaml:"partitions,omitempty" mapstructure:"partitions"`
+	ExtraPartitions PartitionList       `yaml:"extra-partitions,omitempty" mapstructure:"extra-partitions"`
+	PartitionOrder  []string            `yaml:"partition-order,omitempty" mapstructure:"partition-order"`
+	Expandable      bool                `yaml:"expandable,omitempty" mapstructure:"expandable"`
+	System          *ImageSource        `yaml:"system,omitempty" mapstructure:"system"`
+	RecoverySystem  Image               `yaml:"recovery-system,omitempty" mapstructure:"recovery-system"`
+	GrubConf        string
+	CloudInit       []string `yaml:"cloud-init,omitempty" mapstructure:"cloud-init"`
+	GrubDefEntry    string   `yaml:"grub-entry-name,omitempty" mapstructure:"grub-entry-name"`
+	Type            string   `yaml:"type,omitempty" mapstructure:"type"`
+	Compression     string   `yaml:"compression,omitempty" mapstructure:"compression"`
+	DeployCmd       []string `yaml:"deploy-command,omitempty" mapstructure:"deploy-command"`
+	VM              VMSpec   `yaml:"vm,omitempty" mapstructure:"vm"`
+}
+
+// VMSpec holds the virtual hardware of the virtual machines described by appliance images
+type VMSpec struct {
+	CPUs   uint `yaml:"cpus,omitempty" mapstructure:"cpus"`
+	Memory uint `yaml:"memory,omitempty" mapstructure:"memory"`
 }
 
 // Sanitize checks the consistency of the struct, returns error
@@ -798,6 +1410,52 @@ func (d *DiskSpec) Sanitize() error {
 		d.RecoverySystem.Label = constants.SystemLabel
 	}
 
+	if d.Compression != "" && d.Compression != constants.NoCompression && d.Type != constants.QCOW2Type {
+		return fmt.Errorf("compression is not supported for %s disk images", d.Type)
+	}
+
+	if d.Type == constants.OVAType && (d.VM.CPUs == 0 || d.VM.Memory == 0) {
+		return fmt.Errorf("virtual machine CPUs and memory are required for ova disk images")
+	}
+
+	// Expandable disks only include EFI, OEM and Recovery partitions, the rest is created at first boot
+	if d.Expandable && (len(d.ExtraPartitions) > 0 || len(d.PartitionOrder) > 0) {
+		return fmt.Errorf("extra partitions and partition order are not supported for expandable disks")
+	}
+	for _, p := range d.ExtraPartitions {
+		if p.Size == 0 {
+			return fmt.Errorf("extra partition %s requires a size on disk images", p.Name)
+		}
+	}
+	// Disk images are always GPT, legacy BIOS adds the BIOS boot partition
+	err := d.Partitions.SetFirmwarePartitions(d.Firmware, GPT)
+	if err != nil {
+		return err
+	}
+
+	// Raspberry Pi firmware and U-Boot are installed next to grub in the EFI partition
+	switch d.Board {
+	case "":
+	case constants.RPiBoard:
+		if d.Firmware != EFI {
+			return fmt.Errorf("firmware '%s' is not supported on %s boards", d.Firmware, d.Board)
+		}
+		// Partitions created at first boot rewrite the MBR as protective only, dropping the hybrid
+		// MBR the Raspberry Pi firmware boots from
+		if d.Expandable {
+			return fmt.Errorf("expandable disks are not supported on %s boards", d.Board)
+		}
+		if d.Partitions.Boot.Size < constants.RPiBootSize {
+			d.Partitions.Boot.Size = constants.RPiBootSize
+		}
+	default:
+		return fmt.Errorf("invalid board '%s', supported boards: %s", d.Board, constants.RPiBoard)
+	}
+	err = d.PartitionsByLayout().ValidateLayout(d.PartitionOrder, GPT)
+	if err != nil {
+		return err
+	}
+
 	// The disk size is enough for all partitions
 	minSize := d.MinDiskSize()
 	if d.Size != 0 && !d.Expandable && d.Size <= minSize {
@@ -807,13 +1465,24 @@ func (d *DiskSpec) Sanitize() error {
 	return nil
 }
 
+// PartitionsByLayout returns the partitions of the disk image sorted according to the configured layout
+func (d *DiskSpec) PartitionsByLayout(excludes ...*Partition) PartitionList {
+	return d.Partitions.PartitionsByLayout(d.PartitionOrder, d.ExtraPartitions, excludes...)
+}
+
 // minDiskSize counts the minimum size (MB) required for the disk given the partitions setup
 func (d *DiskSpec) MinDiskSize() uint {
 	var minDiskSize uint
 
 	// First partition is aligned at the first 1MB and the last one ends at -1MB
 	minDiskSize = 2
-	for _, part := range d.Partitions.PartitionsByInstallOrder(PartitionList{}) {
+	parts := d.PartitionsByLayout()
+	offsets, err := parts.StartOffsets()
+	for i, part := range parts {
+		if err == nil {
+			// Include the gaps required by explicit start offsets and alignments
+			minDiskSize = offsets[i] + 1
+		}
 		if part.Size == 0 {
 			minDiskSize += constants.MinPartSize
 		} else {
@@ -836,6 +1505,18 @@ type PartitionState struct {
 	FSLabel       string               `yaml:"label,omitempty"`
 	RecoveryImage *SystemState         `yaml:"recovery,omitempty"`
 	Snapshots     map[int]*SystemState `yaml:"snapshots,omitempty"`
+	Encryption    *VolumeEncryption    `yaml:"encryption,omitempty"`
+	RAIDDevice    string               `yaml:"raid-device,omitempty"`
+	Layout        *PartitionLayout     `yaml:"layout,omitempty"`
+	// Mirrors of the EFI partition on mirrored disks, kept in sync with the EFI partition
+	Mirrors []string `yaml:"mirrors,omitempty"`
+}
+
+// PartitionLayout tracks the location of a partition in the disk, start and size are expressed in MiB
+type PartitionLayout struct {
+	Number int  `yaml:"number"`
+	Start  uint `yaml:"start"`
+	Size   uint `yaml:"size"`
 }
 
 // SystemState represents data of a deployed OS image
@@ -848,4 +1529,5 @@ type SystemState struct {
 	Labels     map[string]string `yaml:"labels,omitempty"`
 	Date       string            `yaml:"date,omitempty"`
 	FromAction string            `yaml:"fromAction,omitempty"`
+	RootHash   string            `yaml:"verity-roothash,omitempty"`
 }
@@ -194,24 +194,35 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			Expect(err).Should(HaveOccurred())
 		})
 		It("sets firmware partitions on bios", func() {
-			Expect(ep.Boot == nil && ep.BIOS == nil).To(BeTrue())
+			ep.Boot = &types.Partition{Flags: []string{types.ESP}}
+			Expect(ep.BIOS == nil).To(BeTrue())
 			err := ep.SetFirmwarePartitions(types.BIOS, types.GPT)
 			Expect(err).ShouldNot(HaveOccurred())
-			Expect(ep.Boot == nil && ep.BIOS != nil).To(BeTrue())
+			Expect(ep.Boot != nil && ep.BIOS != nil).To(BeTrue())
+			Expect(ep.BIOS.Flags).To(Equal([]string{"bios_grub"}))
+
+			err = ep.SetFirmwarePartitions(types.EFI, types.GPT)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ep.BIOS == nil).To(BeTrue())
 		})
 		It("sets firmware partitions on msdos", func() {
-			ep.State = &types.Partition{}
-			Expect(ep.Boot == nil && ep.BIOS == nil).To(BeTrue())
+			ep.Boot = &types.Partition{Flags: []string{types.ESP}}
+			Expect(ep.BIOS == nil).To(BeTrue())
 			err := ep.SetFirmwarePartitions(types.BIOS, types.MSDOS)
 			Expect(err).ShouldNot(HaveOccurred())
-			Expect(ep.Boot == nil && ep.BIOS == nil).To(BeTrue())
-			Expect(ep.State.Flags != nil && ep.State.Flags[0] == "boot").To(BeTrue())
+			Expect(ep.BIOS == nil).To(BeTrue())
+			Expect(ep.Boot.Flags).To(Equal([]string{"esp", "boot"}))
 		})
-		It("fails to set firmware partitions of state is not defined on msdos", func() {
+		It("fails to set firmware partitions if the bootloader partition is not defined", func() {
 			Expect(ep.Boot == nil && ep.BIOS == nil).To(BeTrue())
 			err := ep.SetFirmwarePartitions(types.BIOS, types.MSDOS)
 			Expect(err).Should(HaveOccurred())
 		})
+		It("fails to set firmware partitions for unknown firmware or partition table types", func() {
+			ep.Boot = &types.Partition{}
+			Expect(ep.SetFirmwarePartitions("uboot", types.GPT)).NotTo(Succeed())
+			Expect(ep.SetFirmwarePartitions(types.BIOS, "loop")).NotTo(Succeed())
+		})
 		It("initializes an ElementalPartitions from a PartitionList", func() {
 			// Use custom label for recovery partition
 			ep := types.NewElementalPartitionsFromList(p, &types.InstallState{
@@ -292,6 +303,29 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			})
 		})
 
+		Describe("returns a partition list by layout", func() {
+			It("sorts listed partitions first", func() {
+				ep := types.NewElementalPartitionsFromList(p, nil)
+				ep.Persistent.Size = 10
+				extraParts := types.PartitionList{
+					&types.Partition{Name: "firmware", Size: 4},
+					&types.Partition{Name: "data", Size: 0},
+				}
+				lst := ep.PartitionsByLayout([]string{"firmware", "persistent", "unknown"}, extraParts)
+				Expect(len(lst)).To(Equal(4))
+				Expect(lst[0].Name).To(Equal("firmware"))
+				Expect(lst[1].Name).To(Equal("persistent"))
+				Expect(lst[2].Name).To(Equal("oem"))
+				Expect(lst[3].Name).To(Equal("data"))
+			})
+			It("keeps the partition with size == 0 last", func() {
+				ep := types.NewElementalPartitionsFromList(p, nil)
+				lst := ep.PartitionsByLayout([]string{"persistent", "oem"}, types.PartitionList{})
+				Expect(len(lst)).To(Equal(2))
+				Expect(lst[0].Name).To(Equal("oem"))
+				Expect(lst[1].Name).To(Equal("persistent"))
+			})
+		})
 		It("returns a partition list by mount order", func() {
 			ep := types.NewElementalPartitionsFromList(p, nil)
 			lst := ep.PartitionsByMountPoint(false)
@@ -364,6 +398,67 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			Expect(p.GetByName("nonexistent")).To(BeNil())
 		})
 	})
+	Describe("Partition layout", func() {
+		It("computes start offsets", func() {
+			pl := types.PartitionList{
+				&types.Partition{Name: "efi", Size: 64},
+				&types.Partition{Name: "firmware", Size: 4, Start: 128},
+				&types.Partition{Name: "oem", Size: 60, Align: 8},
+				&types.Partition{Name: "persistent", Size: 0},
+			}
+			offsets, err := pl.StartOffsets()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(offsets).To(Equal([]uint{1, 128, 136, 196}))
+		})
+		It("fails on overlapping start offsets", func() {
+			pl := types.PartitionList{
+				&types.Partition{Name: "efi", Size: 64},
+				&types.Partition{Name: "firmware", Size: 4, Start: 32},
+			}
+			_, err := pl.StartOffsets()
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails if the partition with size == 0 is not the last one", func() {
+			pl := types.PartitionList{
+				&types.Partition{Name: "persistent", Size: 0},
+				&types.Partition{Name: "firmware", Size: 4},
+			}
+			_, err := pl.StartOffsets()
+			Expect(err).To(HaveOccurred())
+		})
+		It("parses GPT attributes by name or bit", func() {
+			part := types.Partition{Name: "data", Attributes: []string{"no-automount", "read-only", "48"}}
+			Expect(part.GPTAttributes()).To(Equal([]uint{63, 60, 48}))
+
+			part.Attributes = []string{"64"}
+			_, err := part.GPTAttributes()
+			Expect(err).To(HaveOccurred())
+
+			part.Attributes = []string{"unknown"}
+			_, err = part.GPTAttributes()
+			Expect(err).To(HaveOccurred())
+		})
+		It("validates the partition layout", func() {
+			pl := types.PartitionList{
+				&types.Partition{Name: "efi", Size: 64, TypeGUID: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"},
+				&types.Partition{Name: "data", Size: 0, UUID: "7d4e2b9c-3c1a-4f8e-9b7e-2a6f0c1d5e3f"},
+			}
+			Expect(pl.ValidateLayout([]string{"data"}, types.GPT)).To(Succeed())
+
+			// GPT settings on msdos
+			Expect(pl.ValidateLayout(nil, types.MSDOS)).NotTo(Succeed())
+
+			// Unknown partition in order
+			Expect(pl.ValidateLayout([]string{"unknown"}, types.GPT)).NotTo(Succeed())
+
+			// Duplicated partition in order
+			Expect(pl.ValidateLayout([]string{"efi", "efi"}, types.GPT)).NotTo(Succeed())
+
+			// Invalid type GUID
+			pl[0].TypeGUID = "EF00"
+			Expect(pl.ValidateLayout(nil, types.GPT)).NotTo(Succeed())
+		})
+	})
 	Describe("InstallSpec", func() {
 		var spec *types.InstallSpec
 
@@ -399,6 +494,22 @@ var _ = Describe("Types", Label("types", "config"), func() {
 				err = spec.Sanitize()
 				Expect(err).Should(HaveOccurred())
 			})
+			It("sets legacy BIOS partitions", func() {
+				spec.System = types.NewDirSrc("/dir")
+				spec.Firmware = types.BIOS
+				Expect(spec.Sanitize()).To(Succeed())
+				Expect(spec.Partitions.BIOS).NotTo(BeNil())
+				Expect(spec.Partitions.Boot).NotTo(BeNil())
+
+				spec.PartTable = types.MSDOS
+				Expect(spec.Sanitize()).To(Succeed())
+				Expect(spec.Partitions.BIOS).To(BeNil())
+				Expect(spec.Partitions.Boot.Flags).To(ContainElement("boot"))
+
+				// GPT partition settings are not valid on msdos tables
+				spec.Partitions.State.TypeGUID = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+				Expect(spec.Sanitize()).NotTo(Succeed())
+			})
 			Describe("with extra partitions", func() {
 				BeforeEach(func() {
 					// Set a source for the install
@@ -425,6 +536,140 @@ var _ = Describe("Types", Label("types", "config"), func() {
 					Expect(err).ToNot(HaveOccurred())
 				})
 			})
+			Describe("with a declarative layout", func() {
+				BeforeEach(func() {
+					spec.System = types.NewDirSrc("/dir")
+					spec.Firmware = types.EFI
+					spec.PartTable = types.GPT
+				})
+				It("accepts a firmware partition at a fixed offset", func() {
+					spec.ExtraPartitions = types.PartitionList{{
+						Name: "firmware", Size: 4, Start: 2048,
+						TypeGUID: "21686148-6449-6E6F-744E-656564454649", Attributes: []string{"required"},
+					}}
+					spec.Partitions.Persistent.Size = 100
+					spec.PartitionOrder = []string{"firmware"}
+					Expect(spec.Sanitize()).To(Succeed())
+				})
+				It("fails on an unknown partition in the order", func() {
+					spec.PartitionOrder = []string{"unknown"}
+					Expect(spec.Sanitize()).NotTo(Succeed())
+				})
+				It("fails on overlapping partitions", func() {
+					spec.Partitions.OEM.Start = 1
+					Expect(spec.Sanitize()).NotTo(Succeed())
+				})
+			})
+			Describe("with mirror targets", func() {
+				BeforeEach(func() {
+					spec.System = types.NewDirSrc("/dir")
+					spec.Target = "/dev/sda"
+					spec.Firmware = types.EFI
+					spec.PartTable = types.GPT
+				})
+				It("accepts additional mirror targets", func() {
+					spec.MirrorTargets = []string{"/dev/sdb", "/dev/sdc"}
+					Expect(spec.Sanitize()).To(Succeed())
+				})
+				It("fails on duplicated targets", func() {
+					spec.MirrorTargets = []string{"/dev/sdb", "/dev/sda"}
+					Expect(spec.Sanitize()).NotTo(Succeed())
+				})
+				It("fails if no-format is set", func() {
+					spec.MirrorTargets = []string{"/dev/sdb"}
+					spec.NoFormat = true
+					Expect(spec.Sanitize()).NotTo(Succeed())
+				})
+			})
+			Describe("with encrypted partitions", func() {
+				BeforeEach(func() {
+					spec.System = types.NewDirSrc("/dir")
+				})
+				It("accepts an encrypted persistent partition", func() {
+					spec.Partitions.Persistent.Encryption = &types.Encryption{
+						KeySlots: []types.KeySlot{{TPM: true}, {Passphrase: "secret"}},
+					}
+					Expect(spec.Sanitize()).To(Succeed())
+				})
+				It("fails to encrypt partitions required at boot", func() {
+					spec.Partitions.State.Encryption = &types.Encryption{
+						KeySlots: []types.KeySlot{{Passphrase: "secret"}},
+					}
+					err := spec.Sanitize()
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("not supported for the state partition"))
+				})
+				It("fails on key slots without exactly one key", func() {
+					spec.Partitions.Persistent.Encryption = &types.Encryption{
+						KeySlots: []types.KeySlot{{Passphrase: "secret", KeyFile: "/some/key"}},
+					}
+					Expect(spec.Sanitize()).NotTo(Succeed())
+
+					spec.Partitions.Persistent.Encryption.KeySlots = []types.KeySlot{}
+					Expect(spec.Sanitize()).NotTo(Succeed())
+
+					spec.Partitions.Persistent.Encryption.KeySlots = []types.KeySlot{{KeyFile: "/some/key", TPMPCRs: "7"}}
+					Expect(spec.Sanitize()).NotTo(Succeed())
+				})
+				It("fails on key files not available at boot without a TPM slot", func() {
+					spec.Partitions.Persistent.Encryption = &types.Encryption{
+						KeySlots: []types.KeySlot{{Passphrase: "secret"}, {KeyFile: "/etc/luks.key"}},
+					}
+					Expect(spec.Sanitize()).To(MatchError(ContainSubstring("key files must be within")))
+
+					spec.Partitions.Persistent.Encryption.KeySlots[1].KeyFile = "/etc/elemental/keys/luks.key"
+					Expect(spec.Sanitize()).To(Succeed())
+
+					spec.Partitions.Persistent.Encryption.KeySlots = []types.KeySlot{{TPM: true}, {KeyFile: "/etc/luks.key"}}
+					Expect(spec.Sanitize()).To(Succeed())
+				})
+			})
+		})
+	})
+	Describe("AutoInstallSpec", Label("autoinstall"), func() {
+		It("runs sanitize method", func() {
+			auto := config.NewAutoInstallSpec()
+			Expect(auto.Sanitize()).To(Succeed())
+			Expect(auto.Countdown).To(Equal(uint(constants.AutoInstallCountdown)))
+
+			auto.Target = types.TargetSelector{Device: "/dev/[sv]d*", MinSize: 8192, MaxSize: 4096}
+			Expect(auto.Sanitize()).To(MatchError(ContainSubstring("bigger than max-size")))
+
+			auto.Target.MaxSize = 0
+			Expect(auto.Sanitize()).To(Succeed())
+
+			auto.Target.Model = "QEMU[HARDDISK"
+			Expect(auto.Sanitize()).To(MatchError(ContainSubstring("invalid target pattern")))
+		})
+		It("matches disks against all the target rules", func() {
+			target := types.TargetSelector{}
+			Expect(target.Matches("/dev/sda", "QEMU HARDDISK", "1234", 1024*1024*1024)).To(BeTrue())
+
+			target = types.TargetSelector{Device: "/dev/nvme*", Serial: "S4EW*", MinSize: 10240, MaxSize: 102400}
+			Expect(target.Matches("/dev/nvme0n1", "Samsung SSD", "S4EWNX0", 64*1024*1024*1024)).To(BeTrue())
+			Expect(target.Matches("/dev/sda", "Samsung SSD", "S4EWNX0", 64*1024*1024*1024)).To(BeFalse())
+			Expect(target.Matches("/dev/nvme0n1", "Samsung SSD", "X1234", 64*1024*1024*1024)).To(BeFalse())
+			Expect(target.Matches("/dev/nvme0n1", "Samsung SSD", "S4EWNX0", 8*1024*1024*1024)).To(BeFalse())
+			Expect(target.Matches("/dev/nvme0n1", "Samsung SSD", "S4EWNX0", 512*1024*1024*1024)).To(BeFalse())
+		})
+	})
+	Describe("Encryption", func() {
+		It("returns the volume unlock data without secrets", func() {
+			var enc *types.Encryption
+			Expect(enc.VolumeEncryption()).To(BeNil())
+
+			enc = &types.Encryption{KeySlots: []types.KeySlot{{Passphrase: "secret"}}}
+			Expect(*enc.VolumeEncryption()).To(Equal(types.VolumeEncryption{}))
+
+			enc.KeySlots = append(enc.KeySlots, types.KeySlot{TPM: true})
+			Expect(*enc.VolumeEncryption()).To(Equal(types.VolumeEncryption{TPM: true}))
+
+			// Only key files embedded into the initrd are recorded
+			enc.KeySlots = append(enc.KeySlots, types.KeySlot{KeyFile: "/etc/luks.key"})
+			Expect(*enc.VolumeEncryption()).To(Equal(types.VolumeEncryption{TPM: true}))
+
+			enc.KeySlots = append(enc.KeySlots, types.KeySlot{KeyFile: "/etc/elemental/keys/luks.key"})
+			Expect(*enc.VolumeEncryption()).To(Equal(types.VolumeEncryption{KeyFile: "/etc/elemental/keys/luks.key", TPM: true}))
 		})
 	})
 	Describe("ResetSpec", func() {
@@ -440,6 +685,22 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			err := spec.Sanitize()
 			Expect(err).ShouldNot(HaveOccurred())
 
+			// Encryption is only applied when formatting persistent
+			spec.Partitions.Persistent = &types.Partition{Encryption: &types.Encryption{}}
+			spec.Encryption = &types.Encryption{KeySlots: []types.KeySlot{{TPM: true}}}
+			Expect(spec.Sanitize()).To(Succeed())
+			Expect(spec.Partitions.Persistent.Encryption.KeySlots).To(BeEmpty())
+
+			spec.FormatPersistent = true
+			Expect(spec.Sanitize()).To(Succeed())
+			Expect(spec.Partitions.Persistent.Encryption).To(Equal(spec.Encryption))
+
+			// Fails to format an encrypted persistent partition without key slots
+			spec.Partitions.Persistent.Encryption = &types.Encryption{}
+			spec.Encryption = nil
+			Expect(spec.Sanitize()).NotTo(Succeed())
+			spec.FormatPersistent = false
+
 			//Fails on missing state partition
 			spec.Partitions.State = nil
 			err = spec.Sanitize()
@@ -451,6 +712,36 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			Expect(err).Should(HaveOccurred())
 		})
 	})
+	Describe("RepartitionSpec", func() {
+		It("runs sanitize method", func() {
+			spec := &types.RepartitionSpec{Target: "/dev/sda"}
+
+			// Fails if no changes are requested
+			Expect(spec.Sanitize()).NotTo(Succeed())
+
+			spec.Partitions.State = &types.Partition{Size: 16384}
+			spec.ExtraPartitions = types.PartitionList{{Name: "data", FS: "ext4"}}
+			Expect(spec.Sanitize()).To(Succeed())
+			Expect(spec.Partitions.State.Name).To(Equal("state"))
+			Expect(spec.Changes()).To(Equal(types.PartitionList{spec.Partitions.State, spec.ExtraPartitions[0]}))
+
+			// Fails on duplicated or reserved names
+			spec.ExtraPartitions = append(spec.ExtraPartitions, &types.Partition{Name: "state"})
+			Expect(spec.Sanitize()).NotTo(Succeed())
+			spec.ExtraPartitions[1].Name = "date"
+			Expect(spec.Sanitize()).NotTo(Succeed())
+			spec.ExtraPartitions = spec.ExtraPartitions[:1]
+
+			// Fails on bootloader changes
+			spec.Partitions.Boot = &types.Partition{Size: 128}
+			Expect(spec.Sanitize()).NotTo(Succeed())
+			spec.Partitions.Boot = nil
+
+			// Fails on missing target
+			spec.Target = ""
+			Expect(spec.Sanitize()).NotTo(Succeed())
+		})
+	})
 	Describe("UpgradeSpec", func() {
 		It("runs sanitize method", func() {
 			spec := &types.UpgradeSpec{
@@ -516,6 +807,12 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
 			Expect(iso.Sanitize()).ShouldNot(HaveOccurred())
 
+			// Only EFI and hybrid legacy BIOS ISOs are supported
+			spec.Firmware = types.BIOS
+			Expect(spec.Sanitize()).ShouldNot(HaveOccurred())
+			spec.Firmware = "uboot"
+			Expect(spec.Sanitize()).Should(HaveOccurred())
+
 			//Fails when packages were provided in incorrect format
 			spec = &types.LiveISO{
 				RootFS: []*types.ImageSource{
@@ -531,23 +828,186 @@ var _ = Describe("Types", Label("types", "config"), func() {
 			Expect(spec.Sanitize()).Should(HaveOccurred())
 		})
 	})
+	Describe("Netboot", Label("netboot"), func() {
+		It("runs sanitize method", func() {
+			netboot := config.NewNetboot()
+			Expect(netboot.Sanitize()).To(MatchError(ContainSubstring("undefined base URL")))
+
+			netboot.BaseURL = "https://pxe.example.org/elemental/"
+			netboot.RootFS = []*types.ImageSource{types.NewDirSrc("/system/os")}
+			Expect(netboot.Sanitize()).To(Succeed())
+			Expect(netboot.BaseURL).To(Equal("https://pxe.example.org/elemental"))
+
+			// Only HTTP URLs are supported
+			netboot.BaseURL = "tftp://pxe.example.org/elemental"
+			Expect(netboot.Sanitize()).To(MatchError(ContainSubstring("invalid base URL")))
+			netboot.BaseURL = "/srv/tftp"
+			Expect(netboot.Sanitize()).To(MatchError(ContainSubstring("invalid base URL")))
+
+			netboot.BaseURL = "http://pxe.example.org"
+			netboot.RootFS = []*types.ImageSource{nil}
+			Expect(netboot.Sanitize()).NotTo(Succeed())
+		})
+	})
+	Describe("DiskSpec", func() {
+		It("runs sanitize method", func() {
+			disk := config.NewDisk(config.NewBuildConfig(config.WithMounter(v1mocks.NewFakeMounter())))
+			disk.System = types.NewDirSrc("/system/os")
+			Expect(disk.Sanitize()).To(Succeed())
+
+			// Compression is only supported on qcow2 images
+			disk.Compression = constants.ZstdCompression
+			Expect(disk.Sanitize()).NotTo(Succeed())
+			disk.Type = constants.QCOW2Type
+			Expect(disk.Sanitize()).To(Succeed())
+
+			// OVA appliances require the virtual machine hardware
+			disk.Compression = constants.NoCompression
+			disk.Type = constants.OVAType
+			Expect(disk.Sanitize()).To(Succeed())
+			disk.VM.Memory = 0
+			Expect(disk.Sanitize()).NotTo(Succeed())
+		})
+		It("adds the BIOS boot partition for legacy BIOS", func() {
+			disk := config.NewDisk(config.NewBuildConfig(config.WithMounter(v1mocks.NewFakeMounter())))
+			disk.System = types.NewDirSrc("/system/os")
+			minSize := disk.MinDiskSize()
+			disk.Firmware = types.BIOS
+			Expect(disk.Sanitize()).To(Succeed())
+			Expect(disk.PartitionsByLayout()[0].Name).To(Equal(constants.BiosPartName))
+			Expect(disk.MinDiskSize()).To(Equal(minSize + constants.BiosSize))
+
+			disk.Firmware = "uboot"
+			Expect(disk.Sanitize()).NotTo(Succeed())
+		})
+		It("sets the Raspberry Pi board profile", func() {
+			disk := config.NewDisk(config.NewBuildConfig(config.WithMounter(v1mocks.NewFakeMounter())))
+			disk.System = types.NewDirSrc("/system/os")
+			disk.Board = constants.RPiBoard
+			Expect(disk.Sanitize()).To(Succeed())
+			Expect(disk.Partitions.Boot.Size).To(Equal(constants.RPiBootSize))
+
+			disk.Firmware = types.BIOS
+			Expect(disk.Sanitize()).NotTo(Succeed())
+
+			disk.Firmware = types.EFI
+			disk.Expandable = true
+			Expect(disk.Sanitize()).To(MatchError(ContainSubstring("expandable disks are not supported")))
+
+			disk.Expandable = false
+			disk.Board = "jetson"
+			Expect(disk.Sanitize()).To(MatchError(ContainSubstring("invalid board")))
+		})
+	})
 	Describe("MountSpec", func() {
 		It("sanitizes empty paths", func() {
 			spec := types.MountSpec{
 				Ephemeral: types.EphemeralMounts{
 					Type:  constants.Tmpfs,
-					Paths: []string{"/var", "", "/etc"},
+					Paths: types.NewEphemeralPaths("/var", "", "/etc"),
 				},
 				Persistent: types.PersistentMounts{
 					Mode:  constants.OverlayMode,
-					Paths: []string{"/etc/rancher", "", "/root"},
+					Paths: types.NewPersistentPaths("/etc/rancher", "", "/root"),
 				},
 			}
 
 			Expect(spec.Sanitize()).To(Succeed())
 
-			Expect(spec.Ephemeral.Paths).To(Equal([]string{"/var", "/etc"}))
-			Expect(spec.Persistent.Paths).To(Equal([]string{"/root", "/etc/rancher"}))
+			Expect(spec.Ephemeral.Paths).To(Equal([]types.EphemeralPath{{Path: "/var"}, {Path: "/etc"}}))
+			Expect(spec.Persistent.Paths).To(Equal([]types.PersistentPath{
+				{Path: "/root", Mode: constants.OverlayMode}, {Path: "/etc/rancher", Mode: constants.OverlayMode},
+			}))
+		})
+		It("sets per path persistence modes", func() {
+			spec := types.MountSpec{
+				Ephemeral: types.EphemeralMounts{Type: constants.Tmpfs},
+				Persistent: types.PersistentMounts{
+					Mode:  constants.OverlayMode,
+					Paths: types.NewPersistentPaths("/var/lib/rancher:bind", "/etc/ssh", "/opt:overlay", "/srv/a:b"),
+				},
+			}
+			Expect(spec.Sanitize()).To(Succeed())
+			Expect(spec.Persistent.Paths).To(Equal([]types.PersistentPath{
+				{Path: "/opt", Mode: constants.OverlayMode},
+				{Path: "/etc/ssh", Mode: constants.OverlayMode},
+				{Path: "/srv/a:b", Mode: constants.OverlayMode},
+				{Path: "/var/lib/rancher", Mode: constants.BindMode},
+			}))
+
+			spec.Persistent.Paths = []types.PersistentPath{{Path: "/etc/ssh", Mode: "copy"}}
+			Expect(spec.Sanitize()).NotTo(Succeed())
+		})
+		It("unmarshals persistent paths", func() {
+			path := &types.PersistentPath{}
+			_, err := path.CustomUnmarshal("/etc/ssh:bind")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*path).To(Equal(types.PersistentPath{Path: "/etc/ssh", Mode: constants.BindMode}))
+
+			_, err = path.CustomUnmarshal(map[string]interface{}{"/var/lib/rancher": "bind"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*path).To(Equal(types.PersistentPath{Path: "/var/lib/rancher", Mode: constants.BindMode}))
+
+			cont, err := path.CustomUnmarshal(map[string]interface{}{"path": "/home", "mode": "overlay"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cont).To(BeTrue())
+
+			_, err = path.CustomUnmarshal(map[string]interface{}{"/var/lib/rancher": 1})
+			Expect(err).To(HaveOccurred())
+
+			_, err = path.CustomUnmarshal(map[string]interface{}{"/var/log": map[string]interface{}{"mode": "bind", "size": "2G"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*path).To(Equal(types.PersistentPath{Path: "/var/log", Mode: constants.BindMode, Size: "2G"}))
+		})
+		It("unmarshals ephemeral paths", func() {
+			path := &types.EphemeralPath{}
+			_, err := path.CustomUnmarshal("/var")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*path).To(Equal(types.EphemeralPath{Path: "/var"}))
+
+			_, err = path.CustomUnmarshal(map[string]interface{}{"/tmp": "512m"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*path).To(Equal(types.EphemeralPath{Path: "/tmp", Size: "512m"}))
+		})
+		It("validates path sizes and enables project quotas", func() {
+			spec := types.MountSpec{
+				Ephemeral: types.EphemeralMounts{
+					Type:  constants.Tmpfs,
+					Paths: []types.EphemeralPath{{Path: "/tmp", Size: "25%"}},
+				},
+				Persistent: types.PersistentMounts{
+					Mode:  constants.OverlayMode,
+					Paths: []types.PersistentPath{{Path: "/var/log", Size: "2G"}, {Path: "/home"}},
+				},
+			}
+			Expect(spec.Sanitize()).To(Succeed())
+			Expect(spec.Persistent.HasQuotas()).To(BeTrue())
+			Expect(spec.Persistent.Volume.Options).To(ContainElement(constants.PrjQuotaOpt))
+
+			// options are not duplicated
+			Expect(spec.Sanitize()).To(Succeed())
+			Expect(spec.Persistent.Volume.Options).To(HaveLen(1))
+
+			spec.Ephemeral.Paths[0].Size = "lots"
+			Expect(spec.Sanitize()).NotTo(Succeed())
+
+			spec.Ephemeral.Paths[0].Size = ""
+			spec.Persistent.Paths[0].Size = "10%"
+			Expect(spec.Sanitize()).NotTo(Succeed())
+		})
+		It("defaults to fstab output and fails on unknown outputs", func() {
+			spec := types.MountSpec{
+				Ephemeral:  types.EphemeralMounts{Type: constants.Tmpfs},
+				Persistent: types.PersistentMounts{Mode: constants.OverlayMode},
+			}
+			Expect(spec.Sanitize()).To(Succeed())
+			Expect(spec.Output).To(Equal(constants.FstabOutput))
+
+			spec.Output = constants.SystemdUnitsOutput
+			Expect(spec.Sanitize()).To(Succeed())
+
+			spec.Output = "crontab"
+			Expect(spec.Sanitize()).NotTo(Succeed())
 		})
 	})
 	Describe("KeyValuePair", func() {
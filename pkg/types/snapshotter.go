@@ -48,11 +48,13 @@ type Snapshot struct {
 	WorkDir    string
 	Label      string
 	InProgress bool
+	RootHash   string
 }
 
 type LoopDeviceConfig struct {
-	Size uint   `yaml:"size,omitempty" mapstructure:"size"`
-	FS   string `yaml:"fs,omitempty" mapstructure:"fs"`
+	Size   uint   `yaml:"size,omitempty" mapstructure:"size"`
+	FS     string `yaml:"fs,omitempty" mapstructure:"fs"`
+	Verity bool   `yaml:"verity,omitempty" mapstructure:"verity"`
 }
 
 type BtrfsConfig struct {
@@ -23,6 +23,7 @@ import (
 type SyscallInterface interface {
 	Chroot(string) error
 	Chdir(string) error
+	Getxattr(path string, attr string, dest []byte) (int, error)
 }
 
 type RealSyscall struct{}
@@ -34,3 +35,7 @@ func (r *RealSyscall) Chroot(path string) error {
 func (r *RealSyscall) Chdir(path string) error {
 	return syscall.Chdir(path)
 }
+
+func (r *RealSyscall) Getxattr(path string, attr string, dest []byte) (int, error) {
+	return syscall.Getxattr(path, attr, dest)
+}
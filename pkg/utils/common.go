@@ -83,6 +83,7 @@ func CopyFile(fs types.FS, source string, target string) error {
 // Source files are concatenated into target file in the given order.
 // If target is a directory source is copied into that directory using
 // 1st source name file. The result keeps the file mode of the 1st source.
+// Holes and zero blocks of the sources are kept as holes in the target.
 func ConcatFiles(fs types.FS, sources []string, target string) (err error) {
 	if len(sources) == 0 {
 		return fmt.Errorf("Empty sources list")
@@ -107,21 +108,34 @@ func ConcatFiles(fs types.FS, sources []string, target string) (err error) {
 		}
 	}()
 
+	// Holes and zero blocks of regular files are not written, the final truncate
+	// preserves them as holes in the target file
 	var sourceFile *os.File
+	var offset, size int64
 	for _, source := range sources {
 		sourceFile, err = fs.OpenFile(source, os.O_RDONLY, constants.FilePerm)
 		if err != nil {
 			return err
 		}
-		_, err = io.Copy(targetFile, sourceFile)
+		if sInf, sErr := sourceFile.Stat(); sErr == nil && sInf.Mode().IsRegular() {
+			size, err = SparseCopy(targetFile, offset, sourceFile)
+		} else {
+			size, err = io.Copy(io.NewOffsetWriter(targetFile, offset), sourceFile)
+		}
 		if err != nil {
+			_ = sourceFile.Close()
 			return err
 		}
+		offset += size
 		err = sourceFile.Close()
 		if err != nil {
 			return err
 		}
 	}
+	err = targetFile.Truncate(offset)
+	if err != nil {
+		return err
+	}
 
 	return fs.Chmod(target, fInf.Mode())
 }
@@ -278,6 +292,16 @@ func CosignVerify(fs types.FS, runner types.Runner, image string, publicKey stri
 	return string(out), err
 }
 
+// CosignVerifyBlob runs a cosign validation of the given file against its detached signature and the
+// given public key
+func CosignVerifyBlob(runner types.Runner, file, signature, publicKey string) (string, error) {
+	if publicKey == "" {
+		return "", fmt.Errorf("a cosign public key is required to verify %s", file)
+	}
+	out, err := runner.Run("cosign", "verify-blob", "--key", publicKey, "--signature", signature, file)
+	return string(out), err
+}
+
 // CreateSquashFS creates a squash file at destination from a source, with options
 func CreateSquashFS(runner types.Runner, logger types.Logger, source string, destination string, options []string, excludes ...string) error {
 	// create args
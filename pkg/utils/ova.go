@@ -0,0 +1,209 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+)
+
+// This file contains utils to bundle VMDK disks into OVA appliances
+
+// ovfTemplate is an OVF 1.0 descriptor of a virtual machine booting in EFI or legacy BIOS mode from a single streamOptimized disk
+const ovfTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vmw="http://www.vmware.com/schema/ovf" xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <References>
+    <File ovf:href="{{xml .Disk}}" ovf:id="file1" ovf:size="{{.DiskSize}}"/>
+  </References>
+  <DiskSection>
+    <Info>Virtual disk information</Info>
+    <Disk ovf:capacity="{{.Capacity}}" ovf:capacityAllocationUnits="byte" ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/>
+  </DiskSection>
+  <NetworkSection>
+    <Info>The list of logical networks</Info>
+    <Network ovf:name="VM Network">
+      <Description>The VM Network network</Description>
+    </Network>
+  </NetworkSection>
+  <VirtualSystem ovf:id="{{xml .Name}}">
+    <Info>A virtual machine</Info>
+    <Name>{{xml .Name}}</Name>
+    <OperatingSystemSection ovf:id="101" vmw:osType="otherLinux64Guest">
+      <Info>The kind of installed guest operating system</Info>
+    </OperatingSystemSection>
+    <VirtualHardwareSection>
+      <Info>Virtual hardware requirements</Info>
+      <System>
+        <vssd:ElementName>Virtual Hardware Family</vssd:ElementName>
+        <vssd:InstanceID>0</vssd:InstanceID>
+        <vssd:VirtualSystemIdentifier>{{xml .Name}}</vssd:VirtualSystemIdentifier>
+        <vssd:VirtualSystemType>vmx-14</vssd:VirtualSystemType>
+      </System>
+      <Item>
+        <rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits>
+        <rasd:Description>Number of Virtual CPUs</rasd:Description>
+        <rasd:ElementName>{{.VM.CPUs}} virtual CPU(s)</rasd:ElementName>
+        <rasd:InstanceID>1</rasd:InstanceID>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>{{.VM.CPUs}}</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:Description>Memory Size</rasd:Description>
+        <rasd:ElementName>{{.VM.Memory}}MB of memory</rasd:ElementName>
+        <rasd:InstanceID>2</rasd:InstanceID>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>{{.VM.Memory}}</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:Address>0</rasd:Address>
+        <rasd:Description>SCSI Controller</rasd:Description>
+        <rasd:ElementName>SCSI Controller 0</rasd:ElementName>
+        <rasd:InstanceID>3</rasd:InstanceID>
+        <rasd:ResourceSubType>VirtualSCSI</rasd:ResourceSubType>
+        <rasd:ResourceType>6</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>0</rasd:AddressOnParent>
+        <rasd:ElementName>Hard Disk 1</rasd:ElementName>
+        <rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource>
+        <rasd:InstanceID>4</rasd:InstanceID>
+        <rasd:Parent>3</rasd:Parent>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>7</rasd:AddressOnParent>
+        <rasd:AutomaticAllocation>true</rasd:AutomaticAllocation>
+        <rasd:Connection>VM Network</rasd:Connection>
+        <rasd:Description>VmxNet3 ethernet adapter on "VM Network"</rasd:Description>
+        <rasd:ElementName>Network adapter 1</rasd:ElementName>
+        <rasd:InstanceID>5</rasd:InstanceID>
+        <rasd:ResourceSubType>VmxNet3</rasd:ResourceSubType>
+        <rasd:ResourceType>10</rasd:ResourceType>
+      </Item>
+      <vmw:Config ovf:required="false" vmw:key="firmware" vmw:value="{{.Firmware}}"/>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
+
+// ovfData holds the values rendered in the OVF descriptor
+type ovfData struct {
+	Name     string
+	Disk     string
+	DiskSize int64
+	Capacity int64
+	VM       types.VMSpec
+	Firmware string
+}
+
+// OVFDescriptor returns the OVF descriptor of a virtual machine named after the given name, including
+// the given VMDK disk file of the given size and capacity in bytes. The virtual machine boots in legacy
+// BIOS mode for the bios firmware and in EFI mode otherwise.
+func OVFDescriptor(name, disk string, diskSize, capacity int64, vm types.VMSpec, firmware string) (string, error) {
+	tmpl, err := template.New("ovf").Funcs(template.FuncMap{
+		"xml": func(s string) (string, error) {
+			var buffer strings.Builder
+			err := xml.EscapeText(&buffer, []byte(s))
+			return buffer.String(), err
+		},
+	}).Parse(ovfTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	vmFirmware := types.EFI
+	if firmware == types.BIOS {
+		vmFirmware = types.BIOS
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, ovfData{Name: name, Disk: disk, DiskSize: diskSize, Capacity: capacity, VM: vm, Firmware: vmFirmware})
+	return out.String(), err
+}
+
+// VmdkToOva bundles the given VMDK disk with the given virtual disk capacity in bytes into the given OVA
+// file for the given firmware. The OVA includes the OVF descriptor, a manifest with the SHA256 digests and
+// the disk, in this order.
+func VmdkToOva(fs types.FS, vmdk, ova string, capacity int64, vm types.VMSpec, firmware string) error {
+	name := strings.TrimSuffix(filepath.Base(ova), filepath.Ext(ova))
+	diskName := name + ".vmdk"
+
+	info, err := fs.Stat(vmdk)
+	if err != nil {
+		return err
+	}
+	diskSum, err := CalcFileChecksum(fs, vmdk)
+	if err != nil {
+		return err
+	}
+
+	descriptor, err := OVFDescriptor(name, diskName, info.Size(), capacity, vm, firmware)
+	if err != nil {
+		return err
+	}
+	manifest := fmt.Sprintf("SHA256(%s.ovf)= %x\nSHA256(%s)= %s\n", name, sha256.Sum256([]byte(descriptor)), diskName, diskSum)
+
+	file, err := fs.Create(ova)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tarWriter := tar.NewWriter(file)
+	now := time.Now()
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{name + ".ovf", []byte(descriptor)}, {name + ".mf", []byte(manifest)}} {
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name: entry.name, Size: int64(len(entry.data)), Mode: 0644, ModTime: now, Format: tar.FormatUSTAR,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(tarWriter, bytes.NewReader(entry.data)); err != nil {
+			return err
+		}
+	}
+
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name: diskName, Size: info.Size(), Mode: 0644, ModTime: now, Format: tar.FormatUSTAR,
+	})
+	if err != nil {
+		return err
+	}
+	disk, err := fs.Open(vmdk)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+	if _, err = io.Copy(tarWriter, disk); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
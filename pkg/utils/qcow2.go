@@ -0,0 +1,326 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+)
+
+// This file contains utils to work with QCOW2 disks
+
+const (
+	QCOW2Magic        = 0x514649fb // 'Q', 'F', 'I', 0xfb
+	QCOW2Version      = 3
+	QCOW2ClusterBits  = 16
+	QCOW2ClusterSize  = 1 << QCOW2ClusterBits
+	QCOW2HeaderLength = 112
+
+	// QCOW2 L1 and L2 table entry flags and masks
+	QCOW2Copied     = uint64(1) << 63
+	QCOW2Compressed = uint64(1) << 62
+	QCOW2OffsetMask = uint64(0x00fffffffffffe00)
+	// QCOW2CompressedSectorsShift is the first bit of the sectors count in compressed cluster descriptors
+	QCOW2CompressedSectorsShift = 62 - (QCOW2ClusterBits - 8)
+
+	// QCOW2 compression types, a non zlib compression is flagged as an incompatible feature
+	QCOW2ZlibCompressionType = 0
+	QCOW2ZstdCompressionType = 1
+	QCOW2CompressionFeature  = uint64(1) << 3
+
+	// 16 bits refcounts
+	qcow2RefcountOrder     = 4
+	qcow2RefcountsPerBlock = QCOW2ClusterSize * 8 / (1 << qcow2RefcountOrder)
+	qcow2EntriesPerTable   = QCOW2ClusterSize / 8
+	qcow2SectorBits        = 9
+)
+
+// QCOW2Header is the version 3 QCOW2 header including the compression type field
+type QCOW2Header struct {
+	Magic                 uint32  // QCOW magic string ("QFI\xfb")
+	Version               uint32  // Version number, only 3 is written
+	BackingFileOffset     uint64  // Offset of the backing file name, 0 as there is no backing file
+	BackingFileSize       uint32  // Length of the backing file name
+	ClusterBits           uint32  // Number of bits used for addressing an offset within a cluster
+	Size                  uint64  // Virtual disk size in bytes
+	CryptMethod           uint32  // 0 for no encryption
+	L1Size                uint32  // Number of entries in the active L1 table
+	L1TableOffset         uint64  // Offset of the active L1 table, cluster aligned
+	RefcountTableOffset   uint64  // Offset of the refcount table, cluster aligned
+	RefcountTableClusters uint32  // Number of clusters of the refcount table
+	NbSnapshots           uint32  // Number of snapshots contained in the image
+	SnapshotsOffset       uint64  // Offset of the snapshot table
+	IncompatibleFeatures  uint64  // Bitmask of features an implementation must support to open the image
+	CompatibleFeatures    uint64  // Bitmask of features an implementation can safely ignore
+	AutoclearFeatures     uint64  // Bitmask of features cleared by implementations not supporting them
+	RefcountOrder         uint32  // Width of a refcount block entry: refcount_bits = 1 << refcount_order
+	HeaderLength          uint32  // Length of the header structure in bytes
+	CompressionType       uint8   // Compression method used for compressed clusters
+	Padding               [7]byte // Pads the header to a multiple of 8 bytes
+}
+
+// qcow2Writer writes the data clusters of a QCOW2 image while keeping track of the mapping
+// of the guest clusters and the references of the host clusters
+type qcow2Writer struct {
+	file      *os.File
+	offset    int64
+	compress  func([]byte) ([]byte, error)
+	refcounts []uint16
+	l2Tables  map[int64][]uint64
+}
+
+// ref increments the refcount of the host clusters including the given range
+func (w *qcow2Writer) ref(offset, length int64) {
+	for c := offset / QCOW2ClusterSize; c <= (offset+length-1)/QCOW2ClusterSize; c++ {
+		for int64(len(w.refcounts)) <= c {
+			w.refcounts = append(w.refcounts, 0)
+		}
+		w.refcounts[c]++
+	}
+}
+
+// alignOffset moves the write offset to the next cluster boundary
+func (w *qcow2Writer) alignOffset() {
+	w.offset = (w.offset + QCOW2ClusterSize - 1) / QCOW2ClusterSize * QCOW2ClusterSize
+}
+
+// writeCluster writes the given guest cluster data and maps it in the L2 tables. Clusters are
+// stored compressed only if compression is enabled and the result is smaller than a cluster.
+func (w *qcow2Writer) writeCluster(index int64, data []byte) error {
+	var entry uint64
+
+	var compressed []byte
+	if w.compress != nil {
+		out, err := w.compress(data)
+		if err != nil {
+			return err
+		}
+		if len(out) < QCOW2ClusterSize {
+			compressed = out
+		}
+	}
+
+	if compressed != nil {
+		if _, err := w.file.WriteAt(compressed, w.offset); err != nil {
+			return err
+		}
+		length := int64(len(compressed))
+		sectors := uint64(((w.offset + length - 1) >> qcow2SectorBits) - (w.offset >> qcow2SectorBits))
+		entry = QCOW2Compressed | sectors<<QCOW2CompressedSectorsShift | uint64(w.offset)
+		w.ref(w.offset, length)
+		w.offset += length
+	} else {
+		w.alignOffset()
+		if _, err := w.file.WriteAt(data, w.offset); err != nil {
+			return err
+		}
+		entry = QCOW2Copied | uint64(w.offset)
+		w.ref(w.offset, QCOW2ClusterSize)
+		w.offset += QCOW2ClusterSize
+	}
+
+	table, ok := w.l2Tables[index/qcow2EntriesPerTable]
+	if !ok {
+		table = make([]uint64, qcow2EntriesPerTable)
+		w.l2Tables[index/qcow2EntriesPerTable] = table
+	}
+	table[index%qcow2EntriesPerTable] = entry
+	return nil
+}
+
+// writeTable writes the given big endian entries at the current offset and returns the table offset
+func (w *qcow2Writer) writeTable(entries interface{}, clusters int64) (int64, error) {
+	buffer := new(bytes.Buffer)
+	_ = binary.Write(buffer, binary.BigEndian, entries)
+	data := make([]byte, clusters*QCOW2ClusterSize)
+	copy(data, buffer.Bytes())
+
+	offset := w.offset
+	if _, err := w.file.WriteAt(data, offset); err != nil {
+		return 0, err
+	}
+	w.offset += int64(len(data))
+	return offset, nil
+}
+
+// qcow2Compressor returns the cluster compression function and the QCOW2 compression type
+// for the given compression name. A nil function means no compression.
+func qcow2Compressor(compression string) (func([]byte) ([]byte, error), uint8, error) {
+	switch compression {
+	case "", constants.NoCompression:
+		return nil, QCOW2ZlibCompressionType, nil
+	case constants.ZlibCompression:
+		// QCOW2 zlib compressed clusters are raw deflate streams without zlib headers
+		return func(data []byte) ([]byte, error) {
+			buffer := new(bytes.Buffer)
+			zw, err := flate.NewWriter(buffer, flate.DefaultCompression)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = zw.Write(data); err != nil {
+				return nil, err
+			}
+			if err = zw.Close(); err != nil {
+				return nil, err
+			}
+			return buffer.Bytes(), nil
+		}, QCOW2ZlibCompressionType, nil
+	case constants.ZstdCompression:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, 0, err
+		}
+		return func(data []byte) ([]byte, error) {
+			return encoder.EncodeAll(data, nil), nil
+		}, QCOW2ZstdCompressionType, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported qcow2 compression '%s'", compression)
+	}
+}
+
+// RawDiskToQcow2 writes the contents of the given raw disk file into the given QCOW2 file. Clusters
+// only including zeros are not allocated, the rest are compressed using the given compression.
+// RawDiskToQcow2 makes no effort into opening/closing/checking if the files exist
+func RawDiskToQcow2(rawFile *os.File, qcow2File *os.File, compression string) error {
+	compress, compressionType, err := qcow2Compressor(compression)
+	if err != nil {
+		return err
+	}
+
+	info, err := rawFile.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	// The first cluster is reserved for the header
+	w := &qcow2Writer{
+		file:      qcow2File,
+		offset:    QCOW2ClusterSize,
+		compress:  compress,
+		refcounts: []uint16{1},
+		l2Tables:  map[int64][]uint64{},
+	}
+
+	data := make([]byte, QCOW2ClusterSize)
+	clusters := (size + QCOW2ClusterSize - 1) / QCOW2ClusterSize
+	for i := int64(0); i < clusters; i++ {
+		n, err := rawFile.ReadAt(data, i*QCOW2ClusterSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		clear(data[n:])
+		if isZeroCluster(data) {
+			continue
+		}
+		if err = w.writeCluster(i, data); err != nil {
+			return err
+		}
+	}
+	w.alignOffset()
+
+	// Metadata is written after the data clusters. The refcount blocks have to include themselves
+	// and the refcount table, so their number is computed until it settles.
+	l1Size := (clusters + qcow2EntriesPerTable - 1) / qcow2EntriesPerTable
+	l1Clusters := max((l1Size*8+QCOW2ClusterSize-1)/QCOW2ClusterSize, 1)
+	metadataStart := w.offset / QCOW2ClusterSize
+	var blocks, tableClusters int64
+	for {
+		total := metadataStart + int64(len(w.l2Tables)) + l1Clusters + blocks + tableClusters
+		newBlocks := (total + qcow2RefcountsPerBlock - 1) / qcow2RefcountsPerBlock
+		newTableClusters := (newBlocks*8 + QCOW2ClusterSize - 1) / QCOW2ClusterSize
+		if newBlocks == blocks && newTableClusters == tableClusters {
+			break
+		}
+		blocks, tableClusters = newBlocks, newTableClusters
+	}
+	metadataClusters := int64(len(w.l2Tables)) + l1Clusters + tableClusters + blocks
+	w.ref(w.offset, metadataClusters*QCOW2ClusterSize)
+
+	var indexes []int64
+	for index := range w.l2Tables {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	l1Table := make([]uint64, l1Size)
+	for _, index := range indexes {
+		offset, err := w.writeTable(w.l2Tables[index], 1)
+		if err != nil {
+			return err
+		}
+		l1Table[index] = QCOW2Copied | uint64(offset)
+	}
+	l1Offset, err := w.writeTable(l1Table, l1Clusters)
+	if err != nil {
+		return err
+	}
+
+	refcountTable := make([]uint64, blocks)
+	for i := range refcountTable {
+		refcountTable[i] = uint64(w.offset + (tableClusters+int64(i))*QCOW2ClusterSize)
+	}
+	refcountTableOffset, err := w.writeTable(refcountTable, tableClusters)
+	if err != nil {
+		return err
+	}
+	refcounts := make([]uint16, blocks*qcow2RefcountsPerBlock)
+	copy(refcounts, w.refcounts)
+	if _, err = w.writeTable(refcounts, blocks); err != nil {
+		return err
+	}
+
+	header := QCOW2Header{
+		Magic:                 QCOW2Magic,
+		Version:               QCOW2Version,
+		ClusterBits:           QCOW2ClusterBits,
+		Size:                  uint64(size),
+		L1Size:                uint32(l1Size),
+		L1TableOffset:         uint64(l1Offset),
+		RefcountTableOffset:   uint64(refcountTableOffset),
+		RefcountTableClusters: uint32(tableClusters),
+		RefcountOrder:         qcow2RefcountOrder,
+		HeaderLength:          QCOW2HeaderLength,
+		CompressionType:       compressionType,
+	}
+	if compressionType != QCOW2ZlibCompressionType {
+		header.IncompatibleFeatures |= QCOW2CompressionFeature
+	}
+	// The header cluster is written in full, so the header extensions area is terminated by zeros
+	w.offset = 0
+	_, err = w.writeTable(header, 1)
+	return err
+}
+
+// isZeroCluster returns true if the given data only includes zeros
+func isZeroCluster(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// readQcow2 parses the given QCOW2 image and returns its header, its guest data and the
+// refcounts of the host clusters referenced by the image metadata
+func readQcow2(image []byte) (utils.QCOW2Header, []byte, map[uint64]uint16) {
+	header := utils.QCOW2Header{}
+	Expect(binary.Read(bytes.NewReader(image), binary.BigEndian, &header)).To(Succeed())
+
+	used := map[uint64]uint16{0: 1}
+	use := func(offset, length uint64) {
+		for c := offset / utils.QCOW2ClusterSize; c <= (offset+length-1)/utils.QCOW2ClusterSize; c++ {
+			used[c]++
+		}
+	}
+	entry := func(offset uint64) uint64 {
+		return binary.BigEndian.Uint64(image[offset : offset+8])
+	}
+
+	data := make([]byte, header.Size)
+	for i := uint64(0); i < uint64(header.L1Size); i++ {
+		l1Entry := entry(header.L1TableOffset + i*8)
+		if l1Entry == 0 {
+			continue
+		}
+		l2Offset := l1Entry & utils.QCOW2OffsetMask
+		use(l2Offset, utils.QCOW2ClusterSize)
+		for j := uint64(0); j < utils.QCOW2ClusterSize/8; j++ {
+			l2Entry := entry(l2Offset + j*8)
+			guestOffset := (i*utils.QCOW2ClusterSize/8 + j) * utils.QCOW2ClusterSize
+			if l2Entry == 0 || guestOffset >= header.Size {
+				continue
+			}
+			cluster := data[guestOffset:min(guestOffset+utils.QCOW2ClusterSize, header.Size)]
+			if l2Entry&utils.QCOW2Compressed == 0 {
+				Expect(l2Entry & utils.QCOW2Copied).NotTo(BeZero())
+				offset := l2Entry & utils.QCOW2OffsetMask
+				use(offset, utils.QCOW2ClusterSize)
+				copy(cluster, image[offset:offset+utils.QCOW2ClusterSize])
+				continue
+			}
+			offsetMask := uint64(1)<<utils.QCOW2CompressedSectorsShift - 1
+			offset := l2Entry & offsetMask
+			sectors := (l2Entry&^utils.QCOW2Compressed)>>utils.QCOW2CompressedSectorsShift + 1
+			length := (offset/512+sectors)*512 - offset
+			use(offset, length)
+			compressed := bytes.NewReader(image[offset:min(offset+length, uint64(len(image)))])
+			var reader io.Reader
+			if header.CompressionType == utils.QCOW2ZstdCompressionType {
+				decoder, err := zstd.NewReader(compressed)
+				Expect(err).ToNot(HaveOccurred())
+				defer decoder.Close()
+				reader = decoder
+			} else {
+				reader = flate.NewReader(compressed)
+			}
+			decompressed := make([]byte, utils.QCOW2ClusterSize)
+			_, err := io.ReadFull(reader, decompressed)
+			Expect(err).ToNot(HaveOccurred())
+			copy(cluster, decompressed)
+		}
+	}
+	// Empty images still allocate a cluster for the L1 table
+	use(header.L1TableOffset, max(uint64(header.L1Size)*8, 1))
+
+	refcounts := map[uint64]uint16{}
+	use(header.RefcountTableOffset, uint64(header.RefcountTableClusters)*utils.QCOW2ClusterSize)
+	for i := uint64(0); i < uint64(header.RefcountTableClusters)*utils.QCOW2ClusterSize/8; i++ {
+		blockOffset := entry(header.RefcountTableOffset + i*8)
+		if blockOffset == 0 {
+			continue
+		}
+		use(blockOffset, utils.QCOW2ClusterSize)
+		for j := uint64(0); j < utils.QCOW2ClusterSize/2; j++ {
+			refcount := binary.BigEndian.Uint16(image[blockOffset+j*2:])
+			if refcount > 0 {
+				refcounts[i*utils.QCOW2ClusterSize/2+j] = refcount
+			}
+		}
+	}
+	Expect(refcounts).To(Equal(used))
+	return header, data, refcounts
+}
+
+var _ = Describe("QCOW2 utils", Label("qcow2"), func() {
+	var fs vfs.FS
+	var cleanup func()
+	var raw []byte
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		// Sparse data with a partial last cluster
+		raw = make([]byte, 100*utils.QCOW2ClusterSize+1000)
+		copy(raw[0:], bytes.Repeat([]byte("elemental"), 1000))
+		copy(raw[3*utils.QCOW2ClusterSize+10:], []byte("sector data"))
+		for i := 0; i < utils.QCOW2ClusterSize; i++ {
+			raw[50*utils.QCOW2ClusterSize+i] = byte(i * 7 % 251)
+		}
+		copy(raw[len(raw)-11:], []byte("end of disk"))
+		Expect(fs.WriteFile("/disk.raw", raw, constants.FilePerm)).To(Succeed())
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	convert := func(compression string) []byte {
+		rawFile, err := fs.OpenFile("/disk.raw", os.O_RDONLY, constants.FilePerm)
+		Expect(err).ToNot(HaveOccurred())
+		defer rawFile.Close()
+		qcow2File, err := fs.Create("/disk.qcow2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(utils.RawDiskToQcow2(rawFile, qcow2File, compression)).To(Succeed())
+		Expect(qcow2File.Close()).To(Succeed())
+
+		image, err := fs.ReadFile("/disk.qcow2")
+		Expect(err).ToNot(HaveOccurred())
+		return image
+	}
+
+	It("writes a sparse image without compression", func() {
+		image := convert(constants.NoCompression)
+		header, data, _ := readQcow2(image)
+
+		Expect(header.Magic).To(Equal(uint32(utils.QCOW2Magic)))
+		Expect(header.Version).To(Equal(uint32(3)))
+		Expect(header.ClusterBits).To(Equal(uint32(16)))
+		Expect(header.Size).To(Equal(uint64(len(raw))))
+		Expect(header.HeaderLength).To(Equal(uint32(utils.QCOW2HeaderLength)))
+		Expect(header.IncompatibleFeatures).To(BeZero())
+		Expect(header.L1Size).To(Equal(uint32(1)))
+		Expect(data).To(Equal(raw))
+
+		// Header, 4 data clusters, L2 table, L1 table, refcount table and refcount block
+		Expect(len(image)).To(Equal(9 * utils.QCOW2ClusterSize))
+	})
+
+	It("writes zlib compressed clusters", func() {
+		image := convert(constants.ZlibCompression)
+		header, data, _ := readQcow2(image)
+
+		Expect(header.CompressionType).To(Equal(uint8(utils.QCOW2ZlibCompressionType)))
+		Expect(header.IncompatibleFeatures).To(BeZero())
+		Expect(data).To(Equal(raw))
+		Expect(len(image)).To(BeNumerically("<", 9*utils.QCOW2ClusterSize))
+	})
+
+	It("writes zstd compressed clusters", func() {
+		image := convert(constants.ZstdCompression)
+		header, data, refcounts := readQcow2(image)
+
+		Expect(header.CompressionType).To(Equal(uint8(utils.QCOW2ZstdCompressionType)))
+		Expect(header.IncompatibleFeatures).To(Equal(utils.QCOW2CompressionFeature))
+		Expect(data).To(Equal(raw))
+		// Several compressed clusters share the first data cluster
+		Expect(refcounts[1]).To(BeNumerically(">", 1))
+	})
+
+	It("writes an empty image for an empty disk", func() {
+		Expect(fs.WriteFile("/disk.raw", []byte{}, constants.FilePerm)).To(Succeed())
+		header, data, _ := readQcow2(convert(constants.NoCompression))
+		Expect(header.Size).To(BeZero())
+		Expect(data).To(BeEmpty())
+	})
+
+	It("fails on unknown compression types", func() {
+		rawFile, err := fs.Open("/disk.raw")
+		Expect(err).ToNot(HaveOccurred())
+		defer rawFile.Close()
+		qcow2File, err := fs.Create("/disk.qcow2")
+		Expect(err).ToNot(HaveOccurred())
+		defer qcow2File.Close()
+		Expect(utils.RawDiskToQcow2(rawFile.(*os.File), qcow2File, "lz4")).NotTo(Succeed())
+	})
+})
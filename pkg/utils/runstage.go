@@ -17,13 +17,18 @@ limitations under the License.
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/url"
+	"path/filepath"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/rancher/yip/pkg/schema"
 	"gopkg.in/yaml.v3"
 
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 )
 
@@ -65,6 +70,13 @@ func RunStage(cfg *types.Config, stage string, strict bool, cloudInitPaths ...st
 	stageBefore := fmt.Sprintf("%s.before", stage)
 	stageAfter := fmt.Sprintf("%s.after", stage)
 
+	// Remote configs are fetched and verified once, so all stages run the same contents.
+	// Configs set by the cos.setup kernel parameter are left to yip.
+	cloudInitPaths, err := fetchRemoteURIs(cfg, cloudInitPaths...)
+	if err != nil {
+		allErrors = multierror.Append(allErrors, err)
+	}
+
 	// Check if the cmdline has the cos.setup key and extract its value to run yip on that given uri
 	cmdLineOut, err := cfg.Fs.ReadFile("/proc/cmdline")
 	if err != nil {
@@ -116,6 +128,166 @@ func RunStage(cfg *types.Config, stage string, strict bool, cloudInitPaths ...st
 	return allErrors
 }
 
+// fetchRemoteURIs replaces the HTTP(S) URIs of the given slice by verified local copies. Copies are cached
+// and fetched once per boot, so later stages of the same boot and boots failing to fetch the URI use the cached
+// copy, always verified again against the current checksum or signature. URIs failing to be fetched or verified
+// with no valid cached copy are removed from the returned slice.
+func fetchRemoteURIs(cfg *types.Config, uris ...string) ([]string, error) {
+	var errs error
+
+	bootID, _ := cfg.Fs.ReadFile(constants.BootIDFile)
+	boot := strings.TrimSpace(string(bootID))
+
+	paths := []string{}
+	for _, uri := range uris {
+		if remote, _ := IsHTTPURI(uri); !remote {
+			paths = append(paths, uri)
+			continue
+		}
+
+		cached := remoteCachePath(uri)
+		if boot != "" && fetchedOnBoot(cfg, cached, boot) {
+			if err := verifyRemoteCopy(cfg, uri, cached, cached+".sig"); err == nil {
+				cfg.Logger.Debugf("Cloud-init config %s already fetched on this boot", redactURI(uri))
+				paths = append(paths, cached)
+				continue
+			}
+		}
+
+		err := fetchRemoteURI(cfg, uri, cached)
+		if err != nil {
+			if ok, _ := Exists(cfg.Fs, cached); !ok {
+				errs = multierror.Append(errs, fmt.Errorf("failed fetching cloud-init config %s: %w", redactURI(uri), err))
+				continue
+			}
+			if vErr := verifyRemoteCopy(cfg, uri, cached, cached+".sig"); vErr != nil {
+				errs = multierror.Append(errs, fmt.Errorf(
+					"failed fetching cloud-init config %s: %w, the cached copy can't be verified either: %s",
+					redactURI(uri), err, vErr.Error(),
+				))
+				continue
+			}
+			cfg.Logger.Warnf("Failed fetching cloud-init config %s, using the cached copy: %s", redactURI(uri), err.Error())
+		} else if boot != "" {
+			_ = cfg.Fs.WriteFile(cached+".boot", []byte(boot), constants.FilePerm)
+		}
+		paths = append(paths, cached)
+	}
+	return paths, errs
+}
+
+// fetchedOnBoot checks if the given cached copy was fetched on the boot of the given ID
+func fetchedOnBoot(cfg *types.Config, cached, boot string) bool {
+	fetched, err := cfg.Fs.ReadFile(cached + ".boot")
+	return err == nil && strings.TrimSpace(string(fetched)) == boot
+}
+
+// fetchRemoteURI downloads the given URI and verifies it, see verifyRemoteCopy. The cached copy, and its
+// signature if any, are only replaced once verified.
+func fetchRemoteURI(cfg *types.Config, uri, cached string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	_, hasSum := strings.CutPrefix(u.Fragment, "sha256=")
+	u.Fragment = ""
+	if !hasSum && !cfg.Cosign {
+		return fmt.Errorf("no checksum or signature to verify it, set a '#sha256=' fragment or enable cosign")
+	}
+
+	tmpDir, err := TempDir(cfg.Fs, "", "elemental-cloud-init")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cfg.Fs.RemoveAll(tmpDir) }()
+	rawTmpDir, err := cfg.Fs.RawPath(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	download := filepath.Join(tmpDir, "config")
+	signature := filepath.Join(tmpDir, "config.sig")
+	if err = cfg.Client.GetURL(cfg.Logger, u.String(), filepath.Join(rawTmpDir, "config")); err != nil {
+		return err
+	}
+	if !hasSum {
+		if err = cfg.Client.GetURL(cfg.Logger, u.String()+".sig", filepath.Join(rawTmpDir, "config.sig")); err != nil {
+			return fmt.Errorf("failed fetching signature: %w", err)
+		}
+	}
+
+	if err = verifyRemoteCopy(cfg, uri, download, signature); err != nil {
+		return err
+	}
+
+	if err = MkdirAll(cfg.Fs, filepath.Dir(cached), constants.DirPerm); err != nil {
+		return err
+	}
+	if !hasSum {
+		if err = CopyFile(cfg.Fs, signature, cached+".sig"); err != nil {
+			return err
+		}
+	}
+	return CopyFile(cfg.Fs, download, cached)
+}
+
+// verifyRemoteCopy verifies the given local copy of the given URI against the sha256 checksum of the URI
+// fragment (e.g. 'https://host/config.yaml#sha256=<sum>') or, if cosign is enabled, against the given
+// detached signature, published at the same URL with the '.sig' suffix.
+func verifyRemoteCopy(cfg *types.Config, uri, file, signature string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	sum, hasSum := strings.CutPrefix(u.Fragment, "sha256=")
+	switch {
+	case hasSum:
+		current, err := CalcFileChecksum(cfg.Fs, file)
+		if err != nil {
+			return err
+		}
+		if current != strings.ToLower(sum) {
+			return fmt.Errorf("checksum mismatch: expected %s, got %s", sum, current)
+		}
+	case cfg.Cosign:
+		rawFile, err := cfg.Fs.RawPath(file)
+		if err != nil {
+			return err
+		}
+		rawSignature, err := cfg.Fs.RawPath(signature)
+		if err != nil {
+			return err
+		}
+		if out, err := CosignVerifyBlob(cfg.Runner, rawFile, rawSignature, cfg.CosignPubKey); err != nil {
+			return fmt.Errorf("signature verification failed: %s", strings.TrimSpace(out))
+		}
+	default:
+		return fmt.Errorf("no checksum or signature to verify it, set a '#sha256=' fragment or enable cosign")
+	}
+	return nil
+}
+
+// remoteCachePath returns the path of the cached copy of the given URI. Cached copies have no yaml
+// extension, so they are not loaded as part of the cloud-init directory holding the cache.
+func remoteCachePath(uri string) string {
+	u, err := url.Parse(uri)
+	if err == nil {
+		u.Fragment = ""
+		uri = u.String()
+	}
+	sum := sha256.Sum256([]byte(uri))
+	return filepath.Join(constants.CloudInitCacheDir, hex.EncodeToString(sum[:])+".cloud-config")
+}
+
+// redactURI returns the given URI without credentials, so it can be logged
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return u.Redacted()
+}
+
 // filterNonExistingLocalURIs attempts to remove non existing local paths from the given URI slice.
 // Returns the filtered slice.
 func filterNonExistingLocalURIs(cfg *types.Config, uris ...string) []string {
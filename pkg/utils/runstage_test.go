@@ -18,8 +18,14 @@ package utils_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -30,6 +36,7 @@ import (
 	"github.com/rancher/elemental-toolkit/v2/pkg/cloudinit"
 	conf "github.com/rancher/elemental-toolkit/v2/pkg/config"
 	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	elementalhttp "github.com/rancher/elemental-toolkit/v2/pkg/http"
 	"github.com/rancher/elemental-toolkit/v2/pkg/mocks"
 	"github.com/rancher/elemental-toolkit/v2/pkg/types"
 	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
@@ -167,8 +174,135 @@ var _ = Describe("run stage", Label("RunStage"), func() {
 
 		Expect(ci.GetStageArgs("stage")).To(ContainElement("/existing"))
 		Expect(ci.GetStageArgs("stage")).To(ContainElement("/symlinkToExistingDir"))
-		Expect(ci.GetStageArgs("stage")).To(ContainElement("https://my.domain.org/cloud-file"))
 		Expect(ci.GetStageArgs("stage")).NotTo(ContainElement("/nonexisting"))
 		Expect(ci.GetStageArgs("stage")).NotTo(ContainElement("/wrongpath"))
+		// Remote configs with no checksum or signature are not run
+		Expect(ci.GetStageArgs("stage")).NotTo(ContainElement("https://my.domain.org/cloud-file"))
+	})
+
+	Describe("remote cloud-init paths", Label("remote"), func() {
+		var server *httptest.Server
+		var ci *mocks.FakeCloudInitRunner
+		var files map[string]string
+		var sum string
+		var requests int
+
+		cachedPath := func(url string) string {
+			hash := sha256.Sum256([]byte(url))
+			return filepath.Join(constants.CloudInitCacheDir, hex.EncodeToString(hash[:])+".cloud-config")
+		}
+
+		BeforeEach(func() {
+			files = map[string]string{"/config.yaml": testingStages, "/config.yaml.sig": "signature"}
+			hash := sha256.Sum256([]byte(testingStages))
+			sum = hex.EncodeToString(hash[:])
+
+			requests = 0
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				content, ok := files[r.URL.Path]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_, _ = w.Write([]byte(content))
+			}))
+			config.Client = elementalhttp.NewClient()
+			ci = &mocks.FakeCloudInitRunner{}
+			config.CloudInitRunner = ci
+			Expect(writeCmdline("", fs)).To(Succeed())
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("runs a verified and cached copy of configs matching their checksum", func() {
+			url := server.URL + "/config.yaml"
+			Expect(utils.RunStage(config, "luke", true, url+"#sha256="+sum)).To(Succeed())
+
+			Expect(ci.GetStageArgs("luke")).To(ContainElement(cachedPath(url)))
+			Expect(ci.GetStageArgs("luke")).NotTo(ContainElement(url + "#sha256=" + sum))
+			data, err := fs.ReadFile(cachedPath(url))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal(testingStages))
+		})
+
+		It("uses the cached copy if the config can't be fetched", func() {
+			url := server.URL + "/config.yaml"
+			Expect(utils.RunStage(config, "luke", true, url+"#sha256="+sum)).To(Succeed())
+			server.Close()
+
+			ci.ExecStages = nil
+			Expect(utils.RunStage(config, "luke", true, url+"#sha256="+sum)).To(Succeed())
+			Expect(ci.ExecStages).To(ContainElement("luke"))
+			Expect(memLog.String()).To(ContainSubstring("using the cached copy"))
+		})
+
+		It("does not use a cached copy not matching the current checksum", func() {
+			url := server.URL + "/config.yaml"
+			Expect(utils.RunStage(config, "luke", true, url+"#sha256="+sum)).To(Succeed())
+			server.Close()
+
+			ci = &mocks.FakeCloudInitRunner{}
+			config.CloudInitRunner = ci
+			err := utils.RunStage(config, "luke", true, url+"#sha256="+strings.Repeat("0", 64))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("the cached copy can't be verified"))
+			Expect(ci.GetStageArgs("luke")).NotTo(ContainElement(cachedPath(url)))
+		})
+
+		It("fetches configs once per boot", func() {
+			url := server.URL + "/config.yaml#sha256=" + sum
+			Expect(utils.MkdirAll(fs, filepath.Dir(constants.BootIDFile), constants.DirPerm)).To(Succeed())
+			Expect(fs.WriteFile(constants.BootIDFile, []byte("boot-1\n"), constants.FilePerm)).To(Succeed())
+
+			Expect(utils.RunStage(config, "luke", true, url)).To(Succeed())
+			Expect(utils.RunStage(config, "leia", true, url)).To(Succeed())
+			Expect(requests).To(Equal(1))
+			Expect(ci.GetStageArgs("leia")).To(ContainElement(cachedPath(server.URL + "/config.yaml")))
+
+			// The cached copy is verified even if already fetched on this boot
+			Expect(fs.WriteFile(cachedPath(server.URL+"/config.yaml"), []byte("tampered"), constants.FilePerm)).To(Succeed())
+			Expect(utils.RunStage(config, "luke", true, url)).To(Succeed())
+			Expect(requests).To(Equal(2))
+
+			Expect(fs.WriteFile(constants.BootIDFile, []byte("boot-2\n"), constants.FilePerm)).To(Succeed())
+			Expect(utils.RunStage(config, "luke", true, url)).To(Succeed())
+			Expect(requests).To(Equal(3))
+		})
+
+		It("does not run configs not matching their checksum", func() {
+			url := server.URL + "/config.yaml#sha256=" + strings.Repeat("0", 64)
+			err := utils.RunStage(config, "luke", true, url)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("checksum mismatch"))
+			Expect(ci.GetStageArgs("luke")).NotTo(ContainElement(url))
+			Expect(ci.GetStageArgs("luke")).NotTo(ContainElement(cachedPath(server.URL + "/config.yaml")))
+		})
+
+		It("does not run configs with no checksum if cosign is disabled", func() {
+			err := utils.RunStage(config, "luke", true, server.URL+"/config.yaml")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("no checksum or signature"))
+		})
+
+		It("verifies the detached signature with cosign", func() {
+			url := server.URL + "/config.yaml"
+			config.Cosign = true
+			config.CosignPubKey = "/etc/cosign.pub"
+			Expect(utils.RunStage(config, "luke", true, url)).To(Succeed())
+
+			Expect(runner.GetCmds()).To(HaveLen(1))
+			Expect(runner.GetCmds()[0][:5]).To(Equal([]string{"cosign", "verify-blob", "--key", "/etc/cosign.pub", "--signature"}))
+			Expect(ci.GetStageArgs("luke")).To(ContainElement(cachedPath(url)))
+
+			// The cached copy is verified against its cached signature
+			server.Close()
+			runner.ReturnError = fmt.Errorf("invalid signature")
+			err := utils.RunStage(config, "luke", true, url)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("the cached copy can't be verified"))
+			Expect(runner.GetCmds()[1][5]).To(HaveSuffix(".cloud-config.sig"))
+		})
 	})
 })
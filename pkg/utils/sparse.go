@@ -0,0 +1,216 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// This file contains utils to work with sparse files
+
+const (
+	// lseek whence values to find the data and the holes of a file, as defined in Linux
+	seekData = 3
+	seekHole = 4
+
+	// sparseBlockSize is the granularity used to detect zero runs within the data of a file
+	sparseBlockSize = 64 * 1024
+)
+
+// DataExtent is a range of a file including data
+type DataExtent struct {
+	Offset int64
+	Length int64
+}
+
+// dataRegions returns the regions of the given file reported to include data by the filesystem.
+// If the filesystem does not support finding holes the whole file is returned as a single region.
+func dataRegions(f *os.File, size int64) ([]DataExtent, error) {
+	var regions []DataExtent
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// No more data after offset
+			break
+		} else if errors.Is(err, syscall.EINVAL) && offset == 0 {
+			return []DataExtent{{Offset: 0, Length: size}}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, size)
+		regions = append(regions, DataExtent{Offset: start, Length: end - start})
+		offset = end
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return regions, err
+}
+
+// walkDataBlocks calls the given function for each block of the given file including data. Holes
+// and blocks only including zeros are skipped. The block data is only valid during the call.
+func walkDataBlocks(f *os.File, fn func(offset int64, data []byte) error) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	regions, err := dataRegions(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, sparseBlockSize)
+	for _, region := range regions {
+		for offset := region.Offset; offset < region.Offset+region.Length; offset += sparseBlockSize {
+			data := buffer[:min(sparseBlockSize, region.Offset+region.Length-offset)]
+			if _, err = f.ReadAt(data, offset); err != nil {
+				return err
+			}
+			if isZeroCluster(data) {
+				continue
+			}
+			if err = fn(offset, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DataExtents returns the ranges of the given file including data. Holes and blocks only including
+// zeros are not part of any extent.
+func DataExtents(f *os.File) ([]DataExtent, error) {
+	var extents []DataExtent
+	err := walkDataBlocks(f, func(offset int64, data []byte) error {
+		last := len(extents) - 1
+		if last >= 0 && extents[last].Offset+extents[last].Length == offset {
+			extents[last].Length += int64(len(data))
+		} else {
+			extents = append(extents, DataExtent{Offset: offset, Length: int64(len(data))})
+		}
+		return nil
+	})
+	return extents, err
+}
+
+// SparseCopy copies the data of the source file into the target file at the given offset. Holes
+// and blocks only including zeros are not written, so they are kept as holes in the target file
+// as long as the target range was not written before. Returns the size of the source file.
+func SparseCopy(target *os.File, offset int64, source *os.File) (int64, error) {
+	info, err := source.Stat()
+	if err != nil {
+		return 0, err
+	}
+	err = walkDataBlocks(source, func(o int64, data []byte) error {
+		_, err := target.WriteAt(data, offset+o)
+		return err
+	})
+	return info.Size(), err
+}
+
+// SparseTar writes a tar archive including the given file as a GNU sparse file with the given name and
+// size, in the old GNU format. Only the data extents of the file are stored in the archive, any size
+// beyond the file size is stored as a hole.
+func SparseTar(w io.Writer, f *os.File, name string, size int64, mode int64) error {
+	extents, err := DataExtents(f)
+	if err != nil {
+		return err
+	}
+	// A trailing empty extent sets the size of files ending with a hole
+	if len(extents) == 0 || extents[len(extents)-1].Offset+extents[len(extents)-1].Length < size {
+		extents = append(extents, DataExtent{Offset: size})
+	}
+	var stored int64
+	for _, e := range extents {
+		stored += e.Length
+	}
+
+	header := make([]byte, 512)
+	copy(header[0:100], name)
+	tarNumeric(header[100:108], mode)
+	tarNumeric(header[108:116], 0)
+	tarNumeric(header[116:124], 0)
+	tarNumeric(header[124:136], stored)
+	tarNumeric(header[136:148], time.Now().Unix())
+	header[156] = 'S'
+	copy(header[257:265], "ustar  \x00")
+	tarNumeric(header[483:495], size)
+
+	// The header holds the first 4 sparse entries, the rest are stored in extension blocks of 21 entries
+	blocks := [][]byte{header}
+	entries, isExtended := header[386:482], 482
+	for i, e := range extents {
+		if i >= 4 && (i-4)%21 == 0 {
+			blocks[len(blocks)-1][isExtended] = 1
+			blocks = append(blocks, make([]byte, 512))
+			entries, isExtended = blocks[len(blocks)-1][0:504], 504
+		}
+		slot := i
+		if i >= 4 {
+			slot = (i - 4) % 21
+		}
+		tarNumeric(entries[slot*24:slot*24+12], e.Offset)
+		tarNumeric(entries[slot*24+12:slot*24+24], e.Length)
+	}
+
+	// Checksum is computed with the checksum field filled with spaces
+	copy(header[148:156], "        ")
+	var checksum int64
+	for _, b := range header {
+		checksum += int64(b)
+	}
+	copy(header[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+
+	for _, block := range blocks {
+		if _, err = w.Write(block); err != nil {
+			return err
+		}
+	}
+	for _, e := range extents {
+		if _, err = io.Copy(w, io.NewSectionReader(f, e.Offset, e.Length)); err != nil {
+			return err
+		}
+	}
+	// Data is padded to a full block and the archive ends with two zero blocks
+	padding := (512 - stored%512) % 512
+	_, err = w.Write(make([]byte, padding+1024))
+	return err
+}
+
+// tarNumeric writes the given number into the given tar header field, in octal if it fits
+// in the field or in base-256 otherwise
+func tarNumeric(field []byte, n int64) {
+	octal := strconv.FormatInt(n, 8)
+	if len(octal) < len(field) {
+		copy(field, fmt.Sprintf("%0*s", len(field)-1, octal))
+		field[len(field)-1] = 0
+		return
+	}
+	for i := len(field) - 1; i > 0; i-- {
+		field[i] = byte(n)
+		n >>= 8
+	}
+	field[0] = 0x80
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// allocatedSize returns the bytes allocated on disk by the given file
+func allocatedSize(fs vfs.FS, path string) int64 {
+	info, err := fs.Stat(path)
+	Expect(err).ToNot(HaveOccurred())
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+var _ = Describe("Sparse utils", Label("sparse"), func() {
+	var fs vfs.FS
+	var cleanup func()
+	const MiB = 1024 * 1024
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(map[string]interface{}{"/dir": &vfst.Dir{Perm: 0755}})
+		Expect(err).ToNot(HaveOccurred())
+
+		// 64 MiB file with data at 1 MiB and at the end, an explicit zero run and holes elsewhere
+		f, err := fs.Create("/dir/part.img")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.WriteAt(bytes.Repeat([]byte("data"), 1024), MiB)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.WriteAt(make([]byte, 8*MiB), 10*MiB)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.WriteAt([]byte("end"), 64*MiB-3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("finds the data extents of a file", func() {
+		f, err := fs.Open("/dir/part.img")
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		extents, err := utils.DataExtents(f.(*os.File))
+		Expect(err).ToNot(HaveOccurred())
+		// Extents are aligned to the filesystem blocks, the zero run is not part of any extent
+		Expect(extents).To(HaveLen(2))
+		Expect(extents[0].Offset).To(Equal(int64(MiB)))
+		Expect(extents[0].Length).To(BeNumerically(">=", 4096))
+		Expect(extents[1].Offset + extents[1].Length).To(Equal(int64(64 * MiB)))
+		Expect(extents[1].Length).To(BeNumerically("<=", 64*1024))
+	})
+
+	It("concatenates files preserving holes and zero runs", func() {
+		Expect(fs.WriteFile("/dir/small.img", []byte("small"), constants.FilePerm)).To(Succeed())
+		Expect(utils.ConcatFiles(fs, []string{"/dir/small.img", "/dir/part.img", "/dir/part.img"}, "/dir/disk.raw")).To(Succeed())
+
+		disk, err := fs.ReadFile("/dir/disk.raw")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disk).To(HaveLen(5 + 2*64*MiB))
+		part, err := fs.ReadFile("/dir/part.img")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disk[:5]).To(Equal([]byte("small")))
+		Expect(disk[5 : 5+64*MiB]).To(Equal(part))
+		Expect(disk[5+64*MiB:]).To(Equal(part))
+
+		// Only a few blocks including data are allocated
+		Expect(allocatedSize(fs, "/dir/disk.raw")).To(BeNumerically("<", 2*MiB))
+	})
+
+	It("writes a sparse tar readable by tar", func() {
+		f, err := fs.Open("/dir/part.img")
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		var archive bytes.Buffer
+		Expect(utils.SparseTar(&archive, f.(*os.File), "disk.raw", 128*MiB, 0644)).To(Succeed())
+		// Only the data extents are stored
+		Expect(archive.Len()).To(BeNumerically("<", MiB))
+
+		reader := tar.NewReader(&archive)
+		header, err := reader.Next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.Name).To(Equal("disk.raw"))
+		Expect(header.Size).To(Equal(int64(128 * MiB)))
+		data, err := io.ReadAll(reader)
+		Expect(err).ToNot(HaveOccurred())
+		part, err := fs.ReadFile("/dir/part.img")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data[:64*MiB]).To(Equal(part))
+		Expect(data[64*MiB:]).To(Equal(make([]byte, 64*MiB)))
+
+		_, err = reader.Next()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("writes sparse tar sizes beyond the octal limits and many extents", func() {
+		f, err := fs.Create("/dir/fragmented.img")
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		for i := int64(0); i < 30; i++ {
+			_, err = f.WriteAt([]byte{byte(i + 1)}, i*MiB)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		var archive bytes.Buffer
+		Expect(utils.SparseTar(&archive, f, "disk.raw", 10*1024*MiB, 0644)).To(Succeed())
+		reader := tar.NewReader(&archive)
+		header, err := reader.Next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.Size).To(Equal(int64(10 * 1024 * MiB)))
+
+		// Read the first extents only, the rest of the file is a hole
+		data := make([]byte, 30*MiB)
+		_, err = io.ReadFull(reader, data)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 30; i++ {
+			Expect(data[i*MiB]).To(Equal(byte(i + 1)))
+		}
+	})
+})
@@ -1186,6 +1186,59 @@ var _ = Describe("Utils", Label("utils"), func() {
 			Expect(hex.EncodeToString(header.DataOffset[:])).To(Equal("ffffffffffffffff"))
 			Expect(hex.EncodeToString(header.CreatorApplication[:])).To(Equal("656c656d"))
 		})
+		It("creates a dynamic vhd", func() {
+			tmpDir, _ := utils.TempDir(fs, "", "")
+			raw := make([]byte, 5*utils.VHDBlockSize+1024)
+			copy(raw[100:], "first block")
+			copy(raw[len(raw)-10:], "last block")
+			Expect(fs.WriteFile(filepath.Join(tmpDir, "disk.raw"), raw, constants.FilePerm)).To(Succeed())
+
+			rawFile, _ := fs.OpenFile(filepath.Join(tmpDir, "disk.raw"), os.O_RDONLY, constants.FilePerm)
+			vhdFile, _ := fs.Create(filepath.Join(tmpDir, "disk.vhd"))
+			Expect(utils.RawDiskToDynamicVhd(rawFile, vhdFile)).To(Succeed())
+			_ = rawFile.Close()
+			_ = vhdFile.Close()
+
+			vhd, err := fs.ReadFile(filepath.Join(tmpDir, "disk.vhd"))
+			Expect(err).ToNot(HaveOccurred())
+			// Footer copy, dynamic header, BAT, two blocks with their bitmaps and footer
+			Expect(len(vhd)).To(Equal(512 + 1024 + 512 + 2*(512+utils.VHDBlockSize) + 512))
+			Expect(vhd[:512]).To(Equal(vhd[len(vhd)-512:]))
+
+			footer := utils.VHDHeader{}
+			Expect(binary.Read(bytes.NewReader(vhd[len(vhd)-512:]), binary.BigEndian, &footer)).To(Succeed())
+			Expect(string(footer.Cookie[:])).To(Equal("conectix"))
+			Expect(hex.EncodeToString(footer.DiskType[:])).To(Equal("00000003"))
+			Expect(hex.EncodeToString(footer.DataOffset[:])).To(Equal("0000000000000200"))
+			Expect(binary.BigEndian.Uint64(footer.CurrentSize[:])).To(Equal(uint64(len(raw))))
+
+			header := utils.VHDDynamicHeader{}
+			Expect(binary.Read(bytes.NewReader(vhd[512:]), binary.BigEndian, &header)).To(Succeed())
+			Expect(string(header.Cookie[:])).To(Equal("cxsparse"))
+			Expect(binary.BigEndian.Uint32(header.MaxTableEntries[:])).To(Equal(uint32(6)))
+			Expect(binary.BigEndian.Uint32(header.BlockSize[:])).To(Equal(uint32(utils.VHDBlockSize)))
+			checksum := 0
+			for i, b := range vhd[512:1536] {
+				if i < 36 || i >= 40 {
+					checksum += int(b)
+				}
+			}
+			Expect(binary.BigEndian.Uint32(header.Checksum[:])).To(Equal(uint32(^checksum)))
+
+			// Read back the disk through the BAT
+			data := make([]byte, len(raw))
+			tableOffset := binary.BigEndian.Uint64(header.TableOffset[:])
+			for i := 0; i < 6; i++ {
+				entry := binary.BigEndian.Uint32(vhd[tableOffset+uint64(i)*4:])
+				if i > 0 && i < 5 {
+					Expect(entry).To(Equal(uint32(utils.VHDUnusedBlock)))
+					continue
+				}
+				Expect(vhd[entry*512 : entry*512+512]).To(Equal(bytes.Repeat([]byte{0xff}, 512)))
+				copy(data[i*utils.VHDBlockSize:], vhd[entry*512+512:entry*512+512+utils.VHDBlockSize])
+			}
+			Expect(data).To(Equal(raw))
+		})
 		Describe("CHS calculation", func() {
 			It("limits the number of sectors", func() {
 				tmpDir, _ := utils.TempDir(fs, "", "")
@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"io"
 	"math"
 	"os"
 	"time"
@@ -49,11 +51,46 @@ type VHDHeader struct {
 	Reserved           [427]byte // This field contains zeroes.
 }
 
+const (
+	vhdFixedDisk   = "00000002"
+	vhdDynamicDisk = "00000003"
+
+	// VHDBlockSize is the size of the data section of the blocks of dynamic VHD disks
+	VHDBlockSize = 2 * 1024 * 1024
+	// VHDUnusedBlock is the BAT entry of the blocks not allocated in dynamic VHD disks
+	VHDUnusedBlock = 0xffffffff
+	vhdSectorSize  = 512
+)
+
+// VHDDynamicHeader is the header of dynamic VHD disks, it is placed right after the copy of the footer
+// at the beginning of the file and points to the Block Allocation Table (BAT)
+type VHDDynamicHeader struct {
+	Cookie               [8]byte   // Holds the "cxsparse" cookie
+	DataOffset           [8]byte   // Unused, set to 0xFFFFFFFF
+	TableOffset          [8]byte   // Absolute byte offset of the Block Allocation Table (BAT) in the file
+	HeaderVersion        [4]byte   // Version of the dynamic disk header, 0x00010000
+	MaxTableEntries      [4]byte   // Maximum number of entries of the BAT, the number of blocks of the disk
+	BlockSize            [4]byte   // Size of the data section of a block, it does not include the sector bitmap
+	Checksum             [4]byte   // One’s complement of the sum of all the bytes in the header without the checksum field
+	ParentUniqueID       [16]byte  // Unique ID of the parent disk, only used by differencing disks
+	ParentTimeStamp      [4]byte   // Modification time stamp of the parent disk, only used by differencing disks
+	Reserved             [4]byte   // This field contains zeroes.
+	ParentUnicodeName    [512]byte // Name of the parent disk, only used by differencing disks
+	ParentLocatorEntries [192]byte // Platform specific locators of the parent disk, only used by differencing disks
+	Reserved2            [256]byte // This field contains zeroes.
+}
+
 func newVHDFixed(size uint64) VHDHeader {
+	return newVHDFooter(size, vhdFixedDisk, "ffffffffffffffff")
+}
+
+// newVHDFooter returns a VHD footer for the given disk size, disk type and dynamic header offset
+func newVHDFooter(size uint64, diskType string, dataOffset string) VHDHeader {
 	header := VHDHeader{}
+	copy(header.Cookie[:], "conectix")
 	hexToField("00000002", header.Features[:])
 	hexToField("00010000", header.FileFormatVersion[:])
-	hexToField("ffffffffffffffff", header.DataOffset[:])
+	hexToField(dataOffset, header.DataOffset[:])
 	t := uint32(time.Now().Unix() - 946684800)
 	binary.BigEndian.PutUint32(header.Timestamp[:], t)
 	hexToField("656c656d", header.CreatorApplication[:]) // Cos
@@ -66,7 +103,7 @@ func newVHDFixed(size uint64) VHDHeader {
 	binary.BigEndian.PutUint16(header.DiskGeometry[:2], uint16(geometry.cylinders))
 	header.DiskGeometry[2] = uint8(geometry.heads)
 	header.DiskGeometry[3] = uint8(geometry.sectorsPerTrack)
-	hexToField("00000002", header.DiskType[:]) // Fixed 0x00000002
+	hexToField(diskType, header.DiskType[:])
 	hexToField("00000000", header.Checksum[:])
 	uuid := uuidPkg.Generate()
 	copy(header.UniqueID[:], uuid.String())
@@ -74,17 +111,37 @@ func newVHDFixed(size uint64) VHDHeader {
 	return header
 }
 
+// newVHDDynamicHeader returns the dynamic disk header for the given number of blocks and BAT offset
+func newVHDDynamicHeader(blocks uint32, tableOffset uint64) VHDDynamicHeader {
+	header := VHDDynamicHeader{}
+	copy(header.Cookie[:], "cxsparse")
+	hexToField("ffffffffffffffff", header.DataOffset[:])
+	binary.BigEndian.PutUint64(header.TableOffset[:], tableOffset)
+	hexToField("00010000", header.HeaderVersion[:])
+	binary.BigEndian.PutUint32(header.MaxTableEntries[:], blocks)
+	binary.BigEndian.PutUint32(header.BlockSize[:], VHDBlockSize)
+	buffer := new(bytes.Buffer)
+	_ = binary.Write(buffer, binary.BigEndian, header)
+	binary.BigEndian.PutUint32(header.Checksum[:], vhdChecksum(buffer.Bytes()))
+	return header
+}
+
 // generateChecksum generates the checksum of the vhd header
 // Lifted from the official VHD Format Spec
 func generateChecksum(header *VHDHeader) {
 	buffer := new(bytes.Buffer)
 	_ = binary.Write(buffer, binary.BigEndian, header)
+	binary.BigEndian.PutUint32(header.Checksum[:], vhdChecksum(buffer.Bytes()[:512]))
+}
+
+// vhdChecksum returns the one's complement of the sum of all the given bytes, the checksum field
+// is expected to be zeroed
+func vhdChecksum(data []byte) uint32 {
 	checksum := 0
-	bb := buffer.Bytes()
-	for counter := 0; counter < 512; counter++ {
-		checksum += int(bb[counter])
+	for _, b := range data {
+		checksum += int(b)
 	}
-	binary.BigEndian.PutUint32(header.Checksum[:], uint32(^checksum))
+	return uint32(^checksum)
 }
 
 // hexToField decodes an hex to bytes and copies it to the given header field
@@ -153,3 +210,56 @@ func RawDiskToFixedVhd(diskFile *os.File) {
 	header := newVHDFixed(size)
 	_ = binary.Write(diskFile, binary.BigEndian, header)
 }
+
+// RawDiskToDynamicVhd writes the contents of the given raw disk file into the given file as a dynamic VHD.
+// Blocks only including zeros are not allocated, so the VHD only takes the space of the actual data.
+// RawDiskToDynamicVhd makes no effort into opening/closing/checking if the files exist
+func RawDiskToDynamicVhd(rawFile *os.File, vhdFile *os.File) error {
+	info, err := rawFile.Stat()
+	if err != nil {
+		return err
+	}
+	size := uint64(info.Size())
+	blocks := (size + VHDBlockSize - 1) / VHDBlockSize
+
+	// Disk layout: footer copy, dynamic header, BAT, blocks and footer. Each block starts with
+	// a sector bitmap flagging which sectors of the block include data.
+	tableOffset := uint64(vhdSectorSize + 1024)
+	tableSize := (blocks*4 + vhdSectorSize - 1) / vhdSectorSize * vhdSectorSize
+	offset := int64(tableOffset + tableSize)
+
+	bitmap := bytes.Repeat([]byte{0xff}, vhdSectorSize)
+	bat := make([]uint32, blocks)
+	data := make([]byte, VHDBlockSize)
+	for i := uint64(0); i < blocks; i++ {
+		n, err := rawFile.ReadAt(data, int64(i*VHDBlockSize))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		clear(data[n:])
+		if isZeroCluster(data) {
+			bat[i] = VHDUnusedBlock
+			continue
+		}
+		bat[i] = uint32(offset / vhdSectorSize)
+		if _, err = vhdFile.WriteAt(bitmap, offset); err != nil {
+			return err
+		}
+		if _, err = vhdFile.WriteAt(data, offset+vhdSectorSize); err != nil {
+			return err
+		}
+		offset += vhdSectorSize + VHDBlockSize
+	}
+
+	footer := newVHDFooter(size, vhdDynamicDisk, fmt.Sprintf("%016x", vhdSectorSize))
+	buffer := new(bytes.Buffer)
+	_ = binary.Write(buffer, binary.BigEndian, footer)
+	if _, err = vhdFile.WriteAt(buffer.Bytes(), offset); err != nil {
+		return err
+	}
+	_ = binary.Write(buffer, binary.BigEndian, newVHDDynamicHeader(uint32(blocks), tableOffset))
+	_ = binary.Write(buffer, binary.BigEndian, bat)
+	buffer.Write(make([]byte, tableOffset+tableSize-uint64(buffer.Len())))
+	_, err = vhdFile.WriteAt(buffer.Bytes(), 0)
+	return err
+}
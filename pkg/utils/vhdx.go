@@ -0,0 +1,276 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	uuidPkg "github.com/distribution/distribution/uuid"
+)
+
+// This file contains utils to work with dynamic VHDX disks
+
+const (
+	VHDXMB             = 1024 * 1024
+	VHDXBlockSize      = 2 * VHDXMB
+	VHDXSectorSize     = 512
+	VHDXHeaderOffset1  = 64 * 1024
+	VHDXHeaderOffset2  = 128 * 1024
+	VHDXRegionOffset1  = 192 * 1024
+	VHDXRegionOffset2  = 256 * 1024
+	VHDXLogOffset      = 1 * VHDXMB
+	VHDXLogLength      = 1 * VHDXMB
+	VHDXMetadataOffset = 2 * VHDXMB
+	VHDXMetadataLength = 1 * VHDXMB
+	VHDXBATOffset      = 3 * VHDXMB
+
+	// VHDXChunkRatio is the number of payload blocks described by a sector bitmap block
+	VHDXChunkRatio = (1 << 23) * VHDXSectorSize / VHDXBlockSize
+
+	// VHDX BAT entry states
+	VHDXPayloadBlockNotPresent   = 0
+	VHDXPayloadBlockFullyPresent = 6
+
+	// VHDX region and metadata item GUIDs
+	VHDXBATRegionGUID         = "2DC27766-F623-4200-9D64-115E9BFD4A08"
+	VHDXMetadataRegionGUID    = "8B7CA206-4790-4B9A-B8FE-575F050F886E"
+	VHDXFileParametersGUID    = "CAA16737-FA36-4D43-B3B6-33F0AA44E76B"
+	VHDXVirtualDiskSizeGUID   = "2FA54224-CD1B-4876-B211-5DBED83BF4B8"
+	VHDXVirtualDiskIDGUID     = "BECA12AB-B2E6-4523-93EF-C309E000C746"
+	VHDXLogicalSectorGUID     = "8141BF1D-A96F-4709-BA47-F233A8FAAB5F"
+	VHDXPhysicalSectorGUID    = "CDA348C7-445D-4471-9CC9-E9885251C556"
+	vhdxMetadataItemsOffset   = 64 * 1024
+	vhdxMetadataIsVirtualDisk = 1 << 1
+	vhdxMetadataIsRequired    = 1 << 2
+)
+
+// VHDXFileIdentifier is the structure at the beginning of VHDX files, it is stored in little endian
+type VHDXFileIdentifier struct {
+	Signature [8]byte   // Holds the "vhdxfile" signature
+	Creator   [512]byte // UTF-16 name of the application which created the file
+}
+
+// VHDXHeader is the VHDX header, two copies are stored and the one with the highest sequence number is used
+type VHDXHeader struct {
+	Signature      [4]byte    // Holds the "head" signature
+	Checksum       uint32     // CRC-32C of the 4 KB header with the checksum field zeroed
+	SequenceNumber uint64     // The header with the highest sequence number is the current header
+	FileWriteGUID  [16]byte   // Changed the first time the file is written after opening it
+	DataWriteGUID  [16]byte   // Changed the first time the disk contents are written after opening it
+	LogGUID        [16]byte   // Identifies the valid log entries, zero when the log is empty
+	LogVersion     uint16     // Version of the log format, 0
+	Version        uint16     // Version of the VHDX format, 1
+	LogLength      uint32     // Size of the log, multiple of 1 MB
+	LogOffset      uint64     // Byte offset of the log, multiple of 1 MB
+	Reserved       [4016]byte // This field contains zeroes.
+}
+
+// VHDXRegionTableHeader is the header of the VHDX region table, two copies are stored
+type VHDXRegionTableHeader struct {
+	Signature  [4]byte // Holds the "regi" signature
+	Checksum   uint32  // CRC-32C of the 64 KB region table with the checksum field zeroed
+	EntryCount uint32  // Number of valid entries of the region table
+	Reserved   uint32
+}
+
+// VHDXRegionTableEntry locates a region of the VHDX file
+type VHDXRegionTableEntry struct {
+	GUID       [16]byte // Identifies the region
+	FileOffset uint64   // Byte offset of the region, multiple of 1 MB
+	Length     uint32   // Length of the region, multiple of 1 MB
+	Required   uint32   // Set to 1 if the region must be recognized to load the file
+}
+
+// VHDXMetadataTableHeader is the header of the table of the metadata region
+type VHDXMetadataTableHeader struct {
+	Signature  [8]byte // Holds the "metadata" signature
+	Reserved   uint16
+	EntryCount uint16 // Number of valid entries of the metadata table
+	Reserved2  [20]byte
+}
+
+// VHDXMetadataTableEntry locates a metadata item within the metadata region
+type VHDXMetadataTableEntry struct {
+	ItemID   [16]byte // Identifies the metadata item
+	Offset   uint32   // Byte offset of the item within the metadata region
+	Length   uint32   // Length of the item
+	Flags    uint32   // IsUser, IsVirtualDisk and IsRequired bits
+	Reserved uint32
+}
+
+// VHDXGUID returns the given GUID string in the mixed endian byte order used in VHDX files
+func VHDXGUID(guid string) [16]byte {
+	var out [16]byte
+	b, _ := hex.DecodeString(strings.ReplaceAll(guid, "-", ""))
+	binary.LittleEndian.PutUint32(out[0:], binary.BigEndian.Uint32(b[0:]))
+	binary.LittleEndian.PutUint16(out[4:], binary.BigEndian.Uint16(b[4:]))
+	binary.LittleEndian.PutUint16(out[6:], binary.BigEndian.Uint16(b[6:]))
+	copy(out[8:], b[8:])
+	return out
+}
+
+// vhdxChecksum sets the CRC-32C checksum of the given serialized structure, stored at bytes 4 to 8
+func vhdxChecksum(data []byte) {
+	binary.LittleEndian.PutUint32(data[4:], 0)
+	binary.LittleEndian.PutUint32(data[4:], crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+}
+
+// vhdxBuffer serializes the given little endian structures into a buffer of the given size
+func vhdxBuffer(size int, data ...interface{}) []byte {
+	buffer := new(bytes.Buffer)
+	for _, d := range data {
+		_ = binary.Write(buffer, binary.LittleEndian, d)
+	}
+	out := make([]byte, size)
+	copy(out, buffer.Bytes())
+	return out
+}
+
+// vhdxMetadata returns the metadata region of a dynamic VHDX disk of the given virtual size
+func vhdxMetadata(size uint64) []byte {
+	type item struct {
+		guid  string
+		flags uint32
+		data  interface{}
+	}
+	diskID := uuidPkg.Generate()
+	items := []item{
+		// Block size and flags, blocks are not left allocated and the disk has no parent
+		{VHDXFileParametersGUID, vhdxMetadataIsRequired, []uint32{VHDXBlockSize, 0}},
+		{VHDXVirtualDiskSizeGUID, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired, size},
+		{VHDXVirtualDiskIDGUID, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired, diskID},
+		{VHDXLogicalSectorGUID, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired, uint32(VHDXSectorSize)},
+		{VHDXPhysicalSectorGUID, vhdxMetadataIsVirtualDisk | vhdxMetadataIsRequired, uint32(VHDXSectorSize)},
+	}
+
+	header := VHDXMetadataTableHeader{EntryCount: uint16(len(items))}
+	copy(header.Signature[:], "metadata")
+	table := []interface{}{header}
+
+	var values []interface{}
+	offset := uint32(vhdxMetadataItemsOffset)
+	for _, i := range items {
+		length := uint32(binary.Size(i.data))
+		table = append(table, VHDXMetadataTableEntry{ItemID: VHDXGUID(i.guid), Offset: offset, Length: length, Flags: i.flags})
+		values = append(values, i.data)
+		offset += length
+	}
+
+	region := vhdxBuffer(VHDXMetadataLength, table...)
+	copy(region[vhdxMetadataItemsOffset:], vhdxBuffer(int(offset-vhdxMetadataItemsOffset), values...))
+	return region
+}
+
+// RawDiskToVhdx writes the contents of the given raw disk file into the given file as a dynamic VHDX.
+// Blocks only including zeros are not allocated, so the VHDX only takes the space of the actual data.
+// RawDiskToVhdx makes no effort into opening/closing/checking if the files exist
+func RawDiskToVhdx(rawFile *os.File, vhdxFile *os.File) error {
+	info, err := rawFile.Stat()
+	if err != nil {
+		return err
+	}
+	// The virtual size must be a multiple of the logical sector size
+	size := uint64(info.Size()+VHDXSectorSize-1) / VHDXSectorSize * VHDXSectorSize
+	blocks := (size + VHDXBlockSize - 1) / VHDXBlockSize
+
+	// The BAT interleaves a sector bitmap entry after each chunk of payload blocks. Sector
+	// bitmaps are only used by differencing disks, so their entries are left as not present.
+	var entries uint64
+	if blocks > 0 {
+		entries = blocks + (blocks-1)/VHDXChunkRatio
+	}
+	batLength := (entries*8 + VHDXMB - 1) / VHDXMB * VHDXMB
+	batLength = max(batLength, VHDXMB)
+	bat := make([]uint64, entries)
+
+	// Payload blocks are written after the BAT, aligned to 1 MB
+	offset := int64(VHDXBATOffset + batLength)
+	data := make([]byte, VHDXBlockSize)
+	for i := uint64(0); i < blocks; i++ {
+		n, err := rawFile.ReadAt(data, int64(i*VHDXBlockSize))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		clear(data[n:])
+		if isZeroCluster(data) {
+			continue
+		}
+		if _, err = vhdxFile.WriteAt(data, offset); err != nil {
+			return err
+		}
+		bat[i+i/VHDXChunkRatio] = uint64(offset) | VHDXPayloadBlockFullyPresent
+		offset += VHDXBlockSize
+	}
+
+	if _, err = vhdxFile.WriteAt(vhdxBuffer(int(batLength), bat), VHDXBATOffset); err != nil {
+		return err
+	}
+	if _, err = vhdxFile.WriteAt(vhdxMetadata(size), VHDXMetadataOffset); err != nil {
+		return err
+	}
+	// The log is empty, it only has to be allocated
+	if _, err = vhdxFile.WriteAt(make([]byte, VHDXLogLength), VHDXLogOffset); err != nil {
+		return err
+	}
+
+	identifier := VHDXFileIdentifier{}
+	copy(identifier.Signature[:], "vhdxfile")
+	for i, c := range utf16.Encode([]rune("elemental")) {
+		binary.LittleEndian.PutUint16(identifier.Creator[i*2:], c)
+	}
+	if _, err = vhdxFile.WriteAt(vhdxBuffer(VHDXHeaderOffset1, identifier), 0); err != nil {
+		return err
+	}
+
+	header := VHDXHeader{
+		FileWriteGUID: uuidPkg.Generate(),
+		DataWriteGUID: uuidPkg.Generate(),
+		Version:       1,
+		LogLength:     VHDXLogLength,
+		LogOffset:     VHDXLogOffset,
+	}
+	copy(header.Signature[:], "head")
+	for i, headerOffset := range []int64{VHDXHeaderOffset1, VHDXHeaderOffset2} {
+		header.SequenceNumber = uint64(i)
+		buffer := vhdxBuffer(4*1024, header)
+		vhdxChecksum(buffer)
+		if _, err = vhdxFile.WriteAt(buffer, headerOffset); err != nil {
+			return err
+		}
+	}
+
+	regions := VHDXRegionTableHeader{EntryCount: 2}
+	copy(regions.Signature[:], "regi")
+	regionTable := vhdxBuffer(64*1024, regions,
+		VHDXRegionTableEntry{GUID: VHDXGUID(VHDXBATRegionGUID), FileOffset: VHDXBATOffset, Length: uint32(batLength), Required: 1},
+		VHDXRegionTableEntry{GUID: VHDXGUID(VHDXMetadataRegionGUID), FileOffset: VHDXMetadataOffset, Length: VHDXMetadataLength, Required: 1},
+	)
+	vhdxChecksum(regionTable)
+	for _, regionOffset := range []int64{VHDXRegionOffset1, VHDXRegionOffset2} {
+		if _, err = vhdxFile.WriteAt(regionTable, regionOffset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// checkVhdxChecksum verifies the CRC-32C checksum stored at bytes 4 to 8 of the given structure
+func checkVhdxChecksum(data []byte) {
+	checked := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(checked[4:], 0)
+	Expect(binary.LittleEndian.Uint32(data[4:])).To(Equal(crc32.Checksum(checked, crc32.MakeTable(crc32.Castagnoli))))
+}
+
+// readVhdx parses the given VHDX disk and returns its guest data read through the BAT
+func readVhdx(image []byte) []byte {
+	Expect(string(image[:8])).To(Equal("vhdxfile"))
+
+	for _, offset := range []int{utils.VHDXHeaderOffset1, utils.VHDXHeaderOffset2} {
+		header := utils.VHDXHeader{}
+		Expect(binary.Read(bytes.NewReader(image[offset:]), binary.LittleEndian, &header)).To(Succeed())
+		Expect(string(header.Signature[:])).To(Equal("head"))
+		Expect(header.Version).To(Equal(uint16(1)))
+		Expect(header.LogGUID).To(Equal([16]byte{}))
+		checkVhdxChecksum(image[offset : offset+4096])
+	}
+
+	regions := map[[16]byte]utils.VHDXRegionTableEntry{}
+	for _, offset := range []int{utils.VHDXRegionOffset1, utils.VHDXRegionOffset2} {
+		reader := bytes.NewReader(image[offset:])
+		header := utils.VHDXRegionTableHeader{}
+		Expect(binary.Read(reader, binary.LittleEndian, &header)).To(Succeed())
+		Expect(string(header.Signature[:])).To(Equal("regi"))
+		checkVhdxChecksum(image[offset : offset+64*1024])
+		for i := uint32(0); i < header.EntryCount; i++ {
+			entry := utils.VHDXRegionTableEntry{}
+			Expect(binary.Read(reader, binary.LittleEndian, &entry)).To(Succeed())
+			regions[entry.GUID] = entry
+		}
+	}
+	bat, ok := regions[utils.VHDXGUID(utils.VHDXBATRegionGUID)]
+	Expect(ok).To(BeTrue())
+	metadata, ok := regions[utils.VHDXGUID(utils.VHDXMetadataRegionGUID)]
+	Expect(ok).To(BeTrue())
+
+	reader := bytes.NewReader(image[metadata.FileOffset:])
+	table := utils.VHDXMetadataTableHeader{}
+	Expect(binary.Read(reader, binary.LittleEndian, &table)).To(Succeed())
+	Expect(string(table.Signature[:])).To(Equal("metadata"))
+	items := map[[16]byte][]byte{}
+	for i := uint16(0); i < table.EntryCount; i++ {
+		entry := utils.VHDXMetadataTableEntry{}
+		Expect(binary.Read(reader, binary.LittleEndian, &entry)).To(Succeed())
+		start := metadata.FileOffset + uint64(entry.Offset)
+		items[entry.ItemID] = image[start : start+uint64(entry.Length)]
+	}
+	blockSize := uint64(binary.LittleEndian.Uint32(items[utils.VHDXGUID(utils.VHDXFileParametersGUID)]))
+	size := binary.LittleEndian.Uint64(items[utils.VHDXGUID(utils.VHDXVirtualDiskSizeGUID)])
+	Expect(items[utils.VHDXGUID(utils.VHDXVirtualDiskIDGUID)]).To(HaveLen(16))
+	Expect(binary.LittleEndian.Uint32(items[utils.VHDXGUID(utils.VHDXLogicalSectorGUID)])).To(Equal(uint32(512)))
+	Expect(binary.LittleEndian.Uint32(items[utils.VHDXGUID(utils.VHDXPhysicalSectorGUID)])).To(Equal(uint32(512)))
+
+	data := make([]byte, size)
+	chunkRatio := (uint64(1) << 23) * 512 / blockSize
+	for i := uint64(0); i*blockSize < size; i++ {
+		entry := binary.LittleEndian.Uint64(image[bat.FileOffset+(i+i/chunkRatio)*8:])
+		if entry&7 == utils.VHDXPayloadBlockNotPresent {
+			continue
+		}
+		Expect(entry & 7).To(Equal(uint64(utils.VHDXPayloadBlockFullyPresent)))
+		offset := entry >> 20 << 20
+		copy(data[i*blockSize:], image[offset:offset+blockSize])
+	}
+	return data
+}
+
+var _ = Describe("VHDX utils", Label("vhdx"), func() {
+	var fs vfs.FS
+	var cleanup func()
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("writes a dynamic vhdx disk", func() {
+		// Data in the first and last blocks of the disk
+		raw := make([]byte, 10*utils.VHDXBlockSize+4096)
+		copy(raw[10:], "first block")
+		copy(raw[len(raw)-10:], "last block")
+		Expect(fs.WriteFile("/disk.raw", raw, constants.FilePerm)).To(Succeed())
+
+		rawFile, err := fs.OpenFile("/disk.raw", os.O_RDONLY, constants.FilePerm)
+		Expect(err).ToNot(HaveOccurred())
+		defer rawFile.Close()
+		vhdxFile, err := fs.Create("/disk.vhdx")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(utils.RawDiskToVhdx(rawFile, vhdxFile)).To(Succeed())
+		Expect(vhdxFile.Close()).To(Succeed())
+
+		image, err := fs.ReadFile("/disk.vhdx")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readVhdx(image)).To(Equal(raw))
+
+		// Headers, log, metadata and BAT regions and two payload blocks
+		Expect(len(image)).To(Equal(4*utils.VHDXMB + 2*utils.VHDXBlockSize))
+	})
+
+	It("encodes GUIDs in mixed endian byte order", func() {
+		Expect(utils.VHDXGUID("2DC27766-F623-4200-9D64-115E9BFD4A08")).To(Equal([16]byte{
+			0x66, 0x77, 0xc2, 0x2d, 0x23, 0xf6, 0x00, 0x42, 0x9d, 0x64, 0x11, 0x5e, 0x9b, 0xfd, 0x4a, 0x08,
+		}))
+	})
+})
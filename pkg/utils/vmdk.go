@@ -0,0 +1,246 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// This file contains utils to work with streamOptimized VMDK disks
+
+const (
+	VMDKMagic           = 0x564d444b // 'K', 'D', 'M', 'V'
+	VMDKVersion         = 3
+	VMDKSectorSize      = 512
+	VMDKGrainSectors    = 128
+	VMDKGrainSize       = VMDKGrainSectors * VMDKSectorSize
+	VMDKGTEsPerGT       = 512
+	VMDKGDAtEnd         = 0xffffffffffffffff
+	VMDKCompressDeflate = 1
+
+	// VMDK header flags
+	VMDKValidNewLineDetection = 1 << 0
+	VMDKCompressedGrains      = 1 << 16
+	VMDKHasMarkers            = 1 << 17
+
+	// VMDK stream marker types
+	VMDKMarkerEOS    = 0
+	VMDKMarkerGT     = 1
+	VMDKMarkerGD     = 2
+	VMDKMarkerFooter = 3
+
+	vmdkGTSectors = VMDKGTEsPerGT * 4 / VMDKSectorSize
+)
+
+// VMDKHeader is the sparse extent header of VMDK disks, it is stored in little endian
+type VMDKHeader struct {
+	MagicNumber        uint32    // Identifies the file as a sparse extent ("KDMV")
+	Version            uint32    // Version 3 is required for streamOptimized disks
+	Flags              uint32    // Bit field of the extent features
+	Capacity           uint64    // Capacity of the extent in sectors
+	GrainSize          uint64    // Size of a grain in sectors
+	DescriptorOffset   uint64    // Sector of the embedded descriptor
+	DescriptorSize     uint64    // Size of the embedded descriptor in sectors
+	NumGTEsPerGT       uint32    // Number of entries in a grain table
+	RgdOffset          uint64    // Sector of the redundant grain directory, unused on streamOptimized disks
+	GdOffset           uint64    // Sector of the grain directory, set to GD_AT_END in the header of streamOptimized disks
+	OverHead           uint64    // Number of sectors of metadata before the first grain
+	UncleanShutdown    uint8     // Set to 1 when the disk was not closed properly
+	SingleEndLineChar  byte      // Used to detect the corruption by FTP transfers in text mode
+	NonEndLineChar     byte      // Used to detect the corruption by FTP transfers in text mode
+	DoubleEndLineChar1 byte      // Used to detect the corruption by FTP transfers in text mode
+	DoubleEndLineChar2 byte      // Used to detect the corruption by FTP transfers in text mode
+	CompressAlgorithm  uint16    // 1 for deflate
+	Pad                [433]byte // This field contains zeroes.
+}
+
+// VMDKMarker is the sector sized metadata marker of streamOptimized disks. Grain markers only
+// include the Value and Size fields and are directly followed by the compressed grain data.
+type VMDKMarker struct {
+	Value   uint64 // Number of sectors of the metadata following the marker or the first sector of the grain
+	Size    uint32 // Size of the compressed grain data, 0 for metadata markers
+	Type    uint32 // Type of the metadata following the marker
+	Padding [496]byte
+}
+
+// vmdkWriter writes VMDK data sequentially keeping track of the current sector
+type vmdkWriter struct {
+	file   io.Writer
+	offset int64
+}
+
+// write writes the given data padded to the next sector boundary
+func (w *vmdkWriter) write(data []byte) error {
+	padded := make([]byte, (int64(len(data))+VMDKSectorSize-1)/VMDKSectorSize*VMDKSectorSize)
+	copy(padded, data)
+	n, err := w.file.Write(padded)
+	w.offset += int64(n)
+	return err
+}
+
+// writeStruct writes the given little endian struct padded to the next sector boundary
+func (w *vmdkWriter) writeStruct(data interface{}) error {
+	buffer := new(bytes.Buffer)
+	_ = binary.Write(buffer, binary.LittleEndian, data)
+	return w.write(buffer.Bytes())
+}
+
+// sector returns the current sector
+func (w *vmdkWriter) sector() uint64 {
+	return uint64(w.offset / VMDKSectorSize)
+}
+
+// newVMDKHeader returns a streamOptimized VMDK header for the given capacity in sectors
+func newVMDKHeader(capacity, descriptorSize, overHead uint64) VMDKHeader {
+	return VMDKHeader{
+		MagicNumber:        VMDKMagic,
+		Version:            VMDKVersion,
+		Flags:              VMDKValidNewLineDetection | VMDKCompressedGrains | VMDKHasMarkers,
+		Capacity:           capacity,
+		GrainSize:          VMDKGrainSectors,
+		DescriptorOffset:   1,
+		DescriptorSize:     descriptorSize,
+		NumGTEsPerGT:       VMDKGTEsPerGT,
+		GdOffset:           VMDKGDAtEnd,
+		OverHead:           overHead,
+		SingleEndLineChar:  '\n',
+		NonEndLineChar:     ' ',
+		DoubleEndLineChar1: '\r',
+		DoubleEndLineChar2: '\n',
+		CompressAlgorithm:  VMDKCompressDeflate,
+	}
+}
+
+// vmdkDescriptor returns the embedded descriptor of a single extent streamOptimized disk
+func vmdkDescriptor(extent string, capacity uint64) string {
+	// VMware reports a fixed geometry of 255 heads and 63 sectors per track for SCSI disks
+	cylinders := min(capacity/(255*63), 65535)
+	return fmt.Sprintf(`# Disk DescriptorFile
+version=1
+CID=%08x
+parentCID=ffffffff
+createType="streamOptimized"
+
+# Extent description
+RW %d SPARSE "%s"
+
+# The Disk Data Base
+#DDB
+
+ddb.virtualHWVersion = "4"
+ddb.adapterType = "lsilogic"
+ddb.geometry.cylinders = "%d"
+ddb.geometry.heads = "255"
+ddb.geometry.sectors = "63"
+`, rand.Uint32(), capacity, extent, cylinders)
+}
+
+// RawDiskToStreamVmdk writes the contents of the given raw disk file into the given file as a streamOptimized
+// VMDK disk. Grains only including zeros are not stored, the rest are stored deflate compressed.
+// RawDiskToStreamVmdk makes no effort into opening/closing/checking if the files exist
+func RawDiskToStreamVmdk(rawFile *os.File, vmdkFile *os.File) error {
+	info, err := rawFile.Stat()
+	if err != nil {
+		return err
+	}
+	capacity := uint64(info.Size()+VMDKSectorSize-1) / VMDKSectorSize
+	grains := (capacity + VMDKGrainSectors - 1) / VMDKGrainSectors
+	numGTs := (grains + VMDKGTEsPerGT - 1) / VMDKGTEsPerGT
+
+	descriptor := vmdkDescriptor(filepath.Base(vmdkFile.Name()), capacity)
+	descriptorSize := uint64(len(descriptor)+VMDKSectorSize-1) / VMDKSectorSize
+	// Grains start at the first grain boundary after the header and the descriptor
+	overHead := (1 + descriptorSize + VMDKGrainSectors - 1) / VMDKGrainSectors * VMDKGrainSectors
+	header := newVMDKHeader(capacity, descriptorSize, overHead)
+
+	w := &vmdkWriter{file: vmdkFile}
+	if err = w.writeStruct(header); err != nil {
+		return err
+	}
+	if err = w.write([]byte(descriptor)); err != nil {
+		return err
+	}
+	if err = w.write(make([]byte, (overHead-w.sector())*VMDKSectorSize)); err != nil {
+		return err
+	}
+
+	gts := make([]uint32, numGTs*VMDKGTEsPerGT)
+	data := make([]byte, VMDKGrainSize)
+	for i := uint64(0); i < grains; i++ {
+		n, err := rawFile.ReadAt(data, int64(i*VMDKGrainSize))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		clear(data[n:])
+		if isZeroCluster(data) {
+			continue
+		}
+
+		buffer := new(bytes.Buffer)
+		zw := zlib.NewWriter(buffer)
+		if _, err = zw.Write(data); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+
+		// Grain markers are only 12 bytes long and directly followed by the compressed data
+		grain := make([]byte, 12, 12+buffer.Len())
+		binary.LittleEndian.PutUint64(grain[0:], i*VMDKGrainSectors)
+		binary.LittleEndian.PutUint32(grain[8:], uint32(buffer.Len()))
+		gts[i] = uint32(w.sector())
+		if err = w.write(append(grain, buffer.Bytes()...)); err != nil {
+			return err
+		}
+	}
+
+	gd := make([]uint32, numGTs)
+	for i := range gd {
+		if err = w.writeStruct(VMDKMarker{Value: vmdkGTSectors, Type: VMDKMarkerGT}); err != nil {
+			return err
+		}
+		gd[i] = uint32(w.sector())
+		if err = w.writeStruct(gts[i*VMDKGTEsPerGT : (i+1)*VMDKGTEsPerGT]); err != nil {
+			return err
+		}
+	}
+
+	gdSectors := (numGTs*4 + VMDKSectorSize - 1) / VMDKSectorSize
+	if err = w.writeStruct(VMDKMarker{Value: gdSectors, Type: VMDKMarkerGD}); err != nil {
+		return err
+	}
+	header.GdOffset = w.sector()
+	if err = w.writeStruct(gd); err != nil {
+		return err
+	}
+
+	if err = w.writeStruct(VMDKMarker{Value: 1, Type: VMDKMarkerFooter}); err != nil {
+		return err
+	}
+	if err = w.writeStruct(header); err != nil {
+		return err
+	}
+	return w.writeStruct(VMDKMarker{Type: VMDKMarkerEOS})
+}
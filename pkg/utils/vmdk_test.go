@@ -0,0 +1,200 @@
+/*
+Copyright © 2022 - 2025 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/v4"
+	"github.com/twpayne/go-vfs/v4/vfst"
+
+	"github.com/rancher/elemental-toolkit/v2/pkg/constants"
+	"github.com/rancher/elemental-toolkit/v2/pkg/types"
+	"github.com/rancher/elemental-toolkit/v2/pkg/utils"
+)
+
+// readVmdk parses the given streamOptimized VMDK disk and returns its footer, its embedded
+// descriptor and its guest data read through the grain directory
+func readVmdk(image []byte) (utils.VMDKHeader, string, []byte) {
+	header := utils.VMDKHeader{}
+	Expect(binary.Read(bytes.NewReader(image), binary.LittleEndian, &header)).To(Succeed())
+	Expect(header.GdOffset).To(Equal(uint64(utils.VMDKGDAtEnd)))
+
+	// Stream ends with the footer marker, the footer and the end of stream marker
+	marker := utils.VMDKMarker{}
+	Expect(binary.Read(bytes.NewReader(image[len(image)-1536:]), binary.LittleEndian, &marker)).To(Succeed())
+	Expect(marker.Type).To(Equal(uint32(utils.VMDKMarkerFooter)))
+	footer := utils.VMDKHeader{}
+	Expect(binary.Read(bytes.NewReader(image[len(image)-1024:]), binary.LittleEndian, &footer)).To(Succeed())
+	Expect(image[len(image)-512:]).To(Equal(make([]byte, 512)))
+
+	descriptor := image[header.DescriptorOffset*512 : (header.DescriptorOffset+header.DescriptorSize)*512]
+	descriptor = bytes.TrimRight(descriptor, "\x00")
+
+	sector := func(s uint64) []byte {
+		return image[s*512:]
+	}
+	Expect(binary.Read(bytes.NewReader(sector(footer.GdOffset-1)), binary.LittleEndian, &marker)).To(Succeed())
+	Expect(marker.Type).To(Equal(uint32(utils.VMDKMarkerGD)))
+
+	data := make([]byte, footer.Capacity*512)
+	grains := (footer.Capacity + footer.GrainSize - 1) / footer.GrainSize
+	numGTs := (grains + uint64(footer.NumGTEsPerGT) - 1) / uint64(footer.NumGTEsPerGT)
+	for i := uint64(0); i < numGTs; i++ {
+		gtOffset := uint64(binary.LittleEndian.Uint32(sector(footer.GdOffset)[i*4:]))
+		Expect(binary.Read(bytes.NewReader(sector(gtOffset-1)), binary.LittleEndian, &marker)).To(Succeed())
+		Expect(marker.Type).To(Equal(uint32(utils.VMDKMarkerGT)))
+
+		for j := uint64(0); j < uint64(footer.NumGTEsPerGT); j++ {
+			grainOffset := uint64(binary.LittleEndian.Uint32(sector(gtOffset)[j*4:]))
+			if grainOffset == 0 {
+				continue
+			}
+			grain := sector(grainOffset)
+			lba := binary.LittleEndian.Uint64(grain)
+			size := binary.LittleEndian.Uint32(grain[8:])
+			Expect(lba).To(Equal((i*uint64(footer.NumGTEsPerGT) + j) * footer.GrainSize))
+
+			reader, err := zlib.NewReader(bytes.NewReader(grain[12 : 12+size]))
+			Expect(err).ToNot(HaveOccurred())
+			decompressed, err := io.ReadAll(reader)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decompressed).To(HaveLen(utils.VMDKGrainSize))
+			copy(data[lba*512:], decompressed)
+		}
+	}
+	return footer, string(descriptor), data
+}
+
+var _ = Describe("VMDK utils", Label("vmdk"), func() {
+	var fs vfs.FS
+	var cleanup func()
+	var raw []byte
+
+	BeforeEach(func() {
+		var err error
+		fs, cleanup, err = vfst.NewTestFS(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		// Sparse data spread over two grain tables
+		raw = make([]byte, 600*utils.VMDKGrainSize)
+		copy(raw[0:], bytes.Repeat([]byte("elemental"), 1000))
+		copy(raw[3*utils.VMDKGrainSize+10:], []byte("sector data"))
+		copy(raw[len(raw)-11:], []byte("end of disk"))
+		Expect(fs.WriteFile("/disk.raw", raw, constants.FilePerm)).To(Succeed())
+	})
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("writes a streamOptimized disk", func() {
+		rawFile, err := fs.OpenFile("/disk.raw", os.O_RDONLY, constants.FilePerm)
+		Expect(err).ToNot(HaveOccurred())
+		defer rawFile.Close()
+		vmdkFile, err := fs.Create("/disk.vmdk")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(utils.RawDiskToStreamVmdk(rawFile, vmdkFile)).To(Succeed())
+		Expect(vmdkFile.Close()).To(Succeed())
+
+		image, err := fs.ReadFile("/disk.vmdk")
+		Expect(err).ToNot(HaveOccurred())
+		footer, descriptor, data := readVmdk(image)
+
+		Expect(footer.MagicNumber).To(Equal(uint32(utils.VMDKMagic)))
+		Expect(footer.Version).To(Equal(uint32(3)))
+		Expect(footer.Flags).To(Equal(uint32(0x30001)))
+		Expect(footer.Capacity).To(Equal(uint64(len(raw) / 512)))
+		Expect(footer.OverHead).To(Equal(uint64(128)))
+		Expect(footer.CompressAlgorithm).To(Equal(uint16(1)))
+		Expect(descriptor).To(ContainSubstring(`createType="streamOptimized"`))
+		Expect(descriptor).To(ContainSubstring(fmt.Sprintf(`RW %d SPARSE "disk.vmdk"`, len(raw)/512)))
+		Expect(data).To(Equal(raw))
+
+		// Only the three grains including data are stored
+		Expect(len(image)).To(BeNumerically("<", 2*utils.VMDKGrainSize))
+	})
+
+	It("bundles an OVA appliance", func() {
+		Expect(fs.WriteFile("/disk.vmdk", []byte("vmdk data"), constants.FilePerm)).To(Succeed())
+		vm := types.VMSpec{CPUs: 4, Memory: 8192}
+		Expect(utils.VmdkToOva(fs, "/disk.vmdk", "/elemental.ova", 1024*1024, vm, types.EFI)).To(Succeed())
+
+		ova, err := fs.Open("/elemental.ova")
+		Expect(err).ToNot(HaveOccurred())
+		defer ova.Close()
+
+		var names []string
+		contents := map[string][]byte{}
+		tarReader := tar.NewReader(ova)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).ToNot(HaveOccurred())
+			names = append(names, header.Name)
+			contents[header.Name], err = io.ReadAll(tarReader)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		// The OVF descriptor has to be the first file of the bundle
+		Expect(names).To(Equal([]string{"elemental.ovf", "elemental.mf", "elemental.vmdk"}))
+		Expect(string(contents["elemental.vmdk"])).To(Equal("vmdk data"))
+		Expect(string(contents["elemental.mf"])).To(Equal(fmt.Sprintf(
+			"SHA256(elemental.ovf)= %x\nSHA256(elemental.vmdk)= %x\n",
+			sha256.Sum256(contents["elemental.ovf"]), sha256.Sum256([]byte("vmdk data")),
+		)))
+
+		ovf := string(contents["elemental.ovf"])
+		envelope := struct {
+			XMLName xml.Name
+		}{}
+		Expect(xml.Unmarshal(contents["elemental.ovf"], &envelope)).To(Succeed())
+		Expect(envelope.XMLName.Local).To(Equal("Envelope"))
+		Expect(ovf).To(ContainSubstring(`ovf:href="elemental.vmdk" ovf:id="file1" ovf:size="9"`))
+		Expect(ovf).To(ContainSubstring(`ovf:capacity="1048576"`))
+		Expect(ovf).To(ContainSubstring(`<rasd:VirtualQuantity>4</rasd:VirtualQuantity>`))
+		Expect(ovf).To(ContainSubstring(`<rasd:VirtualQuantity>8192</rasd:VirtualQuantity>`))
+		Expect(ovf).To(ContainSubstring(`vmw:key="firmware" vmw:value="efi"`))
+	})
+
+	It("escapes the appliance name in the OVF descriptor", func() {
+		ovf, err := utils.OVFDescriptor("a&b", "a&b.vmdk", 1, 1, types.VMSpec{CPUs: 1, Memory: 1}, types.EFI)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ovf).To(ContainSubstring("<Name>a&amp;b</Name>"))
+	})
+
+	It("sets the virtual machine firmware of the OVF descriptor", func() {
+		ovf, err := utils.OVFDescriptor("elemental", "elemental.vmdk", 1, 1, types.VMSpec{CPUs: 1, Memory: 1}, types.BIOS)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ovf).To(ContainSubstring(`vmw:key="firmware" vmw:value="bios"`))
+		Expect(ovf).NotTo(ContainSubstring(`vmw:value="efi"`))
+
+		ovf, err = utils.OVFDescriptor("elemental", "elemental.vmdk", 1, 1, types.VMSpec{CPUs: 1, Memory: 1}, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ovf).To(ContainSubstring(`vmw:key="firmware" vmw:value="efi"`))
+	})
+})